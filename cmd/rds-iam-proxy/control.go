@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/token"
+)
+
+// controlIOTimeout bounds one control request, so a stuck client cannot hold
+// a connection open.
+const controlIOTimeout = 5 * time.Second
+
+// statusProfile is one profile in the control socket's status reply.
+type statusProfile struct {
+	Profile        string     `json:"profile"`
+	ListenAddr     string     `json:"listen_addr"`
+	ActiveConns    int        `json:"active_conns"`
+	PoolIdle       int        `json:"pool_idle"`
+	PoolSize       int        `json:"pool_size"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"` // earliest cached token; nil before the first login
+}
+
+type statusReport struct {
+	StartedAt time.Time       `json:"started_at"`
+	UptimeMs  int64           `json:"uptime_ms"`
+	Profiles  []statusProfile `json:"profiles"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// startControlServer answers `rds-iam-proxy status` on the Unix socket at
+// path until ctx is cancelled. The protocol is one command line per
+// connection ("status") answered with one JSON document. A leftover socket
+// from a crashed run is replaced; one a live process still answers on is not.
+func startControlServer(ctx context.Context, logger *slog.Logger, path string, startedAt time.Time, profiles []config.Profile, instances []*proxy.Proxy, cache *token.Cache) error {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return fmt.Errorf("control socket %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove stale control socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listen control socket: %w", err)
+	}
+	// Status names listeners and profiles; keep it to the proxy's own user.
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return fmt.Errorf("chmod control socket: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					logger.Warn("control socket stopped", "error", err)
+				}
+				return
+			}
+			go serveControlConn(conn, func() statusReport {
+				return collectStatus(startedAt, profiles, instances, cache)
+			})
+		}
+	}()
+	logger.Info("control socket listening", "path", path)
+	return nil
+}
+
+func serveControlConn(conn net.Conn, status func() statusReport) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlIOTimeout))
+	line, err := bufio.NewReader(io.LimitReader(conn, 256)).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	var reply statusReport
+	switch cmd := strings.TrimSpace(line); cmd {
+	case "status":
+		reply = status()
+	default:
+		reply.Error = fmt.Sprintf("unknown command %q", cmd)
+	}
+	_ = json.NewEncoder(conn).Encode(reply)
+}
+
+func collectStatus(startedAt time.Time, profiles []config.Profile, instances []*proxy.Proxy, cache *token.Cache) statusReport {
+	byName := make(map[string]config.Profile, len(profiles))
+	for _, p := range profiles {
+		byName[p.Name] = p
+	}
+	report := statusReport{
+		StartedAt: startedAt.UTC(),
+		UptimeMs:  time.Since(startedAt).Milliseconds(),
+		Profiles:  []statusProfile{},
+	}
+	for _, px := range instances {
+		for _, ps := range px.Snapshot() {
+			sp := statusProfile{
+				Profile:     ps.Profile,
+				ListenAddr:  ps.ListenAddr,
+				ActiveConns: ps.ActiveConns,
+				PoolIdle:    ps.Pool.Idle,
+				PoolSize:    ps.Pool.Size,
+			}
+			if p, ok := byName[ps.Profile]; ok {
+				if exp, ok := cache.NextExpiry(p); ok {
+					sp.TokenExpiresAt = &exp
+				}
+			}
+			report.Profiles = append(report.Profiles, sp)
+		}
+	}
+	return report
+}
+
+// runStatus implements `rds-iam-proxy status`: it asks a running proxy for
+// its status over the control socket and prints it as a table. It returns
+// the process exit code.
+func runStatus(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		configPath string
+		configDir  string
+		socketPath string
+		timeout    time.Duration
+	)
+	fs.StringVar(&configPath, "config", "", "Path to config YAML whose control_socket to use")
+	fs.StringVar(&configDir, "config-dir", "", "Directory of config YAML files whose control_socket to use")
+	fs.StringVar(&socketPath, "socket", "", "Control socket path; overrides control_socket from the config")
+	fs.DurationVar(&timeout, "timeout", controlIOTimeout, "How long to wait for the running proxy to answer")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if socketPath == "" {
+		cfg, source, err := loadConfigFromFlags(configPath, configDir)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if cfg.ControlSocket == "" {
+			fmt.Fprintf(stderr, "config %s sets no control_socket; pass --socket\n", source)
+			return 1
+		}
+		socketPath = cfg.ControlSocket
+	}
+
+	report, err := queryStatus(socketPath, timeout)
+	if err != nil {
+		fmt.Fprintf(stderr, "status: %v\n", err)
+		return 1
+	}
+	writeStatusTable(stdout, report, time.Now())
+	return 0
+}
+
+func queryStatus(path string, timeout time.Duration) (statusReport, error) {
+	var report statusReport
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return report, fmt.Errorf("connect control socket (is the proxy running with control_socket set?): %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := io.WriteString(conn, "status\n"); err != nil {
+		return report, fmt.Errorf("send request: %w", err)
+	}
+	if err := json.NewDecoder(conn).Decode(&report); err != nil {
+		return report, fmt.Errorf("read reply: %w", err)
+	}
+	if report.Error != "" {
+		return report, errors.New(report.Error)
+	}
+	return report, nil
+}
+
+func writeStatusTable(w io.Writer, report statusReport, now time.Time) {
+	fmt.Fprintf(w, "uptime: %s (started %s)\n\n", (time.Duration(report.UptimeMs) * time.Millisecond).Round(time.Second), report.StartedAt.Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROFILE\tLISTEN\tACTIVE\tPOOL\tTOKEN EXPIRES")
+	for _, p := range report.Profiles {
+		expires := "-"
+		if p.TokenExpiresAt != nil {
+			expires = fmt.Sprintf("%s (in %s)", p.TokenExpiresAt.Local().Format(time.TimeOnly), p.TokenExpiresAt.Sub(now).Round(time.Second))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d/%d\t%s\n", p.Profile, p.ListenAddr, p.ActiveConns, p.PoolIdle, p.PoolSize, expires)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/token"
+)
+
+// shortSocketPath keeps the path under the ~104 byte sun_path limit that
+// t.TempDir can exceed on macOS.
+func shortSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "riap")
+	if err != nil {
+		t.Fatalf("mkdir temp: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "ctl.sock")
+}
+
+func TestControlSocketStatusRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	prof := config.Profile{Name: "p1", ListenAddr: "127.0.0.1:3307", ProxyUser: "local_proxy", RDSHost: "db", RDSPort: 3306}
+	pool := proxy.NewBackendPool(3, time.Minute, time.Second, logger, nil)
+	px := proxy.New(prof, logger, pool, time.Second, 0)
+	path := shortSocketPath(t)
+
+	// A stale socket file from a crashed run is replaced.
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("write stale socket: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startedAt := time.Now().Add(-time.Hour)
+	if err := startControlServer(ctx, logger, path, startedAt, []config.Profile{prof}, []*proxy.Proxy{px}, token.New(time.Minute, time.Minute)); err != nil {
+		t.Fatalf("start control server: %v", err)
+	}
+	if err := startControlServer(ctx, logger, path, startedAt, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("expected in-use error for a live socket, got: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runStatus([]string{"--socket", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runStatus exit %d: %s", code, stderr.String())
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "uptime: 1h0m0s") {
+		t.Fatalf("expected uptime line, got:\n%s", out)
+	}
+	if !strings.Contains(out, "PROFILE") || !strings.Contains(out, "p1") || !strings.Contains(out, "0/3") {
+		t.Fatalf("expected p1 row with pool fill, got:\n%s", out)
+	}
+}
+
+func TestRunStatusWithoutProxyFails(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+	if code := runStatus([]string{"--socket", shortSocketPath(t), "--timeout", "100ms"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "connect control socket") {
+		t.Fatalf("expected connect error, got: %s", stderr.String())
+	}
+}
+
+func TestWriteStatusTableShowsTokenExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	exp := now.Add(9*time.Minute + 30*time.Second)
+	var buf bytes.Buffer
+	writeStatusTable(&buf, statusReport{
+		StartedAt: now.Add(-90 * time.Second),
+		UptimeMs:  90_000,
+		Profiles: []statusProfile{
+			{Profile: "a", ListenAddr: "127.0.0.1:3307", ActiveConns: 2, PoolIdle: 4, PoolSize: 5, TokenExpiresAt: &exp},
+			{Profile: "b", ListenAddr: "127.0.0.1:3308", PoolSize: 5},
+		},
+	}, now)
+	out := buf.String()
+	if !strings.Contains(out, "uptime: 1m30s") || !strings.Contains(out, "(in 9m30s)") || !strings.Contains(out, "4/5") {
+		t.Fatalf("unexpected table:\n%s", out)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "b ") || !strings.HasSuffix(last, "-") {
+		t.Fatalf("expected b row without token expiry, got %q", last)
+	}
+}
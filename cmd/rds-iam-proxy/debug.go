@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/token"
+)
+
+type debugListener struct {
+	ListenAddr   string                 `json:"listen_addr"`
+	ActiveConns  int                    `json:"active_conns"`
+	AuthFailures proxy.AuthFailureStats `json:"auth_failures"`
+	AcceptErrors uint64                 `json:"accept_errors"`
+}
+
+// debugBorrows summarizes successful pool borrows of one source.
+type debugBorrows struct {
+	Count  uint64 `json:"count"`
+	MeanMs int64  `json:"mean_ms"`
+	MaxMs  int64  `json:"max_ms"`
+}
+
+type debugPool struct {
+	Profile       string       `json:"profile"`
+	Size          int          `json:"size"`
+	Idle          int          `json:"idle"`
+	WarmBorrows   debugBorrows `json:"warm_borrows"`
+	FreshBorrows  debugBorrows `json:"fresh_borrows"`
+	Invalidations uint64       `json:"invalidations"`
+	Starvations   uint64       `json:"starvations"`
+	// TokenExpiresInSeconds counts down to the profile's soonest cached token
+	// expiry and goes negative while refreshes fail; nil before the first token.
+	TokenExpiresInSeconds *int64 `json:"token_expires_in_seconds,omitempty"`
+}
+
+type debugSnapshot struct {
+	Goroutines int             `json:"goroutines"`
+	Listeners  []debugListener `json:"listeners"`
+	Pools      []debugPool     `json:"pools"`
+	TokenCache token.Stats     `json:"token_cache"`
+}
+
+func newDebugBorrows(s proxy.BorrowSummary) debugBorrows {
+	return debugBorrows{Count: s.Count, MeanMs: s.Mean().Milliseconds(), MaxMs: s.Max.Milliseconds()}
+}
+
+// startDebugServer serves the standard pprof endpoints plus a JSON runtime
+// summary at /debug/rds-iam-proxy, and rebuilds the backend pools on a POST to
+// /debug/rds-iam-proxy/refresh. It only binds loopback addresses and shuts
+// down when ctx is cancelled.
+func startDebugServer(ctx context.Context, logger *slog.Logger, addr string, profiles []config.Profile, instances []*proxy.Proxy, cache *token.Cache, refresh func() int) error {
+	if !config.IsLoopbackAddr(addr) {
+		return fmt.Errorf("pprof-addr %q is not loopback", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen pprof-addr: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler:           newDebugMux(logger, profiles, instances, cache, refresh),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warn("debug server stopped", "error", err)
+		}
+	}()
+	logger.Info("debug server listening", "pprof_addr", ln.Addr().String())
+	return nil
+}
+
+func newDebugMux(logger *slog.Logger, profiles []config.Profile, instances []*proxy.Proxy, cache *token.Cache, refresh func() int) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/rds-iam-proxy", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(collectDebugSnapshot(profiles, instances, cache, time.Now()))
+	})
+	// POST only, so a browser prefetch or a stray GET cannot drop the pools.
+	mux.HandleFunc("POST /debug/rds-iam-proxy/refresh", func(w http.ResponseWriter, _ *http.Request) {
+		n := refresh()
+		logger.Info("backend pools refreshed", "trigger", "debug_endpoint", "pools", n)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]int{"pools_refreshed": n})
+	})
+	return mux
+}
+
+func collectDebugSnapshot(profiles []config.Profile, instances []*proxy.Proxy, cache *token.Cache, now time.Time) debugSnapshot {
+	byName := make(map[string]config.Profile, len(profiles))
+	for _, p := range profiles {
+		byName[p.Name] = p
+	}
+	snap := debugSnapshot{
+		Goroutines: runtime.NumGoroutine(),
+		Listeners:  make([]debugListener, 0, len(instances)),
+		Pools:      []debugPool{},
+		TokenCache: cache.Stats(),
+	}
+	for _, px := range instances {
+		snap.Listeners = append(snap.Listeners, debugListener{
+			ListenAddr:   px.ListenAddr(),
+			ActiveConns:  px.ActiveConns(),
+			AuthFailures: px.AuthFailures(),
+			AcceptErrors: px.AcceptErrors(),
+		})
+		for _, ps := range px.Snapshot() {
+			pool := debugPool{
+				Profile:       ps.Profile,
+				Size:          ps.Pool.Size,
+				Idle:          ps.Pool.Idle,
+				WarmBorrows:   newDebugBorrows(ps.Pool.Warm),
+				FreshBorrows:  newDebugBorrows(ps.Pool.Fresh),
+				Invalidations: ps.Pool.Invalidations,
+				Starvations:   ps.Pool.Starvations,
+			}
+			if p, ok := byName[ps.Profile]; ok {
+				if exp, ok := cache.NextExpiry(p); ok {
+					secs := int64(exp.Sub(now) / time.Second)
+					pool.TokenExpiresInSeconds = &secs
+				}
+			}
+			snap.Pools = append(snap.Pools, pool)
+		}
+	}
+	return snap
+}
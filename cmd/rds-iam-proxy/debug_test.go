@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/token"
+)
+
+func TestStartDebugServerRejectsNonLoopback(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	err := startDebugServer(context.Background(), logger, "0.0.0.0:6060", nil, nil, token.New(time.Minute, time.Minute), nil)
+	if err == nil || !strings.Contains(err.Error(), "not loopback") {
+		t.Fatalf("expected loopback error, got: %v", err)
+	}
+}
+
+func TestDebugSnapshotHandler(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pool := proxy.NewBackendPool(2, time.Minute, time.Second, logger, nil)
+	px := proxy.New(config.Profile{Name: "p1", ListenAddr: "127.0.0.1:3307", ProxyUser: "local_proxy"}, logger, pool, time.Second, 0)
+	srv := httptest.NewServer(newDebugMux(logger, nil, []*proxy.Proxy{px}, token.New(time.Minute, time.Minute), nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/rds-iam-proxy")
+	if err != nil {
+		t.Fatalf("get debug snapshot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var snap debugSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if snap.Goroutines <= 0 {
+		t.Fatalf("expected goroutine count, got %d", snap.Goroutines)
+	}
+	if len(snap.Listeners) != 1 || snap.Listeners[0].ListenAddr != "127.0.0.1:3307" || snap.Listeners[0].ActiveConns != 0 || snap.Listeners[0].AuthFailures != (proxy.AuthFailureStats{}) {
+		t.Fatalf("unexpected listeners: %+v", snap.Listeners)
+	}
+	if len(snap.Pools) != 1 || snap.Pools[0].Profile != "p1" || snap.Pools[0].Size != 2 || snap.Pools[0].FreshBorrows.Count != 0 || snap.Pools[0].TokenExpiresInSeconds != nil {
+		t.Fatalf("unexpected pools: %+v", snap.Pools)
+	}
+
+	resp, err = http.Get(srv.URL + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatalf("get pprof goroutine: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected pprof status %d", resp.StatusCode)
+	}
+}
+
+func TestDebugRefreshEndpointRequiresPost(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	calls := 0
+	srv := httptest.NewServer(newDebugMux(logger, nil, nil, token.New(time.Minute, time.Minute), func() int {
+		calls++
+		return 3
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/rds-iam-proxy/refresh")
+	if err != nil {
+		t.Fatalf("get refresh: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || calls != 0 {
+		t.Fatalf("GET refresh: status %d, calls %d; want 405 and no refresh", resp.StatusCode, calls)
+	}
+
+	resp, err = http.Post(srv.URL+"/debug/rds-iam-proxy/refresh", "", nil)
+	if err != nil {
+		t.Fatalf("post refresh: %v", err)
+	}
+	defer resp.Body.Close()
+	var body map[string]int
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode refresh response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || calls != 1 || body["pools_refreshed"] != 3 {
+		t.Fatalf("POST refresh: status %d, calls %d, body %v", resp.StatusCode, calls, body)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+
+	"rds-iam-proxy/internal/config"
+)
+
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// checkRDSHost resolves each of p's backend hosts and logs the addresses,
+// warning when one does not resolve (typically a deleted endpoint) or neither
+// the name nor its CNAME looks like an RDS endpoint. It only logs; ok is false
+// when any resolution failed.
+func checkRDSHost(ctx context.Context, logger *slog.Logger, r hostResolver, p config.Profile) bool {
+	ok := true
+	for _, host := range p.BackendHosts() {
+		if !checkBackendHost(ctx, logger, r, p.Name, host) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func checkBackendHost(ctx context.Context, logger *slog.Logger, r hostResolver, profile, host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		logger.Warn("rds_host does not resolve; check that the endpoint still exists",
+			"profile", profile, "rds_host", host, "error", err)
+		return false
+	}
+	logger.Info("rds_host resolved", "profile", profile, "rds_host", host, "addrs", strings.Join(addrs, ","))
+
+	// Tunnels (SSM, SSH) point rds_host at loopback on purpose.
+	if looksLikeRDSEndpoint(host) || allLoopback(addrs) {
+		return true
+	}
+	cname, err := r.LookupCNAME(ctx, host)
+	if err != nil || !looksLikeRDSEndpoint(cname) {
+		logger.Warn("rds_host does not look like an RDS endpoint",
+			"profile", profile, "rds_host", host, "cname", strings.TrimSuffix(cname, "."))
+	}
+	return true
+}
+
+func looksLikeRDSEndpoint(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return strings.HasSuffix(name, ".rds.amazonaws.com") || strings.HasSuffix(name, ".rds.amazonaws.com.cn")
+}
+
+func allLoopback(addrs []string) bool {
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip == nil || !ip.IsLoopback() {
+			return false
+		}
+	}
+	return len(addrs) > 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"rds-iam-proxy/internal/config"
+)
+
+type fakeResolver struct {
+	hosts map[string][]string
+	cname map[string]string
+}
+
+func (r fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r fakeResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	if c, ok := r.cname[host]; ok {
+		return c, nil
+	}
+	return host + ".", nil
+}
+
+func TestCheckRDSHost(t *testing.T) {
+	t.Parallel()
+
+	r := fakeResolver{
+		hosts: map[string][]string{
+			"db.abc123.eu-west-1.rds.amazonaws.com": {"10.0.0.5"},
+			"reporting.db.example.com":              {"10.0.0.6"},
+			"stale.db.example.com":                  {"10.0.0.7"},
+			"localhost":                             {"127.0.0.1", "::1"},
+		},
+		cname: map[string]string{
+			"reporting.db.example.com": "db.abc123.eu-west-1.rds.amazonaws.com.",
+			"stale.db.example.com":     "old-lb.example.com.",
+		},
+	}
+	cases := []struct {
+		host    string
+		ok      bool
+		warning string
+	}{
+		{host: "db.abc123.eu-west-1.rds.amazonaws.com", ok: true},
+		{host: "reporting.db.example.com", ok: true},
+		{host: "localhost", ok: true},
+		{host: "10.1.2.3", ok: true},
+		{host: "stale.db.example.com", ok: true, warning: "does not look like an RDS endpoint"},
+		{host: "deleted.abc123.eu-west-1.rds.amazonaws.com", ok: false, warning: "does not resolve"},
+	}
+	for _, tc := range cases {
+		var out bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&out, nil))
+		ok := checkRDSHost(context.Background(), logger, r, config.Profile{Name: "p1", RDSHost: tc.host})
+		if ok != tc.ok {
+			t.Fatalf("%s: ok = %v, want %v", tc.host, ok, tc.ok)
+		}
+		warned := strings.Contains(out.String(), "level=WARN")
+		if tc.warning == "" && warned {
+			t.Fatalf("%s: unexpected warning: %s", tc.host, out.String())
+		}
+		if tc.warning != "" && (!warned || !strings.Contains(out.String(), tc.warning) || !strings.Contains(out.String(), "profile=p1")) {
+			t.Fatalf("%s: expected warning %q, got: %s", tc.host, tc.warning, out.String())
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rds-iam-proxy/internal/config"
+)
+
+// errLockHeld is returned by tryLockFile when another process holds the lock.
+var errLockHeld = errors.New("lock held by another process")
+
+// listenLockHeldError reports a listen lock held by another process, with
+// the PID that process wrote into it; PID is 0 when it has not written one
+// yet.
+type listenLockHeldError struct {
+	PID int
+}
+
+func (e *listenLockHeldError) Error() string {
+	if e.PID == 0 {
+		return "held by another rds-iam-proxy process"
+	}
+	return fmt.Sprintf("held by PID %d", e.PID)
+}
+
+// lockListenAddrs takes an exclusive lock on a file in dir for each distinct
+// listen_addr in profiles, so a second rds-iam-proxy on the same address
+// fails before binding and names the process already serving it. The lock
+// is held on the open file and dropped by the OS when the process exits, so
+// a file left by a crashed run is simply locked again; its content is only
+// the PID for that error. The returned release drops the locks.
+func lockListenAddrs(dir string, profiles []config.Profile) (release func(), err error) {
+	var files []*os.File
+	release = func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		if seen[p.ListenAddr] {
+			continue
+		}
+		seen[p.ListenAddr] = true
+		// An ephemeral port never collides with another process.
+		if _, port, err := net.SplitHostPort(p.ListenAddr); err == nil && port == "0" {
+			continue
+		}
+		f, err := lockFile(listenLockPath(dir, p.ListenAddr))
+		if err != nil {
+			release()
+			var held *listenLockHeldError
+			switch {
+			case errors.As(err, &held) && held.PID != 0:
+				err = fmt.Errorf("listen_addr %s already served by PID %d", p.ListenAddr, held.PID)
+			case errors.As(err, &held):
+				err = fmt.Errorf("listen_addr %s already served by another rds-iam-proxy process", p.ListenAddr)
+			default:
+				err = fmt.Errorf("lock listen_addr %s: %w", p.ListenAddr, err)
+			}
+			return nil, &config.ProfileError{Profile: p.Name, Err: err}
+		}
+		files = append(files, f)
+	}
+	return release, nil
+}
+
+// lockFile opens path, creating it if needed, takes an exclusive lock on it
+// and writes the current PID into it. A lock held elsewhere is reported as
+// a *listenLockHeldError with the PID found in the file.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLockFile(f); err != nil {
+		if errors.Is(err, errLockHeld) {
+			data, _ := io.ReadAll(io.LimitReader(f, 32))
+			pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+			err = &listenLockHeldError{PID: pid}
+		}
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// listenLockPath names the lock file for addr, e.g.
+// rds-iam-proxy-127.0.0.1_3307.lock.
+func listenLockPath(dir, addr string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, addr)
+	return filepath.Join(dir, "rds-iam-proxy-"+name+".lock")
+}
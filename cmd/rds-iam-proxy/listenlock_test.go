@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"rds-iam-proxy/internal/config"
+)
+
+func TestLockListenAddrsRefusesAddressHeldByAnotherLock(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	held, err := lockFile(listenLockPath(dir, "127.0.0.1:3308"))
+	if err != nil {
+		t.Fatalf("lockFile: %v", err)
+	}
+	defer held.Close()
+	profiles := []config.Profile{
+		{Name: "prod", ListenAddr: "127.0.0.1:3307"},
+		{Name: "dev", ListenAddr: "127.0.0.1:3308"},
+	}
+	_, err = lockListenAddrs(dir, profiles)
+	var perr *config.ProfileError
+	if !errors.As(err, &perr) || perr.Profile != "dev" || !strings.Contains(err.Error(), "127.0.0.1:3308 already served by PID "+strconv.Itoa(os.Getpid())) {
+		t.Fatalf("expected dev's address to be reported as held, got: %v", err)
+	}
+	// The lock taken for prod before the failure is released.
+	f, err := lockFile(listenLockPath(dir, "127.0.0.1:3307"))
+	if err != nil {
+		t.Fatalf("expected prod's lock released, got: %v", err)
+	}
+	_ = f.Close()
+}
+
+func TestLockListenAddrsTakesOverUnlockedFile(t *testing.T) {
+	t.Parallel()
+
+	// A file naming a live PID without holding the lock, as left by a
+	// crashed run whose PID was reused or planted by hand, does not block.
+	dir := t.TempDir()
+	path := listenLockPath(dir, "127.0.0.1:3307")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())+"\n"), 0o600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	release, err := lockListenAddrs(dir, []config.Profile{{Name: "prod", ListenAddr: "127.0.0.1:3307"}})
+	if err != nil {
+		t.Fatalf("expected an unlocked file to be taken over, got: %v", err)
+	}
+	defer release()
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("expected the lock file to name this process, got %q, err %v", data, err)
+	}
+}
+
+func TestLockListenAddrsLocksEachAddressOnce(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	profiles := []config.Profile{
+		{Name: "a", ListenAddr: "[::1]:3307"},
+		{Name: "b", ListenAddr: "[::1]:3307"},
+		{Name: "c", ListenAddr: "127.0.0.1:0"},
+	}
+	release, err := lockListenAddrs(dir, profiles)
+	if err != nil {
+		t.Fatalf("lockListenAddrs: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "rds-iam-proxy-___1__3307.lock" {
+		t.Fatalf("expected one lock for the shared address, got %v", entries)
+	}
+	release()
+	again, err := lockListenAddrs(dir, profiles)
+	if err != nil {
+		t.Fatalf("expected locks released, got: %v", err)
+	}
+	again()
+}
+
+func TestListenLockDirIsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the Windows temp directory is already per user")
+	}
+
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	if dir, err := listenLockDir(); err != nil || dir != runtimeDir {
+		t.Fatalf("expected XDG_RUNTIME_DIR to be used, got %q, err %v", dir, err)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	dir, err := listenLockDir()
+	if err != nil {
+		t.Fatalf("listenLockDir: %v", err)
+	}
+	if fi, err := os.Stat(dir); err != nil || filepath.Dir(dir) != tmp || fi.Mode().Perm() != 0o700 {
+		t.Fatalf("expected a private directory in the temp directory, got %q (%v)", dir, err)
+	}
+	// A directory others can write to is refused rather than used.
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if _, err := listenLockDir(); err == nil || !strings.Contains(err.Error(), "private") {
+		t.Fatalf("expected a shared directory to be refused, got: %v", err)
+	}
+}
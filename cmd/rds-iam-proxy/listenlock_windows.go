@@ -0,0 +1,39 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// listenLockDir returns the temp directory, which is per user on Windows.
+func listenLockDir() (string, error) {
+	return os.TempDir(), nil
+}
+
+// tryLockFile takes an exclusive LockFileEx lock on f without waiting. The
+// locked byte lies past the PID, at 4 GiB, so other processes can still
+// read the PID from the file.
+func tryLockFile(f *os.File) error {
+	ol := syscall.Overlapped{OffsetHigh: 1}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		if errors.Is(err, errorLockViolation) {
+			return errLockHeld
+		}
+		return err
+	}
+	return nil
+}
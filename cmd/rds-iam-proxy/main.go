@@ -5,173 +5,605 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"rds-iam-proxy/internal/config"
 	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/serverinternal"
 	"rds-iam-proxy/internal/token"
+	"rds-iam-proxy/rdsiamproxy"
+)
+
+const (
+	// configOrderEnv sets the config search order when --config-search-order
+	// is not passed, for deployments that pin it in the service environment.
+	configOrderEnv = "RDS_IAM_PROXY_CONFIG_ORDER"
+	// clockSkewWarnThreshold is well under SigV4's 5 minute tolerance so skew
+	// is reported before RDS starts rejecting tokens.
+	clockSkewWarnThreshold = time.Minute
+	// exitRecycle tells a supervisor the process stopped on purpose after
+	// --max-uptime and should be restarted (EX_TEMPFAIL).
+	exitRecycle = 75
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "status":
+			os.Exit(runStatus(os.Args[2:], os.Stdout, os.Stderr))
+		case "print-config":
+			os.Exit(runPrintConfig(os.Args[2:], os.Stdout, os.Stderr))
+		case "print-config-schema":
+			os.Exit(runPrintConfigSchema(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+	startedAt := time.Now()
+
 	var (
 		configPath        string
+		configDir         string
 		profileName       string
 		profilesCSV       string
 		allProfiles       bool
 		verbose           bool
 		logLevel          string
 		dryRun            bool
+		dryRunTimeout     time.Duration
+		dryRunContinue    bool
+		outputFormat      string
+		selfTest          bool
 		allowDevEmptyPass bool
+		allowInsecure     bool
 		poolSize          int
 		maxConns          int
 		shutdownTimeout   time.Duration
 		connectTimeout    time.Duration
+		poolSweep         time.Duration
+		poolStarvation    time.Duration
+		poolMaxLife       time.Duration
+		routeByUser       bool
+		pprofAddr         string
+		accessLogPath     string
+		maxUptime         time.Duration
+		tokenCooldown     time.Duration
+		requireBackend    bool
+		checkCA           bool
+		backendStartWait  time.Duration
+		pidFile           string
+		noConfigSearch    bool
+		configSearchOrder string
+		promptTimeout     time.Duration
+		promptDefault     string
+		noInteractive     bool
+		noForceExit       bool
+		isolateProfiles   bool
+		requestIDFormat   string
+		noListenLock      bool
 	)
 
 	flag.StringVar(&configPath, "config", "", "Path to config YAML")
+	flag.StringVar(&configDir, "config-dir", "", "Load and merge every *.yaml file in this directory instead of one config file")
 	flag.StringVar(&profileName, "profile", "", "Profile name from config")
 	flag.StringVar(&profilesCSV, "profiles", "", "Comma-separated profile names to run together")
 	flag.BoolVar(&allProfiles, "all-profiles", false, "Run all configured profiles")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose structured logs")
 	flag.StringVar(&logLevel, "log-level", "info", "Log level: debug|info|warn|error")
 	flag.BoolVar(&dryRun, "dry-run", false, "Generate IAM token metadata and exit")
+	flag.DurationVar(&dryRunTimeout, "dry-run-timeout", 10*time.Second, "How long --dry-run waits for each profile's token and clock-skew check")
+	flag.BoolVar(&dryRunContinue, "dry-run-continue-on-error", false, "Check every profile in --dry-run and report all failures instead of stopping at the first")
+	flag.StringVar(&outputFormat, "output", "text", "Dry-run output format: text|json")
+	flag.BoolVar(&selfTest, "self-test", false, "Build token, connect to each backend, run SELECT 1, report timings and exit")
 	flag.BoolVar(&allowDevEmptyPass, "allow-dev-empty-password", false, "Allow empty proxy_password (dev only)")
+	flag.BoolVar(&allowInsecure, "allow-insecure", false, "Honor insecure_skip_tls_verify in profiles (test environments only)")
 	flag.IntVar(&poolSize, "pool-size", 5, "Number of pre-warmed backend connections")
-	flag.IntVar(&maxConns, "max-conns", 0, "Override max concurrent client connections (default uses profile max_conns or 100)")
+	flag.IntVar(&maxConns, "max-conns", 0, "Override max concurrent client connections (default uses profile max_conns, which defaults to 20; -1 for unlimited)")
 	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Graceful shutdown timeout")
 	flag.DurationVar(&connectTimeout, "connect-timeout", 8*time.Second, "Backend connect timeout")
+	flag.BoolVar(&isolateProfiles, "isolate-profiles", false, "Keep other profiles serving when one profile's listener fails; exit once all have stopped")
+	flag.BoolVar(&routeByUser, "route-by-user", false, "Let selected profiles share a listen_addr; clients are routed by proxy_user")
+	flag.DurationVar(&poolMaxLife, "pool-max-life", 14*time.Minute, "Maximum age of a pooled backend connection; must be below the IAM token TTL")
+	flag.DurationVar(&poolSweep, "pool-sweep-interval", 0, "Health-check idle pooled connections at this interval (0 disables)")
+	flag.DurationVar(&poolStarvation, "pool-starvation-window", time.Minute, "Warn when every backend borrow for this long found the pool empty (0 disables)")
+	flag.StringVar(&pprofAddr, "pprof-addr", "", "Serve pprof and /debug/rds-iam-proxy on this loopback address (off by default)")
+	flag.StringVar(&requestIDFormat, "request-id", proxy.RequestIDCounter, "Connection ID in logs: counter (conn_id only) or uuid (adds a random request_id per connection)")
+	flag.StringVar(&accessLogPath, "access-log", "", "Write one line per completed connection to this file (\"-\" for stdout); reopened on SIGHUP")
+	flag.DurationVar(&maxUptime, "max-uptime", 0, "Gracefully stop and exit with code 75 after running this long, for supervisor-driven recycling (0 disables)")
+	flag.DurationVar(&tokenCooldown, "token-failure-cooldown", 5*time.Second, "After an IAM token build fails, return that error for this long before asking AWS again (0 disables)")
+	flag.BoolVar(&requireBackend, "require-backend-at-start", false, "Exit non-zero unless every profile opens at least one backend connection before listening")
+	flag.BoolVar(&checkCA, "check-ca", false, "At startup, open a TLS session to each backend and log an error unless its certificate chains to ca_bundle")
+	flag.DurationVar(&backendStartWait, "backend-start-timeout", 30*time.Second, "How long --require-backend-at-start waits for the first backend connection")
+	flag.StringVar(&pidFile, "pid-file", "", "Write the process ID to this file while running; startup fails if it names another live process")
+	flag.StringVar(&configSearchOrder, "config-search-order", "", "Comma-separated config.yaml search locations in precedence order, from cwd, exe, home (default cwd,exe,home or $"+configOrderEnv+")")
+	flag.BoolVar(&noConfigSearch, "no-config-search", false, "Only use --config or config.yaml in the working directory; skip parent, executable and home fallbacks")
+	flag.DurationVar(&promptTimeout, "prompt-timeout", 30*time.Second, "How long the interactive profile menu waits for each answer (0 waits forever)")
+	flag.StringVar(&promptDefault, "prompt-default", promptDefaultError, "What to do when the interactive menu times out: error|all")
+	flag.BoolVar(&noInteractive, "no-interactive", false, "Never show the interactive profile menu, even on a TTY; multiple profiles then need --profile, --profiles or --all-profiles")
+	flag.BoolVar(&noListenLock, "no-listen-lock", false, "Skip the per-listen_addr lock file that makes a second process on the same address fail early")
+	flag.BoolVar(&noForceExit, "no-force-exit", false, "Ignore a second SIGINT/SIGTERM during shutdown instead of exiting immediately")
 	flag.Parse()
 
-	logger := newLogger(logLevel, verbose)
+	// Keep stdout for the JSON document so scripts can parse it directly.
+	logOut := io.Writer(os.Stdout)
+	if outputFormat == "json" {
+		logOut = os.Stderr
+	}
+	logger := newLoggerWithWriter(logLevel, verbose, logOut)
+
+	if outputFormat != "text" && outputFormat != "json" {
+		logger.Error("invalid output format", "output", outputFormat)
+		os.Exit(1)
+	}
+	if promptDefault != promptDefaultError && promptDefault != promptDefaultAll {
+		logger.Error("invalid prompt default", "prompt_default", promptDefault)
+		os.Exit(1)
+	}
+	if !proxy.ValidRequestIDFormat(requestIDFormat) {
+		logger.Error("invalid request id format", "request_id", requestIDFormat)
+		os.Exit(1)
+	}
+	if promptTimeout < 0 {
+		logger.Error("invalid prompt timeout", "prompt_timeout", promptTimeout)
+		os.Exit(1)
+	}
+	if dryRunTimeout <= 0 {
+		logger.Error("invalid dry-run timeout", "dry_run_timeout", dryRunTimeout)
+		os.Exit(1)
+	}
+	if outputFormat == "json" && !dryRun {
+		logger.Error("flags conflict: --output json requires --dry-run")
+		os.Exit(1)
+	}
+	if dryRunContinue && !dryRun {
+		logger.Error("flags conflict: --dry-run-continue-on-error requires --dry-run")
+		os.Exit(1)
+	}
 
+	if maxConns < config.MaxConnsUnlimited {
+		logger.Error("invalid max-conns override; use -1 for unlimited", "max_conns", maxConns)
+		os.Exit(1)
+	}
 	if maxConns > config.MaxConnsHardLimit() {
 		logger.Error("max-conns override too high", "max_conns", maxConns, "hard_limit", config.MaxConnsHardLimit())
 		os.Exit(1)
 	}
+	if err := validatePoolMaxLife(poolMaxLife, rdsiamproxy.TokenTTL); err != nil {
+		logger.Error("invalid pool-max-life", "error", err)
+		os.Exit(1)
+	}
+	if requireBackend && backendStartWait <= 0 {
+		logger.Error("invalid backend-start-timeout", "backend_start_timeout", backendStartWait)
+		os.Exit(1)
+	}
+	if tokenCooldown < 0 {
+		logger.Error("invalid token-failure-cooldown", "token_failure_cooldown", tokenCooldown)
+		os.Exit(1)
+	}
+	if maxUptime < 0 {
+		logger.Error("invalid max-uptime", "max_uptime", maxUptime)
+		os.Exit(1)
+	}
+	if dryRun && selfTest {
+		logger.Error("flags conflict: use only one of --dry-run or --self-test")
+		os.Exit(1)
+	}
 	if countProvided(profileName, profilesCSV, allProfiles) > 1 {
 		logger.Error("flags conflict: use only one of --profile, --profiles, or --all-profiles")
 		os.Exit(1)
 	}
 
-	cfgResolution, err := config.ResolveConfigPathDetailed(configPath)
-	if err != nil {
-		logger.Error("resolve config", "error", err)
+	if noConfigSearch && configSearchOrder != "" {
+		logger.Error("flags conflict: use only one of --no-config-search or --config-search-order")
 		os.Exit(1)
 	}
-	cfgPath := cfgResolution.Path
-	logger.Info("config resolved", "path", cfgPath, "source", cfgResolution.Source)
-	for _, checked := range cfgResolution.Checked {
-		logger.Debug("config lookup checked", "path", checked)
+	if configDir != "" && (configPath != "" || noConfigSearch || configSearchOrder != "") {
+		logger.Error("flags conflict: --config-dir cannot be combined with --config, --no-config-search or --config-search-order")
+		os.Exit(1)
 	}
+	var cfg *config.Config
+	if configDir != "" {
+		var err error
+		cfg, err = config.LoadDir(configDir)
+		if err != nil {
+			logger.Error("load config", append([]any{"error", err, "config_dir", configDir}, profileAttr(err)...)...)
+			os.Exit(1)
+		}
+		logger.Info("config resolved", "config_dir", configDir, "profiles", len(cfg.Profiles))
+	} else {
+		resolveConfig := config.ResolveConfigPathDetailed
+		switch {
+		case noConfigSearch:
+			resolveConfig = config.ResolveConfigPathInCwd
+		case configSearchOrder != "" || os.Getenv(configOrderEnv) != "":
+			raw := configSearchOrder
+			if raw == "" {
+				raw = os.Getenv(configOrderEnv)
+			}
+			order, err := config.ParseConfigSearchOrder(raw)
+			if err != nil {
+				logger.Error("invalid config search order", "error", err)
+				os.Exit(1)
+			}
+			resolveConfig = func(path string) (config.ConfigResolution, error) {
+				return config.ResolveConfigPathInOrder(path, order)
+			}
+		}
+		cfgResolution, err := resolveConfig(configPath)
+		if err != nil {
+			logger.Error("resolve config", "error", err)
+			os.Exit(1)
+		}
+		cfgPath := cfgResolution.Path
+		logger.Info("config resolved", "path", cfgPath, "source", cfgResolution.Source)
+		for _, checked := range cfgResolution.Checked {
+			logger.Debug("config lookup checked", "path", checked)
+		}
 
-	cfg, err := config.Load(cfgPath)
-	if err != nil {
-		logger.Error("load config", "error", err, "path", cfgPath)
-		os.Exit(1)
+		cfg, err = config.Load(cfgPath)
+		if err != nil {
+			logger.Error("load config", append([]any{"error", err, "path", cfgPath}, profileAttr(err)...)...)
+			os.Exit(1)
+		}
 	}
 
-	selected, err := resolveSelectedProfiles(cfg, profileName, profilesCSV, allProfiles)
+	selected, err := resolveSelectedProfiles(cfg, profileName, profilesCSV, allProfiles, promptOptions{timeout: promptTimeout, onTimeout: promptDefault, disabled: noInteractive})
 	if err != nil {
-		logger.Error("select profiles", "error", err)
+		logger.Error("select profiles", append([]any{"error", err}, profileAttr(err)...)...)
 		os.Exit(1)
 	}
-	if err := validateUniqueListenAddrs(selected); err != nil {
-		logger.Error("listen address validation failed", "error", err)
-		os.Exit(1)
+	if !routeByUser {
+		if err := rdsiamproxy.ValidateUniqueListenAddrs(selected); err != nil {
+			logger.Error("listen address validation failed", append([]any{"error", err}, profileAttr(err)...)...)
+			os.Exit(1)
+		}
 	}
 
 	for _, prof := range selected {
-		if err := prof.ValidateRuntime(allowDevEmptyPass); err != nil {
+		if err := prof.ValidateRuntime(allowDevEmptyPass, allowInsecure); err != nil {
 			logger.Error("profile validation failed", "profile", prof.Name, "error", err)
 			os.Exit(1)
 		}
+		if prof.InsecureSkipTLSVerify {
+			logger.Warn("INSECURE: backend TLS certificates are not verified; use only against test databases",
+				"profile", prof.Name, "rds_host", strings.Join(prof.BackendHosts(), ","))
+		}
 	}
+	warnCommonProxyUsers(logger, selected)
 
-	tokenCache := token.New(5*time.Minute, 15*time.Minute)
+	// log_level gives a profile its own handler; the others share logger.
+	profileLogger := func(p config.Profile) *slog.Logger {
+		if p.LogLevel == "" || p.LogLevel == logLevel {
+			return logger
+		}
+		return newLoggerWithWriter(p.LogLevel, verbose, logOut)
+	}
+
+	tokenCache := token.New(rdsiamproxy.TokenRefreshBefore, rdsiamproxy.TokenTTL)
+	tokenCache.SetFailureCooldown(tokenCooldown)
 
 	if dryRun {
-		runDryRun(logger, tokenCache, selected)
+		runDryRun(logger, tokenCache, selected, outputFormat, dryRunTimeout, dryRunContinue)
 		return
 	}
+	if selfTest {
+		runSelfTest(logger, tokenCache, selected, connectTimeout)
+		return
+	}
+
+	// removeRunFiles deletes the pid file and releases the listen locks;
+	// os.Exit paths call it because they skip deferred cleanup.
+	removePIDFile, releaseListenLocks := func() {}, func() {}
+	removeRunFiles := func() {
+		releaseListenLocks()
+		removePIDFile()
+	}
+	defer removeRunFiles()
+	if pidFile != "" {
+		removePIDFile, err = writePIDFile(pidFile)
+		if err != nil {
+			logger.Error("pid file init failed", "error", err)
+			os.Exit(1)
+		}
+	}
+	if !noListenLock {
+		var dir string
+		dir, err = listenLockDir()
+		if err == nil {
+			releaseListenLocks, err = lockListenAddrs(dir, selected)
+		}
+		if err != nil {
+			logger.Error("listen address lock failed", append([]any{"error", err}, profileAttr(err)...)...)
+			removeRunFiles()
+			os.Exit(1)
+		}
+	}
+
+	srv, err := rdsiamproxy.New(selected, rdsiamproxy.Options{
+		Logger:                logger,
+		PoolSize:              poolSize,
+		PoolMaxLife:           poolMaxLife,
+		PoolSweepInterval:     poolSweep,
+		PoolStarvationWindow:  poolStarvation,
+		ConnectTimeout:        connectTimeout,
+		ShutdownTimeout:       shutdownTimeout,
+		MaxConns:              maxConns,
+		RouteByUser:           routeByUser,
+		IsolateProfiles:       isolateProfiles,
+		TokenCacheFile:        cfg.TokenCacheFile,
+		RequestIDFormat:       requestIDFormat,
+		AccessLogPath:         accessLogPath,
+		TokenFailureCooldown:  tokenCooldown,
+		AllowDevEmptyPassword: allowDevEmptyPass,
+		AllowInsecure:         allowInsecure,
+		ProfileLogger:         profileLogger,
+	})
+	if err != nil {
+		logger.Error("proxy init failed", append([]any{"error", err}, profileAttr(err)...)...)
+		removeRunFiles()
+		os.Exit(1)
+	}
+
+	logProfileSummary(logger, selected, maxConns)
+	internals := serverinternal.Of(srv)
 
-	ctx, stop := signalContext()
+	ctx, stop := signalContext(!noForceExit)
 	defer stop()
 
-	var (
-		wg    sync.WaitGroup
-		errCh = make(chan error, len(selected))
-	)
+	onSignal(ctx, reopenSignals, func() {
+		if accessLogPath != "" {
+			if err := srv.ReopenAccessLog(); err != nil {
+				logger.Error("access log reopen failed", "error", err)
+			} else {
+				logger.Info("access log reopened", "path", accessLogPath)
+			}
+		}
+	})
+	onSignal(ctx, refreshSignals, func() {
+		logger.Info("backend pools refreshed", "trigger", "signal", "pools", srv.RefreshBackends())
+	})
+
 	for _, prof := range selected {
-		current := prof
-		backendFactory, err := proxy.NewBackendFactory(current, tokenCache, connectTimeout)
-		if err != nil {
-			logger.Error("backend factory init failed", "profile", current.Name, "error", err)
+		go func(p config.Profile) {
+			checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			plog := profileLogger(p)
+			checkRDSHost(checkCtx, plog, net.DefaultResolver, p)
+			checkClockSkew(checkCtx, plog, internals.TokenCache, p)
+			if checkCA {
+				checkBackendCA(checkCtx, plog, p, connectTimeout)
+			}
+		}(prof)
+	}
+
+	srv.Start(ctx)
+	if requireBackend {
+		if err := srv.WaitReady(ctx, backendStartWait); err != nil {
+			logger.Error("backend not reachable at start", "error", err)
+			removeRunFiles()
 			os.Exit(1)
 		}
-		pool := proxy.NewBackendPool(poolSize, 14*time.Minute, connectTimeout, logger.With("profile", current.Name), backendFactory.NewConn)
-		pool.Start(ctx)
+	}
 
-		resolvedMaxConns := current.MaxConns
-		if maxConns > 0 {
-			resolvedMaxConns = maxConns
+	if pprofAddr != "" {
+		if err := startDebugServer(ctx, logger, pprofAddr, selected, internals.Proxies, internals.TokenCache, srv.RefreshBackends); err != nil {
+			logger.Error("debug server failed", "error", err)
+			removeRunFiles()
+			os.Exit(1)
 		}
-		instance := proxy.New(current, logger.With("profile", current.Name), pool, shutdownTimeout, resolvedMaxConns)
+	}
 
-		wg.Add(1)
-		go func(pf config.Profile, px *proxy.Proxy) {
-			defer wg.Done()
-			if err := px.Run(ctx); err != nil {
-				errCh <- fmt.Errorf("profile %s: %w", pf.Name, err)
-				stop()
-			}
-		}(current, instance)
+	if cfg.ControlSocket != "" {
+		if err := startControlServer(ctx, logger, cfg.ControlSocket, startedAt, selected, internals.Proxies, internals.TokenCache); err != nil {
+			logger.Error("control socket failed", "error", err)
+			removeRunFiles()
+			os.Exit(1)
+		}
 	}
 
-	done := make(chan struct{})
+	onSignal(ctx, snapshotSignals, func() { logSnapshots(logger, internals.Proxies) })
+	recycled, cancelRecycle := scheduleMaxUptime(logger, maxUptime, stop)
+	defer cancelRecycle()
+
+	// One event for supervisors that wait on readiness, after every
+	// "proxy listening" line.
 	go func() {
-		defer close(done)
-		wg.Wait()
+		if addrs, err := srv.WaitListening(ctx); err == nil {
+			logger.Info("all profiles ready", "count", len(selected), "listen_addrs", strings.Join(addrs, ","))
+		}
 	}()
 
-	select {
-	case err := <-errCh:
+	if err := srv.Run(ctx); err != nil {
 		logger.Error("proxy stopped with error", "error", err)
+		removeRunFiles()
 		os.Exit(1)
-	case <-done:
+	}
+	if recycled() {
+		removeRunFiles()
+		os.Exit(exitRecycle)
+	}
+}
+
+// logProfileSummary logs the routing map of a multi-profile run, one line per
+// profile in selection order, before any listener starts. A single profile
+// is already described by its "proxy listening" line.
+func logProfileSummary(logger *slog.Logger, profiles []config.Profile, maxConnsOverride int) {
+	if len(profiles) < 2 {
 		return
 	}
+	logger.Info("starting profiles", "count", len(profiles))
+	for _, p := range profiles {
+		maxConns := p.MaxConns
+		if maxConnsOverride != 0 {
+			maxConns = maxConnsOverride
+		}
+		logger.Info("profile summary",
+			"profile", p.Name,
+			"listen_addr", p.ListenAddr,
+			"rds_endpoint", rdsEndpoints(p),
+			"region", p.RDSRegion,
+			"max_conns", maxConns,
+		)
+	}
+}
+
+// commonDBUsers are real database usernames; a proxy_user matching one
+// suggests the local proxy credential was mixed up with the RDS one.
+var commonDBUsers = []string{"root", "admin", "app"}
+
+// warnCommonProxyUsers logs a warning for each profile whose proxy_user is a
+// common database username. It never fails startup.
+func warnCommonProxyUsers(logger *slog.Logger, profiles []config.Profile) {
+	for _, p := range profiles {
+		for _, name := range commonDBUsers {
+			if strings.EqualFold(p.ProxyUser, name) {
+				logger.Warn("proxy_user looks like a real database username; it is only the local credential clients use to reach the proxy",
+					"profile", p.Name, "proxy_user", p.ProxyUser)
+				break
+			}
+		}
+	}
+}
+
+// rdsEndpoints lists p's backend host:port pairs, comma-separated.
+func rdsEndpoints(p config.Profile) string {
+	hosts := p.BackendHosts()
+	endpoints := make([]string, len(hosts))
+	for i, host := range hosts {
+		endpoints[i] = net.JoinHostPort(host, strconv.Itoa(p.RDSPort))
+	}
+	return strings.Join(endpoints, ",")
+}
+
+// scheduleMaxUptime triggers the graceful shutdown path via stop once d has
+// elapsed. recycled reports whether that happened; cancel disarms the timer.
+func scheduleMaxUptime(logger *slog.Logger, d time.Duration, stop func()) (recycled func() bool, cancel func()) {
+	var fired atomic.Bool
+	if d <= 0 {
+		return fired.Load, func() {}
+	}
+	logger.Info("max uptime recycle scheduled", "max_uptime", d.String(), "recycle_at", time.Now().Add(d).Format(time.RFC3339))
+	timer := time.AfterFunc(d, func() {
+		fired.Store(true)
+		logger.Warn("max uptime reached; starting graceful shutdown for recycle", "max_uptime", d.String(), "exit_code", exitRecycle)
+		stop()
+	})
+	return fired.Load, func() { timer.Stop() }
+}
+
+// dryRunEntry is one profile in --dry-run --output json. A failed profile
+// (with --dry-run-continue-on-error) carries only Profile, Region and Error.
+type dryRunEntry struct {
+	Profile           string    `json:"profile"`
+	TokenLen          int       `json:"token_len,omitempty"`
+	TokenSHA256Prefix string    `json:"token_sha256_prefix,omitempty"`
+	ExpiresAt         time.Time `json:"expires_at,omitzero"`
+	Region            string    `json:"region"`
+	Error             string    `json:"error,omitempty"`
 }
 
-func runDryRun(logger *slog.Logger, cache *token.Cache, profiles []config.Profile) {
+// runDryRun builds a token for each profile and prints its metadata. The
+// first failure exits the process unless continueOnError is set, in which
+// case every profile is reported and the exit is non-zero once all ran.
+func runDryRun(logger *slog.Logger, cache *token.Cache, profiles []config.Profile, format string, timeout time.Duration, continueOnError bool) {
+	entries := make([]dryRunEntry, 0, len(profiles))
+	failed := 0
 	for _, p := range profiles {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		tok, err := cache.Get(ctx, p)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		checkRDSHost(ctx, logger, net.DefaultResolver, p)
+		// Tokens for rds_hosts differ only in the signed hostname; one proves
+		// the credentials.
+		tok, timing, err := cache.GetWithTiming(ctx, p.ForHost(p.BackendHosts()[0]))
 		cancel()
 		if err != nil {
-			logger.Error("dry-run failed", "profile", p.Name, "error", err)
-			os.Exit(1)
+			logger.Error("dry-run failed", "profile", p.Name, "error", err,
+				"aws_config_ms", timing.ConfigLoad.Milliseconds(), "build_ms", timing.Build.Milliseconds())
+			if !continueOnError {
+				os.Exit(1)
+			}
+			failed++
+			if format == "json" {
+				entries = append(entries, dryRunEntry{Profile: p.Name, Region: p.RDSRegion, Error: err.Error()})
+			} else {
+				fmt.Printf("profile=%s status=failed error=%q\n", p.Name, err.Error())
+			}
+			continue
 		}
 
 		sum := sha256.Sum256([]byte(tok.Value))
-		fmt.Printf("profile=%s token_len=%d token_sha256_prefix=%s expires_at=%s\n",
-			p.Name,
-			len(tok.Value),
-			hex.EncodeToString(sum[:])[:12],
+		entry := dryRunEntry{
+			Profile:           p.Name,
+			TokenLen:          len(tok.Value),
+			TokenSHA256Prefix: hex.EncodeToString(sum[:])[:12],
+			ExpiresAt:         tok.ExpiresAt.UTC(),
+			Region:            p.RDSRegion,
+		}
+		if format == "json" {
+			entries = append(entries, entry)
+			continue
+		}
+
+		skewCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		skewText := "unknown"
+		if skew, ok := checkClockSkew(skewCtx, logger, cache, p); ok {
+			skewText = strconv.FormatInt(skew.Milliseconds(), 10)
+		}
+		cancel()
+
+		fmt.Printf("profile=%s status=ok token_len=%d token_sha256_prefix=%s expires_at=%s aws_config_ms=%d build_ms=%d total_ms=%d clock_skew_ms=%s\n",
+			entry.Profile,
+			entry.TokenLen,
+			entry.TokenSHA256Prefix,
 			tok.ExpiresAt.Format(time.RFC3339),
+			timing.ConfigLoad.Milliseconds(),
+			timing.Build.Milliseconds(),
+			timing.Total().Milliseconds(),
+			skewText,
 		)
 	}
+	if format == "json" {
+		if err := writeDryRunJSON(os.Stdout, entries); err != nil {
+			logger.Error("write dry-run output", "error", err)
+			os.Exit(1)
+		}
+	}
+	if failed > 0 {
+		logger.Error("dry-run finished with failures", "failed", failed, "profiles", len(profiles))
+		os.Exit(1)
+	}
+}
+
+func writeDryRunJSON(w io.Writer, entries []dryRunEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
 }
 
-func resolveSelectedProfiles(cfg *config.Config, profileName, profilesCSV string, allProfiles bool) ([]config.Profile, error) {
+// checkClockSkew measures host clock skew against STS and warns when it exceeds
+// clockSkewWarnThreshold. ok is false when skew could not be measured.
+func checkClockSkew(ctx context.Context, logger *slog.Logger, cache *token.Cache, p config.Profile) (time.Duration, bool) {
+	skew, err := cache.ClockSkew(ctx, p)
+	if err != nil {
+		logger.Debug("clock skew check unavailable", "profile", p.Name, "error", err)
+		return 0, false
+	}
+	if skew.Abs() > clockSkewWarnThreshold {
+		logger.Warn("local clock skew detected; IAM tokens may be rejected",
+			"profile", p.Name, "skew_ms", skew.Milliseconds(), "threshold_ms", clockSkewWarnThreshold.Milliseconds())
+	}
+	return skew, true
+}
+
+func resolveSelectedProfiles(cfg *config.Config, profileName, profilesCSV string, allProfiles bool, prompt promptOptions) ([]config.Profile, error) {
 	switch {
 	case profileName != "":
 		p, err := config.SelectProfile(cfg, profileName)
@@ -191,28 +623,117 @@ func resolveSelectedProfiles(cfg *config.Config, profileName, profilesCSV string
 		if len(cfg.Profiles) == 1 {
 			return cloneProfiles(cfg.Profiles), nil
 		}
-		if !isInteractiveTerminal() {
+		if prompt.disabled || !isInteractiveTerminal() {
 			return nil, errors.New("multiple profiles configured; pass --profile, --profiles, or --all-profiles")
 		}
-		return interactiveSelectProfiles(cfg.Profiles)
+		selected, err := interactiveSelectProfiles(newPromptReader(os.Stdin, prompt.timeout), cfg.Profiles)
+		if errors.Is(err, errPromptTimeout) {
+			if prompt.onTimeout == promptDefaultAll {
+				fmt.Printf("\nNo answer within %s; running all profiles.\n", prompt.timeout)
+				return cloneProfiles(cfg.Profiles), nil
+			}
+			return nil, fmt.Errorf("no answer within --prompt-timeout %s; pass --profile, --profiles, or --all-profiles", prompt.timeout)
+		}
+		return selected, err
 	}
 }
 
-func interactiveSelectProfiles(profiles []config.Profile) ([]config.Profile, error) {
-	reader := bufio.NewReader(os.Stdin)
+const (
+	promptDefaultError = "error"
+	promptDefaultAll   = "all"
+	// maxPromptAttempts bounds reprompts after invalid answers.
+	maxPromptAttempts = 5
+)
+
+// promptOptions are the --prompt-timeout, --prompt-default and
+// --no-interactive settings.
+type promptOptions struct {
+	timeout   time.Duration
+	onTimeout string
+	disabled  bool // --no-interactive: never prompt, whatever the TTY looks like
+}
+
+var errPromptTimeout = errors.New("prompt timed out")
+
+// promptReader reads answer lines with a per-answer timeout, so a supervisor
+// that gives the process a TTY but never types does not hang startup. Its
+// reading goroutine stays blocked on input after the menu is done; nothing
+// else reads stdin.
+type promptReader struct {
+	lines   chan promptLine
+	timeout time.Duration
+	err     error // sticky read error once input ended
+}
+
+type promptLine struct {
+	text string
+	err  error
+}
+
+func newPromptReader(in io.Reader, timeout time.Duration) *promptReader {
+	pr := &promptReader{lines: make(chan promptLine, 1), timeout: timeout}
+	go func() {
+		br := bufio.NewReader(in)
+		for {
+			text, err := br.ReadString('\n')
+			pr.lines <- promptLine{text: text, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// readLine returns the next answer, errPromptTimeout when none arrives within
+// the timeout (0 waits forever), or the error that ended input.
+func (pr *promptReader) readLine() (string, error) {
+	if pr.err != nil {
+		return "", pr.err
+	}
+	var expired <-chan time.Time
+	if pr.timeout > 0 {
+		t := time.NewTimer(pr.timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case l := <-pr.lines:
+		if l.err != nil {
+			pr.err = l.err
+		}
+		return l.text, l.err
+	case <-expired:
+		return "", errPromptTimeout
+	}
+}
+
+// interactiveSelectProfiles asks which profiles to run. Invalid answers are
+// reported and asked again, up to maxPromptAttempts times per question.
+func interactiveSelectProfiles(pr *promptReader, profiles []config.Profile) ([]config.Profile, error) {
 	fmt.Println("Select startup mode:")
 	fmt.Println("  1) Run one profile")
 	fmt.Println("  2) Run multiple profiles")
 	fmt.Println("  3) Run all profiles")
-	fmt.Print("Choice [1/2/3]: ")
 
-	choiceRaw, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("read choice: %w", err)
-	}
-	choice := strings.TrimSpace(choiceRaw)
-	if choice == "" {
-		choice = "1"
+	var choice string
+	for attempt := 1; ; attempt++ {
+		fmt.Print("Choice [1/2/3]: ")
+		choiceRaw, err := pr.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("read choice: %w", err)
+		}
+		choice = strings.TrimSpace(choiceRaw)
+		if choice == "" {
+			choice = "1"
+		}
+		if choice == "1" || choice == "2" || choice == "3" {
+			break
+		}
+		if attempt == maxPromptAttempts {
+			return nil, errors.New("invalid choice; expected 1, 2, or 3")
+		}
+		fmt.Printf("%q is not a choice; enter 1, 2, or 3.\n", choice)
 	}
 
 	fmt.Println("Available profiles:")
@@ -222,54 +743,138 @@ func interactiveSelectProfiles(profiles []config.Profile) ([]config.Profile, err
 
 	switch choice {
 	case "1":
-		fmt.Print("Select profile number: ")
-		raw, err := reader.ReadString('\n')
+		idxs, err := promptProfileSelection(pr, profiles, "Select profile number or name: ", true)
 		if err != nil {
-			return nil, fmt.Errorf("read profile number: %w", err)
-		}
-		idx, err := strconv.Atoi(strings.TrimSpace(raw))
-		if err != nil || idx < 1 || idx > len(profiles) {
-			return nil, errors.New("invalid profile selection")
+			return nil, err
 		}
-		return []config.Profile{profiles[idx-1]}, nil
+		return []config.Profile{profiles[idxs[0]]}, nil
 	case "2":
-		fmt.Print("Select profile numbers (comma-separated, e.g. 1,3): ")
-		raw, err := reader.ReadString('\n')
+		idxs, err := promptProfileSelection(pr, profiles, "Select profile numbers or names (comma-separated, e.g. 1,3): ", false)
 		if err != nil {
-			return nil, fmt.Errorf("read profile list: %w", err)
-		}
-		parts := splitCSV(raw)
-		if len(parts) == 0 {
-			return nil, errors.New("no profiles selected")
-		}
-		seen := map[int]struct{}{}
-		out := make([]config.Profile, 0, len(parts))
-		for _, part := range parts {
-			idx, err := strconv.Atoi(part)
-			if err != nil || idx < 1 || idx > len(profiles) {
-				return nil, fmt.Errorf("invalid profile index: %s", part)
-			}
-			if _, ok := seen[idx]; ok {
-				continue
-			}
-			seen[idx] = struct{}{}
-			out = append(out, profiles[idx-1])
+			return nil, err
+		}
+		out := make([]config.Profile, 0, len(idxs))
+		for _, idx := range idxs {
+			out = append(out, profiles[idx])
 		}
 		return out, nil
-	case "3":
-		return cloneProfiles(profiles), nil
 	default:
-		return nil, errors.New("invalid choice; expected 1, 2, or 3")
+		return cloneProfiles(profiles), nil
 	}
 }
 
-func validateUniqueListenAddrs(profiles []config.Profile) error {
-	seen := map[string]string{}
-	for _, p := range profiles {
-		if prev, ok := seen[p.ListenAddr]; ok {
-			return fmt.Errorf("listen_addr %q is reused by profiles %q and %q", p.ListenAddr, prev, p.Name)
+// promptProfileSelection reads a comma-separated list of profile numbers,
+// names or name prefixes and returns the distinct selected indices in input
+// order; single requires exactly one. An ambiguous prefix lists its matches
+// and an invalid answer is reported, then it prompts again.
+func promptProfileSelection(pr *promptReader, profiles []config.Profile, prompt string, single bool) ([]int, error) {
+	for attempt := 1; ; attempt++ {
+		fmt.Print(prompt)
+		raw, err := pr.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("read profile selection: %w", err)
+		}
+		idxs, err := resolveProfileInputs(profiles, splitCSV(raw))
+		switch {
+		case err != nil:
+		case len(idxs) == 0:
+			err = errors.New("no profiles selected")
+		case single && len(idxs) != 1:
+			err = errors.New("invalid profile selection; expected a single profile")
+		default:
+			return idxs, nil
+		}
+		if attempt == maxPromptAttempts {
+			return nil, err
+		}
+		var ambiguous *ambiguousProfileError
+		if errors.As(err, &ambiguous) {
+			fmt.Printf("%q matches %s; type more of the name.\n", ambiguous.input, strings.Join(ambiguous.matches, ", "))
+			continue
 		}
-		seen[p.ListenAddr] = p.Name
+		fmt.Printf("%v; try again.\n", err)
+	}
+}
+
+type ambiguousProfileError struct {
+	input   string
+	matches []string
+}
+
+func (e *ambiguousProfileError) Error() string {
+	return fmt.Sprintf("profile %q is ambiguous: matches %s", e.input, strings.Join(e.matches, ", "))
+}
+
+func resolveProfileInputs(profiles []config.Profile, inputs []string) ([]int, error) {
+	seen := map[int]struct{}{}
+	out := make([]int, 0, len(inputs))
+	for _, input := range inputs {
+		idx, err := resolveProfileInput(profiles, input)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[idx]; ok {
+			continue
+		}
+		seen[idx] = struct{}{}
+		out = append(out, idx)
+	}
+	return out, nil
+}
+
+// resolveProfileInput maps a 1-based number, an exact profile name or a
+// unique case-insensitive name prefix to a profile index.
+func resolveProfileInput(profiles []config.Profile, input string) (int, error) {
+	if n, err := strconv.Atoi(input); err == nil {
+		if n < 1 || n > len(profiles) {
+			return 0, fmt.Errorf("invalid profile index: %s", input)
+		}
+		return n - 1, nil
+	}
+	for i, p := range profiles {
+		if p.Name == input {
+			return i, nil
+		}
+	}
+	var matches []int
+	lower := strings.ToLower(input)
+	for i, p := range profiles {
+		if strings.HasPrefix(strings.ToLower(p.Name), lower) {
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("profile %q not found", input)
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, idx := range matches {
+			names[i] = profiles[idx].Name
+		}
+		return 0, &ambiguousProfileError{input: input, matches: names}
+	}
+}
+
+// validatePoolMaxLife keeps pooled connections from outliving the IAM token
+// window they were authenticated in.
+func validatePoolMaxLife(maxLife, ttl time.Duration) error {
+	if maxLife <= 0 {
+		return fmt.Errorf("must be > 0, got %s", maxLife)
+	}
+	if maxLife >= ttl {
+		return fmt.Errorf("%s must be less than token ttl %s", maxLife, ttl)
+	}
+	return nil
+}
+
+// profileAttr returns a "profile" log attribute when err belongs to one
+// profile, so early startup errors filter like per-profile runtime logs.
+func profileAttr(err error) []any {
+	var pe *config.ProfileError
+	if errors.As(err, &pe) {
+		return []any{"profile", pe.Profile}
 	}
 	return nil
 }
@@ -287,7 +892,7 @@ func selectByNames(cfg *config.Config, names []string) ([]config.Profile, error)
 		}
 		p, ok := index[name]
 		if !ok {
-			return nil, fmt.Errorf("profile %q not found", name)
+			return nil, &config.ProfileError{Profile: name, Err: errors.New("not found")}
 		}
 		seen[name] = struct{}{}
 		out = append(out, p)
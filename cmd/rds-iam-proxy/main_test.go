@@ -3,11 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"strings"
 	"testing"
+	"time"
 
 	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/rdsiamproxy"
 )
 
 func TestSplitCSV(t *testing.T) {
@@ -40,7 +46,7 @@ func TestResolveSelectedProfilesByCSV(t *testing.T) {
 		},
 	}
 
-	selected, err := resolveSelectedProfiles(cfg, "", "p1,p2", false)
+	selected, err := resolveSelectedProfiles(cfg, "", "p1,p2", false, promptOptions{})
 	if err != nil {
 		t.Fatalf("resolveSelectedProfiles: %v", err)
 	}
@@ -59,7 +65,7 @@ func TestResolveSelectedProfilesAll(t *testing.T) {
 		},
 	}
 
-	selected, err := resolveSelectedProfiles(cfg, "", "", true)
+	selected, err := resolveSelectedProfiles(cfg, "", "", true, promptOptions{})
 	if err != nil {
 		t.Fatalf("resolveSelectedProfiles: %v", err)
 	}
@@ -68,18 +74,37 @@ func TestResolveSelectedProfilesAll(t *testing.T) {
 	}
 }
 
-func TestValidateUniqueListenAddrs(t *testing.T) {
+func TestResolveSelectedProfilesNoInteractive(t *testing.T) {
 	t.Parallel()
 
-	err := validateUniqueListenAddrs([]config.Profile{
-		{Name: "p1", ListenAddr: "127.0.0.1:3307"},
-		{Name: "p2", ListenAddr: "127.0.0.1:3307"},
-	})
-	if err == nil {
-		t.Fatal("expected duplicate listen address error")
+	cfg := &config.Config{
+		Profiles: []config.Profile{
+			{Name: "p1"},
+			{Name: "p2"},
+		},
+	}
+
+	_, err := resolveSelectedProfiles(cfg, "", "", false, promptOptions{disabled: true})
+	if err == nil || !strings.Contains(err.Error(), "--all-profiles") {
+		t.Fatalf("expected a selection error instead of a prompt, got: %v", err)
+	}
+	selected, err := resolveSelectedProfiles(&config.Config{Profiles: cfg.Profiles[:1]}, "", "", false, promptOptions{disabled: true})
+	if err != nil || len(selected) != 1 {
+		t.Fatalf("expected the single profile to be selected, got %v, %v", selected, err)
+	}
+}
+
+func TestValidatePoolMaxLife(t *testing.T) {
+	t.Parallel()
+
+	ttl := 15 * time.Minute
+	if err := validatePoolMaxLife(14*time.Minute, ttl); err != nil {
+		t.Fatalf("expected 14m to be valid, got: %v", err)
 	}
-	if !strings.Contains(err.Error(), "reused") {
-		t.Fatalf("unexpected error: %v", err)
+	for _, bad := range []time.Duration{0, -time.Minute, 15 * time.Minute, 20 * time.Minute} {
+		if err := validatePoolMaxLife(bad, ttl); err == nil {
+			t.Fatalf("expected %s to be rejected", bad)
+		}
 	}
 }
 
@@ -123,3 +148,261 @@ func TestNewLoggerVerboseModeIncludesLevelAndSource(t *testing.T) {
 		t.Fatalf("expected source in verbose output, got: %s", out)
 	}
 }
+
+func TestScheduleMaxUptimeStopsAndReportsRecycle(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	logger := newLoggerWithWriter("info", false, &out)
+	stopped := make(chan struct{})
+	recycled, cancel := scheduleMaxUptime(logger, 20*time.Millisecond, func() { close(stopped) })
+	defer cancel()
+
+	if recycled() {
+		t.Fatal("recycle reported before max uptime")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop was not called after max uptime")
+	}
+	if !recycled() {
+		t.Fatal("expected recycle to be reported")
+	}
+	if !strings.Contains(out.String(), "max uptime recycle scheduled") {
+		t.Fatalf("expected planned recycle log, got: %s", out.String())
+	}
+
+	disabled, cancelDisabled := scheduleMaxUptime(logger, 0, func() { t.Error("stop called with max uptime disabled") })
+	cancelDisabled()
+	if disabled() {
+		t.Fatal("expected no recycle when disabled")
+	}
+}
+
+func TestResolveProfileInput(t *testing.T) {
+	t.Parallel()
+
+	profiles := []config.Profile{{Name: "prod-reporting"}, {Name: "prod-billing"}, {Name: "dev"}, {Name: "dev-eu"}}
+	cases := []struct {
+		input   string
+		want    int
+		wantErr string
+	}{
+		{input: "2", want: 1},
+		{input: "dev", want: 2}, // exact name wins over being a prefix of dev-eu
+		{input: "prod-r", want: 0},
+		{input: "PROD-B", want: 1},
+		{input: "dev-", want: 3},
+		{input: "5", wantErr: "invalid profile index"},
+		{input: "staging", wantErr: "not found"},
+		{input: "prod", wantErr: "ambiguous"},
+	}
+	for _, tc := range cases {
+		got, err := resolveProfileInput(profiles, tc.input)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("resolveProfileInput(%q): expected %q error, got %d, %v", tc.input, tc.wantErr, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Fatalf("resolveProfileInput(%q) = %d, %v; want %d", tc.input, got, err, tc.want)
+		}
+	}
+}
+
+func TestPromptProfileSelectionRepromptsOnAmbiguousPrefix(t *testing.T) {
+	t.Parallel()
+
+	profiles := []config.Profile{{Name: "prod-reporting"}, {Name: "prod-billing"}, {Name: "dev"}}
+	reader := newPromptReader(strings.NewReader("prod\nprod-b, 3, dev\n"), 0)
+	got, err := promptProfileSelection(reader, profiles, "", false)
+	if err != nil {
+		t.Fatalf("promptProfileSelection: %v", err)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("unexpected selection: %v", got)
+	}
+
+	if _, err := promptProfileSelection(newPromptReader(strings.NewReader("staging\n"), 0), profiles, "", false); err == nil {
+		t.Fatal("expected unknown profile to fail")
+	}
+}
+
+func TestPromptProfileSelectionRepromptsOnInvalidInput(t *testing.T) {
+	t.Parallel()
+
+	profiles := []config.Profile{{Name: "prod-reporting"}, {Name: "dev"}}
+	reader := newPromptReader(strings.NewReader("staging\n\n1,2\ndev\n"), 0)
+	got, err := promptProfileSelection(reader, profiles, "", true)
+	if err != nil {
+		t.Fatalf("promptProfileSelection: %v", err)
+	}
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("unexpected selection: %v", got)
+	}
+
+	input := strings.Repeat("staging\n", maxPromptAttempts) + "dev\n"
+	if _, err := promptProfileSelection(newPromptReader(strings.NewReader(input), 0), profiles, "", true); err == nil || !strings.Contains(err.Error(), "staging") {
+		t.Fatalf("expected the last invalid answer after %d attempts, got: %v", maxPromptAttempts, err)
+	}
+}
+
+func TestInteractiveSelectProfilesTimesOutWithoutInput(t *testing.T) {
+	t.Parallel()
+
+	profiles := []config.Profile{{Name: "a"}, {Name: "b"}}
+	in, w := io.Pipe()
+	defer w.Close()
+	start := time.Now()
+	_, err := interactiveSelectProfiles(newPromptReader(in, 20*time.Millisecond), profiles)
+	if !errors.Is(err, errPromptTimeout) {
+		t.Fatalf("expected errPromptTimeout, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("prompt took %s to time out", elapsed)
+	}
+
+	got, err := interactiveSelectProfiles(newPromptReader(strings.NewReader("4\n3\n"), time.Second), profiles)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("expected a reprompt then all profiles, got %v, %v", got, err)
+	}
+}
+
+func TestProfileAttrNamesProfileFromConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	err := rdsiamproxy.ValidateUniqueListenAddrs([]config.Profile{
+		{Name: "p1", ListenAddr: "127.0.0.1:3307"},
+		{Name: "p2", ListenAddr: "127.0.0.1:3307"},
+	})
+	if got := profileAttr(err); len(got) != 2 || got[0] != "profile" || got[1] != "p2" {
+		t.Fatalf("unexpected attr for listen addr error: %v", got)
+	}
+
+	wrapped := fmt.Errorf("load: %w", &config.ProfileError{Profile: "prod", Err: errors.New("rds_host is required")})
+	if got := profileAttr(wrapped); len(got) != 2 || got[1] != "prod" {
+		t.Fatalf("unexpected attr for wrapped error: %v", got)
+	}
+	if got := profileAttr(errors.New("config has no profiles")); got != nil {
+		t.Fatalf("expected no attr for a config-wide error, got %v", got)
+	}
+}
+
+func TestWriteDryRunJSONEmitsArrayWithFields(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	expires := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+	err := writeDryRunJSON(&buf, []dryRunEntry{
+		{Profile: "p1", TokenLen: 812, TokenSHA256Prefix: "0123456789ab", ExpiresAt: expires, Region: "eu-west-1"},
+	})
+	if err != nil {
+		t.Fatalf("writeDryRunJSON: %v", err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, buf.String())
+	}
+	want := map[string]any{
+		"profile":             "p1",
+		"token_len":           float64(812),
+		"token_sha256_prefix": "0123456789ab",
+		"expires_at":          "2026-03-01T10:15:00Z",
+		"region":              "eu-west-1",
+	}
+	if len(got) != 1 || len(got[0]) != len(want) {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+	for k, v := range want {
+		if got[0][k] != v {
+			t.Fatalf("%s = %v, want %v", k, got[0][k], v)
+		}
+	}
+
+	buf.Reset()
+	if err := writeDryRunJSON(&buf, []dryRunEntry{}); err != nil || strings.TrimSpace(buf.String()) != "[]" {
+		t.Fatalf("expected empty array, got %q (err %v)", buf.String(), err)
+	}
+}
+
+func TestWriteDryRunJSONReportsFailedProfiles(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	err := writeDryRunJSON(&buf, []dryRunEntry{
+		{Profile: "ok", TokenLen: 812, TokenSHA256Prefix: "0123456789ab", ExpiresAt: time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC), Region: "eu-west-1"},
+		{Profile: "slow", Region: "ap-southeast-2", Error: "context deadline exceeded"},
+	})
+	if err != nil {
+		t.Fatalf("writeDryRunJSON: %v", err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, buf.String())
+	}
+	if len(got) != 2 || got[0]["error"] != nil {
+		t.Fatalf("expected the successful profile without an error, got: %s", buf.String())
+	}
+	want := map[string]any{"profile": "slow", "region": "ap-southeast-2", "error": "context deadline exceeded"}
+	if len(got[1]) != len(want) {
+		t.Fatalf("expected only profile, region and error for a failed profile, got: %v", got[1])
+	}
+	for k, v := range want {
+		if got[1][k] != v {
+			t.Fatalf("%s = %v, want %v", k, got[1][k], v)
+		}
+	}
+}
+
+func TestWarnCommonProxyUsers(t *testing.T) {
+	t.Parallel()
+
+	profiles := []config.Profile{
+		{Name: "prod", ProxyUser: "Admin"},
+		{Name: "dev", ProxyUser: "local_proxy"},
+	}
+	var buf bytes.Buffer
+	warnCommonProxyUsers(newLoggerWithWriter("info", false, &buf), profiles)
+
+	out := strings.TrimSpace(buf.String())
+	if strings.Count(out, "\n") != 0 || !strings.Contains(out, "profile=prod") || !strings.Contains(out, "proxy_user=Admin") {
+		t.Fatalf("expected one warning for prod, got:\n%s", buf.String())
+	}
+}
+
+func TestLogProfileSummaryListsEverySelectedProfile(t *testing.T) {
+	t.Parallel()
+
+	profiles := []config.Profile{
+		{Name: "prod", ListenAddr: "127.0.0.1:3307", RDSHost: "prod.abc.eu-west-1.rds.amazonaws.com", RDSPort: 3306, RDSRegion: "eu-west-1", MaxConns: 20},
+		{Name: "dev", ListenAddr: "127.0.0.1:3308", RDSHost: "dev.abc.us-east-1.rds.amazonaws.com", RDSPort: 3307, RDSRegion: "us-east-1", MaxConns: 5},
+	}
+	var buf bytes.Buffer
+	logProfileSummary(newLoggerWithWriter("info", false, &buf), profiles, 0)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "count=2") {
+		t.Fatalf("expected a header and one line per profile, got:\n%s", buf.String())
+	}
+	for _, want := range []string{"profile=prod", "listen_addr=127.0.0.1:3307", "rds_endpoint=prod.abc.eu-west-1.rds.amazonaws.com:3306", "region=eu-west-1", "max_conns=20"} {
+		if !strings.Contains(lines[1], want) {
+			t.Fatalf("expected %q in %q", want, lines[1])
+		}
+	}
+	if !strings.Contains(lines[2], "profile=dev") {
+		t.Fatalf("expected profiles in selection order, got: %q", lines[2])
+	}
+
+	buf.Reset()
+	logProfileSummary(newLoggerWithWriter("info", false, &buf), profiles, 50)
+	if strings.Count(buf.String(), "max_conns=50") != 2 {
+		t.Fatalf("expected --max-conns to override every profile, got:\n%s", buf.String())
+	}
+	buf.Reset()
+	logProfileSummary(newLoggerWithWriter("info", false, &buf), profiles[:1], 0)
+	if buf.Len() != 0 {
+		t.Fatalf("expected no summary for a single profile, got:\n%s", buf.String())
+	}
+}
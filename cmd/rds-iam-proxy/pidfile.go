@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pidFileHeldError reports a pid file that names another live process.
+type pidFileHeldError struct {
+	Path string
+	PID  int
+}
+
+func (e *pidFileHeldError) Error() string {
+	return fmt.Sprintf("pid file %s points to running process %d", e.Path, e.PID)
+}
+
+// writePIDFile records the current PID at path for init systems that track
+// the process by file. It refuses to overwrite a file naming another live
+// process; a stale file from a crashed run is replaced. The returned remove
+// deletes the file if it still holds this process's PID.
+func writePIDFile(path string) (remove func(), err error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if pid, perr := strconv.Atoi(strings.TrimSpace(string(data))); perr == nil && pid > 0 && pid != os.Getpid() && processAlive(pid) {
+			return nil, &pidFileHeldError{Path: path, PID: pid}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("read pid file: %w", err)
+	}
+
+	own := strconv.Itoa(os.Getpid())
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(own+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("write pid file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("write pid file: %w", err)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			data, err := os.ReadFile(path)
+			if err == nil && strings.TrimSpace(string(data)) == own {
+				_ = os.Remove(path)
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWritePIDFileWritesAndRemovesOwnPID(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "proxy.pid")
+	remove, err := writePIDFile(path)
+	if err != nil {
+		t.Fatalf("writePIDFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("unexpected pid file content %q, err %v", data, err)
+	}
+	remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected pid file removed, got: %v", err)
+	}
+}
+
+func TestWritePIDFileRefusesLiveProcess(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "proxy.pid")
+	live := strconv.Itoa(os.Getppid())
+	if err := os.WriteFile(path, []byte(live+"\n"), 0o644); err != nil {
+		t.Fatalf("write pid file: %v", err)
+	}
+	if _, err := writePIDFile(path); err == nil || !strings.Contains(err.Error(), "running process "+live) {
+		t.Fatalf("expected live process error, got: %v", err)
+	}
+}
+
+func TestWritePIDFileReplacesStaleAndKeepsForeignOnRemove(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "proxy.pid")
+	if err := os.WriteFile(path, []byte("999999999\n"), 0o644); err != nil {
+		t.Fatalf("write pid file: %v", err)
+	}
+	remove, err := writePIDFile(path)
+	if err != nil {
+		t.Fatalf("expected stale pid file to be replaced, got: %v", err)
+	}
+
+	// Another instance took the file over; our cleanup must leave it alone.
+	if err := os.WriteFile(path, []byte("12345\n"), 0o644); err != nil {
+		t.Fatalf("overwrite pid file: %v", err)
+	}
+	remove()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected foreign pid file kept, got: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// processAlive reports whether pid names a running process; FindProcess
+// fails on Windows when no such process exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
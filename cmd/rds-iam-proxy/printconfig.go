@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"rds-iam-proxy/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedPassword replaces proxy_password in print-config output.
+const redactedPassword = "<redacted>"
+
+// runPrintConfigSchema writes the config file's JSON Schema to stdout.
+func runPrintConfigSchema(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("print-config-schema", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	out, err := json.MarshalIndent(config.JSONSchema(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "encode schema: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s\n", out)
+	return 0
+}
+
+// runPrintConfig loads the config as the proxy would and writes it back as
+// YAML with defaults applied, hosts templates expanded and relative paths
+// resolved, so what a profile will actually use can be checked without
+// starting it. proxy_password is redacted.
+func runPrintConfig(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("print-config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		configPath  string
+		configDir   string
+		profileName string
+	)
+	fs.StringVar(&configPath, "config", "", "Path to config YAML")
+	fs.StringVar(&configDir, "config-dir", "", "Directory of config YAML files to merge")
+	fs.StringVar(&profileName, "profile", "", "Print only this profile; default prints all")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, source, err := loadConfigFromFlags(configPath, configDir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if profileName != "" {
+		p, err := config.SelectProfile(cfg, profileName)
+		if err != nil {
+			fmt.Fprintf(stderr, "config %s: %v\n", source, err)
+			return 1
+		}
+		cfg.Profiles = []config.Profile{*p}
+	}
+	for i := range cfg.Profiles {
+		if cfg.Profiles[i].ProxyPassword != "" {
+			cfg.Profiles[i].ProxyPassword = redactedPassword
+		}
+	}
+
+	enc := yaml.NewEncoder(stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		fmt.Fprintf(stderr, "encode config: %v\n", err)
+		return 1
+	}
+	if err := enc.Close(); err != nil {
+		fmt.Fprintf(stderr, "encode config: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// loadConfigFromFlags loads --config-dir when set, otherwise the file
+// --config resolves to, and returns it with the path it came from.
+func loadConfigFromFlags(configPath, configDir string) (*config.Config, string, error) {
+	if configDir != "" {
+		cfg, err := config.LoadDir(configDir)
+		if err != nil {
+			return nil, configDir, fmt.Errorf("load config %s: %w", configDir, err)
+		}
+		return cfg, configDir, nil
+	}
+	res, err := config.ResolveConfigPathDetailed(configPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("resolve config: %w", err)
+	}
+	cfg, err := config.Load(res.Path)
+	if err != nil {
+		return nil, res.Path, fmt.Errorf("load config %s: %w", res.Path, err)
+	}
+	return cfg, res.Path, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunPrintConfigShowsResolvedProfile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	raw := `profiles:
+  - name: p1
+    proxy_user: local_proxy
+    proxy_password: s3cret
+    rds_host: db.example.com
+    rds_region: eu-west-1
+    rds_db_user: app
+    ca_bundle: certs/ca.pem
+  - name: p2
+    listen_addr: 127.0.0.1:3308
+    proxy_user: other_proxy
+    rds_host: db2.example.com
+    rds_region: eu-west-1
+    rds_db_user: app2
+    ca_bundle: certs/ca.pem
+`
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runPrintConfig([]string{"--config", path, "--profile", "p1"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runPrintConfig exit %d: %s", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"listen_addr: 127.0.0.1:3307",
+		"max_conns: 20",
+		"rds_port: 3306",
+		"auth_timeout: 10s",
+		"ca_bundle: " + filepath.Join(dir, "certs", "ca.pem"),
+		"proxy_password: " + redactedPassword,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "s3cret") || strings.Contains(out, "p2") {
+		t.Fatalf("expected only p1 with its password redacted:\n%s", out)
+	}
+
+	stdout.Reset()
+	if code := runPrintConfig([]string{"--config", path, "--profile", "nope"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit 1 for an unknown profile, got %d", code)
+	}
+}
+
+func TestRunPrintConfigSchemaWritesJSON(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+	if code := runPrintConfigSchema(nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("runPrintConfigSchema exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"$schema"`) || !strings.Contains(stdout.String(), `"max_conns"`) {
+		t.Fatalf("unexpected schema output:\n%s", stdout.String())
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/token"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+type selfTestResult struct {
+	Profile    string
+	Token      time.Duration
+	CA         time.Duration
+	Connect    time.Duration
+	Query      time.Duration
+	FailedStep string
+	Err        error
+}
+
+func runSelfTest(logger *slog.Logger, cache *token.Cache, profiles []config.Profile, connectTimeout time.Duration) {
+	failed := 0
+	for _, p := range profiles {
+		dnsCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		checkRDSHost(dnsCtx, logger, net.DefaultResolver, p)
+		cancel()
+		for _, host := range p.BackendHosts() {
+			res := selfTestProfile(cache, p.ForHost(host), connectTimeout)
+			if len(p.RDSHosts) > 0 {
+				res.Profile = p.Name + "@" + host
+			}
+			fmt.Println(formatSelfTestResult(res))
+			if res.Err != nil {
+				logger.Error("self-test failed", "profile", p.Name, "rds_host", host, "step", res.FailedStep, "error", res.Err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// selfTestProfile runs the same token -> TLS connect -> query path a client
+// connection takes, timing each step.
+func selfTestProfile(cache *token.Cache, p config.Profile, connectTimeout time.Duration) selfTestResult {
+	res := selfTestResult{Profile: p.Name}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second+connectTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := cache.Get(ctx, p); err != nil {
+		res.FailedStep, res.Err = "token", err
+		return res
+	}
+	res.Token = time.Since(start)
+
+	factory, err := proxy.NewBackendFactory(p, cache, connectTimeout)
+	if err != nil {
+		res.FailedStep, res.Err = "tls", err
+		return res
+	}
+
+	// A wrong bundle would only surface as a generic x509 error on connect.
+	// Checking through the factory dials the same pinned address as the
+	// connect below.
+	start = time.Now()
+	if err := factory.VerifyCA(ctx); err != nil {
+		res.FailedStep, res.Err = "ca", err
+		return res
+	}
+	res.CA = time.Since(start)
+
+	start = time.Now()
+	conn, err := factory.NewConn(ctx)
+	if err != nil {
+		res.FailedStep, res.Err = "connect", err
+		if dbErr := defaultDBError(p, err); dbErr != nil {
+			res.FailedStep, res.Err = "default_db", dbErr
+		}
+		return res
+	}
+	defer conn.Close()
+	res.Connect = time.Since(start)
+
+	start = time.Now()
+	result, err := conn.Execute("SELECT 1")
+	if err != nil {
+		res.FailedStep, res.Err = "query", err
+		return res
+	}
+	result.Close()
+	res.Query = time.Since(start)
+
+	return res
+}
+
+// defaultDBError reports a connect error caused by default_db naming a schema
+// that is missing or not granted to rds_db_user, with the MySQL error as is.
+func defaultDBError(p config.Profile, err error) error {
+	var myErr *mysql.MyError
+	if p.DefaultDB == "" || !errors.As(err, &myErr) {
+		return nil
+	}
+	switch myErr.Code {
+	case mysql.ER_BAD_DB_ERROR, mysql.ER_DBACCESS_DENIED_ERROR:
+		return fmt.Errorf("default_db %q is not accessible: %w", p.DefaultDB, myErr)
+	}
+	return nil
+}
+
+func formatSelfTestResult(res selfTestResult) string {
+	if res.Err != nil {
+		return fmt.Sprintf("profile=%s status=failed step=%s error=%q", res.Profile, res.FailedStep, res.Err.Error())
+	}
+	return fmt.Sprintf("profile=%s status=ok token_ms=%d ca_ms=%d connect_ms=%d query_ms=%d total_ms=%d",
+		res.Profile,
+		res.Token.Milliseconds(),
+		res.CA.Milliseconds(),
+		res.Connect.Milliseconds(),
+		res.Query.Milliseconds(),
+		(res.Token + res.CA + res.Connect + res.Query).Milliseconds(),
+	)
+}
+
+// checkBackendCA runs proxy.VerifyBackendCA against each of p's backend
+// hosts for --check-ca. Like the other startup checks it only logs.
+func checkBackendCA(ctx context.Context, logger *slog.Logger, p config.Profile, timeout time.Duration) {
+	for _, host := range p.BackendHosts() {
+		if err := proxy.VerifyBackendCA(ctx, p.ForHost(host), timeout); err != nil {
+			logger.Error("backend CA check failed", "profile", p.Name, "rds_host", host, "error", err)
+			continue
+		}
+		logger.Info("backend certificate verified against ca_bundle", "profile", p.Name, "rds_host", host)
+	}
+}
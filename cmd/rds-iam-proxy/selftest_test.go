@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestFormatSelfTestResultSuccess(t *testing.T) {
+	t.Parallel()
+
+	got := formatSelfTestResult(selfTestResult{
+		Profile: "p1",
+		Token:   120 * time.Millisecond,
+		CA:      40 * time.Millisecond,
+		Connect: 300 * time.Millisecond,
+		Query:   5 * time.Millisecond,
+	})
+	for _, want := range []string{"profile=p1", "status=ok", "token_ms=120", "ca_ms=40", "connect_ms=300", "query_ms=5", "total_ms=465"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in output, got: %s", want, got)
+		}
+	}
+}
+
+func TestFormatSelfTestResultFailure(t *testing.T) {
+	t.Parallel()
+
+	got := formatSelfTestResult(selfTestResult{
+		Profile:    "p1",
+		FailedStep: "connect",
+		Err:        errors.New("x509: certificate signed by unknown authority"),
+	})
+	if !strings.Contains(got, "status=failed") || !strings.Contains(got, "step=connect") {
+		t.Fatalf("expected failed connect step, got: %s", got)
+	}
+	if !strings.Contains(got, `error="x509: certificate signed by unknown authority"`) {
+		t.Fatalf("expected quoted error, got: %s", got)
+	}
+}
+
+func TestDefaultDBErrorReportsUnknownSchema(t *testing.T) {
+	t.Parallel()
+
+	p := config.Profile{Name: "p1", DefaultDB: "analytcs"}
+	unknown := fmt.Errorf("connect backend: %w", mysql.NewError(mysql.ER_BAD_DB_ERROR, "Unknown database 'analytcs'"))
+	err := defaultDBError(p, unknown)
+	if err == nil || !strings.Contains(err.Error(), `default_db "analytcs"`) || !strings.Contains(err.Error(), "Unknown database 'analytcs'") {
+		t.Fatalf("expected default_db error with the MySQL message, got: %v", err)
+	}
+
+	if err := defaultDBError(p, mysql.NewError(mysql.ER_ACCESS_DENIED_ERROR, "Access denied")); err != nil {
+		t.Fatalf("expected auth errors to stay connect errors, got: %v", err)
+	}
+	if err := defaultDBError(config.Profile{Name: "p1"}, unknown); err != nil {
+		t.Fatalf("expected no default_db error without default_db, got: %v", err)
+	}
+}
@@ -3,32 +3,95 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"rds-iam-proxy/internal/proxy"
 )
 
-func signalContext() (context.Context, context.CancelFunc) {
+// signalContext is cancelled on the first SIGINT or SIGTERM. With forceExit,
+// a second one exits the process with code 130; without it, later signals
+// only report that shutdown is still in progress.
+func signalContext(forceExit bool) (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	sigCh := make(chan os.Signal, 2)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		<-sigCh
-		fmt.Fprintln(os.Stderr)
-		fmt.Fprintln(os.Stderr, formatSignalMessage(time.Now(), "interrupt received, starting graceful shutdown (press Ctrl+C again to force exit)"))
+	go handleShutdownSignals(sigCh, cancel, forceExit, os.Stderr, os.Exit)
+
+	return ctx, func() {
+		signal.Stop(sigCh)
 		cancel()
+	}
+}
+
+func handleShutdownSignals(sigCh <-chan os.Signal, cancel context.CancelFunc, forceExit bool, out io.Writer, exit func(int)) {
+	<-sigCh
+	msg := "interrupt received, starting graceful shutdown"
+	if forceExit {
+		msg += " (press Ctrl+C again to force exit)"
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, formatSignalMessage(time.Now(), msg))
+	cancel()
+	if forceExit {
 		// If graceful shutdown is blocked, a second Ctrl+C forces immediate exit.
 		<-sigCh
-		fmt.Fprintln(os.Stderr)
-		fmt.Fprintln(os.Stderr, formatSignalMessage(time.Now(), "second interrupt received, forcing exit"))
-		os.Exit(130)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, formatSignalMessage(time.Now(), "second interrupt received, forcing exit"))
+		exit(130)
+		return
+	}
+	for range sigCh {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, formatSignalMessage(time.Now(), "interrupt received, graceful shutdown still in progress (--no-force-exit)"))
+	}
+}
+
+// onSignal runs fn for every delivery of sigs until ctx is done. It is a no-op
+// when sigs is empty (e.g. the signal is unsupported on this platform).
+func onSignal(ctx context.Context, sigs []os.Signal, fn func()) {
+	if len(sigs) == 0 {
+		return
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sigs...)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigCh:
+				fn()
+			}
+		}
 	}()
+}
 
-	return ctx, func() {
-		signal.Stop(sigCh)
-		cancel()
+// logSnapshots writes one structured line per profile with its active
+// connections, pool fill state and borrow sources.
+func logSnapshots(logger *slog.Logger, instances []*proxy.Proxy) {
+	for _, px := range instances {
+		for _, snap := range px.Snapshot() {
+			logger.Info("profile snapshot",
+				"profile", snap.Profile,
+				"listen_addr", snap.ListenAddr,
+				"active_conns", snap.ActiveConns,
+				"oldest_conn_age_ms", snap.OldestAge.Milliseconds(),
+				"pool_idle", snap.Pool.Idle,
+				"pool_size", snap.Pool.Size,
+				"borrows_warm", snap.Pool.Warm.Count,
+				"borrows_fresh", snap.Pool.Fresh.Count,
+				"fresh_wait_mean_ms", snap.Pool.Fresh.Mean().Milliseconds(),
+				"pool_invalidations", snap.Pool.Invalidations,
+				"pool_starvations", snap.Pool.Starvations,
+			)
+		}
 	}
 }
 
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
 )
 
 func TestFormatSignalMessage(t *testing.T) {
@@ -19,3 +27,58 @@ func TestFormatSignalMessage(t *testing.T) {
 		t.Fatalf("expected message suffix, got: %s", got)
 	}
 }
+
+func TestHandleShutdownSignals(t *testing.T) {
+	t.Parallel()
+
+	for _, forceExit := range []bool{true, false} {
+		sigCh := make(chan os.Signal, 2)
+		sigCh <- syscall.SIGINT
+		sigCh <- syscall.SIGINT
+		close(sigCh)
+		var (
+			out       bytes.Buffer
+			cancelled bool
+			exitCode  = -1
+		)
+		handleShutdownSignals(sigCh, func() { cancelled = true }, forceExit, &out, func(code int) { exitCode = code })
+
+		if !cancelled {
+			t.Fatalf("forceExit=%v: expected the first signal to cancel", forceExit)
+		}
+		if forceExit {
+			if exitCode != 130 || !strings.Contains(out.String(), "forcing exit") {
+				t.Fatalf("expected forced exit 130, got %d: %s", exitCode, out.String())
+			}
+			continue
+		}
+		if exitCode != -1 || !strings.Contains(out.String(), "still in progress") || strings.Contains(out.String(), "press Ctrl+C again") {
+			t.Fatalf("expected second signal to be ignored, got exit %d: %s", exitCode, out.String())
+		}
+	}
+}
+
+func TestLogSnapshotsWritesOneLinePerProfile(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	logger := newLoggerWithWriter("info", false, &out)
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pool := proxy.NewBackendPool(2, time.Minute, time.Second, quiet, nil)
+	px := proxy.New(config.Profile{Name: "p1", ListenAddr: "127.0.0.1:3307", ProxyUser: "u1"}, quiet, pool, time.Second, 0)
+	if err := px.AddRoute(config.Profile{Name: "p2", ListenAddr: "127.0.0.1:3307", ProxyUser: "u2"}, quiet, pool, 0); err != nil {
+		t.Fatalf("AddRoute: %v", err)
+	}
+
+	logSnapshots(logger, []*proxy.Proxy{px})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 snapshot lines, got %d: %q", len(lines), out.String())
+	}
+	for i, name := range []string{"p1", "p2"} {
+		if !strings.Contains(lines[i], "profile="+name) || !strings.Contains(lines[i], "active_conns=0") || !strings.Contains(lines[i], "pool_size=2") {
+			t.Fatalf("unexpected snapshot line: %s", lines[i])
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// snapshotSignals trigger a per-profile connection/pool snapshot log.
+var snapshotSignals = []os.Signal{syscall.SIGUSR2}
+
+// reopenSignals reopen file outputs such as the access log after rotation.
+var reopenSignals = []os.Signal{syscall.SIGHUP}
+
+// refreshSignals rebuild every backend pool with fresh logins.
+var refreshSignals = []os.Signal{syscall.SIGUSR1}
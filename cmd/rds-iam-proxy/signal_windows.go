@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// snapshotSignals is empty on Windows, which has no SIGUSR2.
+var snapshotSignals []os.Signal
+
+// reopenSignals is empty on Windows, which has no SIGHUP delivery.
+var reopenSignals []os.Signal
+
+// refreshSignals is empty on Windows, which has no SIGUSR1.
+var refreshSignals []os.Signal
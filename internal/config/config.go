@@ -1,42 +1,154 @@
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	defaultListenAddr = "127.0.0.1:3307"
-	defaultRDSPort    = 3306
-	defaultMaxConns   = 20
-	maxConnsHardLimit = 200
+	defaultListenAddr  = "127.0.0.1:3307"
+	defaultRDSPort     = 3306
+	defaultMaxConns    = 20
+	maxConnsHardLimit  = 200
+	defaultAuthTimeout = 10 * time.Second
+	minMaxPacketBytes  = 1024 // MySQL's own max_allowed_packet floor
 )
 
 type Config struct {
 	Profiles []Profile `yaml:"profiles"`
+	// ControlSocket is the Unix socket a running proxy answers
+	// `rds-iam-proxy status` on; empty disables it.
+	ControlSocket string `yaml:"control_socket"`
+	// TokenCacheFile persists IAM tokens across restarts; empty disables it.
+	TokenCacheFile string `yaml:"token_cache_file"`
 }
 
 type Profile struct {
-	Name          string `yaml:"name"`
-	ListenAddr    string `yaml:"listen_addr"`
-	MaxConns      int    `yaml:"max_conns"`
-	ProxyUser     string `yaml:"proxy_user"`
-	ProxyPassword string `yaml:"proxy_password"`
-	RDSHost       string `yaml:"rds_host"`
-	RDSPort       int    `yaml:"rds_port"`
-	RDSRegion     string `yaml:"rds_region"`
-	RDSDBUser     string `yaml:"rds_db_user"`
-	AWSProfile    string `yaml:"aws_profile"`
-	DefaultDB     string `yaml:"default_db"`
-	CABundle      string `yaml:"ca_bundle"`
+	Name                      string         `yaml:"name"`
+	ListenAddr                string         `yaml:"listen_addr"`
+	MaxConns                  int            `yaml:"max_conns"`
+	ProxyUser                 string         `yaml:"proxy_user"`
+	ProxyPassword             string         `yaml:"proxy_password"`
+	RDSHost                   string         `yaml:"rds_host"`
+	RDSPort                   int            `yaml:"rds_port"`
+	RDSRegion                 string         `yaml:"rds_region"`
+	RDSDBUser                 string         `yaml:"rds_db_user"`
+	AWSProfile                string         `yaml:"aws_profile"`
+	AWSEndpointURL            string         `yaml:"aws_endpoint_url"`
+	STSEndpointURL            string         `yaml:"sts_endpoint_url"`
+	AssumeRoleARN             string         `yaml:"assume_role_arn"`
+	ExternalID                string         `yaml:"external_id"`
+	RoleSessionName           string         `yaml:"role_session_name"`
+	DefaultDB                 string         `yaml:"default_db"`
+	CABundle                  string         `yaml:"ca_bundle"`
+	BackendKeepAlive          time.Duration  `yaml:"backend_keepalive_interval"`
+	ServerVersion             string         `yaml:"server_version"`
+	DenyStatements            []string       `yaml:"deny_statements"`
+	AllowedClients            []string       `yaml:"allowed_clients"`
+	BackendUnavailableMessage string         `yaml:"backend_unavailable_message"`
+	ChangeUserMode            string         `yaml:"change_user_mode"`
+	Hosts                     []string       `yaml:"hosts"`
+	ExpandedFrom              string         `yaml:"-"` // template profile name when generated from hosts
+	SourceFile                string         `yaml:"-"` // file the profile came from when loaded with LoadDir
+	LongSessionWarnAfter      time.Duration  `yaml:"long_session_warn_after"`
+	TCPNoDelay                *bool          `yaml:"tcp_nodelay"`
+	SocketSendBuffer          int            `yaml:"socket_send_buffer"`
+	SocketRecvBuffer          int            `yaml:"socket_recv_buffer"`
+	IAMTokenDBUser            string         `yaml:"iam_token_db_user"`
+	AWSConfigFile             string         `yaml:"aws_config_file"`
+	AWSCredentialsFile        string         `yaml:"aws_credentials_file"`
+	AuthTimeout               time.Duration  `yaml:"auth_timeout"`
+	AllowCompression          bool           `yaml:"allow_compression"`
+	LabelClientSession        bool           `yaml:"label_client_session"`
+	ListenFamily              string         `yaml:"listen_family"`
+	AuroraFailoverAware       bool           `yaml:"aurora_failover_aware"`
+	LogLevel                  string         `yaml:"log_level"`
+	MaxPacketBytes            int            `yaml:"max_packet_bytes"`
+	MinTLSVersion             string         `yaml:"min_tls_version"`
+	CipherSuites              []string       `yaml:"cipher_suites"`
+	AWSCredentialTimeout      time.Duration  `yaml:"aws_credential_timeout"`
+	LogUnavailableQuery       bool           `yaml:"log_unavailable_query"`
+	RDSHosts                  []string       `yaml:"rds_hosts"`
+	VersionComment            string         `yaml:"version_comment"`
+	BackendSessionInit        []string       `yaml:"backend_session_init"`
+	AllowedClientAuthPlugins  []string       `yaml:"allowed_client_auth_plugins"`
+	ClientTLSAutocert         bool           `yaml:"client_tls_autocert"`
+	ProxyProtocol             bool           `yaml:"proxy_protocol"`
+	BackendLinger             *time.Duration `yaml:"backend_linger"`
+	PinBackendIP              bool           `yaml:"pin_backend_ip"`
+	BackendResolveInterval    time.Duration  `yaml:"backend_resolve_interval"`
+	PrewarmConcurrency        int            `yaml:"prewarm_concurrency"`
+	InsecureSkipTLSVerify     bool           `yaml:"insecure_skip_tls_verify"`
+	TransferProgressInterval  time.Duration  `yaml:"transfer_progress_interval"`
+	ReportMaxConnections      bool           `yaml:"report_max_connections"`
+	DrainMode                 string         `yaml:"drain_mode"`
+	SessionSetupTimeout       time.Duration  `yaml:"session_setup_timeout"`
+	CABundleBase              string         `yaml:"ca_bundle_base"`
 }
 
+// ProfileError is a config error attributable to one profile, so callers can
+// log the profile name as its own attribute.
+type ProfileError struct {
+	Profile string
+	Err     error
+}
+
+func (e *ProfileError) Error() string {
+	return fmt.Sprintf("profile %q: %v", e.Profile, e.Err)
+}
+
+func (e *ProfileError) Unwrap() error {
+	return e.Err
+}
+
+// listen_family values. Unset binds listen_addr as given, or both loopback
+// families when its host is localhost.
+const (
+	ListenIPv4 = "ipv4"
+	ListenIPv6 = "ipv6"
+	ListenDual = "dual"
+)
+
+// change_user_mode values. Unset forwards COM_CHANGE_USER to the backend as-is.
+const (
+	ChangeUserReject = "reject" // answer COM_CHANGE_USER with an error
+	ChangeUserReset  = "reset"  // turn a same-user COM_CHANGE_USER into a backend session reset
+)
+
+// drain_mode values: what a listener does with new clients while shutdown
+// drains existing sessions. Unset defaults to DrainCloseListener.
+const (
+	DrainCloseListener   = "close-listener"    // stop accepting; new clients see connection refused
+	DrainRejectWithError = "reject-with-error" // keep accepting and answer authenticated clients with ER_SERVER_SHUTDOWN
+)
+
+// ca_bundle_base values: what a relative ca_bundle resolves against. Unset
+// defaults to CABundleBaseConfigDir. Configs read from stdin or a URL have no
+// directory, so there both resolve against the working directory.
+const (
+	CABundleBaseConfigDir = "config-dir" // the directory of the config file
+	CABundleBaseCwd       = "cwd"        // the proxy's working directory
+)
+
+// ClientAuthPlugins lists the auth plugins the proxy can authenticate clients
+// with, the values allowed in allowed_client_auth_plugins.
+var ClientAuthPlugins = []string{"mysql_native_password", "caching_sha2_password", "sha256_password"}
+
 type ConfigResolution struct {
 	Path    string
 	Source  string
@@ -55,16 +167,64 @@ func ResolveConfigPathDetailed(flagPath string) (ConfigResolution, error) {
 	return resolveConfigPathDetailed(flagPath, os.Getwd, os.Executable, os.UserHomeDir)
 }
 
+// ResolveConfigPathInOrder is ResolveConfigPathDetailed with the search
+// locations and their precedence given by order.
+func ResolveConfigPathInOrder(flagPath string, order []string) (ConfigResolution, error) {
+	return resolveConfigPathInOrder(flagPath, order, os.Getwd, os.Executable, os.UserHomeDir)
+}
+
+// ResolveConfigPathInCwd is ResolveConfigPathDetailed without the parent,
+// executable and home fallbacks: only an explicit flagPath or config.yaml in
+// the working directory is used, so a stray parent config is never picked up.
+func ResolveConfigPathInCwd(flagPath string) (ConfigResolution, error) {
+	return resolveConfigPathInCwd(flagPath, os.Getwd)
+}
+
+func resolveConfigPathInCwd(flagPath string, getwd func() (string, error)) (ConfigResolution, error) {
+	if flagPath != "" {
+		return resolveConfigPathDetailed(flagPath, nil, nil, nil)
+	}
+	wd, err := getwd()
+	if err != nil {
+		return ConfigResolution{}, fmt.Errorf("resolve working directory: %w", err)
+	}
+	p := filepath.Join(wd, "config.yaml")
+	if !fileExists(p) {
+		return ConfigResolution{}, fmt.Errorf("config file not found at %s and config search is disabled; use --config <path>", p)
+	}
+	return ConfigResolution{Path: p, Source: "current working directory", Checked: []string{p}}, nil
+}
+
 func resolveConfigPathDetailed(
 	flagPath string,
 	getwd func() (string, error),
 	executablePath func() (string, error),
 	homeDir func() (string, error),
+) (ConfigResolution, error) {
+	return resolveConfigPathInOrder(flagPath, DefaultConfigSearchOrder, getwd, executablePath, homeDir)
+}
+
+func resolveConfigPathInOrder(
+	flagPath string,
+	order []string,
+	getwd func() (string, error),
+	executablePath func() (string, error),
+	homeDir func() (string, error),
 ) (ConfigResolution, error) {
 	resolution := ConfigResolution{
 		Checked: make([]string, 0, 16),
 	}
 
+	if flagPath == StdinConfigPath {
+		resolution.Path = flagPath
+		resolution.Source = "flag --config (stdin)"
+		return resolution, nil
+	}
+	if isConfigURL(flagPath) {
+		resolution.Path = flagPath
+		resolution.Source = "flag --config (url)"
+		return resolution, nil
+	}
 	if flagPath != "" {
 		absPath, err := filepath.Abs(flagPath)
 		if err != nil {
@@ -76,95 +236,280 @@ func resolveConfigPathDetailed(
 		return resolution, nil
 	}
 
-	if getwd != nil {
-		wd, err := getwd()
-		if err == nil && wd != "" {
-			for _, dir := range cwdAndSingleParent(wd) {
-				p := filepath.Join(dir, "config.yaml")
-				resolution.Checked = append(resolution.Checked, p)
-				if fileExists(p) {
-					resolution.Path = p
-					if samePath(dir, wd) {
-						resolution.Source = "current working directory"
-					} else {
-						resolution.Source = fmt.Sprintf("parent directory (%s)", dir)
-					}
-					return resolution, nil
-				}
-			}
+	return searchConfigPath(resolution, order, getwd, executablePath, homeDir)
+}
+
+// Config search locations, in their default order; see ParseConfigSearchOrder.
+const (
+	ConfigSearchCwd  = "cwd"  // working directory, then its parent
+	ConfigSearchExe  = "exe"  // executable directory, then its parent
+	ConfigSearchHome = "home" // ~/.config/rds-iam-proxy
+)
+
+// DefaultConfigSearchOrder is where config.yaml is looked for without --config.
+var DefaultConfigSearchOrder = []string{ConfigSearchCwd, ConfigSearchExe, ConfigSearchHome}
+
+var configSearchHints = map[string]string{
+	ConfigSearchCwd:  "cwd/cwd-parent",
+	ConfigSearchExe:  "executable-dir/executable-parent",
+	ConfigSearchHome: "~/.config/rds-iam-proxy/config.yaml",
+}
+
+// ParseConfigSearchOrder parses a comma-separated list of search locations
+// (cwd, exe, home). Locations left out are not searched.
+func ParseConfigSearchOrder(v string) ([]string, error) {
+	var order []string
+	for _, loc := range strings.Split(v, ",") {
+		loc = strings.ToLower(strings.TrimSpace(loc))
+		if _, ok := configSearchHints[loc]; !ok {
+			return nil, fmt.Errorf("config search location %q must be %s, %s or %s", loc, ConfigSearchCwd, ConfigSearchExe, ConfigSearchHome)
 		}
+		if slices.Contains(order, loc) {
+			return nil, fmt.Errorf("config search location %q is listed more than once", loc)
+		}
+		order = append(order, loc)
 	}
+	return order, nil
+}
 
-	if executablePath != nil {
-		exePath, err := executablePath()
-		if err == nil && exePath != "" {
-			exeDir := filepath.Dir(exePath)
-			for _, dir := range cwdAndSingleParent(exeDir) {
-				p := filepath.Join(dir, "config.yaml")
-				resolution.Checked = append(resolution.Checked, p)
-				if fileExists(p) {
-					resolution.Path = p
-					if samePath(dir, exeDir) {
-						resolution.Source = "executable directory"
-					} else {
-						resolution.Source = fmt.Sprintf("executable parent directory (%s)", dir)
-					}
-					return resolution, nil
-				}
-			}
+func searchConfigPath(
+	resolution ConfigResolution,
+	order []string,
+	getwd func() (string, error),
+	executablePath func() (string, error),
+	homeDir func() (string, error),
+) (ConfigResolution, error) {
+	hints := make([]string, 0, len(order))
+	for _, loc := range order {
+		hints = append(hints, configSearchHints[loc])
+		var found bool
+		switch loc {
+		case ConfigSearchCwd:
+			found = searchDirAndParent(&resolution, getwd, "current working directory", "parent directory")
+		case ConfigSearchExe:
+			found = searchDirAndParent(&resolution, dirOf(executablePath), "executable directory", "executable parent directory")
+		case ConfigSearchHome:
+			found = searchHomeConfig(&resolution, homeDir)
+		}
+		if found {
+			return resolution, nil
 		}
 	}
 
-	home := ""
-	if homeDir != nil {
-		home, _ = homeDir()
+	return ConfigResolution{}, fmt.Errorf(
+		"config file not found; checked: %s; use --config <path> or create config.yaml in %s",
+		strings.Join(resolution.Checked, ", "), joinOr(hints),
+	)
+}
+
+// searchDirAndParent looks for config.yaml in the directory dir returns and
+// then its parent, recording the hit in resolution.
+func searchDirAndParent(resolution *ConfigResolution, dir func() (string, error), source, parentSource string) bool {
+	if dir == nil {
+		return false
 	}
-	if home != "" {
-		p := filepath.Join(home, ".config", "rds-iam-proxy", "config.yaml")
+	base, err := dir()
+	if err != nil || base == "" {
+		return false
+	}
+	for _, d := range cwdAndSingleParent(base) {
+		p := filepath.Join(d, "config.yaml")
 		resolution.Checked = append(resolution.Checked, p)
 		if fileExists(p) {
 			resolution.Path = p
-			resolution.Source = "home config"
-			return resolution, nil
+			if samePath(d, base) {
+				resolution.Source = source
+			} else {
+				resolution.Source = fmt.Sprintf("%s (%s)", parentSource, d)
+			}
+			return true
 		}
 	}
+	return false
+}
 
-	return ConfigResolution{}, fmt.Errorf(
-		"config file not found; checked: %s; use --config <path> or create config.yaml in cwd/cwd-parent, executable-dir/executable-parent, or ~/.config/rds-iam-proxy/config.yaml",
-		strings.Join(resolution.Checked, ", "),
-	)
+// dirOf turns a file path lookup such as os.Executable into its directory.
+func dirOf(path func() (string, error)) func() (string, error) {
+	if path == nil {
+		return nil
+	}
+	return func() (string, error) {
+		p, err := path()
+		if err != nil || p == "" {
+			return "", err
+		}
+		return filepath.Dir(p), nil
+	}
+}
+
+func searchHomeConfig(resolution *ConfigResolution, homeDir func() (string, error)) bool {
+	home := ""
+	if homeDir != nil {
+		home, _ = homeDir()
+	}
+	if home == "" {
+		return false
+	}
+	p := filepath.Join(home, ".config", "rds-iam-proxy", "config.yaml")
+	resolution.Checked = append(resolution.Checked, p)
+	if fileExists(p) {
+		resolution.Path = p
+		resolution.Source = "home config"
+		return true
+	}
+	return false
+}
+
+// joinOr joins items as "a, b, or c".
+func joinOr(items []string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " or " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", or " + items[len(items)-1]
 }
 
+// StdinConfigPath makes Load read YAML from standard input.
+const StdinConfigPath = "-"
+
+var (
+	configStdin   io.Reader = os.Stdin
+	configHTTPGet           = (&http.Client{Timeout: 15 * time.Second}).Get
+)
+
+// Load reads config from a file, from stdin when path is "-", or over HTTPS
+// when path is an https:// URL. Relative paths in stdin/URL configs resolve
+// against the working directory, whatever ca_bundle_base says.
 func Load(path string) (*Config, error) {
-	raw, err := os.ReadFile(path)
+	raw, baseDir, err := readConfigSource(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(raw, baseDir, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Profiles) == 0 {
+		return nil, errors.New("config has no profiles")
+	}
+	if err := validateUniqueUsernames(cfg.Profiles); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadDir merges every *.yaml file in dir, in name order, into one Config by
+// concatenating their profiles. Relative paths resolve against dir, and
+// proxy_user, rds_db_user and profile names must be unique across files.
+// control_socket and token_cache_file may be set in more than one file only
+// with the same value.
+func LoadDir(dir string) (*Config, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
 	if err != nil {
-		return nil, fmt.Errorf("read config: %w", err)
+		return nil, fmt.Errorf("list config dir: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("config dir %s has no *.yaml files", dir)
 	}
 
+	merged := &Config{}
+	names := map[string]Profile{}
+	for _, file := range files {
+		raw, baseDir, err := readConfigSource(file)
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := parseConfig(raw, baseDir, file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		for _, p := range cfg.Profiles {
+			if prev, ok := names[p.Name]; ok {
+				return nil, &ProfileError{Profile: p.Name, Err: fmt.Errorf("%s: profile name is already used in %s", filepath.Base(file), filepath.Base(prev.SourceFile))}
+			}
+			names[p.Name] = p
+		}
+		merged.Profiles = append(merged.Profiles, cfg.Profiles...)
+		if err := mergeSetting("control_socket", &merged.ControlSocket, cfg.ControlSocket); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		if err := mergeSetting("token_cache_file", &merged.TokenCacheFile, cfg.TokenCacheFile); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+	}
+	if len(merged.Profiles) == 0 {
+		return nil, fmt.Errorf("config dir %s has no profiles", dir)
+	}
+	if err := validateUniqueUsernames(merged.Profiles); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergeSetting sets *dst to value, refusing a second, different value.
+func mergeSetting(key string, dst *string, value string) error {
+	if value == "" || value == *dst {
+		return nil
+	}
+	if *dst != "" {
+		return fmt.Errorf("%s %q conflicts with %q set in another file", key, value, *dst)
+	}
+	*dst = value
+	return nil
+}
+
+// parseConfig decodes one config document and expands, defaults, resolves
+// and validates its profiles. A non-empty source is recorded on each profile
+// as its SourceFile.
+func parseConfig(raw []byte, baseDir, source string) (*Config, error) {
+	// Reject unknown keys so typos like "rds_hots" fail with the key name
+	// instead of surfacing later as a missing required field.
 	cfg := &Config{}
-	if err := yaml.Unmarshal(raw, cfg); err != nil {
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("parse yaml: %w", err)
 	}
 
-	if len(cfg.Profiles) == 0 {
-		return nil, errors.New("config has no profiles")
+	var err error
+	cfg.Profiles, err = expandHosts(cfg.Profiles)
+	if err != nil {
+		return nil, err
 	}
 
-	baseDir := filepath.Dir(path)
 	for i := range cfg.Profiles {
 		applyDefaults(&cfg.Profiles[i])
-		resolveRelativePaths(&cfg.Profiles[i], baseDir)
-		if err := validateProfile(cfg.Profiles[i]); err != nil {
-			return nil, fmt.Errorf("profile %q: %w", cfg.Profiles[i].Name, err)
+		cfg.Profiles[i].SourceFile = source
+		err := resolveRelativePaths(&cfg.Profiles[i], baseDir)
+		if err == nil {
+			err = validateProfile(cfg.Profiles[i])
+		}
+		if err != nil {
+			return nil, &ProfileError{Profile: cfg.Profiles[i].Name, Err: err}
 		}
 	}
-	if err := validateUniqueUsernames(cfg.Profiles); err != nil {
-		return nil, err
+	if cfg.ControlSocket != "" && !filepath.IsAbs(cfg.ControlSocket) {
+		cfg.ControlSocket = filepath.Join(baseDir, cfg.ControlSocket)
+	}
+	if cfg.TokenCacheFile != "" && !filepath.IsAbs(cfg.TokenCacheFile) {
+		cfg.TokenCacheFile = filepath.Join(baseDir, cfg.TokenCacheFile)
 	}
-
 	return cfg, nil
 }
 
+// Describe returns p's quoted name for conflict errors, followed by the
+// file it came from when loaded with LoadDir.
+func (p Profile) Describe() string {
+	if p.SourceFile == "" {
+		return strconv.Quote(p.Name)
+	}
+	return fmt.Sprintf("%q (%s)", p.Name, filepath.Base(p.SourceFile))
+}
+
 func SelectProfile(cfg *Config, selected string) (*Profile, error) {
 	if selected != "" {
 		for i := range cfg.Profiles {
@@ -172,7 +517,7 @@ func SelectProfile(cfg *Config, selected string) (*Profile, error) {
 				return &cfg.Profiles[i], nil
 			}
 		}
-		return nil, fmt.Errorf("profile %q not found", selected)
+		return nil, &ProfileError{Profile: selected, Err: errors.New("not found")}
 	}
 
 	if len(cfg.Profiles) == 1 {
@@ -190,14 +535,20 @@ func (p Profile) Address() string {
 	return net.JoinHostPort(p.RDSHost, fmt.Sprintf("%d", p.RDSPort))
 }
 
-func (p Profile) ValidateRuntime(allowDevEmptyPassword bool) error {
+// ValidateRuntime checks p against the local environment and the CLI's
+// acknowledgements: allowDevEmptyPassword permits an empty proxy_password and
+// allowInsecure permits insecure_skip_tls_verify.
+func (p Profile) ValidateRuntime(allowDevEmptyPassword, allowInsecure bool) error {
 	if p.ProxyPassword == "" && !allowDevEmptyPassword {
 		return errors.New("proxy_password is empty")
 	}
+	if p.InsecureSkipTLSVerify && !allowInsecure {
+		return errors.New("insecure_skip_tls_verify is set; pass --allow-insecure to acknowledge that backend certificates will not be verified")
+	}
 	if p.ProxyPassword == "change-me" || p.ProxyPassword == "change-me-too" {
 		return errors.New("proxy_password must not use example default value")
 	}
-	if !isLoopbackAddr(p.ListenAddr) {
+	if !IsLoopbackAddr(p.ListenAddr) {
 		return fmt.Errorf("listen_addr %q is not loopback", p.ListenAddr)
 	}
 	if _, err := os.Stat(p.CABundle); err != nil {
@@ -206,6 +557,130 @@ func (p Profile) ValidateRuntime(allowDevEmptyPassword bool) error {
 	return nil
 }
 
+func readConfigSource(path string) ([]byte, string, error) {
+	if path != StdinConfigPath && !isConfigURL(path) {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("read config: %w", err)
+		}
+		return raw, filepath.Dir(path), nil
+	}
+
+	baseDir, err := os.Getwd()
+	if err != nil {
+		return nil, "", fmt.Errorf("resolve working directory: %w", err)
+	}
+	if path == StdinConfigPath {
+		raw, err := io.ReadAll(configStdin)
+		if err != nil {
+			return nil, "", fmt.Errorf("read config from stdin: %w", err)
+		}
+		return raw, baseDir, nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid config url: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, "", fmt.Errorf("config url %q must be an absolute https URL", u.Redacted())
+	}
+	resp, err := configHTTPGet(u.String())
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetch config: unexpected status %s", resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch config: %w", err)
+	}
+	return raw, baseDir, nil
+}
+
+// isConfigURL reports whether a --config value names a URL rather than a file.
+func isConfigURL(path string) bool {
+	return strings.Contains(path, "://")
+}
+
+// expandHosts fans every profile with a hosts list out into one profile per
+// host. Generated profiles are named <name>-<first DNS label> (or <name>-<n>
+// when labels collide), use proxy_user <proxy_user>_<n>, and listen on
+// sequential ports starting at the template's listen_addr.
+func expandHosts(profiles []Profile) ([]Profile, error) {
+	names := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		if len(p.Hosts) == 0 {
+			names[p.Name] = true
+		}
+	}
+
+	out := make([]Profile, 0, len(profiles))
+	for _, tmpl := range profiles {
+		if len(tmpl.Hosts) == 0 {
+			out = append(out, tmpl)
+			continue
+		}
+		if tmpl.Name == "" {
+			return nil, errors.New("name is required for profiles with hosts")
+		}
+		if tmpl.RDSHost != "" {
+			return nil, &ProfileError{Profile: tmpl.Name, Err: errors.New("set either rds_host or hosts, not both")}
+		}
+		if len(tmpl.RDSHosts) > 0 {
+			return nil, &ProfileError{Profile: tmpl.Name, Err: errors.New("set either rds_hosts or hosts, not both")}
+		}
+		listenAddr := tmpl.ListenAddr
+		if listenAddr == "" {
+			listenAddr = defaultListenAddr
+		}
+		listenHost, portText, err := net.SplitHostPort(listenAddr)
+		if err != nil {
+			return nil, &ProfileError{Profile: tmpl.Name, Err: fmt.Errorf("invalid listen_addr: %w", err)}
+		}
+		basePort, err := strconv.Atoi(portText)
+		if err != nil || basePort < 1 || basePort+len(tmpl.Hosts)-1 > 65535 {
+			return nil, &ProfileError{Profile: tmpl.Name, Err: fmt.Errorf("listen_addr port %q leaves no room for %d hosts", portText, len(tmpl.Hosts))}
+		}
+
+		labels := make(map[string]int, len(tmpl.Hosts))
+		for _, host := range tmpl.Hosts {
+			labels[hostLabel(host)]++
+		}
+		for i, host := range tmpl.Hosts {
+			if strings.TrimSpace(host) == "" {
+				return nil, &ProfileError{Profile: tmpl.Name, Err: fmt.Errorf("hosts entry %d is empty", i+1)}
+			}
+			p := tmpl
+			p.Hosts = nil
+			p.ExpandedFrom = tmpl.Name
+			p.RDSHost = host
+			p.ListenAddr = net.JoinHostPort(listenHost, strconv.Itoa(basePort+i))
+			if label := hostLabel(host); labels[label] == 1 {
+				p.Name = tmpl.Name + "-" + label
+			} else {
+				p.Name = tmpl.Name + "-" + strconv.Itoa(i+1)
+			}
+			if tmpl.ProxyUser != "" {
+				p.ProxyUser = tmpl.ProxyUser + "_" + strconv.Itoa(i+1)
+			}
+			if names[p.Name] {
+				return nil, &ProfileError{Profile: p.Name, Err: errors.New("generated from hosts; clashes with an existing profile name")}
+			}
+			names[p.Name] = true
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func hostLabel(host string) string {
+	label, _, _ := strings.Cut(strings.TrimSpace(host), ".")
+	return label
+}
+
 func applyDefaults(p *Profile) {
 	if p.ListenAddr == "" {
 		p.ListenAddr = defaultListenAddr
@@ -216,12 +691,66 @@ func applyDefaults(p *Profile) {
 	if p.MaxConns == 0 {
 		p.MaxConns = defaultMaxConns
 	}
+	if p.RDSDBUser == "" {
+		p.RDSDBUser = p.IAMTokenDBUser
+	}
+	// A negative auth_timeout is kept as is and disables the deadline.
+	if p.AuthTimeout == 0 {
+		p.AuthTimeout = defaultAuthTimeout
+	}
+	if p.DrainMode == "" {
+		p.DrainMode = DrainCloseListener
+	}
+	if p.CABundleBase == "" {
+		p.CABundleBase = CABundleBaseConfigDir
+	}
+}
+
+// TokenDBUser is the DB user the IAM auth token is generated for:
+// iam_token_db_user when set, otherwise the login user rds_db_user.
+func (p Profile) TokenDBUser() string {
+	if p.IAMTokenDBUser != "" {
+		return p.IAMTokenDBUser
+	}
+	return p.RDSDBUser
 }
 
-func resolveRelativePaths(p *Profile, baseDir string) {
+// BackendHosts returns the hosts backend connections are spread across:
+// rds_hosts when set, otherwise rds_host alone.
+func (p Profile) BackendHosts() []string {
+	if len(p.RDSHosts) > 0 {
+		return p.RDSHosts
+	}
+	return []string{p.RDSHost}
+}
+
+// ForHost returns p narrowed to one of its backend hosts, for the token and
+// TLS settings that are signed for a single hostname.
+func (p Profile) ForHost(host string) Profile {
+	p.RDSHost = host
+	p.RDSHosts = nil
+	return p
+}
+
+func resolveRelativePaths(p *Profile, baseDir string) error {
 	if p.CABundle != "" && !filepath.IsAbs(p.CABundle) {
-		p.CABundle = filepath.Join(baseDir, p.CABundle)
+		caBase := baseDir
+		if p.CABundleBase == CABundleBaseCwd {
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("resolve working directory for ca_bundle: %w", err)
+			}
+			caBase = wd
+		}
+		p.CABundle = filepath.Join(caBase, p.CABundle)
+	}
+	if p.AWSConfigFile != "" && !filepath.IsAbs(p.AWSConfigFile) {
+		p.AWSConfigFile = filepath.Join(baseDir, p.AWSConfigFile)
 	}
+	if p.AWSCredentialsFile != "" && !filepath.IsAbs(p.AWSCredentialsFile) {
+		p.AWSCredentialsFile = filepath.Join(baseDir, p.AWSCredentialsFile)
+	}
+	return nil
 }
 
 func validateProfile(p Profile) error {
@@ -231,42 +760,320 @@ func validateProfile(p Profile) error {
 	if p.ProxyUser == "" {
 		return errors.New("proxy_user is required")
 	}
-	if p.MaxConns < 1 {
-		return errors.New("max_conns must be >= 1")
+	if p.MaxConns < 1 && p.MaxConns != MaxConnsUnlimited {
+		return fmt.Errorf("max_conns must be >= 1, or %d for unlimited", MaxConnsUnlimited)
 	}
 	if p.MaxConns > maxConnsHardLimit {
 		return fmt.Errorf("max_conns must be <= %d", maxConnsHardLimit)
 	}
-	if p.RDSHost == "" {
+	if p.RDSHost != "" && len(p.RDSHosts) > 0 {
+		return errors.New("set either rds_host or rds_hosts, not both")
+	}
+	if p.RDSHost == "" && len(p.RDSHosts) == 0 {
 		return errors.New("rds_host is required")
 	}
+	seenHosts := make(map[string]bool, len(p.RDSHosts))
+	for i, host := range p.RDSHosts {
+		if strings.TrimSpace(host) == "" {
+			return fmt.Errorf("rds_hosts entry %d is empty", i+1)
+		}
+		if seenHosts[host] {
+			return fmt.Errorf("rds_hosts lists %q more than once", host)
+		}
+		seenHosts[host] = true
+	}
 	if p.RDSRegion == "" {
 		return errors.New("rds_region is required")
 	}
-	if p.RDSDBUser == "" {
-		return errors.New("rds_db_user is required")
+	if p.TokenDBUser() == "" {
+		return errors.New("rds_db_user or iam_token_db_user is required")
 	}
-	if p.ProxyUser == p.RDSDBUser {
-		return errors.New("proxy_user and rds_db_user must be different")
+	if p.ProxyUser == p.RDSDBUser || p.ProxyUser == p.TokenDBUser() {
+		return errors.New("proxy_user must differ from rds_db_user and iam_token_db_user")
 	}
 	if p.CABundle == "" {
 		return errors.New("ca_bundle is required")
 	}
+	switch p.CABundleBase {
+	case "", CABundleBaseConfigDir, CABundleBaseCwd:
+	default:
+		return fmt.Errorf("ca_bundle_base %q must be %s or %s", p.CABundleBase, CABundleBaseConfigDir, CABundleBaseCwd)
+	}
 	if _, _, err := net.SplitHostPort(p.ListenAddr); err != nil {
 		return fmt.Errorf("invalid listen_addr: %w", err)
 	}
+	if err := validateEndpointURL("aws_endpoint_url", p.AWSEndpointURL); err != nil {
+		return err
+	}
+	if err := validateEndpointURL("sts_endpoint_url", p.STSEndpointURL); err != nil {
+		return err
+	}
+	if p.ServerVersion != "" && (p.ServerVersion[0] < '0' || p.ServerVersion[0] > '9' || strings.ContainsRune(p.ServerVersion, 0)) {
+		return fmt.Errorf("server_version %q must start with a version number (e.g. 8.0.35)", p.ServerVersion)
+	}
+	for _, stmt := range p.DenyStatements {
+		if !isStatementPrefix(stmt) {
+			return fmt.Errorf("deny_statements entry %q must be one or more SQL keywords (e.g. INSERT, LOAD DATA)", stmt)
+		}
+	}
+	if p.SocketSendBuffer < 0 || p.SocketRecvBuffer < 0 {
+		return errors.New("socket_send_buffer and socket_recv_buffer must be >= 0")
+	}
+	if p.BackendLinger != nil && (*p.BackendLinger < 0 || *p.BackendLinger%time.Second != 0) {
+		return fmt.Errorf("backend_linger must be 0 or a whole number of seconds, got %s", *p.BackendLinger)
+	}
+	if err := validateListenFamily(p); err != nil {
+		return err
+	}
+	if p.MaxPacketBytes != 0 && p.MaxPacketBytes < minMaxPacketBytes {
+		return fmt.Errorf("max_packet_bytes must be 0 (unlimited) or at least %d, got %d", minMaxPacketBytes, p.MaxPacketBytes)
+	}
+	if p.AllowCompression && (len(p.DenyStatements) > 0 || p.ChangeUserMode != "" || p.MaxPacketBytes > 0 || p.VersionComment != "" || p.ReportMaxConnections) {
+		return errors.New("allow_compression cannot be combined with deny_statements, change_user_mode, max_packet_bytes, version_comment or report_max_connections: compressed traffic is not inspected")
+	}
+	if strings.ContainsRune(p.VersionComment, 0) {
+		return errors.New("version_comment must not contain NUL bytes")
+	}
+	if p.AuroraFailoverAware && len(p.RDSHosts) > 0 {
+		return errors.New("aurora_failover_aware cannot be combined with rds_hosts: it follows a single cluster writer endpoint")
+	}
+	if p.AuroraFailoverAware && !IsAuroraWriterEndpoint(p.RDSHost) {
+		return fmt.Errorf("aurora_failover_aware requires rds_host to be an Aurora cluster writer endpoint (<cluster>.cluster-<id>.<region>.rds.amazonaws.com), got %q", p.RDSHost)
+	}
+	if p.TransferProgressInterval < 0 {
+		return fmt.Errorf("transfer_progress_interval must be >= 0, got %s", p.TransferProgressInterval)
+	}
+	if p.PrewarmConcurrency < 0 {
+		return fmt.Errorf("prewarm_concurrency must be >= 0, got %d", p.PrewarmConcurrency)
+	}
+	if p.BackendResolveInterval < 0 {
+		return fmt.Errorf("backend_resolve_interval must be >= 0, got %s", p.BackendResolveInterval)
+	}
+	if p.BackendResolveInterval != 0 && !p.PinBackendIP {
+		return errors.New("backend_resolve_interval requires pin_backend_ip")
+	}
+	if p.PinBackendIP && p.AuroraFailoverAware {
+		return errors.New("pin_backend_ip cannot be combined with aurora_failover_aware: the writer endpoint moves on failover")
+	}
+	if p.AWSCredentialTimeout < 0 {
+		return fmt.Errorf("aws_credential_timeout must be >= 0, got %s", p.AWSCredentialTimeout)
+	}
+	if p.SessionSetupTimeout < 0 {
+		return fmt.Errorf("session_setup_timeout must be >= 0, got %s", p.SessionSetupTimeout)
+	}
+	switch p.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log_level %q must be debug, info, warn or error", p.LogLevel)
+	}
+	if p.LongSessionWarnAfter < 0 {
+		return fmt.Errorf("long_session_warn_after must be >= 0, got %s", p.LongSessionWarnAfter)
+	}
+	for i, stmt := range p.BackendSessionInit {
+		if strings.TrimSpace(stmt) == "" {
+			return fmt.Errorf("backend_session_init[%d] is empty", i)
+		}
+	}
+	for i, plugin := range p.AllowedClientAuthPlugins {
+		if !slices.Contains(ClientAuthPlugins, plugin) {
+			return fmt.Errorf("allowed_client_auth_plugins[%d] %q must be one of %s", i, plugin, strings.Join(ClientAuthPlugins, ", "))
+		}
+	}
+	if len(p.AllowedClientAuthPlugins) > 0 && p.ClientTLSAutocert {
+		return errors.New("allowed_client_auth_plugins cannot check clients that log in over TLS, so it cannot be combined with client_tls_autocert")
+	}
+	switch p.ChangeUserMode {
+	case "", ChangeUserReject, ChangeUserReset:
+	default:
+		return fmt.Errorf("change_user_mode %q must be %q or %q", p.ChangeUserMode, ChangeUserReject, ChangeUserReset)
+	}
+	switch p.DrainMode {
+	case "", DrainCloseListener, DrainRejectWithError:
+	default:
+		return fmt.Errorf("drain_mode %q must be %q or %q", p.DrainMode, DrainCloseListener, DrainRejectWithError)
+	}
+	if _, err := ParseAllowedClients(p.AllowedClients); err != nil {
+		return err
+	}
+	minTLS, err := ParseTLSVersion(p.MinTLSVersion)
+	if err != nil {
+		return err
+	}
+	if _, err := ParseCipherSuites(p.CipherSuites); err != nil {
+		return err
+	}
+	if minTLS == tls.VersionTLS13 && len(p.CipherSuites) > 0 {
+		return errors.New("cipher_suites only apply to TLS 1.2; TLS 1.3 suites are not configurable, so drop cipher_suites or use min_tls_version 1.2")
+	}
+	if p.AssumeRoleARN == "" && (p.ExternalID != "" || p.RoleSessionName != "") {
+		return errors.New("external_id and role_session_name require assume_role_arn")
+	}
+	if p.AssumeRoleARN != "" && !strings.HasPrefix(p.AssumeRoleARN, "arn:") {
+		return fmt.Errorf("assume_role_arn %q is not an ARN", p.AssumeRoleARN)
+	}
+	return nil
+}
+
+func validateEndpointURL(field, raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", field, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute https URL", field)
+	}
+	return nil
+}
+
+// ParseAllowedClients parses allowed_clients entries as CIDR prefixes; a bare
+// IP address is treated as a single-host prefix.
+func ParseAllowedClients(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("allowed_clients entry %q is not an IP address or CIDR", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ParseTLSVersion parses min_tls_version ("1.2" or "1.3") for backend
+// connections; unset is TLS 1.2.
+func ParseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("min_tls_version %q must be 1.2 or 1.3", v)
+}
+
+// ParseCipherSuites maps cipher_suites names (Go/IANA names such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, case-insensitive) to suite IDs. Only
+// secure TLS 1.2 suites are accepted; nil leaves Go's defaults.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		suite := findCipherSuite(tls.CipherSuites(), name)
+		if suite == nil {
+			if findCipherSuite(tls.InsecureCipherSuites(), name) != nil {
+				return nil, fmt.Errorf("cipher_suites entry %q is insecure and not allowed", name)
+			}
+			return nil, fmt.Errorf("cipher_suites entry %q is not a known cipher suite", name)
+		}
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("cipher_suites entry %q is a TLS 1.3 suite, which is always enabled and cannot be listed", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+func findCipherSuite(suites []*tls.CipherSuite, name string) *tls.CipherSuite {
+	for _, s := range suites {
+		if strings.EqualFold(s.Name, name) {
+			return s
+		}
+	}
 	return nil
 }
 
-func isLoopbackAddr(addr string) bool {
+func isStatementPrefix(stmt string) bool {
+	if strings.TrimSpace(stmt) == "" {
+		return false
+	}
+	for _, r := range stmt {
+		if r != ' ' && r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsLoopbackAddr reports whether a host:port address binds a loopback IP or
+// localhost.
+func IsLoopbackAddr(addr string) bool {
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		return false
 	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
 	ip := net.ParseIP(host)
 	return ip != nil && ip.IsLoopback()
 }
 
+// ListenAddrs returns the addresses the profile's listener binds: listen_addr
+// itself, or 127.0.0.1 and/or [::1] on its port for localhost depending on
+// listen_family.
+func (p Profile) ListenAddrs() []string {
+	host, port, err := net.SplitHostPort(p.ListenAddr)
+	if err != nil || !strings.EqualFold(host, "localhost") {
+		return []string{p.ListenAddr}
+	}
+	v4, v6 := net.JoinHostPort("127.0.0.1", port), net.JoinHostPort("::1", port)
+	switch p.ListenFamily {
+	case ListenIPv4:
+		return []string{v4}
+	case ListenIPv6:
+		return []string{v6}
+	}
+	return []string{v4, v6}
+}
+
+// IsAuroraWriterEndpoint reports whether host is an Aurora cluster (writer)
+// endpoint, which moves to the new writer on failover. Reader and custom
+// endpoints balance across instances and do not qualify.
+func IsAuroraWriterEndpoint(host string) bool {
+	labels := strings.Split(strings.ToLower(host), ".")
+	if len(labels) < 3 || labels[0] == "" {
+		return false
+	}
+	id, ok := strings.CutPrefix(labels[1], "cluster-")
+	return ok && id != "" && !strings.HasPrefix(id, "ro-") && !strings.HasPrefix(id, "custom-")
+}
+
+// validateListenFamily checks listen_family against listen_addr: an explicit
+// IP host must match the family, and dual needs localhost.
+func validateListenFamily(p Profile) error {
+	if p.ListenFamily == "" {
+		return nil
+	}
+	switch p.ListenFamily {
+	case ListenIPv4, ListenIPv6, ListenDual:
+	default:
+		return fmt.Errorf("listen_family %q must be %q, %q or %q", p.ListenFamily, ListenIPv4, ListenIPv6, ListenDual)
+	}
+	host, _, err := net.SplitHostPort(p.ListenAddr)
+	if err != nil || strings.EqualFold(host, "localhost") {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case p.ListenFamily == ListenDual:
+		return fmt.Errorf("listen_family %q needs listen_addr host localhost, got %q", ListenDual, p.ListenAddr)
+	case ip != nil && (ip.To4() != nil) != (p.ListenFamily == ListenIPv4):
+		return fmt.Errorf("listen_family %q does not match listen_addr %q", p.ListenFamily, p.ListenAddr)
+	}
+	return nil
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -298,28 +1105,54 @@ func samePath(a, b string) bool {
 	return filepath.Clean(ar) == filepath.Clean(br)
 }
 
+// MaxConnsUnlimited as max_conns (or the --max-conns override) removes the
+// frontend connection cap, including the hard limit.
+const MaxConnsUnlimited = -1
+
 func MaxConnsHardLimit() int {
 	return maxConnsHardLimit
 }
 
+// sameTemplate reports whether profile prevName and p were both generated from
+// one hosts template; those intentionally share rds_db_user.
+func sameTemplate(profiles []Profile, prevName string, p Profile) bool {
+	if p.ExpandedFrom == "" {
+		return false
+	}
+	for _, other := range profiles {
+		if other.Name == prevName {
+			return other.ExpandedFrom == p.ExpandedFrom
+		}
+	}
+	return false
+}
+
+// inSourceFile prefixes err with p's SourceFile, if any.
+func inSourceFile(p Profile, err error) error {
+	if p.SourceFile == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", filepath.Base(p.SourceFile), err)
+}
+
 func validateUniqueUsernames(profiles []Profile) error {
 	if len(profiles) < 2 {
 		return nil
 	}
 
-	proxyUsers := make(map[string]string, len(profiles))
-	rdsUsers := make(map[string]string, len(profiles))
+	proxyUsers := make(map[string]Profile, len(profiles))
+	rdsUsers := make(map[string]Profile, len(profiles))
 
 	for _, p := range profiles {
 		if prev, ok := proxyUsers[p.ProxyUser]; ok {
-			return fmt.Errorf("proxy_user %q is reused by profiles %q and %q; use unique proxy_user values per profile", p.ProxyUser, prev, p.Name)
+			return &ProfileError{Profile: p.Name, Err: inSourceFile(p, fmt.Errorf("proxy_user %q is already used by profile %s; use unique proxy_user values per profile", p.ProxyUser, prev.Describe()))}
 		}
-		proxyUsers[p.ProxyUser] = p.Name
+		proxyUsers[p.ProxyUser] = p
 
-		if prev, ok := rdsUsers[p.RDSDBUser]; ok {
-			return fmt.Errorf("rds_db_user %q is reused by profiles %q and %q; use unique rds_db_user values per profile", p.RDSDBUser, prev, p.Name)
+		if prev, ok := rdsUsers[p.RDSDBUser]; ok && !sameTemplate(profiles, prev.Name, p) {
+			return &ProfileError{Profile: p.Name, Err: inSourceFile(p, fmt.Errorf("rds_db_user %q is already used by profile %s; use unique rds_db_user values per profile", p.RDSDBUser, prev.Describe()))}
 		}
-		rdsUsers[p.RDSDBUser] = p.Name
+		rdsUsers[p.RDSDBUser] = p
 	}
 
 	return nil
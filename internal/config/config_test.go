@@ -1,10 +1,16 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadAppliesDefaultsAndResolvesRelativeCA(t *testing.T) {
@@ -52,11 +58,127 @@ profiles:
 	if p.MaxConns != 20 {
 		t.Fatalf("unexpected default max conns: %d", p.MaxConns)
 	}
+	if p.AuthTimeout != 10*time.Second {
+		t.Fatalf("unexpected default auth timeout: %s", p.AuthTimeout)
+	}
 	if p.CABundle != caPath {
 		t.Fatalf("expected resolved ca path %s, got %s", caPath, p.CABundle)
 	}
 }
 
+func TestLoadResolvesRelativeFilePaths(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "config.yaml")
+	content := `
+profiles:
+  - name: p1
+    proxy_user: local_proxy_1
+    rds_host: db.example
+    rds_region: eu-west-1
+    rds_db_user: db_user_1
+    ca_bundle: /tmp/ca.pem
+    aws_config_file: ./aws/config
+    aws_credentials_file: /etc/aws/credentials
+control_socket: run/proxy.sock
+token_cache_file: /var/cache/tokens.json
+`
+	if err := os.WriteFile(cfgPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	p := cfg.Profiles[0]
+	if want := filepath.Join(tmp, "aws", "config"); p.AWSConfigFile != want {
+		t.Fatalf("expected resolved aws_config_file %s, got %s", want, p.AWSConfigFile)
+	}
+	if p.AWSCredentialsFile != "/etc/aws/credentials" {
+		t.Fatalf("expected absolute aws_credentials_file unchanged, got %s", p.AWSCredentialsFile)
+	}
+	if want := filepath.Join(tmp, "run", "proxy.sock"); cfg.ControlSocket != want {
+		t.Fatalf("expected resolved control_socket %s, got %s", want, cfg.ControlSocket)
+	}
+	if cfg.TokenCacheFile != "/var/cache/tokens.json" {
+		t.Fatalf("expected absolute token_cache_file unchanged, got %s", cfg.TokenCacheFile)
+	}
+}
+
+func TestLoadResolvesCABundleAgainstBase(t *testing.T) {
+	tmp := t.TempDir()
+	cfgDir := filepath.Join(tmp, "etc")
+	cwd := filepath.Join(tmp, "work")
+	for _, dir := range []string{cfgDir, cwd} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	t.Chdir(cwd)
+	cwd, err := os.Getwd() // may differ from the TempDir path through symlinks
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+
+	var cfg *Config
+	load := func(base string) (*Config, error) {
+		content := `
+profiles:
+  - name: p1
+    proxy_user: local_proxy_1
+    rds_host: db.example
+    rds_region: eu-west-1
+    rds_db_user: db_user_1
+    ca_bundle: certs/ca.pem
+    aws_config_file: aws/config
+` + base
+		cfgPath := filepath.Join(cfgDir, "config.yaml")
+		if err := os.WriteFile(cfgPath, []byte(content), 0o644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		return Load(cfgPath)
+	}
+
+	for _, tc := range []struct {
+		base string
+		want string
+	}{
+		{"", filepath.Join(cfgDir, "certs", "ca.pem")},
+		{"    ca_bundle_base: config-dir\n", filepath.Join(cfgDir, "certs", "ca.pem")},
+		{"    ca_bundle_base: cwd\n", filepath.Join(cwd, "certs", "ca.pem")},
+	} {
+		cfg, err = load(tc.base)
+		if err != nil {
+			t.Fatalf("Load with %q: %v", tc.base, err)
+		}
+		p := cfg.Profiles[0]
+		if p.CABundle != tc.want {
+			t.Fatalf("with %q expected ca_bundle %s, got %s", tc.base, tc.want, p.CABundle)
+		}
+		if want := filepath.Join(cfgDir, "aws", "config"); p.AWSConfigFile != want {
+			t.Fatalf("with %q expected aws_config_file to stay relative to the config dir, got %s", tc.base, p.AWSConfigFile)
+		}
+	}
+
+	if _, err := load("    ca_bundle_base: home\n"); err == nil || !strings.Contains(err.Error(), "ca_bundle_base") {
+		t.Fatalf("expected invalid ca_bundle_base error, got: %v", err)
+	}
+
+	// stdin has no config directory, so config-dir falls back to cwd.
+	orig := configStdin
+	t.Cleanup(func() { configStdin = orig })
+	configStdin = strings.NewReader(remoteConfigYAML + "    ca_bundle_base: config-dir\n")
+	cfg, err = Load(StdinConfigPath)
+	if err != nil {
+		t.Fatalf("Load stdin: %v", err)
+	}
+	if want := filepath.Join(cwd, "certs", "ca.pem"); cfg.Profiles[0].CABundle != want {
+		t.Fatalf("expected stdin ca_bundle %s, got %s", want, cfg.Profiles[0].CABundle)
+	}
+}
+
 func TestLoadRejectsDuplicateUsernamesAcrossProfiles(t *testing.T) {
 	t.Parallel()
 
@@ -97,6 +219,133 @@ profiles:
 	if !strings.Contains(err.Error(), "proxy_user") {
 		t.Fatalf("expected proxy_user validation error, got: %v", err)
 	}
+	var pe *ProfileError
+	if !errors.As(err, &pe) || pe.Profile != "p2" {
+		t.Fatalf("expected error attributed to p2, got: %#v", err)
+	}
+}
+
+func TestLoadDirMergesProfilesAcrossFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"billing.yaml": `
+profiles:
+  - name: billing
+    listen_addr: "127.0.0.1:3307"
+    proxy_user: local_billing
+    rds_host: billing.example
+    rds_region: eu-west-1
+    rds_db_user: billing_user
+    ca_bundle: certs/ca.pem
+control_socket: run/proxy.sock
+`,
+		"reporting.yaml": `
+profiles:
+  - name: reporting
+    listen_addr: "127.0.0.1:3308"
+    proxy_user: local_reporting
+    rds_host: reporting.example
+    rds_region: eu-west-1
+    rds_db_user: reporting_user
+    ca_bundle: /etc/ssl/rds.pem
+control_socket: run/proxy.sock
+`,
+		"notes.txt": "not a config",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	cfg, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if len(cfg.Profiles) != 2 || cfg.Profiles[0].Name != "billing" || cfg.Profiles[1].Name != "reporting" {
+		t.Fatalf("expected profiles from both files in name order, got %+v", cfg.Profiles)
+	}
+	if want := filepath.Join(dir, "certs", "ca.pem"); cfg.Profiles[0].CABundle != want {
+		t.Fatalf("expected ca_bundle resolved to %s, got %s", want, cfg.Profiles[0].CABundle)
+	}
+	if cfg.Profiles[1].SourceFile != filepath.Join(dir, "reporting.yaml") {
+		t.Fatalf("unexpected SourceFile %q", cfg.Profiles[1].SourceFile)
+	}
+	if want := filepath.Join(dir, "run", "proxy.sock"); cfg.ControlSocket != want {
+		t.Fatalf("expected control_socket %s, got %s", want, cfg.ControlSocket)
+	}
+}
+
+func TestLoadDirNamesSourceFilesInConflicts(t *testing.T) {
+	t.Parallel()
+
+	profile := func(name, proxyUser, dbUser string) string {
+		return fmt.Sprintf(`
+profiles:
+  - name: %s
+    proxy_user: %s
+    rds_host: db.example
+    rds_region: eu-west-1
+    rds_db_user: %s
+    ca_bundle: /tmp/ca.pem
+`, name, proxyUser, dbUser)
+	}
+	cases := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"proxy_user", profile("one", "shared", "u1"), profile("two", "shared", "u2"), `b.yaml: proxy_user "shared" is already used by profile "one" (a.yaml)`},
+		{"rds_db_user", profile("one", "p1", "shared"), profile("two", "p2", "shared"), `b.yaml: rds_db_user "shared" is already used by profile "one" (a.yaml)`},
+		{"profile name", profile("same", "p1", "u1"), profile("same", "p2", "u2"), "b.yaml: profile name is already used in a.yaml"},
+		{"control_socket", profile("one", "p1", "u1") + "control_socket: /run/a.sock\n", profile("two", "p2", "u2") + "control_socket: /run/b.sock\n", `b.yaml: control_socket "/run/b.sock" conflicts`},
+	}
+	for _, tc := range cases {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "a.yaml"), []byte(tc.a), 0o644); err != nil {
+			t.Fatalf("write a.yaml: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "b.yaml"), []byte(tc.b), 0o644); err != nil {
+			t.Fatalf("write b.yaml: %v", err)
+		}
+		if _, err := LoadDir(dir); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected %q, got: %v", tc.name, tc.want, err)
+		}
+	}
+
+	if _, err := LoadDir(t.TempDir()); err == nil || !strings.Contains(err.Error(), "no *.yaml files") {
+		t.Fatalf("expected empty dir error, got: %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "config.yaml")
+	content := `
+profiles:
+  - name: p1
+    proxy_user: local_proxy_1
+    proxy_password: one
+    rds_hots: db-1
+    rds_region: eu-west-1
+    rds_db_user: db_user_1
+    ca_bundle: ca.pem
+`
+	if err := os.WriteFile(cfgPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	_, err := Load(cfgPath)
+	if err == nil {
+		t.Fatal("expected error for unknown field, got nil")
+	}
+	if !strings.Contains(err.Error(), "rds_hots") {
+		t.Fatalf("expected error to mention the misspelled key, got: %v", err)
+	}
 }
 
 func TestValidateRuntimeRejectsNonLoopback(t *testing.T) {
@@ -120,7 +369,7 @@ func TestValidateRuntimeRejectsNonLoopback(t *testing.T) {
 		CABundle:      caPath,
 		MaxConns:      20,
 	}
-	err := p.ValidateRuntime(false)
+	err := p.ValidateRuntime(false, false)
 	if err == nil {
 		t.Fatal("expected non-loopback validation error")
 	}
@@ -129,6 +378,34 @@ func TestValidateRuntimeRejectsNonLoopback(t *testing.T) {
 	}
 }
 
+func TestValidateRuntimeRequiresAckForInsecureSkipTLSVerify(t *testing.T) {
+	t.Parallel()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, []byte("dummy"), 0o644); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+	p := Profile{
+		Name:                  "p1",
+		ListenAddr:            "127.0.0.1:3307",
+		ProxyUser:             "local_proxy_1",
+		ProxyPassword:         "secret",
+		RDSHost:               "db.example",
+		RDSPort:               3306,
+		RDSRegion:             "eu-west-1",
+		RDSDBUser:             "db_user_1",
+		CABundle:              caPath,
+		MaxConns:              20,
+		InsecureSkipTLSVerify: true,
+	}
+	if err := p.ValidateRuntime(false, false); err == nil || !strings.Contains(err.Error(), "--allow-insecure") {
+		t.Fatalf("expected --allow-insecure error, got: %v", err)
+	}
+	if err := p.ValidateRuntime(false, true); err != nil {
+		t.Fatalf("expected acknowledged insecure_skip_tls_verify to validate, got: %v", err)
+	}
+}
+
 func TestSelectProfileAmbiguous(t *testing.T) {
 	t.Parallel()
 
@@ -166,6 +443,64 @@ func TestValidateProfileMaxConnsLimit(t *testing.T) {
 	}
 }
 
+func TestValidateProfileRejectsNonHTTPSEndpointURL(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:           "p",
+		ListenAddr:     "127.0.0.1:3307",
+		MaxConns:       10,
+		ProxyUser:      "local_proxy_1",
+		ProxyPassword:  "pw",
+		RDSHost:        "db",
+		RDSRegion:      "us-gov-west-1",
+		RDSDBUser:      "db_user_1",
+		CABundle:       "/tmp/ca.pem",
+		STSEndpointURL: "http://sts.us-gov-west-1.amazonaws.com",
+	}
+	err := validateProfile(p)
+	if err == nil {
+		t.Fatal("expected sts_endpoint_url validation error")
+	}
+	if !strings.Contains(err.Error(), "sts_endpoint_url") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.STSEndpointURL = "https://sts.us-gov-west-1.amazonaws.com"
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected https endpoint to be accepted, got: %v", err)
+	}
+}
+
+func TestValidateProfileRejectsInvalidDenyStatement(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:           "p",
+		ListenAddr:     "127.0.0.1:3307",
+		MaxConns:       10,
+		ProxyUser:      "local_proxy_1",
+		ProxyPassword:  "pw",
+		RDSHost:        "db",
+		RDSRegion:      "us-gov-west-1",
+		RDSDBUser:      "db_user_1",
+		CABundle:       "/tmp/ca.pem",
+		DenyStatements: []string{"DELETE", "DROP;"},
+	}
+	err := validateProfile(p)
+	if err == nil {
+		t.Fatal("expected deny_statements validation error")
+	}
+	if !strings.Contains(err.Error(), "deny_statements") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.DenyStatements = []string{"DELETE", "load data"}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected keyword entries to be accepted, got: %v", err)
+	}
+}
+
 func TestResolveConfigPathFallsBackToExecutableDir(t *testing.T) {
 	tmp := t.TempDir()
 	t.Setenv("HOME", filepath.Join(tmp, "home"))
@@ -295,6 +630,40 @@ func TestResolveConfigPathFindsParentDirectoryConfig(t *testing.T) {
 	}
 }
 
+func TestResolveConfigPathInCwdIgnoresParentConfig(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	child := filepath.Join(tmp, "repo", "service")
+	if err := os.MkdirAll(child, 0o755); err != nil {
+		t.Fatalf("mkdir child: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "repo", "config.yaml"), []byte("profiles: []"), 0o644); err != nil {
+		t.Fatalf("write parent config: %v", err)
+	}
+	getwd := func() (string, error) { return child, nil }
+
+	_, err := resolveConfigPathInCwd("", getwd)
+	if err == nil || !strings.Contains(err.Error(), "config search is disabled") {
+		t.Fatalf("expected parent config to be ignored, got: %v", err)
+	}
+
+	cwdCfg := filepath.Join(child, "config.yaml")
+	if err := os.WriteFile(cwdCfg, []byte("profiles: []"), 0o644); err != nil {
+		t.Fatalf("write cwd config: %v", err)
+	}
+	res, err := resolveConfigPathInCwd("", getwd)
+	if err != nil || res.Path != cwdCfg || res.Source != "current working directory" {
+		t.Fatalf("expected cwd config, got %+v, %v", res, err)
+	}
+
+	explicit := filepath.Join(tmp, "elsewhere.yaml")
+	res, err = resolveConfigPathInCwd(explicit, getwd)
+	if err != nil || res.Path != explicit || res.Source != "flag --config" {
+		t.Fatalf("expected explicit --config to be honored, got %+v, %v", res, err)
+	}
+}
+
 func TestResolveConfigPathFallsBackToExecutableParentDirectory(t *testing.T) {
 	tmp := t.TempDir()
 	t.Setenv("HOME", filepath.Join(tmp, "home"))
@@ -333,3 +702,761 @@ func TestResolveConfigPathFallsBackToExecutableParentDirectory(t *testing.T) {
 		t.Fatalf("expected executable parent directory source, got %s", resolved.Source)
 	}
 }
+
+func TestParseAllowedClients(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseAllowedClients([]string{"127.0.0.0/8", "10.1.2.3/16", "::1"})
+	if err != nil {
+		t.Fatalf("ParseAllowedClients: %v", err)
+	}
+	want := []string{"127.0.0.0/8", "10.1.0.0/16", "::1/128"}
+	for i, prefix := range got {
+		if prefix.String() != want[i] {
+			t.Fatalf("prefix %d = %s, want %s", i, prefix, want[i])
+		}
+	}
+	if _, err := ParseAllowedClients([]string{"docker-bridge"}); err == nil || !strings.Contains(err.Error(), "allowed_clients") {
+		t.Fatalf("expected allowed_clients error, got: %v", err)
+	}
+}
+
+func TestValidateProfileRejectsUnknownChangeUserMode(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:           "p",
+		ListenAddr:     "127.0.0.1:3307",
+		MaxConns:       10,
+		ProxyUser:      "local_proxy_1",
+		ProxyPassword:  "pw",
+		RDSHost:        "db",
+		RDSRegion:      "eu-west-1",
+		RDSDBUser:      "db_user_1",
+		CABundle:       "/tmp/ca.pem",
+		ChangeUserMode: "replay",
+	}
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "change_user_mode") {
+		t.Fatalf("expected change_user_mode error, got: %v", err)
+	}
+	for _, mode := range []string{"", ChangeUserReject, ChangeUserReset} {
+		p.ChangeUserMode = mode
+		if err := validateProfile(p); err != nil {
+			t.Fatalf("expected mode %q to be accepted, got: %v", mode, err)
+		}
+	}
+}
+
+func TestValidateProfileDrainMode(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:          "p",
+		ListenAddr:    "127.0.0.1:3307",
+		MaxConns:      10,
+		ProxyUser:     "local_proxy_1",
+		ProxyPassword: "pw",
+		RDSHost:       "db",
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_user_1",
+		CABundle:      "/tmp/ca.pem",
+		DrainMode:     "queue",
+	}
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "drain_mode") {
+		t.Fatalf("expected drain_mode error, got: %v", err)
+	}
+	for _, mode := range []string{"", DrainCloseListener, DrainRejectWithError} {
+		p.DrainMode = mode
+		if err := validateProfile(p); err != nil {
+			t.Fatalf("expected mode %q to be accepted, got: %v", mode, err)
+		}
+	}
+}
+
+func TestValidateProfileTimeouts(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:          "p",
+		ListenAddr:    "127.0.0.1:3307",
+		MaxConns:      10,
+		ProxyUser:     "local_proxy_1",
+		ProxyPassword: "pw",
+		RDSHost:       "db",
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_user_1",
+		CABundle:      "/tmp/ca.pem",
+		AuthTimeout:   -time.Second,
+	}
+	// A negative auth_timeout turns the handshake deadline off.
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected negative auth_timeout to be accepted, got: %v", err)
+	}
+	applyDefaults(&p)
+	if p.AuthTimeout != -time.Second {
+		t.Fatalf("expected applyDefaults to keep a negative auth_timeout, got %s", p.AuthTimeout)
+	}
+	p.AuthTimeout, p.SessionSetupTimeout = time.Second, -time.Second
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "session_setup_timeout") {
+		t.Fatalf("expected session_setup_timeout error, got: %v", err)
+	}
+}
+
+func TestValidateProfileRejectsCompressionWithInspection(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:             "p",
+		ListenAddr:       "127.0.0.1:3307",
+		MaxConns:         10,
+		ProxyUser:        "local_proxy_1",
+		ProxyPassword:    "pw",
+		RDSHost:          "db",
+		RDSRegion:        "eu-west-1",
+		RDSDBUser:        "db_user_1",
+		CABundle:         "/tmp/ca.pem",
+		AllowCompression: true,
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected allow_compression alone to validate, got: %v", err)
+	}
+	p.DenyStatements = []string{"DROP"}
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "allow_compression") {
+		t.Fatalf("expected allow_compression error, got: %v", err)
+	}
+}
+
+func TestLoadExpandsHostsIntoProfiles(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	caPath := filepath.Join(tmp, "ca.pem")
+	if err := os.WriteFile(caPath, []byte("dummy"), 0o644); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+	cfgPath := filepath.Join(tmp, "config.yaml")
+	content := `
+profiles:
+  - name: replicas
+    listen_addr: "127.0.0.1:3310"
+    proxy_user: local_replica
+    proxy_password: pw
+    hosts:
+      - replica-a.cluster-xxxx.eu-west-1.rds.amazonaws.com
+      - replica-b.cluster-xxxx.eu-west-1.rds.amazonaws.com
+      - replica-c.cluster-xxxx.eu-west-1.rds.amazonaws.com
+    rds_region: eu-west-1
+    rds_db_user: iam_reader
+    ca_bundle: ` + caPath + `
+  - name: primary
+    listen_addr: "127.0.0.1:3307"
+    proxy_user: local_primary
+    proxy_password: pw
+    rds_host: primary.cluster-xxxx.eu-west-1.rds.amazonaws.com
+    rds_region: eu-west-1
+    rds_db_user: iam_writer
+    ca_bundle: ` + caPath + `
+`
+	if err := os.WriteFile(cfgPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Profiles) != 4 {
+		t.Fatalf("expected 4 profiles after expansion, got %d", len(cfg.Profiles))
+	}
+	for i, want := range []struct{ name, user, listen, host string }{
+		{"replicas-replica-a", "local_replica_1", "127.0.0.1:3310", "replica-a.cluster-xxxx.eu-west-1.rds.amazonaws.com"},
+		{"replicas-replica-b", "local_replica_2", "127.0.0.1:3311", "replica-b.cluster-xxxx.eu-west-1.rds.amazonaws.com"},
+		{"replicas-replica-c", "local_replica_3", "127.0.0.1:3312", "replica-c.cluster-xxxx.eu-west-1.rds.amazonaws.com"},
+	} {
+		p := cfg.Profiles[i]
+		if p.Name != want.name || p.ProxyUser != want.user || p.ListenAddr != want.listen || p.RDSHost != want.host {
+			t.Fatalf("profile %d = %s/%s/%s/%s, want %+v", i, p.Name, p.ProxyUser, p.ListenAddr, p.RDSHost, want)
+		}
+		if p.RDSDBUser != "iam_reader" || p.ExpandedFrom != "replicas" || p.RDSPort != 3306 {
+			t.Fatalf("profile %d did not inherit template fields: %+v", i, p)
+		}
+	}
+	if cfg.Profiles[3].Name != "primary" || cfg.Profiles[3].ExpandedFrom != "" {
+		t.Fatalf("expected plain profile to pass through, got %+v", cfg.Profiles[3])
+	}
+}
+
+func TestExpandHostsRejectsInvalidTemplates(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string][]Profile{
+		"not both":       {{Name: "r", RDSHost: "db", Hosts: []string{"a.x"}}},
+		"leaves no room": {{Name: "r", ListenAddr: "127.0.0.1:65535", Hosts: []string{"a.x", "b.x"}}},
+		"clashes":        {{Name: "r-a", RDSHost: "db"}, {Name: "r", Hosts: []string{"a.x"}}},
+		"is empty":       {{Name: "r", Hosts: []string{"a.x", " "}}},
+	}
+	for want, profiles := range cases {
+		if _, err := expandHosts(profiles); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q, got: %v", want, err)
+		}
+	}
+
+	got, err := expandHosts([]Profile{{Name: "r", ProxyUser: "u", Hosts: []string{"db.one.example", "db.two.example"}}})
+	if err != nil {
+		t.Fatalf("expandHosts: %v", err)
+	}
+	if got[0].Name != "r-1" || got[1].Name != "r-2" || got[0].ListenAddr != "127.0.0.1:3307" {
+		t.Fatalf("expected index names for colliding labels, got %+v", got)
+	}
+}
+
+const remoteConfigYAML = `
+profiles:
+  - name: piped
+    proxy_user: local_proxy_1
+    proxy_password: one
+    rds_host: db-1
+    rds_region: eu-west-1
+    rds_db_user: db_user_1
+    ca_bundle: certs/ca.pem
+`
+
+func TestLoadReadsConfigFromStdin(t *testing.T) {
+	orig := configStdin
+	t.Cleanup(func() { configStdin = orig })
+	configStdin = strings.NewReader(remoteConfigYAML)
+
+	cfg, err := Load(StdinConfigPath)
+	if err != nil {
+		t.Fatalf("Load stdin: %v", err)
+	}
+	wd, _ := os.Getwd()
+	if got := cfg.Profiles[0].CABundle; got != filepath.Join(wd, "certs", "ca.pem") {
+		t.Fatalf("expected ca_bundle relative to cwd, got %s", got)
+	}
+}
+
+func TestLoadFetchesHTTPSConfigAndRejectsPlainHTTP(t *testing.T) {
+	orig := configHTTPGet
+	t.Cleanup(func() { configHTTPGet = orig })
+	var fetched string
+	configHTTPGet = func(u string) (*http.Response, error) {
+		fetched = u
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(remoteConfigYAML))}, nil
+	}
+
+	cfg, err := Load("https://config.internal.example/rds-iam-proxy.yaml")
+	if err != nil {
+		t.Fatalf("Load https: %v", err)
+	}
+	if fetched != "https://config.internal.example/rds-iam-proxy.yaml" || cfg.Profiles[0].Name != "piped" {
+		t.Fatalf("unexpected fetch %q / profiles %+v", fetched, cfg.Profiles)
+	}
+
+	fetched = ""
+	if _, err := Load("http://config.internal.example/rds-iam-proxy.yaml"); err == nil || !strings.Contains(err.Error(), "https") {
+		t.Fatalf("expected https-only error, got: %v", err)
+	}
+	if fetched != "" {
+		t.Fatal("plain http url must not be fetched")
+	}
+
+	res, err := ResolveConfigPathDetailed("https://config.internal.example/rds-iam-proxy.yaml")
+	if err != nil || res.Path != "https://config.internal.example/rds-iam-proxy.yaml" {
+		t.Fatalf("expected url to pass through resolution, got %+v, %v", res, err)
+	}
+}
+
+func TestIAMTokenDBUserDefaultsAndValidation(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:           "p",
+		ProxyUser:      "local_proxy_1",
+		ProxyPassword:  "pw",
+		RDSHost:        "db",
+		RDSRegion:      "eu-west-1",
+		IAMTokenDBUser: "iam_auth",
+		CABundle:       "/tmp/ca.pem",
+	}
+	applyDefaults(&p)
+	if p.RDSDBUser != "iam_auth" || p.TokenDBUser() != "iam_auth" {
+		t.Fatalf("expected login user to default to token user, got %q/%q", p.RDSDBUser, p.TokenDBUser())
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected token-user-only profile to validate, got: %v", err)
+	}
+
+	p.RDSDBUser = "app_login"
+	if p.TokenDBUser() != "iam_auth" {
+		t.Fatalf("expected distinct token user, got %q", p.TokenDBUser())
+	}
+
+	p.IAMTokenDBUser, p.RDSDBUser = "", ""
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "iam_token_db_user") {
+		t.Fatalf("expected missing token user error, got: %v", err)
+	}
+
+	p.RDSDBUser, p.IAMTokenDBUser = "app_login", "local_proxy_1"
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "proxy_user must differ") {
+		t.Fatalf("expected proxy_user clash error, got: %v", err)
+	}
+}
+
+func TestListenAddrsAndFamilyValidation(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		addr, family string
+		want         []string
+		wantErr      string
+	}{
+		{addr: "127.0.0.1:3307", want: []string{"127.0.0.1:3307"}},
+		{addr: "localhost:3307", want: []string{"127.0.0.1:3307", "[::1]:3307"}},
+		{addr: "localhost:3307", family: ListenDual, want: []string{"127.0.0.1:3307", "[::1]:3307"}},
+		{addr: "localhost:3307", family: ListenIPv6, want: []string{"[::1]:3307"}},
+		{addr: "localhost:3307", family: ListenIPv4, want: []string{"127.0.0.1:3307"}},
+		{addr: "[::1]:3307", family: ListenIPv6, want: []string{"[::1]:3307"}},
+		{addr: "127.0.0.1:3307", family: ListenIPv6, wantErr: "does not match"},
+		{addr: "127.0.0.1:3307", family: ListenDual, wantErr: "needs listen_addr host localhost"},
+		{addr: "localhost:3307", family: "both", wantErr: "listen_family"},
+	}
+	for _, tc := range cases {
+		p := Profile{ListenAddr: tc.addr, ListenFamily: tc.family}
+		err := validateListenFamily(p)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("%s/%s: expected error %q, got: %v", tc.addr, tc.family, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s/%s: unexpected error: %v", tc.addr, tc.family, err)
+		}
+		if got := p.ListenAddrs(); strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Fatalf("%s/%s: ListenAddrs() = %v, want %v", tc.addr, tc.family, got, tc.want)
+		}
+	}
+
+	for _, addr := range []string{"localhost:3307", "[::1]:3307", "127.0.0.1:3307"} {
+		if !IsLoopbackAddr(addr) {
+			t.Fatalf("expected %s to count as loopback", addr)
+		}
+	}
+}
+
+func TestAuroraFailoverAwareRequiresWriterEndpoint(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]bool{
+		"orders.cluster-abc123.eu-west-1.rds.amazonaws.com":        true,
+		"Orders.Cluster-ABC123.cn-north-1.rds.amazonaws.com.cn":    true,
+		"orders.cluster-ro-abc123.eu-west-1.rds.amazonaws.com":     false,
+		"orders.cluster-custom-abc123.eu-west-1.rds.amazonaws.com": false,
+		"orders-1.abc123.eu-west-1.rds.amazonaws.com":              false,
+		"10.0.0.5": false,
+		"db":       false,
+	}
+	for host, want := range cases {
+		if got := IsAuroraWriterEndpoint(host); got != want {
+			t.Fatalf("IsAuroraWriterEndpoint(%q) = %v, want %v", host, got, want)
+		}
+	}
+
+	p := Profile{
+		Name:                "p",
+		ListenAddr:          "127.0.0.1:3307",
+		MaxConns:            10,
+		ProxyUser:           "local_proxy_1",
+		ProxyPassword:       "pw",
+		RDSHost:             "orders.cluster-abc123.eu-west-1.rds.amazonaws.com",
+		RDSRegion:           "eu-west-1",
+		RDSDBUser:           "db_user_1",
+		CABundle:            "/tmp/ca.pem",
+		AuroraFailoverAware: true,
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected writer endpoint to validate, got: %v", err)
+	}
+	p.RDSHost = "orders.cluster-ro-abc123.eu-west-1.rds.amazonaws.com"
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "aurora_failover_aware") {
+		t.Fatalf("expected aurora_failover_aware error for reader endpoint, got: %v", err)
+	}
+}
+
+func TestValidateProfileRejectsUnknownLogLevel(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:          "p",
+		ListenAddr:    "127.0.0.1:3307",
+		MaxConns:      10,
+		ProxyUser:     "local_proxy_1",
+		ProxyPassword: "pw",
+		RDSHost:       "db",
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_user_1",
+		CABundle:      "/tmp/ca.pem",
+		LogLevel:      "debug",
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected log_level debug to validate, got: %v", err)
+	}
+	p.LogLevel = "trace"
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "log_level") {
+		t.Fatalf("expected log_level error, got: %v", err)
+	}
+}
+
+func TestValidateProfileMaxPacketBytes(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:           "p",
+		ListenAddr:     "127.0.0.1:3307",
+		MaxConns:       10,
+		ProxyUser:      "local_proxy_1",
+		ProxyPassword:  "pw",
+		RDSHost:        "db",
+		RDSRegion:      "eu-west-1",
+		RDSDBUser:      "db_user_1",
+		CABundle:       "/tmp/ca.pem",
+		MaxPacketBytes: 16 << 20,
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected max_packet_bytes to validate, got: %v", err)
+	}
+	p.MaxPacketBytes = 100
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "max_packet_bytes") {
+		t.Fatalf("expected max_packet_bytes floor error, got: %v", err)
+	}
+	p.MaxPacketBytes, p.AllowCompression = 4096, true
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "allow_compression") {
+		t.Fatalf("expected allow_compression conflict, got: %v", err)
+	}
+}
+
+func TestValidateProfileVersionComment(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:           "p",
+		ListenAddr:     "127.0.0.1:3307",
+		MaxConns:       10,
+		ProxyUser:      "local_proxy_1",
+		ProxyPassword:  "pw",
+		RDSHost:        "db",
+		RDSRegion:      "eu-west-1",
+		RDSDBUser:      "db_user_1",
+		CABundle:       "/tmp/ca.pem",
+		VersionComment: "staging via rds-iam-proxy",
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected version_comment to validate, got: %v", err)
+	}
+	p.VersionComment = "bad\x00comment"
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "version_comment") {
+		t.Fatalf("expected version_comment NUL error, got: %v", err)
+	}
+	p.VersionComment, p.AllowCompression = "staging", true
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "allow_compression") {
+		t.Fatalf("expected allow_compression conflict, got: %v", err)
+	}
+	p.VersionComment, p.ReportMaxConnections = "", true
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "report_max_connections") {
+		t.Fatalf("expected report_max_connections conflict, got: %v", err)
+	}
+}
+
+func TestValidateProfileBackendSessionInit(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:               "p",
+		ListenAddr:         "127.0.0.1:3307",
+		MaxConns:           10,
+		ProxyUser:          "local_proxy_1",
+		ProxyPassword:      "pw",
+		RDSHost:            "db",
+		RDSRegion:          "eu-west-1",
+		RDSDBUser:          "db_user_1",
+		CABundle:           "/tmp/ca.pem",
+		BackendSessionInit: []string{"SET SESSION TRANSACTION READ ONLY"},
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected backend_session_init to validate, got: %v", err)
+	}
+	p.BackendSessionInit = append(p.BackendSessionInit, "  ")
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "backend_session_init[1]") {
+		t.Fatalf("expected empty statement error, got: %v", err)
+	}
+}
+
+func TestValidateProfileAllowedClientAuthPlugins(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:                     "p",
+		ListenAddr:               "127.0.0.1:3307",
+		MaxConns:                 10,
+		ProxyUser:                "local_proxy_1",
+		ProxyPassword:            "pw",
+		RDSHost:                  "db",
+		RDSRegion:                "eu-west-1",
+		RDSDBUser:                "db_user_1",
+		CABundle:                 "/tmp/ca.pem",
+		AllowedClientAuthPlugins: []string{"caching_sha2_password", "mysql_native_password"},
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected allowed_client_auth_plugins to validate, got: %v", err)
+	}
+	p.AllowedClientAuthPlugins = append(p.AllowedClientAuthPlugins, "mysql_clear_password")
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "allowed_client_auth_plugins[2]") {
+		t.Fatalf("expected unsupported plugin error, got: %v", err)
+	}
+	p.AllowedClientAuthPlugins, p.ClientTLSAutocert = []string{"mysql_native_password"}, true
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "client_tls_autocert") {
+		t.Fatalf("expected client_tls_autocert conflict, got: %v", err)
+	}
+}
+
+func TestValidateProfileBackendLinger(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:          "p",
+		ListenAddr:    "127.0.0.1:3307",
+		MaxConns:      10,
+		ProxyUser:     "local_proxy_1",
+		ProxyPassword: "pw",
+		RDSHost:       "db",
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_user_1",
+		CABundle:      "/tmp/ca.pem",
+	}
+	for _, d := range []time.Duration{0, 5 * time.Second} {
+		p.BackendLinger = &d
+		if err := validateProfile(p); err != nil {
+			t.Fatalf("expected backend_linger %s to validate, got: %v", d, err)
+		}
+	}
+	for _, d := range []time.Duration{-time.Second, 1500 * time.Millisecond} {
+		p.BackendLinger = &d
+		if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "backend_linger") {
+			t.Fatalf("expected backend_linger %s error, got: %v", d, err)
+		}
+	}
+}
+
+func TestValidateProfilePinBackendIP(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:                   "p",
+		ListenAddr:             "127.0.0.1:3307",
+		MaxConns:               10,
+		ProxyUser:              "local_proxy_1",
+		ProxyPassword:          "pw",
+		RDSHost:                "app.cluster-abc.eu-west-1.rds.amazonaws.com",
+		RDSRegion:              "eu-west-1",
+		RDSDBUser:              "db_user_1",
+		CABundle:               "/tmp/ca.pem",
+		PinBackendIP:           true,
+		BackendResolveInterval: 30 * time.Second,
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected pin_backend_ip to validate, got: %v", err)
+	}
+	cases := []struct {
+		name   string
+		mutate func(*Profile)
+		want   string
+	}{
+		{"negative interval", func(p *Profile) { p.BackendResolveInterval = -time.Second }, "backend_resolve_interval must be >= 0"},
+		{"interval without pin", func(p *Profile) { p.PinBackendIP = false }, "requires pin_backend_ip"},
+		{"aurora", func(p *Profile) { p.AuroraFailoverAware = true }, "cannot be combined with aurora_failover_aware"},
+		{"negative prewarm concurrency", func(p *Profile) { p.PrewarmConcurrency = -1 }, "prewarm_concurrency must be >= 0"},
+	}
+	for _, tc := range cases {
+		bad := p
+		tc.mutate(&bad)
+		if err := validateProfile(bad); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected %q error, got: %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestValidateProfileTLSSettings(t *testing.T) {
+	t.Parallel()
+
+	base := Profile{
+		Name:          "p",
+		ListenAddr:    "127.0.0.1:3307",
+		MaxConns:      10,
+		ProxyUser:     "local_proxy_1",
+		ProxyPassword: "pw",
+		RDSHost:       "db",
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_user_1",
+		CABundle:      "/tmp/ca.pem",
+	}
+	cases := []struct {
+		minTLS  string
+		suites  []string
+		wantErr string
+	}{
+		{minTLS: "1.3"},
+		{minTLS: "1.2", suites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "tls_ecdhe_ecdsa_with_chacha20_poly1305_sha256"}},
+		{minTLS: "1.1", wantErr: "min_tls_version"},
+		{suites: []string{"TLS_BOGUS"}, wantErr: "not a known cipher suite"},
+		{suites: []string{"TLS_RSA_WITH_RC4_128_SHA"}, wantErr: "insecure"},
+		{suites: []string{"TLS_AES_128_GCM_SHA256"}, wantErr: "TLS 1.3 suite"},
+		{minTLS: "1.3", suites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, wantErr: "only apply to TLS 1.2"},
+	}
+	for _, tc := range cases {
+		p := base
+		p.MinTLSVersion, p.CipherSuites = tc.minTLS, tc.suites
+		err := validateProfile(p)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Fatalf("%q %v: unexpected error: %v", tc.minTLS, tc.suites, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%q %v: expected error %q, got: %v", tc.minTLS, tc.suites, tc.wantErr, err)
+		}
+	}
+}
+
+func TestValidateProfileAWSCredentialTimeout(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:                 "p",
+		ListenAddr:           "127.0.0.1:3307",
+		MaxConns:             10,
+		ProxyUser:            "local_proxy_1",
+		ProxyPassword:        "pw",
+		RDSHost:              "db",
+		RDSRegion:            "eu-west-1",
+		RDSDBUser:            "db_user_1",
+		CABundle:             "/tmp/ca.pem",
+		AWSCredentialTimeout: 5 * time.Second,
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected aws_credential_timeout to validate, got: %v", err)
+	}
+	p.AWSCredentialTimeout = -time.Second
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "aws_credential_timeout") {
+		t.Fatalf("expected aws_credential_timeout error, got: %v", err)
+	}
+}
+
+func TestValidateProfileRDSHosts(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:          "p",
+		ListenAddr:    "127.0.0.1:3307",
+		MaxConns:      10,
+		ProxyUser:     "local_proxy_1",
+		ProxyPassword: "pw",
+		RDSHosts:      []string{"replica-1.abc.eu-west-1.rds.amazonaws.com", "replica-2.abc.eu-west-1.rds.amazonaws.com"},
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_user_1",
+		CABundle:      "/tmp/ca.pem",
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected rds_hosts to validate, got: %v", err)
+	}
+	if got := p.BackendHosts(); len(got) != 2 {
+		t.Fatalf("BackendHosts() = %v", got)
+	}
+	if hp := p.ForHost(p.RDSHosts[1]); hp.RDSHost != p.RDSHosts[1] || hp.RDSHosts != nil {
+		t.Fatalf("ForHost = %q %v", hp.RDSHost, hp.RDSHosts)
+	}
+
+	for name, tc := range map[string]struct {
+		mutate func(*Profile)
+		want   string
+	}{
+		"both":      {func(p *Profile) { p.RDSHost = "db" }, "not both"},
+		"empty":     {func(p *Profile) { p.RDSHosts = []string{"a", " "} }, "rds_hosts entry 2 is empty"},
+		"duplicate": {func(p *Profile) { p.RDSHosts = []string{"a", "a"} }, "more than once"},
+		"aurora":    {func(p *Profile) { p.AuroraFailoverAware = true }, "aurora_failover_aware cannot be combined with rds_hosts"},
+	} {
+		bad := p
+		bad.RDSHosts = slices.Clone(p.RDSHosts)
+		tc.mutate(&bad)
+		if err := validateProfile(bad); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected %q error, got: %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestValidateProfileMaxConnsUnlimited(t *testing.T) {
+	t.Parallel()
+
+	p := Profile{
+		Name:          "p",
+		ListenAddr:    "127.0.0.1:3307",
+		MaxConns:      MaxConnsUnlimited,
+		ProxyUser:     "local_proxy_1",
+		ProxyPassword: "pw",
+		RDSHost:       "db",
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_user_1",
+		CABundle:      "/tmp/ca.pem",
+	}
+	if err := validateProfile(p); err != nil {
+		t.Fatalf("expected max_conns -1 to validate as unlimited, got: %v", err)
+	}
+	p.MaxConns = -2
+	if err := validateProfile(p); err == nil || !strings.Contains(err.Error(), "-1 for unlimited") {
+		t.Fatalf("expected max_conns error, got: %v", err)
+	}
+}
+
+func TestResolveConfigPathInOrderPinsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	cwd := filepath.Join(tmp, "work", "cwd")
+	exeDir := filepath.Join(tmp, "opt", "bin")
+	for _, dir := range []string{cwd, exeDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("profiles: []"), 0o644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+	getwd := func() (string, error) { return cwd, nil }
+	exe := func() (string, error) { return filepath.Join(exeDir, "rds-iam-proxy"), nil }
+	home := func() (string, error) { return filepath.Join(tmp, "home"), nil }
+
+	order, err := ParseConfigSearchOrder(" EXE, cwd")
+	if err != nil {
+		t.Fatalf("ParseConfigSearchOrder: %v", err)
+	}
+	resolved, err := resolveConfigPathInOrder("", order, getwd, exe, home)
+	if err != nil {
+		t.Fatalf("resolveConfigPathInOrder: %v", err)
+	}
+	if resolved.Source != "executable directory" || !samePath(resolved.Path, filepath.Join(exeDir, "config.yaml")) {
+		t.Fatalf("expected the executable directory config first, got %s from %s", resolved.Path, resolved.Source)
+	}
+
+	// Locations left out of the order are never searched.
+	_, err = resolveConfigPathInOrder("", []string{ConfigSearchHome}, getwd, exe, home)
+	if err == nil || strings.Contains(err.Error(), cwd) || !strings.Contains(err.Error(), "create config.yaml in ~/.config/rds-iam-proxy/config.yaml") {
+		t.Fatalf("expected a home-only not found error, got: %v", err)
+	}
+
+	for _, bad := range []string{"", "cwd,etc", "cwd,cwd"} {
+		if _, err := ParseConfigSearchOrder(bad); err == nil {
+			t.Fatalf("ParseConfigSearchOrder(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// durationPattern matches the Go duration strings duration fields accept,
+// e.g. "90s" or "1h30m".
+const durationPattern = `^(0|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+
+// profileFieldSchema adds the constraints and notes validateProfile and
+// ValidateRuntime enforce to the schema of individual profile fields.
+var profileFieldSchema = map[string]map[string]any{
+	"name":       {"description": "Profile name, used by --profile; must be unique."},
+	"proxy_user": {"description": "User clients log in to the proxy as; must be unique across profiles."},
+	"proxy_password": {
+		"description": "Password clients log in with; must not be empty unless --allow-dev-empty-password is passed.",
+	},
+	"listen_addr": {
+		"description": "host:port the proxy listens on. Must be a loopback address or localhost; this is checked at startup.",
+	},
+	"max_conns": {
+		"description": "Frontend connection cap; -1 removes it, including the hard limit.",
+		"minimum":     MaxConnsUnlimited,
+		"maximum":     maxConnsHardLimit,
+	},
+	"rds_host":  {"description": "Backend endpoint; set this, rds_hosts or hosts."},
+	"rds_hosts": {"description": "Backend endpoints connections are spread across; set instead of rds_host.", "uniqueItems": true},
+	"hosts":     {"description": "Expands the profile into one profile per host, on consecutive listen ports."},
+	"rds_port":  {"minimum": 0, "maximum": 65535},
+	"auth_timeout": {
+		"description": "Limit for a client to complete the handshake and login; a negative value such as -1s disables it.",
+		"pattern":     "^-?" + durationPattern[1:],
+	},
+	"rds_db_user": {"description": "DB user the proxy logs in as; defaults to iam_token_db_user."},
+	"ca_bundle": {
+		"description": "PEM bundle backend certificates are verified against; relative paths resolve against ca_bundle_base.",
+	},
+	"ca_bundle_base":   {"enum": []any{"", CABundleBaseConfigDir, CABundleBaseCwd}},
+	"change_user_mode": {"enum": []any{"", ChangeUserReject, ChangeUserReset}},
+	"listen_family":    {"enum": []any{"", ListenIPv4, ListenIPv6, ListenDual}},
+	"log_level":        {"enum": []any{"", "debug", "info", "warn", "error"}},
+	"min_tls_version":  {"enum": []any{"", "1.2", "1.3"}},
+	"drain_mode":       {"enum": []any{"", DrainCloseListener, DrainRejectWithError}},
+	"max_packet_bytes": {"description": "0 leaves packets unlimited.", "anyOf": []any{map[string]any{"const": 0}, map[string]any{"minimum": minMaxPacketBytes}}},
+	"allowed_client_auth_plugins": {
+		"items": map[string]any{"type": "string", "enum": stringsToAny(ClientAuthPlugins)},
+	},
+	"socket_send_buffer":       {"minimum": 0},
+	"socket_recv_buffer":       {"minimum": 0},
+	"prewarm_concurrency":      {"minimum": 0},
+	"insecure_skip_tls_verify": {"description": "Skips backend certificate verification; requires --allow-insecure."},
+}
+
+// JSONSchema returns a JSON Schema (draft 2020-12) for the config file, for
+// editor completion and validation. Defaults are those applyDefaults fills
+// in; checks that need the whole config, such as unique names, or the local
+// environment, such as a loopback listen_addr, are only described.
+func JSONSchema() map[string]any {
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "rds-iam-proxy config",
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []any{"profiles"},
+		"properties": map[string]any{
+			"profiles": map[string]any{
+				"type":     "array",
+				"minItems": 1,
+				"items":    profileSchema(),
+			},
+			"control_socket": map[string]any{
+				"type":        "string",
+				"description": "Unix socket a running proxy answers `rds-iam-proxy status` on; empty disables it.",
+			},
+			"token_cache_file": map[string]any{
+				"type":        "string",
+				"description": "File IAM tokens are persisted to across restarts; empty disables it.",
+			},
+		},
+	}
+}
+
+func profileSchema() map[string]any {
+	defaults := Profile{}
+	applyDefaults(&defaults)
+	dv := reflect.ValueOf(defaults)
+
+	props := map[string]any{}
+	t := dv.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		prop := typeSchema(f.Type)
+		if v := dv.Field(i); !v.IsZero() {
+			if d, ok := v.Interface().(time.Duration); ok {
+				prop["default"] = d.String()
+			} else {
+				prop["default"] = v.Interface()
+			}
+		}
+		for k, v := range profileFieldSchema[key] {
+			prop[k] = v
+		}
+		props[key] = prop
+	}
+	return map[string]any{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []any{"name", "proxy_user", "rds_region", "ca_bundle"},
+		"anyOf": []any{
+			map[string]any{"required": []any{"rds_db_user"}},
+			map[string]any{"required": []any{"iam_token_db_user"}},
+		},
+		"properties": props,
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[time.Duration]() {
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
+
+func stringsToAny(items []string) []any {
+	out := make([]any, len(items))
+	for i, s := range items {
+		out[i] = s
+	}
+	return out
+}
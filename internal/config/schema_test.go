@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONSchemaCoversProfileFields(t *testing.T) {
+	t.Parallel()
+
+	raw, err := json.Marshal(JSONSchema())
+	if err != nil {
+		t.Fatalf("marshal schema: %v", err)
+	}
+	var schema struct {
+		Properties struct {
+			Profiles struct {
+				Items struct {
+					Required   []string                   `json:"required"`
+					Properties map[string]json.RawMessage `json:"properties"`
+				} `json:"items"`
+			} `json:"profiles"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatalf("unmarshal schema: %v", err)
+	}
+	props := schema.Properties.Profiles.Items.Properties
+	for _, key := range []string{"name", "rds_hosts", "session_setup_timeout", "tcp_nodelay"} {
+		if _, ok := props[key]; !ok {
+			t.Fatalf("schema has no %s property", key)
+		}
+	}
+	for _, key := range []string{"ExpandedFrom", "SourceFile", ""} {
+		if _, ok := props[key]; ok {
+			t.Fatalf("schema exposes internal field %q", key)
+		}
+	}
+
+	var maxConns struct {
+		Type    string `json:"type"`
+		Default int    `json:"default"`
+		Minimum int    `json:"minimum"`
+		Maximum int    `json:"maximum"`
+	}
+	if err := json.Unmarshal(props["max_conns"], &maxConns); err != nil {
+		t.Fatalf("unmarshal max_conns: %v", err)
+	}
+	if maxConns.Type != "integer" || maxConns.Default != defaultMaxConns || maxConns.Minimum != MaxConnsUnlimited || maxConns.Maximum != maxConnsHardLimit {
+		t.Fatalf("unexpected max_conns schema: %s", props["max_conns"])
+	}
+	var authTimeout struct {
+		Type    string `json:"type"`
+		Default string `json:"default"`
+	}
+	if err := json.Unmarshal(props["auth_timeout"], &authTimeout); err != nil {
+		t.Fatalf("unmarshal auth_timeout: %v", err)
+	}
+	if authTimeout.Type != "string" || authTimeout.Default != defaultAuthTimeout.String() {
+		t.Fatalf("unexpected auth_timeout schema: %s", props["auth_timeout"])
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log close reasons.
+const (
+	closeAuthFailed          = "auth_failed"
+	closeNoRoute             = "no_route"
+	closeBackendUnavailable  = "backend_unavailable"
+	closeDatabaseUnavailable = "database_unavailable"
+	closePipeError           = "pipe_error"
+	closeNormal              = "closed"
+	closeShutdown            = "shutdown"
+	closeProxyProtocolError  = "proxy_protocol_error"
+	closeBackendClosed       = "backend_closed"
+	closeDrainRejected       = "drain_rejected"
+	closeSetupTimeout        = "setup_timeout"
+)
+
+// AccessLogEntry is one completed client connection.
+type AccessLogEntry struct {
+	Time        time.Time
+	Profile     string
+	ProxyUser   string
+	RemoteAddr  string
+	Duration    time.Duration
+	BytesUp     int64
+	BytesDown   int64
+	CloseReason string
+}
+
+// AccessLog writes one fixed-layout line per completed connection, separate
+// from the operational slog output. It is safe for concurrent use.
+type AccessLog struct {
+	mu   sync.Mutex
+	path string
+	w    io.Writer
+	f    *os.File
+}
+
+// OpenAccessLog appends to path, or writes to stdout when path is "-".
+func OpenAccessLog(path string) (*AccessLog, error) {
+	a := &AccessLog{path: path}
+	if path == "-" {
+		a.w = os.Stdout
+		return a, nil
+	}
+	if err := a.Reopen(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reopen closes and reopens the log file so external rotation (rename then
+// signal) takes effect. It is a no-op for stdout.
+func (a *AccessLog) Reopen() error {
+	if a.path == "-" {
+		return nil
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open access log: %w", err)
+	}
+	a.mu.Lock()
+	prev := a.f
+	a.f, a.w = f, f
+	a.mu.Unlock()
+	if prev != nil {
+		_ = prev.Close()
+	}
+	return nil
+}
+
+func (a *AccessLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f, a.w = nil, io.Discard
+	return err
+}
+
+func (a *AccessLog) Log(e AccessLogEntry) {
+	line := formatAccessLogEntry(e)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, _ = io.WriteString(a.w, line)
+}
+
+// formatAccessLogEntry renders e as logfmt with a fixed field order; empty
+// values are written as "-".
+func formatAccessLogEntry(e AccessLogEntry) string {
+	var b strings.Builder
+	b.WriteString("ts=")
+	b.WriteString(e.Time.UTC().Format(time.RFC3339Nano))
+	writeAccessField(&b, "profile", e.Profile)
+	writeAccessField(&b, "proxy_user", e.ProxyUser)
+	writeAccessField(&b, "remote_addr", e.RemoteAddr)
+	writeAccessField(&b, "duration_ms", strconv.FormatInt(e.Duration.Milliseconds(), 10))
+	writeAccessField(&b, "bytes_up", strconv.FormatInt(e.BytesUp, 10))
+	writeAccessField(&b, "bytes_down", strconv.FormatInt(e.BytesDown, 10))
+	writeAccessField(&b, "close_reason", e.CloseReason)
+	b.WriteByte('\n')
+	return b.String()
+}
+
+func writeAccessField(b *strings.Builder, key, value string) {
+	b.WriteByte(' ')
+	b.WriteString(key)
+	b.WriteByte('=')
+	switch {
+	case value == "":
+		b.WriteByte('-')
+	case strings.ContainsAny(value, " \"=\t\n"):
+		b.WriteString(strconv.Quote(value))
+	default:
+		b.WriteString(value)
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatAccessLogEntryUsesFixedFieldOrder(t *testing.T) {
+	t.Parallel()
+
+	got := formatAccessLogEntry(AccessLogEntry{
+		Time:        time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		Profile:     "prod-reporting",
+		ProxyUser:   "local_proxy",
+		RemoteAddr:  "127.0.0.1:50000",
+		Duration:    1500 * time.Millisecond,
+		BytesUp:     10,
+		BytesDown:   20,
+		CloseReason: closeNormal,
+	})
+	want := "ts=2026-03-01T10:00:00Z profile=prod-reporting proxy_user=local_proxy remote_addr=127.0.0.1:50000 duration_ms=1500 bytes_up=10 bytes_down=20 close_reason=closed\n"
+	if got != want {
+		t.Fatalf("unexpected line:\n got: %s\nwant: %s", got, want)
+	}
+
+	got = formatAccessLogEntry(AccessLogEntry{Time: time.Unix(0, 0), Profile: "has space", CloseReason: closeAuthFailed})
+	if !strings.Contains(got, `profile="has space" proxy_user=- `) || !strings.HasSuffix(got, "close_reason=auth_failed\n") {
+		t.Fatalf("unexpected quoting/empty handling: %s", got)
+	}
+}
+
+func TestAccessLogReopenFollowsRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	a, err := OpenAccessLog(path)
+	if err != nil {
+		t.Fatalf("OpenAccessLog: %v", err)
+	}
+	defer a.Close()
+
+	a.Log(AccessLogEntry{Time: time.Now(), Profile: "before"})
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if err := a.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	a.Log(AccessLogEntry{Time: time.Now(), Profile: "after"})
+
+	old, _ := os.ReadFile(rotated)
+	cur, _ := os.ReadFile(path)
+	if !strings.Contains(string(old), "profile=before") || strings.Contains(string(old), "profile=after") {
+		t.Fatalf("unexpected rotated file: %s", old)
+	}
+	if !strings.Contains(string(cur), "profile=after") {
+		t.Fatalf("unexpected current file: %s", cur)
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// auroraResolveInterval is how often an aurora_failover_aware route resolves
+// its cluster endpoint; Aurora repoints it with a 5s DNS TTL on failover.
+const auroraResolveInterval = 5 * time.Second
+
+// errCaptureLen is how much of an ERR packet readOnlyDetector reads: enough
+// for the code, SQL state and the start of the message.
+const errCaptureLen = 128
+
+var errReadOnlyBackend = errors.New("connected to a read-only instance; writer failover in progress")
+
+// isReadOnlyError reports whether a backend error means the instance stopped
+// being the writer. 1290 is shared with other server options, so only its
+// read-only variants count.
+func isReadOnlyError(code uint16, msg string) bool {
+	switch code {
+	case mysql.ER_READ_ONLY_MODE:
+		return true
+	case mysql.ER_OPTION_PREVENTS_STATEMENT:
+		msg = strings.ToLower(msg)
+		return strings.Contains(msg, "read-only") || strings.Contains(msg, "read_only")
+	}
+	return false
+}
+
+// checkWritable rejects a fresh backend connection that landed on a reader,
+// which happens while the cluster endpoint still resolves to the old writer.
+func checkWritable(conn *client.Conn) error {
+	r, err := conn.Execute("SELECT @@innodb_read_only")
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	ro, err := r.GetInt(0, 0)
+	if err != nil {
+		return err
+	}
+	if ro != 0 {
+		return errReadOnlyBackend
+	}
+	return nil
+}
+
+// readOnlyDetector watches backend->client traffic for an ERR packet that
+// isReadOnlyError accepts and calls onReadOnly once. It follows packet
+// boundaries across writes and only looks at the first payload byte of each
+// packet, where result rows never carry 0xFF. Write never fails, so it can sit
+// behind an io.TeeReader without disturbing forwarding.
+type readOnlyDetector struct {
+	onReadOnly func(code uint16, msg string)
+	fired      bool
+
+	hdr       [4]byte
+	hdrLen    int
+	length    int  // payload length of the current packet
+	remaining int  // payload bytes of the current packet not seen yet
+	continued bool // current packet continues a max-length one
+	inspect   bool // current packet may still be an ERR
+	errBuf    []byte
+}
+
+func newReadOnlyDetector(onReadOnly func(code uint16, msg string)) *readOnlyDetector {
+	return &readOnlyDetector{onReadOnly: onReadOnly}
+}
+
+func (d *readOnlyDetector) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 && !d.fired {
+		if d.hdrLen < len(d.hdr) {
+			c := copy(d.hdr[d.hdrLen:], b)
+			d.hdrLen += c
+			b = b[c:]
+			if d.hdrLen == len(d.hdr) {
+				d.length = int(d.hdr[0]) | int(d.hdr[1])<<8 | int(d.hdr[2])<<16
+				d.remaining = d.length
+				d.inspect = !d.continued
+				d.continued = d.length == maxPayloadLen
+				d.errBuf = d.errBuf[:0]
+				if d.length == 0 {
+					d.hdrLen = 0
+				}
+			}
+			continue
+		}
+
+		c := min(len(b), d.remaining)
+		if d.inspect {
+			if len(d.errBuf) == 0 && b[0] != mysql.ERR_HEADER {
+				d.inspect = false
+			} else {
+				want := min(d.length, errCaptureLen)
+				d.errBuf = append(d.errBuf, b[:min(c, want-len(d.errBuf))]...)
+				if len(d.errBuf) == want {
+					d.inspect = false
+					d.classify()
+				}
+			}
+		}
+		d.remaining -= c
+		b = b[c:]
+		if d.remaining == 0 {
+			d.hdrLen = 0
+		}
+	}
+	return n, nil
+}
+
+// classify parses the captured ERR packet start: 0xFF, a 2-byte code, then
+// an optional '#' and 5-byte SQL state before the message.
+func (d *readOnlyDetector) classify() {
+	if len(d.errBuf) < 3 {
+		return
+	}
+	code := uint16(d.errBuf[1]) | uint16(d.errBuf[2])<<8
+	msg := d.errBuf[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		msg = msg[6:]
+	}
+	if isReadOnlyError(code, string(msg)) {
+		d.fired = true
+		d.onReadOnly(code, string(msg))
+	}
+}
+
+// watchAuroraEndpoint resolves rt's cluster endpoint every interval and
+// invalidates its pool when the address set changes, so idle connections to
+// the old writer are replaced before a client borrows one.
+func (p *Proxy) watchAuroraEndpoint(ctx context.Context, rt *route, interval time.Duration) {
+	host := rt.profile.RDSHost
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last []string
+	for {
+		addrs, err := p.lookupHost(ctx, host)
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				rt.logger.Debug("aurora endpoint lookup failed", "rds_host", host, "error", err)
+			}
+		default:
+			slices.Sort(addrs)
+			if last != nil && !slices.Equal(addrs, last) {
+				rt.logger.Warn("aurora endpoint moved; invalidating backend pool", "rds_host", host, "previous", strings.Join(last, ","), "current", strings.Join(addrs, ","))
+				rt.pool.Invalidate()
+			}
+			last = addrs
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
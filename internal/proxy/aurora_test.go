@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+const readOnlyMessage = "The MySQL server is running with the --read-only option so it cannot execute this statement"
+
+func errPacket(seq uint8, code uint16, msg string) []byte {
+	return commandPacket(seq, mysql.ERR_HEADER, string([]byte{byte(code), byte(code >> 8)})+"#HY000"+msg)
+}
+
+func TestReadOnlyDetectorFiresOnceAcrossSplitWrites(t *testing.T) {
+	t.Parallel()
+
+	var stream []byte
+	stream = append(stream, commandPacket(1, 0x01, "")...) // column count
+	stream = append(stream, commandPacket(2, 0x03, "def")...)
+	stream = append(stream, commandPacket(3, 0x01, "1")...) // row
+	stream = append(stream, errPacket(1, mysql.ER_OPTION_PREVENTS_STATEMENT, readOnlyMessage)...)
+	stream = append(stream, errPacket(1, mysql.ER_READ_ONLY_MODE, "Running in read-only mode")...)
+
+	var fired []uint16
+	d := newReadOnlyDetector(func(code uint16, msg string) {
+		fired = append(fired, code)
+		if msg != readOnlyMessage {
+			t.Errorf("msg = %q, want %q", msg, readOnlyMessage)
+		}
+	})
+	for _, b := range stream {
+		if n, err := d.Write([]byte{b}); n != 1 || err != nil {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	if len(fired) != 1 || fired[0] != mysql.ER_OPTION_PREVENTS_STATEMENT {
+		t.Fatalf("fired = %v, want one ER_OPTION_PREVENTS_STATEMENT", fired)
+	}
+}
+
+func TestReadOnlyDetectorIgnoresOtherErrorsAndContinuations(t *testing.T) {
+	t.Parallel()
+
+	var stream []byte
+	stream = append(stream, errPacket(1, mysql.ER_OPTION_PREVENTS_STATEMENT, "The MySQL server is running with the --secure-file-priv option so it cannot execute this statement")...)
+	stream = append(stream, errPacket(1, mysql.ER_DUP_ENTRY, "Duplicate entry '1' for key 'PRIMARY'")...)
+	// A max-length packet whose continuation happens to start like an ERR.
+	big := make([]byte, maxPayloadLen)
+	stream = append(stream, 0xff, 0xff, 0xff, 1)
+	stream = append(stream, big...)
+	stream = append(stream, errPacket(2, mysql.ER_READ_ONLY_MODE, "Running in read-only mode")...)
+
+	d := newReadOnlyDetector(func(code uint16, msg string) {
+		t.Fatalf("unexpected read-only detection: %d %q", code, msg)
+	})
+	if _, err := io.Copy(d, bytes.NewReader(stream)); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+}
+
+func TestIsReadOnlyError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		code uint16
+		msg  string
+		want bool
+	}{
+		{mysql.ER_READ_ONLY_MODE, "Running in read-only mode", true},
+		{mysql.ER_OPTION_PREVENTS_STATEMENT, readOnlyMessage, true},
+		{mysql.ER_OPTION_PREVENTS_STATEMENT, "The MySQL server is running with the --super-read-only option so it cannot execute this statement", true},
+		{mysql.ER_OPTION_PREVENTS_STATEMENT, "The MySQL server is running with the --skip-grant-tables option so it cannot execute this statement", false},
+		{mysql.ER_ACCESS_DENIED_ERROR, "read-only", false},
+	}
+	for _, tc := range cases {
+		if got := isReadOnlyError(tc.code, tc.msg); got != tc.want {
+			t.Fatalf("isReadOnlyError(%d, %q) = %v, want %v", tc.code, tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestWatchAuroraEndpointInvalidatesPoolWhenAddressesChange(t *testing.T) {
+	t.Parallel()
+
+	var dials atomic.Int32
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pool := NewBackendPool(1, time.Minute, time.Second, logger, func(context.Context) (*client.Conn, time.Time, error) {
+		dials.Add(1)
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	})
+	defer pool.Close()
+	pool.Start(context.Background())
+	if err := pool.WaitReady(context.Background()); err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+
+	answers := make(chan []string, 3)
+	answers <- []string{"10.0.0.1"}
+	answers <- []string{"10.0.0.1"}
+	answers <- []string{"10.0.0.2"}
+	px := &Proxy{lookupHost: func(ctx context.Context, host string) ([]string, error) {
+		select {
+		case a := <-answers:
+			return a, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}}
+	rt := newRoute(config.Profile{Name: "aurora", RDSHost: "orders.cluster-abc123.eu-west-1.rds.amazonaws.com"}, logger, pool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go px.watchAuroraEndpoint(ctx, rt, 10*time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.Stats().Invalidations == 0 || dials.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected an invalidation and a refill after the endpoint moved; invalidations=%d dials=%d", pool.Stats().Invalidations, dials.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := pool.Stats().Invalidations; got != 1 {
+		t.Fatalf("Invalidations = %d, want 1", got)
+	}
+}
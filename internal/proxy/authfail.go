@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// Client auth failure classes, logged as reason= and counted separately.
+const (
+	authFailBadCredentials = "bad_credentials" // wrong password or unknown proxy_user
+	authFailProtocol       = "protocol"        // malformed or unsupported handshake
+	authFailTransport      = "transport"       // TLS, EOF, reset or timeout
+)
+
+const (
+	authFailWindow     = time.Minute
+	authFailThreshold  = 5    // failures from one remote IP per window before warning
+	authFailMaxTracked = 4096 // bound on remembered remote IPs
+)
+
+// classifyAuthError reports which class an authenticateClient error falls in.
+func classifyAuthError(err error) string {
+	if errors.Is(err, server.ErrAccessDenied) {
+		return authFailBadCredentials
+	}
+	var myErr *mysql.MyError
+	if errors.As(err, &myErr) {
+		switch myErr.Code {
+		case mysql.ER_ACCESS_DENIED_ERROR, mysql.ER_NO_SUCH_USER:
+			return authFailBadCredentials
+		}
+		return authFailProtocol
+	}
+	var netErr net.Error
+	var recErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	// go-mysql reports packet read/write failures as ErrBadConn.
+	if errors.Is(err, mysql.ErrBadConn) || isConnCloseErr(err) || errors.As(err, &netErr) || errors.As(err, &recErr) || errors.As(err, &alertErr) {
+		return authFailTransport
+	}
+	return authFailProtocol
+}
+
+// AuthFailureStats counts client auth failures by class since start.
+type AuthFailureStats struct {
+	BadCredentials uint64 `json:"bad_credentials"`
+	Protocol       uint64 `json:"protocol"`
+	Transport      uint64 `json:"transport"`
+}
+
+// authFailures counts failures by class and tracks bad-credential failures
+// per remote IP so repeated attempts surface as a single warning per window.
+type authFailures struct {
+	badCredentials atomic.Uint64
+	protocol       atomic.Uint64
+	transport      atomic.Uint64
+
+	mu      sync.Mutex
+	remotes map[string]*remoteAuthFailures
+}
+
+type remoteAuthFailures struct {
+	windowStart time.Time
+	count       int
+}
+
+func (a *authFailures) stats() AuthFailureStats {
+	return AuthFailureStats{
+		BadCredentials: a.badCredentials.Load(),
+		Protocol:       a.protocol.Load(),
+		Transport:      a.transport.Load(),
+	}
+}
+
+// record counts one failure of class from remoteIP. It returns the failure
+// count in the current window when that count just reached the alerting
+// threshold, and 0 otherwise.
+func (a *authFailures) record(class, remoteIP string, now time.Time) int {
+	switch class {
+	case authFailBadCredentials:
+		a.badCredentials.Add(1)
+	case authFailTransport:
+		a.transport.Add(1)
+		return 0
+	default:
+		a.protocol.Add(1)
+		return 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.remotes == nil {
+		a.remotes = make(map[string]*remoteAuthFailures)
+	}
+	r, ok := a.remotes[remoteIP]
+	if !ok || now.Sub(r.windowStart) > authFailWindow {
+		if !ok && len(a.remotes) >= authFailMaxTracked {
+			a.pruneLocked(now)
+		}
+		r = &remoteAuthFailures{windowStart: now}
+		a.remotes[remoteIP] = r
+	}
+	r.count++
+	if r.count == authFailThreshold {
+		return r.count
+	}
+	return 0
+}
+
+// pruneLocked drops expired windows, and everything if that frees nothing.
+func (a *authFailures) pruneLocked(now time.Time) {
+	for ip, r := range a.remotes {
+		if now.Sub(r.windowStart) > authFailWindow {
+			delete(a.remotes, ip)
+		}
+	}
+	if len(a.remotes) >= authFailMaxTracked {
+		clear(a.remotes)
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+func TestClassifyAuthError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{err: mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, "u", "127.0.0.1:5000", "YES"), want: authFailBadCredentials},
+		{err: mysql.NewDefaultError(mysql.ER_NO_SUCH_USER, "u", "127.0.0.1:5000"), want: authFailBadCredentials},
+		{err: fmt.Errorf("auth: %w", server.ErrAccessDeniedNoPassword), want: authFailBadCredentials},
+		{err: mysql.NewDefaultError(mysql.ER_HANDSHAKE_ERROR), want: authFailProtocol},
+		{err: errors.New("CLIENT_PROTOCOL_41 compatible client is required"), want: authFailProtocol},
+		{err: io.EOF, want: authFailTransport},
+		{err: fmt.Errorf("read header: %w", mysql.ErrBadConn), want: authFailTransport},
+		{err: fmt.Errorf("read: %w", net.ErrClosed), want: authFailTransport},
+		{err: &net.OpError{Op: "read", Err: errors.New("i/o timeout")}, want: authFailTransport},
+		{err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, want: authFailTransport},
+	}
+	for _, tc := range cases {
+		if got := classifyAuthError(tc.err); got != tc.want {
+			t.Fatalf("classifyAuthError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestAuthFailuresWarnOncePerRemoteWindow(t *testing.T) {
+	t.Parallel()
+
+	var a authFailures
+	now := time.Now()
+	alerts := 0
+	for i := 0; i < 2*authFailThreshold; i++ {
+		if n := a.record(authFailBadCredentials, "10.0.0.1", now.Add(time.Duration(i)*time.Second)); n > 0 {
+			if n != authFailThreshold {
+				t.Fatalf("expected alert at %d failures, got %d", authFailThreshold, n)
+			}
+			alerts++
+		}
+	}
+	if alerts != 1 {
+		t.Fatalf("expected one alert in the window, got %d", alerts)
+	}
+	for i := 0; i < authFailThreshold-1; i++ {
+		if n := a.record(authFailBadCredentials, "10.0.0.2", now); n != 0 {
+			t.Fatalf("unexpected alert for another remote: %d", n)
+		}
+	}
+	if n := a.record(authFailTransport, "10.0.0.2", now); n != 0 {
+		t.Fatalf("transport failures must not count toward the alert, got %d", n)
+	}
+
+	later := now.Add(authFailWindow + time.Hour)
+	for i := 0; i < authFailThreshold; i++ {
+		if n := a.record(authFailBadCredentials, "10.0.0.1", later); n > 0 {
+			alerts++
+		}
+	}
+	if alerts != 2 {
+		t.Fatalf("expected a fresh alert after the window expired, got %d alerts", alerts)
+	}
+
+	a.record(authFailProtocol, "10.0.0.3", now)
+	want := AuthFailureStats{BadCredentials: 3*authFailThreshold + authFailThreshold - 1, Protocol: 1, Transport: 1}
+	if got := a.stats(); got != want {
+		t.Fatalf("stats = %+v, want %+v", got, want)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"rds-iam-proxy/internal/config"
@@ -17,46 +19,326 @@ import (
 	"github.com/go-mysql-org/go-mysql/mysql"
 )
 
+// hostRetryAfter is how long a host whose connect failed is skipped while
+// other rds_hosts are still healthy.
+const hostRetryAfter = 10 * time.Second
+
+// defaultBackendResolveInterval is how long a pin_backend_ip address is used
+// before it is resolved again, when backend_resolve_interval is unset.
+const defaultBackendResolveInterval = time.Minute
+
 type BackendFactory struct {
-	profile    config.Profile
+	hosts      []*backendHost
+	next       atomic.Uint32
 	tokenCache *token.Cache
-	tlsConfig  *tls.Config
 	timeout    time.Duration
+	now        func() time.Time
+	lookupHost func(ctx context.Context, host string) ([]string, error) // pin_backend_ip resolution
+}
+
+// backendHost is one of a profile's rds_hosts. IAM tokens and TLS server
+// names are per hostname, so each host carries its own narrowed profile.
+type backendHost struct {
+	profile   config.Profile
+	tlsConfig *tls.Config
+	failedAt  atomic.Int64 // unix nanos of the last failed connect; 0 when healthy
+	pinned    atomic.Pointer[pinnedAddr]
+	resolving atomic.Bool // a background pin refresh is running
+}
+
+// pinnedAddr is the address a pin_backend_ip host is dialed at.
+type pinnedAddr struct {
+	ip         string
+	resolvedAt time.Time
 }
 
 func NewBackendFactory(p config.Profile, tokenCache *token.Cache, timeout time.Duration) (*BackendFactory, error) {
-	tlsCfg, err := buildTLSConfig(p)
-	if err != nil {
-		return nil, err
-	}
-	return &BackendFactory{
-		profile:    p,
+	f := &BackendFactory{
 		tokenCache: tokenCache,
-		tlsConfig:  tlsCfg,
 		timeout:    timeout,
-	}, nil
+		now:        time.Now,
+		lookupHost: net.DefaultResolver.LookupHost,
+	}
+	for _, host := range p.BackendHosts() {
+		hp := p.ForHost(host)
+		tlsCfg, err := buildTLSConfig(hp)
+		if err != nil {
+			return nil, err
+		}
+		f.hosts = append(f.hosts, &backendHost{profile: hp, tlsConfig: tlsCfg})
+	}
+	return f, nil
+}
+
+// pickHost returns the next host in round-robin order that has not failed in
+// the last hostRetryAfter. When every host failed recently it returns the one
+// that failed longest ago, so a full outage still retries something.
+func (f *BackendFactory) pickHost() *backendHost {
+	if len(f.hosts) == 1 {
+		return f.hosts[0]
+	}
+	now := f.now().UnixNano()
+	start := int(f.next.Add(1)-1) % len(f.hosts)
+	var oldest *backendHost
+	for i := range f.hosts {
+		h := f.hosts[(start+i)%len(f.hosts)]
+		failedAt := h.failedAt.Load()
+		if failedAt == 0 || now-failedAt >= int64(hostRetryAfter) {
+			return h
+		}
+		if oldest == nil || failedAt < oldest.failedAt.Load() {
+			oldest = h
+		}
+	}
+	return oldest
 }
 
 func (f *BackendFactory) NewConn(ctx context.Context) (*client.Conn, error) {
-	ct, err := f.tokenCache.Get(ctx, f.profile)
+	conn, _, err := f.newConn(ctx, false)
+	return conn, err
+}
+
+// NewPoolConn is NewConn plus the expiry of the IAM token the connection
+// logged in with, as a BackendPool dialer.
+func (f *BackendFactory) NewPoolConn(ctx context.Context) (*client.Conn, time.Time, error) {
+	return f.newConn(ctx, false)
+}
+
+// NewCompressedConn connects with zlib compression negotiated, for clients
+// that negotiated it with the proxy so raw forwarding stays byte-compatible.
+func (f *BackendFactory) NewCompressedConn(ctx context.Context) (*client.Conn, error) {
+	conn, _, err := f.newConn(ctx, true)
+	return conn, err
+}
+
+func (f *BackendFactory) newConn(ctx context.Context, compress bool) (*client.Conn, time.Time, error) {
+	h := f.pickHost()
+	conn, tokenExpiresAt, err := f.connectHost(ctx, h, compress)
 	if err != nil {
-		return nil, err
+		// Token errors are about credentials, not the host.
+		var hostErr *hostConnectError
+		if errors.As(err, &hostErr) {
+			h.failedAt.Store(f.now().UnixNano())
+			// The instance may have moved; resolve again on the next connect.
+			h.pinned.Store(nil)
+		}
+		if len(f.hosts) > 1 {
+			return nil, time.Time{}, fmt.Errorf("rds host %s: %w", h.profile.RDSHost, err)
+		}
+		return nil, time.Time{}, err
+	}
+	h.failedAt.Store(0)
+	return conn, tokenExpiresAt, nil
+}
+
+// hostConnectError marks a failure of the host itself, as opposed to token
+// acquisition, so pickHost can skip it.
+type hostConnectError struct{ err error }
+
+func (e *hostConnectError) Error() string { return e.err.Error() }
+func (e *hostConnectError) Unwrap() error { return e.err }
+
+// connectHost logs in to h and returns the connection with the expiry of the
+// IAM token it used.
+func (f *BackendFactory) connectHost(ctx context.Context, h *backendHost, compress bool) (*client.Conn, time.Time, error) {
+	p := h.profile
+	ct, err := f.tokenCache.Get(ctx, p)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
 
-	addr := net.JoinHostPort(f.profile.RDSHost, strconv.Itoa(f.profile.RDSPort))
-	conn, err := client.ConnectWithContext(ctx, addr, f.profile.RDSDBUser, ct.Value, f.profile.DefaultDB, f.timeout, func(c *client.Conn) error {
+	// The login user is rds_db_user; the token itself was generated for
+	// TokenDBUser, which differs only when iam_token_db_user is set.
+	// The TLS server name stays rds_host even when dialing a pinned IP.
+	addr := net.JoinHostPort(f.dialHost(ctx, h), strconv.Itoa(p.RDSPort))
+	conn, err := client.ConnectWithContext(ctx, addr, p.RDSDBUser, ct.Value, p.DefaultDB, f.timeout, func(c *client.Conn) error {
 		// Keep backend command-phase packets compatible with raw forwarding from GUI clients.
 		c.UnsetCapability(mysql.CLIENT_QUERY_ATTRIBUTES)
 		c.UnsetCapability(mysql.CLIENT_COMPRESS)
 		c.UnsetCapability(mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM)
-		c.SetTLSConfig(f.tlsConfig)
+		// deny_statements checks one statement per query; clients can only
+		// turn multi-statements on with COM_SET_OPTION, which it refuses.
+		c.UnsetCapability(mysql.CLIENT_MULTI_STATEMENTS)
+		if compress {
+			c.SetCapability(mysql.CLIENT_COMPRESS)
+		}
+		c.SetTLSConfig(h.tlsConfig)
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("connect backend: %w", err)
+		return nil, time.Time{}, &hostConnectError{fmt.Errorf("connect backend: %w", err)}
+	}
+	// The cluster endpoint can still point at the old writer for a few
+	// seconds after a failover; refuse that instance instead of pooling it.
+	if p.AuroraFailoverAware {
+		if err := checkWritable(conn); err != nil {
+			_ = conn.Close()
+			return nil, time.Time{}, &hostConnectError{fmt.Errorf("check backend writable: %w", err)}
+		}
+	}
+	if p.BackendKeepAlive != 0 {
+		// Best effort: an unsupported conn type keeps whatever the dialer set.
+		_ = applyKeepAlive(conn.Conn.Conn, p.BackendKeepAlive)
+	}
+	if p.BackendLinger != nil {
+		_ = applyLinger(conn.Conn.Conn, *p.BackendLinger)
+	}
+	if err := runSessionInit(conn, p.BackendSessionInit); err != nil {
+		_ = conn.Close()
+		return nil, time.Time{}, err
+	}
+
+	return conn, ct.ExpiresAt, nil
+}
+
+// dialHost returns the host to dial for h: rds_host itself, or with
+// pin_backend_ip the address it last resolved to. Only the first connect
+// waits on DNS; once the pin is older than backend_resolve_interval it is
+// refreshed in the background while connects keep using it. When no address
+// has been resolved yet, rds_host is dialed as usual.
+func (f *BackendFactory) dialHost(ctx context.Context, h *backendHost) string {
+	p := h.profile
+	if !p.PinBackendIP || net.ParseIP(p.RDSHost) != nil {
+		return p.RDSHost
+	}
+	pin := h.pinned.Load()
+	if pin == nil {
+		if ip, ok := f.resolvePin(ctx, h); ok {
+			return ip
+		}
+		return p.RDSHost
+	}
+	interval := p.BackendResolveInterval
+	if interval == 0 {
+		interval = defaultBackendResolveInterval
+	}
+	if f.now().Sub(pin.resolvedAt) >= interval && h.resolving.CompareAndSwap(false, true) {
+		go func() {
+			defer h.resolving.Store(false)
+			ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+			defer cancel()
+			// A failed refresh keeps the old pin and is retried on the next connect.
+			f.resolvePin(ctx, h)
+		}()
 	}
+	return pin.ip
+}
+
+// resolvePin looks up h's rds_host and pins its first address.
+func (f *BackendFactory) resolvePin(ctx context.Context, h *backendHost) (string, bool) {
+	addrs, err := f.lookupHost(ctx, h.profile.RDSHost)
+	if err != nil || len(addrs) == 0 {
+		return "", false
+	}
+	h.pinned.Store(&pinnedAddr{ip: addrs[0], resolvedAt: f.now()})
+	return addrs[0], true
+}
+
+// runSessionInit executes backend_session_init in order on a new backend
+// session, before it is pooled or handed to a client.
+func runSessionInit(conn *client.Conn, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := conn.Execute(stmt); err != nil {
+			return fmt.Errorf("backend_session_init %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// backendServerInfo describes what a connected backend reported, to confirm
+// the engine version and that TLS is in use.
+func backendServerInfo(conn *client.Conn) []any {
+	attrs := []any{
+		"server_version", conn.GetServerVersion(),
+		"remote_addr", conn.Conn.RemoteAddr().String(),
+		"capabilities", conn.CapabilityString(),
+	}
+	if tc, ok := conn.Conn.Conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		return append(attrs, "tls_version", tls.VersionName(state.Version), "tls_cipher", tls.CipherSuiteName(state.CipherSuite))
+	}
+	return append(attrs, "tls_version", "none")
+}
+
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// applyKeepAlive sets the TCP keepalive period on conn, unwrapping TLS.
+// A negative interval disables keepalive. It reports whether the option was applied.
+func applyKeepAlive(conn net.Conn, interval time.Duration) bool {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	kc, ok := conn.(keepAliveConn)
+	if !ok {
+		return false
+	}
+	if interval < 0 {
+		return kc.SetKeepAlive(false) == nil
+	}
+	if err := kc.SetKeepAlive(true); err != nil {
+		return false
+	}
+	return kc.SetKeepAlivePeriod(interval) == nil
+}
+
+type lingerConn interface {
+	SetLinger(sec int) error
+}
+
+// applyLinger sets SO_LINGER on conn, unwrapping TLS. A zero linger makes
+// Close reset the connection instead of leaving it in TIME_WAIT. It reports
+// whether the option was applied.
+func applyLinger(conn net.Conn, linger time.Duration) bool {
+	if wc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wc.NetConn()
+	}
+	lc, ok := conn.(lingerConn)
+	if !ok {
+		return false
+	}
+	return lc.SetLinger(int(linger/time.Second)) == nil
+}
 
-	return conn, nil
+type socketTuningConn interface {
+	SetNoDelay(noDelay bool) error
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+func hasSocketOptions(p config.Profile) bool {
+	return p.TCPNoDelay != nil || p.SocketSendBuffer > 0 || p.SocketRecvBuffer > 0
+}
+
+// applySocketOptions applies tcp_nodelay and socket buffer sizes from p to
+// conn, unwrapping TLS and PROXY protocol connections. Connections that are
+// not TCP are left as they are; it reports whether conn supported tuning.
+func applySocketOptions(conn net.Conn, p config.Profile) (bool, error) {
+	if wc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wc.NetConn()
+	}
+	sc, ok := conn.(socketTuningConn)
+	if !ok {
+		return false, nil
+	}
+	if p.TCPNoDelay != nil {
+		if err := sc.SetNoDelay(*p.TCPNoDelay); err != nil {
+			return true, fmt.Errorf("set tcp_nodelay: %w", err)
+		}
+	}
+	if p.SocketSendBuffer > 0 {
+		if err := sc.SetWriteBuffer(p.SocketSendBuffer); err != nil {
+			return true, fmt.Errorf("set socket_send_buffer: %w", err)
+		}
+	}
+	if p.SocketRecvBuffer > 0 {
+		if err := sc.SetReadBuffer(p.SocketRecvBuffer); err != nil {
+			return true, fmt.Errorf("set socket_recv_buffer: %w", err)
+		}
+	}
+	return true, nil
 }
 
 func buildTLSConfig(p config.Profile) (*tls.Config, error) {
@@ -70,9 +352,20 @@ func buildTLSConfig(p config.Profile) (*tls.Config, error) {
 		return nil, fmt.Errorf("invalid PEM in ca bundle %s", p.CABundle)
 	}
 
+	minVersion, err := config.ParseTLSVersion(p.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := config.ParseCipherSuites(p.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
 	return &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		RootCAs:    pool,
-		ServerName: p.RDSHost,
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+		RootCAs:      pool,
+		ServerName:   p.RDSHost,
+		// Only reachable with --allow-insecure, see ValidateRuntime.
+		InsecureSkipVerify: p.InsecureSkipTLSVerify,
 	}, nil
 }
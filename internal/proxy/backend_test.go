@@ -0,0 +1,370 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/client"
+)
+
+func TestApplyKeepAliveOnTCPConn(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			_ = c.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if !applyKeepAlive(conn, 30*time.Second) {
+		t.Fatal("expected keepalive to be applied on tcp conn")
+	}
+	if !applyKeepAlive(conn, -1) {
+		t.Fatal("expected keepalive disable to be applied on tcp conn")
+	}
+}
+
+func TestApplyKeepAliveFallsBackForUnsupportedConn(t *testing.T) {
+	t.Parallel()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	if applyKeepAlive(local, 30*time.Second) {
+		t.Fatal("expected keepalive to be skipped on non-tcp conn")
+	}
+}
+
+type recordingTuningConn struct {
+	net.Conn
+	noDelay     *bool
+	readBuffer  int
+	writeBuffer int
+}
+
+func (c *recordingTuningConn) SetNoDelay(noDelay bool) error {
+	c.noDelay = &noDelay
+	return nil
+}
+
+func (c *recordingTuningConn) SetReadBuffer(bytes int) error {
+	c.readBuffer = bytes
+	return nil
+}
+
+func (c *recordingTuningConn) SetWriteBuffer(bytes int) error {
+	c.writeBuffer = bytes
+	return nil
+}
+
+func TestApplySocketOptionsUsesTuningInterface(t *testing.T) {
+	t.Parallel()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	noDelay := false
+	p := config.Profile{TCPNoDelay: &noDelay, SocketSendBuffer: 1 << 16, SocketRecvBuffer: 1 << 17}
+	if !hasSocketOptions(p) || hasSocketOptions(config.Profile{}) {
+		t.Fatal("hasSocketOptions mismatch")
+	}
+
+	rec := &recordingTuningConn{Conn: local}
+	applied, err := applySocketOptions(rec, p)
+	if err != nil || !applied {
+		t.Fatalf("applySocketOptions = %v, %v", applied, err)
+	}
+	if rec.noDelay == nil || *rec.noDelay || rec.writeBuffer != 1<<16 || rec.readBuffer != 1<<17 {
+		t.Fatalf("unexpected applied options: %+v", rec)
+	}
+
+	if applied, err := applySocketOptions(local, p); applied || err != nil {
+		t.Fatalf("expected non-tcp conn to be skipped, got %v, %v", applied, err)
+	}
+}
+
+type recordingLingerConn struct {
+	net.Conn
+	linger *int
+}
+
+func (c *recordingLingerConn) SetLinger(sec int) error {
+	c.linger = &sec
+	return nil
+}
+
+func TestApplyLingerUnwrapsTLS(t *testing.T) {
+	t.Parallel()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	rec := &recordingLingerConn{Conn: local}
+	if !applyLinger(tls.Client(rec, &tls.Config{}), 0) {
+		t.Fatal("expected linger to be applied through tls")
+	}
+	if rec.linger == nil || *rec.linger != 0 {
+		t.Fatalf("expected linger 0, got %v", rec.linger)
+	}
+	if !applyLinger(rec, 5*time.Second) || *rec.linger != 5 {
+		t.Fatalf("expected linger 5, got %v", *rec.linger)
+	}
+
+	if applyLinger(local, 0) {
+		t.Fatal("expected linger to be skipped on non-tcp conn")
+	}
+}
+
+func TestApplySocketOptionsKeepsTCPForwarding(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	peer := <-accepted
+	defer peer.Close()
+
+	noDelay := true
+	if applied, err := applySocketOptions(conn, config.Profile{TCPNoDelay: &noDelay, SocketSendBuffer: 32 << 10, SocketRecvBuffer: 32 << 10}); err != nil || !applied {
+		t.Fatalf("applySocketOptions on tcp = %v, %v", applied, err)
+	}
+
+	msg := []byte("select 1")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(peer, got); err != nil || string(got) != string(msg) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
+
+func TestBuildTLSConfigAppliesMinVersionAndCipherSuites(t *testing.T) {
+	t.Parallel()
+
+	selfSigned, _, err := newSelfSignedTLS()
+	if err != nil {
+		t.Fatalf("newSelfSignedTLS: %v", err)
+	}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: selfSigned.Certificates[0].Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+
+	cfg, err := buildTLSConfig(config.Profile{RDSHost: "db.example", CABundle: ca})
+	if err != nil {
+		t.Fatalf("buildTLSConfig: %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.CipherSuites != nil || cfg.InsecureSkipVerify {
+		t.Fatalf("expected verified TLS 1.2 and default suites, got min=%x suites=%v insecure=%v", cfg.MinVersion, cfg.CipherSuites, cfg.InsecureSkipVerify)
+	}
+
+	cfg, err = buildTLSConfig(config.Profile{RDSHost: "db.example", CABundle: ca, InsecureSkipTLSVerify: true})
+	if err != nil || !cfg.InsecureSkipVerify {
+		t.Fatalf("expected insecure_skip_tls_verify to disable verification, got %v", err)
+	}
+
+	cfg, err = buildTLSConfig(config.Profile{RDSHost: "db.example", CABundle: ca, CipherSuites: []string{"tls_ecdhe_rsa_with_aes_256_gcm_sha384"}})
+	if err != nil {
+		t.Fatalf("buildTLSConfig with cipher_suites: %v", err)
+	}
+	if len(cfg.CipherSuites) != 1 || cfg.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Fatalf("unexpected cipher suites %v", cfg.CipherSuites)
+	}
+
+	cfg, err = buildTLSConfig(config.Profile{RDSHost: "db.example", CABundle: ca, MinTLSVersion: "1.3"})
+	if err != nil {
+		t.Fatalf("buildTLSConfig with min_tls_version: %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("expected TLS 1.3 minimum, got %x", cfg.MinVersion)
+	}
+}
+
+func TestBackendFactoryRoundRobinsHealthyHosts(t *testing.T) {
+	t.Parallel()
+
+	selfSigned, _, err := newSelfSignedTLS()
+	if err != nil {
+		t.Fatalf("newSelfSignedTLS: %v", err)
+	}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: selfSigned.Certificates[0].Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+
+	f, err := NewBackendFactory(config.Profile{RDSHosts: []string{"r1.example", "r2.example", "r3.example"}, CABundle: ca}, nil, time.Second)
+	if err != nil {
+		t.Fatalf("NewBackendFactory: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	f.now = func() time.Time { return now }
+	for _, h := range f.hosts {
+		if h.tlsConfig.ServerName != h.profile.RDSHost || h.profile.RDSHosts != nil {
+			t.Fatalf("host %s: ServerName=%q RDSHosts=%v", h.profile.RDSHost, h.tlsConfig.ServerName, h.profile.RDSHosts)
+		}
+	}
+
+	order := func(n int) []string {
+		var got []string
+		for range n {
+			got = append(got, f.pickHost().profile.RDSHost)
+		}
+		return got
+	}
+	if got := order(4); !slices.Equal(got, []string{"r1.example", "r2.example", "r3.example", "r1.example"}) {
+		t.Fatalf("round robin order = %v", got)
+	}
+
+	f.hosts[1].failedAt.Store(now.UnixNano())
+	for _, host := range order(6) {
+		if host == "r2.example" {
+			t.Fatal("picked r2.example within hostRetryAfter of its failure")
+		}
+	}
+
+	f.hosts[0].failedAt.Store(now.Add(-time.Second).UnixNano())
+	f.hosts[2].failedAt.Store(now.Add(time.Second).UnixNano())
+	if got := f.pickHost().profile.RDSHost; got != "r1.example" {
+		t.Fatalf("with every host failing, picked %s, want the one that failed longest ago", got)
+	}
+
+	now = now.Add(hostRetryAfter)
+	if got := order(3); !slices.Contains(got, "r2.example") {
+		t.Fatalf("r2.example not retried after hostRetryAfter: %v", got)
+	}
+}
+
+func TestBackendFactoryPinsResolvedIP(t *testing.T) {
+	t.Parallel()
+
+	var lookups atomic.Int32
+	var answer atomic.Value
+	answer.Store("10.0.0.1")
+	now := time.Unix(1000, 0)
+	f := &BackendFactory{
+		timeout: time.Second,
+		now:     func() time.Time { return now },
+		lookupHost: func(ctx context.Context, host string) ([]string, error) {
+			lookups.Add(1)
+			if host != "db.example" {
+				return nil, fmt.Errorf("unexpected lookup of %s", host)
+			}
+			ip := answer.Load().(string)
+			if ip == "" {
+				return nil, errors.New("no such host")
+			}
+			return []string{ip}, nil
+		},
+	}
+	ctx := context.Background()
+
+	plain := &backendHost{profile: config.Profile{RDSHost: "db.example"}}
+	if got := f.dialHost(ctx, plain); got != "db.example" || lookups.Load() != 0 {
+		t.Fatalf("without pin_backend_ip dialed %s after %d lookups", got, lookups.Load())
+	}
+
+	h := &backendHost{profile: config.Profile{RDSHost: "db.example", PinBackendIP: true, BackendResolveInterval: time.Minute}}
+	for range 3 {
+		if got := f.dialHost(ctx, h); got != "10.0.0.1" {
+			t.Fatalf("dialed %s, want the pinned 10.0.0.1", got)
+		}
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Fatalf("expected one lookup within the interval, got %d", n)
+	}
+
+	// Past the interval the old pin is still used while a refresh runs.
+	answer.Store("10.0.0.2")
+	now = now.Add(time.Minute)
+	if got := f.dialHost(ctx, h); got != "10.0.0.1" {
+		t.Fatalf("dialed %s, want the stale pin during refresh", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.resolving.Load() || h.pinned.Load().ip != "10.0.0.2" {
+		if time.Now().After(deadline) {
+			t.Fatal("pin was not refreshed in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Without any resolved address the hostname is dialed as before.
+	answer.Store("")
+	h.pinned.Store(nil)
+	if got := f.dialHost(ctx, h); got != "db.example" {
+		t.Fatalf("dialed %s, want rds_host when resolution fails", got)
+	}
+}
+
+func TestRunSessionInitExecutesStatementsInOrder(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+	conn, err := client.Connect(backendAddr, "backend_user", "backend_pass", "")
+	if err != nil {
+		t.Fatalf("connect backend: %v", err)
+	}
+	defer conn.Close()
+
+	if err := runSessionInit(conn, []string{"SET @proxy_client = 'first'", "SET @proxy_client = 'second'"}); err != nil {
+		t.Fatalf("runSessionInit: %v", err)
+	}
+	res, err := conn.Execute("SELECT @proxy_client")
+	if err != nil {
+		t.Fatalf("read back: %v", err)
+	}
+	if got, _ := res.GetString(0, 0); got != "second" {
+		t.Fatalf("@proxy_client = %q, want the last statement's value", got)
+	}
+
+	err = runSessionInit(conn, []string{"SET SESSION TRANSACTION READ ONLY"})
+	if err == nil || !strings.Contains(err.Error(), "backend_session_init") || !strings.Contains(err.Error(), "READ ONLY") {
+		t.Fatalf("expected error naming the failed statement, got: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// VerifyBackendCA opens a TLS session to each of p's backend hosts, as a
+// login would but without authenticating, and checks that the certificate
+// it presents chains to ca_bundle and names rds_host. buildTLSConfig only
+// checks that the bundle parses, so this catches a valid PEM holding the
+// wrong CA before clients hit "certificate signed by unknown authority".
+// Profiles with insecure_skip_tls_verify are not checked.
+func VerifyBackendCA(ctx context.Context, p config.Profile, timeout time.Duration) error {
+	f, err := NewBackendFactory(p, nil, timeout)
+	if err != nil {
+		return err
+	}
+	return f.VerifyCA(ctx)
+}
+
+// VerifyCA is VerifyBackendCA for the hosts of f, dialed at the addresses
+// logins use, so a pin_backend_ip profile is checked against the instance
+// it is pinned to.
+func (f *BackendFactory) VerifyCA(ctx context.Context) error {
+	for _, h := range f.hosts {
+		if err := f.verifyHostCA(ctx, h); err != nil {
+			if len(f.hosts) > 1 {
+				return fmt.Errorf("rds host %s: %w", h.profile.RDSHost, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *BackendFactory) verifyHostCA(ctx context.Context, h *backendHost) error {
+	p := h.profile
+	if p.InsecureSkipTLSVerify {
+		return nil
+	}
+	addr := net.JoinHostPort(f.dialHost(ctx, h), strconv.Itoa(p.RDSPort))
+	dialer := net.Dialer{Timeout: f.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", addr, err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(f.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if err := requestBackendTLS(conn); err != nil {
+		return fmt.Errorf("%s: %w", addr, err)
+	}
+	// Verification is done below so its failure can name the bundle.
+	probeCfg := h.tlsConfig.Clone()
+	probeCfg.InsecureSkipVerify = true
+	tlsConn := tls.Client(conn, probeCfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("TLS handshake with %s: %w", addr, err)
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("%s presented no certificate", addr)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{Roots: h.tlsConfig.RootCAs, Intermediates: intermediates, DNSName: h.tlsConfig.ServerName})
+	var unknownCA x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &unknownCA):
+		return fmt.Errorf("ca_bundle %s does not contain a CA that the certificate from %s chains to (issuer %q); download the bundle for region %s", p.CABundle, addr, certs[len(certs)-1].Issuer.String(), p.RDSRegion)
+	case errors.As(err, &hostErr):
+		return fmt.Errorf("certificate from %s is not valid for rds_host %q: %w", addr, p.RDSHost, err)
+	default:
+		return fmt.Errorf("verify certificate from %s: %w", addr, err)
+	}
+}
+
+// requestBackendTLS reads the server greeting from conn and answers with an
+// SSLRequest, after which the server expects a TLS ClientHello.
+func requestBackendTLS(conn net.Conn) error {
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return fmt.Errorf("read server greeting: %w", err)
+	}
+	greeting := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return fmt.Errorf("read server greeting: %w", err)
+	}
+	if len(greeting) > 0 && greeting[0] == mysql.ERR_HEADER {
+		return fmt.Errorf("server refused the connection: %s", greeting[min(len(greeting), 3):])
+	}
+	// protocol version, NUL-terminated server version, connection id,
+	// 8 bytes of auth data and a filler precede the capability flags.
+	version := 1
+	for version < len(greeting) && greeting[version] != 0 {
+		version++
+	}
+	capsAt := version + 1 + 4 + 8 + 1
+	if len(greeting) < capsAt+2 {
+		return errors.New("malformed server greeting")
+	}
+	if binary.LittleEndian.Uint16(greeting[capsAt:])&uint16(mysql.CLIENT_SSL) == 0 {
+		return errors.New("server does not offer TLS")
+	}
+
+	capability := uint32(mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION | mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_SSL)
+	payload := binary.LittleEndian.AppendUint32(nil, capability)
+	payload = binary.LittleEndian.AppendUint32(payload, maxPayloadLen)
+	payload = append(payload, mysql.DEFAULT_COLLATION_ID)
+	payload = append(payload, make([]byte, 23)...)
+	packet := append([]byte{byte(len(payload)), 0, 0, header[3] + 1}, payload...)
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("send SSL request: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+func TestVerifyBackendCAReportsWrongBundle(t *testing.T) {
+	t.Parallel()
+
+	backendTLS, pubKey, err := newSelfSignedTLS("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newSelfSignedTLS: %v", err)
+	}
+	srv := server.NewServer("8.0.11", mysql.DEFAULT_COLLATION_ID, mysql.AUTH_NATIVE_PASSWORD, pubKey, backendTLS)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = srv.NewConn(conn, "u", "p", server.EmptyHandler{})
+			}()
+		}
+	}()
+
+	writeCA := func(name string, der []byte) string {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+	otherTLS, _, err := newSelfSignedTLS("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newSelfSignedTLS: %v", err)
+	}
+	p := config.Profile{RDSHost: "127.0.0.1", RDSPort: ln.Addr().(*net.TCPAddr).Port, RDSRegion: "eu-west-1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.CABundle = writeCA("right.pem", backendTLS.Certificates[0].Certificate[0])
+	if err := VerifyBackendCA(ctx, p, 2*time.Second); err != nil {
+		t.Fatalf("expected the matching bundle to verify, got: %v", err)
+	}
+	p.CABundle = writeCA("wrong.pem", otherTLS.Certificates[0].Certificate[0])
+	err = VerifyBackendCA(ctx, p, 2*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "does not contain a CA") || !strings.Contains(err.Error(), "wrong.pem") {
+		t.Fatalf("expected a wrong-CA error naming the bundle, got: %v", err)
+	}
+	p.InsecureSkipTLSVerify = true
+	if err := VerifyBackendCA(ctx, p, 2*time.Second); err != nil {
+		t.Fatalf("expected insecure_skip_tls_verify profiles to be skipped, got: %v", err)
+	}
+}
+
+func TestVerifyCADialsPinnedAddress(t *testing.T) {
+	t.Parallel()
+
+	backendTLS, pubKey, err := newSelfSignedTLS("db.example")
+	if err != nil {
+		t.Fatalf("newSelfSignedTLS: %v", err)
+	}
+	srv := server.NewServer("8.0.11", mysql.DEFAULT_COLLATION_ID, mysql.AUTH_NATIVE_PASSWORD, pubKey, backendTLS)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = srv.NewConn(conn, "u", "p", server.EmptyHandler{})
+			}()
+		}
+	}()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backendTLS.Certificates[0].Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+	// db.example only resolves through the factory's pin lookup, so the
+	// check can only reach the backend by dialing the pinned address.
+	p := config.Profile{RDSHost: "db.example", RDSPort: ln.Addr().(*net.TCPAddr).Port, CABundle: ca, PinBackendIP: true}
+	f, err := NewBackendFactory(p, nil, 2*time.Second)
+	if err != nil {
+		t.Fatalf("NewBackendFactory: %v", err)
+	}
+	f.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host != "db.example" {
+			return nil, fmt.Errorf("unexpected lookup of %s", host)
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := f.VerifyCA(ctx); err != nil {
+		t.Fatalf("expected the pinned backend to verify, got: %v", err)
+	}
+	if pin := f.hosts[0].pinned.Load(); pin == nil || pin.ip != "127.0.0.1" {
+		t.Fatalf("expected the check to pin the resolved address, got %+v", pin)
+	}
+}
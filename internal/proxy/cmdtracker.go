@@ -0,0 +1,356 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// errSessionDrained refuses a command a client sends after shutdown ended
+// its session between commands.
+var errSessionDrained = errors.New("session ended by shutdown")
+
+// framePrefixLen is how much of each packet's payload packetFramer keeps:
+// enough for an OK packet's status flags or a prepare reply's counts.
+const framePrefixLen = 32
+
+// respState is what the backend still owes for the command in flight.
+type respState uint8
+
+const (
+	respIdle      respState = iota // nothing; the session is between commands
+	respUnknown                    // a reply commandTracker does not follow; busy until the next command
+	respAny                        // any one packet
+	respResult                     // OK, ERR, LOCAL INFILE request or a result set's column count
+	respInfile                     // the OK or ERR that ends LOCAL INFILE
+	respColumns                    // pending column definitions, then EOF
+	respRows                       // rows until EOF or ERR
+	respPrepare                    // COM_STMT_PREPARE's OK or ERR
+	respDefs                       // pending prepare definitions and their EOFs
+	respFieldList                  // column definitions until EOF or ERR
+)
+
+// commandTracker follows the command/response exchange of a forwarded
+// session, so shutdown ends it between commands instead of cutting off a
+// query or a result set. It frames client->backend packets as they are
+// written to the backend and backend->client packets once they have been
+// written to the client, so a session is idle only when its last response
+// was fully delivered and no command is outstanding. Every write to the
+// client goes through it, so the shutdown ERR never lands inside another
+// packet. Compressed sessions cannot be framed and are not tracked.
+type commandTracker struct {
+	client  net.Conn
+	backend net.Conn
+	notice  func() // sends the shutdown ERR; nil sends nothing
+
+	mu       sync.Mutex
+	up       packetFramer
+	down     packetFramer
+	resp     respState
+	cmd      byte
+	pending  int  // definitions left in respColumns or respDefs
+	draining bool // shutdown started: end the session at the next idle point
+	stopped  bool // ended; no further commands reach the backend
+}
+
+func newCommandTracker(client, backend net.Conn, notice func()) *commandTracker {
+	return &commandTracker{client: client, backend: backend, notice: notice}
+}
+
+// shutdown ends the session now if it is idle, otherwise as soon as the
+// command in flight has been answered.
+func (t *commandTracker) shutdown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = true
+	if t.resp == respIdle {
+		t.stopLocked()
+	}
+}
+
+// stopLocked sends the shutdown notice and expires both connections'
+// deadlines, which ends pipe's copies.
+func (t *commandTracker) stopLocked() {
+	if t.stopped {
+		return
+	}
+	t.stopped = true
+	if t.notice != nil {
+		t.notice()
+	}
+	now := time.Now()
+	_ = t.client.SetDeadline(now)
+	_ = t.backend.SetDeadline(now)
+}
+
+// toBackend is the client->backend writer: it notes each command before the
+// backend sees it and refuses commands once the session was ended.
+func (t *commandTracker) toBackend() io.Writer {
+	return trackerWriter(func(b []byte) (int, error) {
+		t.mu.Lock()
+		if t.stopped {
+			t.mu.Unlock()
+			return 0, errSessionDrained
+		}
+		t.up.feed(b, t.commandStarted, t.commandSent)
+		t.mu.Unlock()
+		return t.backend.Write(b)
+	})
+}
+
+// toClient is the backend->client writer; packets count towards the reply
+// only once they were written.
+func (t *commandTracker) toClient() io.Writer {
+	return trackerWriter(func(b []byte) (int, error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		n, err := t.client.Write(b)
+		t.down.feed(b[:n], nil, t.replied)
+		return n, err
+	})
+}
+
+// replies is the writer for answers the inspector sends the client itself,
+// which never reach the backend and so leave the state alone.
+func (t *commandTracker) replies() io.Writer {
+	return trackerWriter(func(b []byte) (int, error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.client.Write(b)
+	})
+}
+
+type trackerWriter func([]byte) (int, error)
+
+func (w trackerWriter) Write(b []byte) (int, error) { return w(b) }
+
+// commandStarted marks the session busy as soon as a command's header is
+// seen; packets with a non-zero sequence continue an exchange, e.g. LOCAL
+// INFILE data.
+func (t *commandTracker) commandStarted(seq byte) {
+	if seq == 0 {
+		t.resp = respUnknown
+	}
+}
+
+// commandSent sets what the backend owes for the command in prefix.
+func (t *commandTracker) commandSent(seq byte, _ int, prefix []byte) {
+	if seq != 0 || len(prefix) == 0 {
+		return
+	}
+	t.cmd = prefix[0]
+	switch t.cmd {
+	case mysql.COM_QUERY, mysql.COM_STMT_EXECUTE:
+		t.resp = respResult
+	case mysql.COM_STMT_FETCH:
+		t.resp = respRows
+	case mysql.COM_STMT_PREPARE:
+		t.resp = respPrepare
+	case mysql.COM_FIELD_LIST:
+		t.resp = respFieldList
+	case mysql.COM_PING, mysql.COM_INIT_DB, mysql.COM_RESET_CONNECTION, mysql.COM_SET_OPTION,
+		mysql.COM_STMT_RESET, mysql.COM_STATISTICS, mysql.COM_PROCESS_KILL, mysql.COM_REFRESH,
+		mysql.COM_DEBUG, mysql.COM_CREATE_DB, mysql.COM_DROP_DB:
+		t.resp = respAny
+	case mysql.COM_STMT_CLOSE, mysql.COM_STMT_SEND_LONG_DATA, mysql.COM_QUIT:
+		t.setIdle()
+	}
+}
+
+// replied advances the reply state by one delivered packet.
+func (t *commandTracker) replied(_ byte, length int, prefix []byte) {
+	if len(prefix) == 0 {
+		return
+	}
+	isErr := prefix[0] == mysql.ERR_HEADER
+	isEOF := prefix[0] == mysql.EOF_HEADER && length < 9
+	switch t.resp {
+	case respIdle, respUnknown:
+		// Unsolicited, or a reply that is not followed.
+	case respAny:
+		t.setIdle()
+	case respResult, respInfile:
+		switch {
+		case isErr:
+			t.setIdle()
+		case prefix[0] == mysql.OK_HEADER:
+			if status, ok := okStatus(prefix); !ok {
+				t.resp = respUnknown
+			} else if status&mysql.SERVER_MORE_RESULTS_EXISTS != 0 {
+				t.resp = respResult
+			} else {
+				t.setIdle()
+			}
+		case t.resp == respInfile:
+			t.resp = respUnknown
+		case prefix[0] == mysql.LocalInFile_HEADER:
+			t.resp = respInfile
+		default:
+			n, _, ok := lenencInt(prefix)
+			if !ok {
+				t.resp = respUnknown
+				return
+			}
+			t.resp, t.pending = respColumns, int(n)
+		}
+	case respColumns:
+		if t.pending > 0 {
+			t.pending--
+			return
+		}
+		// The EOF after the definitions. A cursor holds the rows back for
+		// COM_STMT_FETCH.
+		if t.cmd == mysql.COM_STMT_EXECUTE && eofStatus(prefix)&mysql.SERVER_STATUS_CURSOR_EXISTS != 0 {
+			t.setIdle()
+			return
+		}
+		t.resp = respRows
+	case respRows:
+		switch {
+		case isErr:
+			t.setIdle()
+		case isEOF && eofStatus(prefix)&mysql.SERVER_MORE_RESULTS_EXISTS != 0:
+			t.resp = respResult
+		case isEOF:
+			t.setIdle()
+		}
+	case respPrepare:
+		if prefix[0] != mysql.OK_HEADER || len(prefix) < 9 {
+			t.setIdle()
+			return
+		}
+		defs := func(n uint16) int {
+			if n == 0 {
+				return 0
+			}
+			return int(n) + 1 // definitions, then EOF
+		}
+		t.pending = defs(binary.LittleEndian.Uint16(prefix[5:])) + defs(binary.LittleEndian.Uint16(prefix[7:]))
+		if t.pending == 0 {
+			t.setIdle()
+			return
+		}
+		t.resp = respDefs
+	case respDefs:
+		if t.pending--; t.pending == 0 {
+			t.setIdle()
+		}
+	case respFieldList:
+		if isErr || isEOF {
+			t.setIdle()
+		}
+	}
+}
+
+// setIdle marks the session between commands and ends it if shutdown is
+// waiting for that.
+func (t *commandTracker) setIdle() {
+	t.resp = respIdle
+	if t.draining {
+		t.stopLocked()
+	}
+}
+
+// okStatus returns the status flags of an OK packet: after the header come
+// the affected rows and last insert id as length-encoded integers.
+func okStatus(p []byte) (uint16, bool) {
+	pos := 1
+	for range 2 {
+		_, n, ok := lenencInt(p[pos:])
+		if !ok {
+			return 0, false
+		}
+		pos += n
+	}
+	if len(p) < pos+2 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(p[pos:]), true
+}
+
+// eofStatus returns the status flags of an EOF packet, after its warning
+// count; a truncated one reports none.
+func eofStatus(p []byte) uint16 {
+	if len(p) < 5 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(p[3:])
+}
+
+// lenencInt decodes a length-encoded integer, reporting false when b is too
+// short to hold it.
+func lenencInt(b []byte) (uint64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	size := 1
+	switch b[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	}
+	if len(b) < size {
+		return 0, 0, false
+	}
+	num, _, n := mysql.LengthEncodedInt(b)
+	return num, n, true
+}
+
+// packetFramer splits one direction of a session into MySQL packets as
+// bytes pass. A logical packet split into max-length fragments is reported
+// once, with its first fragment's sequence id and prefix.
+type packetFramer struct {
+	hdr       [4]byte
+	hdrLen    int
+	remaining int  // payload bytes of the current fragment not seen yet
+	cont      bool // the current fragment continues a max-length one
+	seq       byte
+	length    int // payload bytes of the logical packet so far
+	prefix    []byte
+}
+
+// feed consumes b, calling start when a logical packet's header is seen and
+// done once its last byte has been.
+func (f *packetFramer) feed(b []byte, start func(seq byte), done func(seq byte, length int, prefix []byte)) {
+	for len(b) > 0 {
+		if f.hdrLen < len(f.hdr) {
+			c := copy(f.hdr[f.hdrLen:], b)
+			f.hdrLen += c
+			b = b[c:]
+			if f.hdrLen < len(f.hdr) {
+				return
+			}
+			n := int(f.hdr[0]) | int(f.hdr[1])<<8 | int(f.hdr[2])<<16
+			if !f.cont {
+				f.seq, f.length, f.prefix = f.hdr[3], 0, f.prefix[:0]
+				if start != nil {
+					start(f.seq)
+				}
+			}
+			f.remaining = n
+			f.length += n
+			f.cont = n == maxPayloadLen
+		} else {
+			c := min(len(b), f.remaining)
+			if room := framePrefixLen - len(f.prefix); room > 0 {
+				f.prefix = append(f.prefix, b[:min(c, room)]...)
+			}
+			f.remaining -= c
+			b = b[c:]
+		}
+		if f.remaining == 0 {
+			f.hdrLen = 0
+			if !f.cont {
+				done(f.seq, f.length, f.prefix)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestCommandTrackerIdleOnlyAfterFullReply(t *testing.T) {
+	t.Parallel()
+
+	eof := func(seq uint8, status uint16) []byte {
+		return commandPacket(seq, mysql.EOF_HEADER, string([]byte{0, 0, byte(status), byte(status >> 8)}))
+	}
+	ok := func(seq uint8, status uint16) []byte {
+		return commandPacket(seq, mysql.OK_HEADER, string([]byte{0, 0, byte(status), byte(status >> 8), 0, 0}))
+	}
+	prepareOK := func(cols, params byte) []byte {
+		return commandPacket(1, mysql.OK_HEADER, string([]byte{1, 0, 0, 0, cols, 0, params, 0, 0, 0, 0}))
+	}
+	cases := []struct {
+		name    string
+		command []byte
+		reply   [][]byte
+	}{
+		{name: "ok", command: commandPacket(0, mysql.COM_QUERY, "SET @a = 1"), reply: [][]byte{ok(1, 0)}},
+		{name: "error", command: commandPacket(0, mysql.COM_QUERY, "SELECT nope"), reply: [][]byte{errPacket(1, 1054, "Unknown column")}},
+		{name: "result set", command: commandPacket(0, mysql.COM_QUERY, "SELECT 1"), reply: [][]byte{
+			commandPacket(1, 0x01, ""), commandPacket(2, 0x03, "def"), eof(3, 0),
+			commandPacket(4, 0x01, "1"), commandPacket(5, 0x01, "\xfe"), eof(6, 0),
+		}},
+		{name: "error mid rows", command: commandPacket(0, mysql.COM_QUERY, "SELECT 1"), reply: [][]byte{
+			commandPacket(1, 0x01, ""), commandPacket(2, 0x03, "def"), eof(3, 0),
+			commandPacket(4, 0x01, "1"), errPacket(5, 1317, "Query execution was interrupted"),
+		}},
+		{name: "multiple results", command: commandPacket(0, mysql.COM_QUERY, "CALL p()"), reply: [][]byte{
+			commandPacket(1, 0x01, ""), commandPacket(2, 0x03, "def"), eof(3, 0),
+			commandPacket(4, 0x01, "1"), eof(5, mysql.SERVER_MORE_RESULTS_EXISTS), ok(6, 0),
+		}},
+		{name: "ok with more results", command: commandPacket(0, mysql.COM_QUERY, "CALL p()"), reply: [][]byte{
+			ok(1, mysql.SERVER_MORE_RESULTS_EXISTS), ok(2, 0),
+		}},
+		{name: "local infile", command: commandPacket(0, mysql.COM_QUERY, "LOAD DATA LOCAL INFILE 'x' INTO TABLE t"), reply: [][]byte{
+			commandPacket(1, mysql.LocalInFile_HEADER, "x"), ok(4, 0),
+		}},
+		{name: "prepare", command: commandPacket(0, mysql.COM_STMT_PREPARE, "SELECT ?"), reply: [][]byte{
+			prepareOK(1, 1), commandPacket(2, 0x03, "def"), eof(3, 0), commandPacket(4, 0x03, "def"), eof(5, 0),
+		}},
+		{name: "execute with cursor", command: commandPacket(0, mysql.COM_STMT_EXECUTE, "\x01\x00\x00\x00\x01"), reply: [][]byte{
+			commandPacket(1, 0x01, ""), commandPacket(2, 0x03, "def"), eof(3, mysql.SERVER_STATUS_CURSOR_EXISTS),
+		}},
+		{name: "fetch", command: commandPacket(0, mysql.COM_STMT_FETCH, "\x01\x00\x00\x00\x01\x00\x00\x00"), reply: [][]byte{
+			commandPacket(1, 0x00, "\x00"), eof(2, mysql.SERVER_STATUS_LAST_ROW_SEND),
+		}},
+		{name: "field list", command: commandPacket(0, mysql.COM_FIELD_LIST, "t\x00"), reply: [][]byte{
+			commandPacket(1, 0x03, "def"), commandPacket(2, 0x03, "def"), eof(3, 0),
+		}},
+		{name: "ping", command: commandPacket(0, mysql.COM_PING, ""), reply: [][]byte{ok(1, 0)}},
+	}
+
+	for _, tc := range cases {
+		tr := &commandTracker{}
+		// Bytes arrive one at a time so every packet is split mid-header and
+		// mid-payload.
+		for _, b := range tc.command {
+			tr.up.feed([]byte{b}, tr.commandStarted, tr.commandSent)
+		}
+		for i, packet := range tc.reply {
+			if tr.resp == respIdle {
+				t.Fatalf("%s: idle before reply packet %d", tc.name, i)
+			}
+			for _, b := range packet {
+				tr.down.feed([]byte{b}, nil, tr.replied)
+			}
+		}
+		if tr.resp != respIdle {
+			t.Fatalf("%s: not idle after the full reply (state %d)", tc.name, tr.resp)
+		}
+	}
+}
+
+func TestCommandTrackerBusyFromCommandHeader(t *testing.T) {
+	t.Parallel()
+
+	tr := &commandTracker{}
+	packet := commandPacket(0, mysql.COM_QUERY, "SELECT 1")
+	tr.up.feed(packet[:4], tr.commandStarted, tr.commandSent)
+	if tr.resp == respIdle {
+		t.Fatal("expected a session to be busy once a command header was sent")
+	}
+}
+
+func TestPacketFramerJoinsMaxLengthFragments(t *testing.T) {
+	t.Parallel()
+
+	var (
+		f     packetFramer
+		calls int
+		got   int
+	)
+	done := func(_ byte, length int, prefix []byte) {
+		calls++
+		got = length
+		if prefix[0] != 0xaa || len(prefix) != framePrefixLen {
+			t.Fatalf("unexpected prefix %x", prefix)
+		}
+	}
+	first := make([]byte, 4+maxPayloadLen)
+	first[0], first[1], first[2] = 0xff, 0xff, 0xff
+	first[4] = 0xaa
+	f.feed(first, nil, done)
+	if calls != 0 {
+		t.Fatal("expected a max-length fragment not to complete the packet")
+	}
+	f.feed([]byte{2, 0, 0, 1, 0, 0}, nil, done)
+	if calls != 1 || got != maxPayloadLen+2 {
+		t.Fatalf("got %d packets of %d bytes, want 1 of %d", calls, got, maxPayloadLen+2)
+	}
+}
@@ -1,11 +1,16 @@
 package proxy
 
 import (
+	"bytes"
+	"compress/zlib"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -42,25 +47,981 @@ func TestLocalOnlyEndToEndProxyFlow(t *testing.T) {
 		CABundle:      "/tmp/unused-in-local-e2e.pem",
 	}
 
-	pool := NewBackendPool(2, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, error) {
-		return client.ConnectWithContext(ctx, backendAddr, backendUser, backendPass, "", 2*time.Second, func(c *client.Conn) error {
+	pool := NewBackendPool(2, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, backendUser, backendPass, "", 2*time.Second, func(c *client.Conn) error {
 			c.UnsetCapability(mysql.CLIENT_QUERY_ATTRIBUTES)
 			c.UnsetCapability(mysql.CLIENT_COMPRESS)
 			c.UnsetCapability(mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM)
 			return nil
+		}))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Start(ctx)
+
+	proxy := New(profile, slog.Default(), pool, 5*time.Second, 20)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- proxy.Run(ctx)
+	}()
+
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	defer frontend.Close()
+
+	result, err := frontend.Execute("SELECT 1")
+	if err != nil {
+		t.Fatalf("execute query through proxy: %v", err)
+	}
+	if !result.HasResultset() || result.RowNumber() != 1 {
+		t.Fatalf("unexpected resultset shape")
+	}
+	got, err := result.GetInt(0, 0)
+	if err != nil {
+		t.Fatalf("read result value: %v", err)
+	}
+	if got != 1 {
+		t.Fatalf("expected 1, got %d", got)
+	}
+
+	_ = frontend.Close()
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("proxy run error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlyProxyAdvertisesConfiguredServerVersion(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:          "versioned",
+		ListenAddr:    proxyAddr,
+		MaxConns:      5,
+		ProxyUser:     "local_proxy_v",
+		ProxyPassword: "local_proxy_pass",
+		RDSHost:       "local-backend",
+		RDSPort:       3306,
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "ignored",
+		ServerVersion: "8.0.35-rds",
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	if got := frontend.GetServerVersion(); got != "8.0.35-rds" {
+		t.Fatalf("expected advertised server version 8.0.35-rds, got %q", got)
+	}
+	if _, err := frontend.Execute("SELECT 1"); err != nil {
+		t.Fatalf("execute query through proxy: %v", err)
+	}
+	_ = frontend.Close()
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("proxy run error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlyVersionCommentAnswersBannerQuery(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:           "banner",
+		ListenAddr:     proxyAddr,
+		MaxConns:       5,
+		ProxyUser:      "local_proxy_banner",
+		ProxyPassword:  "local_proxy_pass",
+		RDSHost:        "local-backend",
+		RDSPort:        3306,
+		RDSRegion:      "eu-west-1",
+		RDSDBUser:      "ignored",
+		VersionComment: "staging read-replica via rds-iam-proxy",
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	res, err := frontend.Execute("select @@version_comment limit 1")
+	if err != nil {
+		t.Fatalf("version_comment query: %v", err)
+	}
+	if got, _ := res.GetString(0, 0); got != profile.VersionComment {
+		t.Fatalf("version_comment = %q, want %q", got, profile.VersionComment)
+	}
+	// Everything else still reaches the backend on the same session.
+	res, err = frontend.Execute("SELECT 1")
+	if err != nil {
+		t.Fatalf("execute query through proxy: %v", err)
+	}
+	if got, _ := res.GetInt(0, 0); got != 1 {
+		t.Fatalf("SELECT 1 = %d", got)
+	}
+	_ = frontend.Close()
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("proxy run error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlyReportMaxConnectionsAnswersWithProxyCap(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:                 "sizing",
+		ListenAddr:           proxyAddr,
+		MaxConns:             7,
+		ProxyUser:            "local_proxy_sizing",
+		ProxyPassword:        "local_proxy_pass",
+		RDSHost:              "local-backend",
+		RDSPort:              3306,
+		RDSRegion:            "eu-west-1",
+		RDSDBUser:            "ignored",
+		ReportMaxConnections: true,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, profile.MaxConns)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	res, err := frontend.Execute("SHOW VARIABLES LIKE 'max_connections'")
+	if err != nil {
+		t.Fatalf("max_connections query: %v", err)
+	}
+	name, _ := res.GetString(0, 0)
+	value, _ := res.GetString(0, 1)
+	if name != "max_connections" || value != "7" {
+		t.Fatalf("got row (%q, %q), want (max_connections, 7)", name, value)
+	}
+	res, err = frontend.Execute("SELECT 1")
+	if err != nil {
+		t.Fatalf("execute query through proxy: %v", err)
+	}
+	if got, _ := res.GetInt(0, 0); got != 1 {
+		t.Fatalf("SELECT 1 = %d", got)
+	}
+	_ = frontend.Close()
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("proxy run error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlyBackendUnavailableUsesConfiguredMessage(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		custom string
+		want   string
+	}{
+		{custom: "", want: "backend unavailable"},
+		{custom: "RDS backend unreachable - see the runbook", want: "RDS backend unreachable - see the runbook"},
+	} {
+		proxyAddr := freeTCPAddr(t)
+		profile := config.Profile{
+			Name:                      "unavailable",
+			ListenAddr:                proxyAddr,
+			ProxyUser:                 "local_proxy_down",
+			ProxyPassword:             "local_proxy_pass",
+			BackendUnavailableMessage: tc.custom,
+		}
+		pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(context.Context) (*client.Conn, time.Time, error) {
+			return nil, time.Time{}, errors.New("dial backend: connection refused")
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+		accessPath := filepath.Join(t.TempDir(), "access.log")
+		accessLog, err := OpenAccessLog(accessPath)
+		if err != nil {
+			cancel()
+			t.Fatalf("OpenAccessLog: %v", err)
+		}
+		px.SetAccessLog(accessLog)
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- px.Run(ctx)
+		}()
+		waitForTCP(t, proxyAddr, 3*time.Second)
+
+		frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+		if err != nil {
+			cancel()
+			t.Fatalf("connect frontend->proxy: %v", err)
+		}
+		_, err = frontend.Execute("SELECT 1")
+		_ = frontend.Close()
+		var myErr *mysql.MyError
+		if !errors.As(err, &myErr) || myErr.Code != mysql.ER_CON_COUNT_ERROR || myErr.Message != tc.want {
+			cancel()
+			t.Fatalf("expected ERR %d %q, got: %v", mysql.ER_CON_COUNT_ERROR, tc.want, err)
+		}
+
+		cancel()
+		select {
+		case <-runErr:
+		case <-time.After(5 * time.Second):
+			t.Fatal("proxy did not shut down")
+		}
+		_ = accessLog.Close()
+		line, _ := os.ReadFile(accessPath)
+		if !strings.Contains(string(line), "profile=unavailable proxy_user=local_proxy_down") || !strings.Contains(string(line), "close_reason=backend_unavailable") {
+			t.Fatalf("unexpected access log: %q", line)
+		}
+	}
+}
+
+func TestLocalOnlyLogUnavailableQueryLogsFirstQuery(t *testing.T) {
+	t.Parallel()
+
+	for _, enabled := range []bool{false, true} {
+		proxyAddr := freeTCPAddr(t)
+		profile := config.Profile{
+			Name:                "unavailable",
+			ListenAddr:          proxyAddr,
+			ProxyUser:           "local_proxy_down",
+			ProxyPassword:       "local_proxy_pass",
+			LogUnavailableQuery: enabled,
+		}
+		var logs syncBuffer
+		logger := slog.New(slog.NewTextHandler(&logs, nil))
+		pool := NewBackendPool(1, time.Minute, time.Second, logger, func(context.Context) (*client.Conn, time.Time, error) {
+			return nil, time.Time{}, errors.New("dial backend: connection refused")
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		px := New(profile, logger, pool, 5*time.Second, 5)
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- px.Run(ctx)
+		}()
+		waitForTCP(t, proxyAddr, 3*time.Second)
+
+		frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+		if err != nil {
+			cancel()
+			t.Fatalf("connect frontend->proxy: %v", err)
+		}
+		_, err = frontend.Execute("SELECT id FROM orders WHERE customer_id = 42")
+		_ = frontend.Close()
+		if err == nil {
+			cancel()
+			t.Fatal("expected backend unavailable error")
+		}
+
+		cancel()
+		select {
+		case <-runErr:
+		case <-time.After(5 * time.Second):
+			t.Fatal("proxy did not shut down")
+		}
+		got := logs.String()
+		logged := strings.Contains(got, `query="SELECT id FROM orders WHERE customer_id = 42"`)
+		if logged != enabled {
+			t.Fatalf("log_unavailable_query=%v: query logged=%v; logs:\n%s", enabled, logged, got)
+		}
+	}
+}
+
+func TestLocalOnlyAuthFailuresAreClassified(t *testing.T) {
+	t.Parallel()
+
+	proxyAddr := freeTCPAddr(t)
+	profile := config.Profile{
+		Name:          "authfail",
+		ListenAddr:    proxyAddr,
+		ProxyUser:     "local_proxy_auth",
+		ProxyPassword: "local_proxy_pass",
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("dial backend: connection refused")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	if _, err := client.Connect(proxyAddr, profile.ProxyUser, "wrong", ""); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+	raw, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	_ = raw.Close() // hang up during the greeting
+
+	deadline := time.Now().Add(3 * time.Second)
+	// waitForTCP's probe connection hangs up during the greeting too.
+	want := AuthFailureStats{BadCredentials: 1, Transport: 2}
+	for px.AuthFailures() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("auth failures = %+v, want %+v", px.AuthFailures(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-runErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlyAuthTimeoutClosesSilentClient(t *testing.T) {
+	t.Parallel()
+
+	proxyAddr := freeTCPAddr(t)
+	profile := config.Profile{
+		Name:          "authtimeout",
+		ListenAddr:    proxyAddr,
+		ProxyUser:     "local_proxy_slow",
+		ProxyPassword: "local_proxy_pass",
+		AuthTimeout:   200 * time.Millisecond,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("dial backend: connection refused")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	// The proxy starts the deadline on accept, so time from before the dial.
+	start := time.Now()
+	raw, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer raw.Close()
+	// Read the greeting, then stay silent until the proxy hangs up.
+	_ = raw.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		t.Fatalf("expected proxy to close the silent client, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < profile.AuthTimeout {
+		t.Fatalf("closed after %s, before auth_timeout %s", elapsed, profile.AuthTimeout)
+	}
+	deadline := time.Now().Add(time.Second)
+	for px.ActiveConns() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the connection slot to be released, active=%d", px.ActiveConns())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-runErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlySessionSetupTimeoutBoundsBackendBorrow(t *testing.T) {
+	t.Parallel()
+
+	proxyAddr := freeTCPAddr(t)
+	profile := config.Profile{
+		Name:                "setup",
+		ListenAddr:          proxyAddr,
+		ProxyUser:           "local_proxy_setup",
+		ProxyPassword:       "local_proxy_pass",
+		SessionSetupTimeout: 300 * time.Millisecond,
+	}
+	// A backend that never finishes connecting.
+	pool := NewBackendPool(1, time.Minute, time.Minute, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		<-ctx.Done()
+		return nil, time.Time{}, ctx.Err()
+	})
+	var logs syncBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.New(slog.NewTextHandler(&logs, nil)), pool, 5*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	defer frontend.Close()
+	start := time.Now()
+	_, err = frontend.Execute("SELECT 1")
+	if err == nil || !strings.Contains(err.Error(), "session_setup_timeout") {
+		t.Fatalf("expected a session_setup_timeout error, got: %v", err)
+	}
+	if waited := time.Since(start); waited > 3*time.Second {
+		t.Fatalf("setup was not bounded, took %s", waited)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), "stage=backend") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), `msg="session setup timed out"`) || !strings.Contains(logs.String(), "stage=backend") {
+		t.Fatalf("expected a setup timeout log for the backend stage, got:\n%s", logs.String())
+	}
+
+	cancel()
+	select {
+	case <-runErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlyShutdownAbortsHandshakeInProgress(t *testing.T) {
+	t.Parallel()
+
+	proxyAddr := freeTCPAddr(t)
+	profile := config.Profile{
+		Name:          "stuck-auth",
+		ListenAddr:    proxyAddr,
+		ProxyUser:     "local_proxy_stuck",
+		ProxyPassword: "local_proxy_pass",
+		AuthTimeout:   time.Minute,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("backend not needed")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 10*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	raw, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer raw.Close()
+	if _, err := raw.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("read greeting: %v", err)
+	}
+
+	// Well inside both auth_timeout and the shutdown timeout.
+	start := time.Now()
+	cancel()
+	select {
+	case <-runErr:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown waited on a client stuck in the handshake")
+	}
+	_ = raw.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		t.Fatalf("expected the proxy to close the client, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("handshake abort took %s", elapsed)
+	}
+}
+
+func TestLocalOnlySharedListenerRoutesByProxyUser(t *testing.T) {
+	t.Parallel()
+
+	proxyAddr := freeTCPAddr(t)
+	profiles := make([]config.Profile, 0, 2)
+	pools := make([]*BackendPool, 0, 2)
+	for _, name := range []string{"alpha", "beta"} {
+		backendAddr := freeTCPAddr(t)
+		stopBackend := startNamedFakeBackend(t, backendAddr, "backend_"+name, "pass_"+name, name)
+		defer stopBackend()
+
+		profiles = append(profiles, config.Profile{
+			Name:          name,
+			ListenAddr:    proxyAddr,
+			MaxConns:      5,
+			ProxyUser:     "local_" + name,
+			ProxyPassword: "local_pass_" + name,
+			RDSHost:       "local-backend",
+			RDSPort:       3306,
+			RDSRegion:     "eu-west-1",
+			RDSDBUser:     "ignored_" + name,
 		})
+		user, pass := "backend_"+name, "pass_"+name
+		pools = append(pools, NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+			return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, user, pass, "", 2*time.Second))
+		}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	px := New(profiles[0], slog.Default(), pools[0], 5*time.Second, 5)
+	if err := px.AddRoute(profiles[1], slog.Default(), pools[1], 5); err != nil {
+		t.Fatalf("AddRoute: %v", err)
+	}
+	if err := px.AddRoute(profiles[1], slog.Default(), pools[1], 5); err == nil {
+		t.Fatal("expected duplicate proxy_user route to be rejected")
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	for _, prof := range profiles {
+		frontend, err := client.Connect(proxyAddr, prof.ProxyUser, prof.ProxyPassword, "")
+		if err != nil {
+			t.Fatalf("connect as %s: %v", prof.ProxyUser, err)
+		}
+		result, err := frontend.Execute("SELECT @@hostname")
+		if err != nil {
+			t.Fatalf("query as %s: %v", prof.ProxyUser, err)
+		}
+		got, err := result.GetString(0, 0)
+		if err != nil {
+			t.Fatalf("read result: %v", err)
+		}
+		if got != prof.Name {
+			t.Fatalf("user %s routed to backend %q, want %q", prof.ProxyUser, got, prof.Name)
+		}
+		_ = frontend.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("proxy run error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down")
+	}
+}
+
+func TestLocalOnlyShutdownEndsIdleSessionWithServerShutdownError(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:          "shutdown",
+		ListenAddr:    proxyAddr,
+		ProxyUser:     "local_proxy_shutdown",
+		ProxyPassword: "local_proxy_pass",
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 30*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	defer frontend.Close()
+	if _, err := frontend.Execute("SELECT 1"); err != nil {
+		t.Fatalf("execute query through proxy: %v", err)
+	}
+
+	// The idle session ends at cancel instead of holding the drain open for
+	// the whole shutdown timeout.
+	cancel()
+	_ = frontend.SetReadDeadline(time.Now().Add(3 * time.Second))
+	frontend.ResetSequence()
+	data, err := frontend.ReadPacket()
+	if err != nil {
+		t.Fatalf("expected shutdown ERR packet, got: %v", err)
+	}
+	if len(data) < 3 || data[0] != mysql.ERR_HEADER || uint16(data[1])|uint16(data[2])<<8 != mysql.ER_SERVER_SHUTDOWN {
+		t.Fatalf("unexpected packet: %q", data)
+	}
+	if _, err := frontend.ReadPacket(); err == nil {
+		t.Fatal("expected the proxy to close the session after the shutdown ERR")
+	}
+
+	select {
+	case <-runErr:
+	case <-time.After(3 * time.Second):
+		t.Fatal("proxy did not shut down before the shutdown timeout")
+	}
+}
+func TestLocalOnlyDrainRejectWithErrorAnswersNewClients(t *testing.T) {
+	t.Parallel()
+
+	proxyAddr := freeTCPAddr(t)
+	profile := config.Profile{
+		Name:          "drain",
+		ListenAddr:    proxyAddr,
+		ProxyUser:     "local_proxy_drain",
+		ProxyPassword: "local_proxy_pass",
+		DrainMode:     config.DrainRejectWithError,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("no backend in this test")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 30*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	// Stand in for a session that is still draining.
+	px.wg.Add(1)
+	cancel()
+
+	// The listener stays open through the drain, so the first connect is
+	// answered whether acceptLoop or rejectWhileDraining accepts it.
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect during drain: %v", err)
+	}
+	defer frontend.Close()
+	_ = frontend.SetReadDeadline(time.Now().Add(3 * time.Second))
+	frontend.ResetSequence()
+	data, err := frontend.ReadPacket()
+	if err != nil {
+		t.Fatalf("expected shutdown ERR packet, got: %v", err)
+	}
+	if len(data) < 3 || data[0] != mysql.ERR_HEADER || uint16(data[1])|uint16(data[2])<<8 != mysql.ER_SERVER_SHUTDOWN {
+		t.Fatalf("unexpected packet: %q", data)
+	}
+
+	px.wg.Done()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("proxy run error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not shut down after the drain")
+	}
+	if conn, err := net.DialTimeout("tcp", proxyAddr, time.Second); err == nil {
+		_ = conn.Close()
+		t.Fatal("expected the listener to close once the drain finished")
+	}
+}
+
+func TestLocalOnlyAcceptAfterCancelIsRejectedWithError(t *testing.T) {
+	t.Parallel()
+
+	profile := config.Profile{
+		Name:          "drain",
+		ListenAddr:    "127.0.0.1:0",
+		ProxyUser:     "local_proxy_drain",
+		ProxyPassword: "local_proxy_pass",
+		DrainMode:     config.DrainRejectWithError,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("no backend in this test")
+	})
+	px := New(profile, slog.Default(), pool, 30*time.Second, 5)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	// The client is queued before shutdown begins and only accepted after,
+	// as when Accept wins the race with the listener deadline.
+	connected := make(chan *client.Conn, 1)
+	connectErr := make(chan error, 1)
+	go func() {
+		c, err := client.Connect(ln.Addr().String(), profile.ProxyUser, profile.ProxyPassword, "")
+		if err != nil {
+			connectErr <- err
+			return
+		}
+		connected <- c
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	loopDone := make(chan error, 1)
+	go func() {
+		loopDone <- px.acceptLoop(ctx, ln)
+	}()
+
+	var frontend *client.Conn
+	select {
+	case frontend = <-connected:
+	case err := <-connectErr:
+		t.Fatalf("expected the late client to be authenticated and rejected, got: %v", err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("late client was not answered")
+	}
+	defer frontend.Close()
+	_ = frontend.SetReadDeadline(time.Now().Add(3 * time.Second))
+	frontend.ResetSequence()
+	data, err := frontend.ReadPacket()
+	if err != nil {
+		t.Fatalf("expected shutdown ERR packet, got: %v", err)
+	}
+	if len(data) < 3 || data[0] != mysql.ERR_HEADER || uint16(data[1])|uint16(data[2])<<8 != mysql.ER_SERVER_SHUTDOWN {
+		t.Fatalf("unexpected packet: %q", data)
+	}
+
+	_ = ln.Close()
+	if err := <-loopDone; err != nil {
+		t.Fatalf("acceptLoop: %v", err)
+	}
+	px.drainRejects.Wait()
+}
+func TestLocalOnlyAllowCompressionForwardsCompressedClients(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:             "compressed",
+		ListenAddr:       proxyAddr,
+		ProxyUser:        "local_proxy_zlib",
+		ProxyPassword:    "local_proxy_pass",
+		AllowCompression: true,
+	}
+	dial := func(compress bool) func(context.Context) (*client.Conn, error) {
+		return func(ctx context.Context) (*client.Conn, error) {
+			return client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second, func(c *client.Conn) error {
+				c.UnsetCapability(mysql.CLIENT_COMPRESS)
+				if compress {
+					c.SetCapability(mysql.CLIENT_COMPRESS)
+				}
+				return nil
+			})
+		}
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		conn, err := dial(false)(ctx)
+		return conn, time.Time{}, err
 	})
+	pool.Start(context.Background())
+	defer pool.Close()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	pool.Start(ctx)
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	if err := px.SetCompressedDialer(profile.Name, dial(true)); err != nil {
+		t.Fatalf("SetCompressedDialer: %v", err)
+	}
+	go func() {
+		_ = px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
 
-	proxy := New(profile, slog.Default(), pool, 5*time.Second, 20)
-	runErr := make(chan error, 1)
+	for _, compress := range []bool{true, false} {
+		frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "", func(c *client.Conn) error {
+			if compress {
+				c.SetCapability(mysql.CLIENT_COMPRESS)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("connect frontend->proxy compress=%v: %v", compress, err)
+		}
+		for i := 0; i < 2; i++ {
+			res, err := frontend.Execute("SELECT 1")
+			if err != nil {
+				t.Fatalf("execute compress=%v: %v", compress, err)
+			}
+			if got, _ := res.GetInt(0, 0); got != 1 {
+				t.Fatalf("compress=%v: expected 1, got %d", compress, got)
+			}
+		}
+		_ = frontend.Close()
+	}
+
+	// The proxy's own replies on a compressed connection must follow the
+	// client's compressed sequence.
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "missing", func(c *client.Conn) error {
+		c.SetCapability(mysql.CLIENT_COMPRESS)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("connect frontend->proxy db=missing: %v", err)
+	}
+	_, err = frontend.Execute("SELECT 1")
+	_ = frontend.Close()
+	var myErr *mysql.MyError
+	if !errors.As(err, &myErr) || myErr.Code != mysql.ER_BAD_DB_ERROR {
+		t.Fatalf("expected unknown database error, got: %v", err)
+	}
+}
+
+func TestLocalOnlyLabelClientSessionSetsRemoteAddress(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:               "labelled",
+		ListenAddr:         proxyAddr,
+		ProxyUser:          "local_proxy_label",
+		ProxyPassword:      "local_proxy_pass",
+		LabelClientSession: true,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+	pool.Start(context.Background())
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
 	go func() {
-		runErr <- proxy.Run(ctx)
+		_ = px.Run(ctx)
 	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	defer frontend.Close()
+	res, err := frontend.Execute("SELECT @proxy_client")
+	if err != nil {
+		t.Fatalf("select label: %v", err)
+	}
+	got, _ := res.GetString(0, 0)
+	if want := frontend.Conn.LocalAddr().String(); got != want {
+		t.Fatalf("@proxy_client = %q, want client address %q", got, want)
+	}
+}
+
+func TestLocalOnlyRequestIDIsLoggedAndLabelled(t *testing.T) {
+	t.Parallel()
 
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:               "traced",
+		ListenAddr:         proxyAddr,
+		ProxyUser:          "local_proxy_traced",
+		ProxyPassword:      "local_proxy_pass",
+		LabelClientSession: true,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+	defer pool.Close()
+
+	var logs syncBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.New(slog.NewTextHandler(&logs, nil)), pool, 5*time.Second, 5)
+	px.SetRequestIDFormat(RequestIDUUID)
+	go func() {
+		_ = px.Run(ctx)
+	}()
 	waitForTCP(t, proxyAddr, 3*time.Second)
 
 	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
@@ -68,23 +1029,193 @@ func TestLocalOnlyEndToEndProxyFlow(t *testing.T) {
 		t.Fatalf("connect frontend->proxy: %v", err)
 	}
 	defer frontend.Close()
+	res, err := frontend.Execute("SELECT @proxy_request_id")
+	if err != nil {
+		t.Fatalf("select request id: %v", err)
+	}
+	id, _ := res.GetString(0, 0)
+	if len(id) != 36 || id[14] != '4' {
+		t.Fatalf("@proxy_request_id = %q, want a version 4 UUID", id)
+	}
+	if !strings.Contains(logs.String(), "request_id="+id) {
+		t.Fatalf("expected connection logs to carry request_id=%s:\n%s", id, logs.String())
+	}
+}
 
-	result, err := frontend.Execute("SELECT 1")
+func TestLocalOnlyProxyProtocolReportsOriginalClient(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:               "behind-lb",
+		ListenAddr:         proxyAddr,
+		ProxyUser:          "local_proxy_lb",
+		ProxyPassword:      "local_proxy_pass",
+		LabelClientSession: true,
+		ProxyProtocol:      true,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+	defer pool.Close()
+
+	var logs syncBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.New(slog.NewTextHandler(&logs, nil)), pool, 5*time.Second, 5)
+	go func() {
+		_ = px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	viaLB := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(conn, "PROXY TCP4 203.0.113.7 127.0.0.1 40001 3307\r\n")
+		return conn, err
+	}
+	frontend, err := client.ConnectWithDialer(context.Background(), "tcp", proxyAddr, profile.ProxyUser, profile.ProxyPassword, "", viaLB)
 	if err != nil {
-		t.Fatalf("execute query through proxy: %v", err)
+		t.Fatalf("connect frontend->proxy: %v", err)
 	}
-	if !result.HasResultset() || result.RowNumber() != 1 {
-		t.Fatalf("unexpected resultset shape")
+	defer frontend.Close()
+	res, err := frontend.Execute("SELECT @proxy_client")
+	if err != nil {
+		t.Fatalf("select label: %v", err)
 	}
-	got, err := result.GetInt(0, 0)
+	if got, _ := res.GetString(0, 0); got != "203.0.113.7:40001" {
+		t.Fatalf("@proxy_client = %q, want the address from the PROXY header", got)
+	}
+	if !strings.Contains(logs.String(), "remote_addr=203.0.113.7:40001 peer_addr=127.0.0.1:") {
+		t.Fatalf("expected logs to name the original client and the load balancer:\n%s", logs.String())
+	}
+
+	// A connection that does not start with the header is closed unanswered.
+	raw, err := net.Dial("tcp", proxyAddr)
 	if err != nil {
-		t.Fatalf("read result value: %v", err)
+		t.Fatalf("dial proxy: %v", err)
 	}
-	if got != 1 {
-		t.Fatalf("expected 1, got %d", got)
+	defer raw.Close()
+	_ = raw.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.WriteString(raw, "\x10\x00\x00\x01garbage"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if n, err := raw.Read(make([]byte, 1)); n != 0 || err == nil || os.IsTimeout(err) {
+		t.Fatalf("expected the proxy to close the connection, got n=%d err=%v", n, err)
+	}
+	if !strings.Contains(logs.String(), "invalid PROXY protocol header") {
+		t.Fatalf("expected a warning for the missing header:\n%s", logs.String())
+	}
+}
+
+func TestLocalOnlyAuroraFailoverAwareInvalidatesPoolOnReadOnlyError(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:                "aurora",
+		ListenAddr:          proxyAddr,
+		ProxyUser:           "local_proxy_aurora",
+		ProxyPassword:       "local_proxy_pass",
+		RDSHost:             "127.0.0.1",
+		AuroraFailoverAware: true,
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+	pool.Start(context.Background())
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	go func() {
+		_ = px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy: %v", err)
+	}
+	defer frontend.Close()
+	if _, err := frontend.Execute("SELECT 1"); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if got := pool.Stats().Invalidations; got != 0 {
+		t.Fatalf("Invalidations = %d before any read-only error, want 0", got)
+	}
+	_, err = frontend.Execute("INSERT INTO t VALUES (1)")
+	var myErr *mysql.MyError
+	if !errors.As(err, &myErr) || myErr.Code != mysql.ER_OPTION_PREVENTS_STATEMENT {
+		t.Fatalf("expected the read-only error to reach the client, got: %v", err)
+	}
+	// The session stays usable; the client decides whether to reconnect.
+	if _, err := frontend.Execute("SELECT 1"); err != nil {
+		t.Fatalf("select after read-only error: %v", err)
+	}
+	if got := pool.Stats().Invalidations; got != 1 {
+		t.Fatalf("Invalidations = %d after a read-only error, want 1", got)
+	}
+}
+
+func TestLocalOnlyLocalhostListensOnBothLoopbackFamilies(t *testing.T) {
+	t.Parallel()
+
+	probe, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(probe.Addr().String())
+	_ = probe.Close()
+
+	backendAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:          "dual",
+		ListenAddr:    net.JoinHostPort("localhost", port),
+		ProxyUser:     "local_proxy_dual",
+		ProxyPassword: "local_proxy_pass",
+	}
+	pool := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+	pool.Start(context.Background())
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+
+	for _, host := range []string{"::1", "127.0.0.1"} {
+		addr := net.JoinHostPort(host, port)
+		waitForTCP(t, addr, 3*time.Second)
+		frontend, err := client.Connect(addr, profile.ProxyUser, profile.ProxyPassword, "")
+		if err != nil {
+			t.Fatalf("connect frontend->proxy over %s: %v", addr, err)
+		}
+		if _, err := frontend.Execute("SELECT 1"); err != nil {
+			t.Fatalf("execute over %s: %v", addr, err)
+		}
+		_ = frontend.Close()
 	}
 
-	_ = frontend.Close()
 	cancel()
 	select {
 	case err := <-runErr:
@@ -92,12 +1223,80 @@ func TestLocalOnlyEndToEndProxyFlow(t *testing.T) {
 			t.Fatalf("proxy run error: %v", err)
 		}
 	case <-time.After(5 * time.Second):
-		t.Fatal("proxy did not shut down")
+		t.Fatal("proxy did not shut down with two listeners")
+	}
+}
+
+func TestLocalOnlyClientRequestedDatabaseIsSelected(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	proxyAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	profile := config.Profile{
+		Name:          "schema",
+		ListenAddr:    proxyAddr,
+		ProxyUser:     "local_proxy_db",
+		ProxyPassword: "local_proxy_pass",
+		DefaultDB:     "analytics",
+	}
+	pool := NewBackendPool(2, time.Minute, time.Second, slog.Default(), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", profile.DefaultDB, 2*time.Second))
+	})
+	pool.Start(context.Background())
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	px := New(profile, slog.Default(), pool, 5*time.Second, 5)
+	go func() {
+		_ = px.Run(ctx)
+	}()
+	waitForTCP(t, proxyAddr, 3*time.Second)
+
+	for _, tc := range []struct{ requested, want string }{
+		{requested: "", want: "analytics"},
+		{requested: "analytics", want: "analytics"},
+		{requested: "reporting", want: "reporting"},
+	} {
+		frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, tc.requested)
+		if err != nil {
+			t.Fatalf("connect frontend->proxy db=%q: %v", tc.requested, err)
+		}
+		res, err := frontend.Execute("SELECT DATABASE()")
+		if err != nil {
+			t.Fatalf("select database db=%q: %v", tc.requested, err)
+		}
+		got, _ := res.GetString(0, 0)
+		_ = frontend.Close()
+		if got != tc.want {
+			t.Fatalf("requested db %q: backend is in %q, want %q", tc.requested, got, tc.want)
+		}
+	}
+
+	frontend, err := client.Connect(proxyAddr, profile.ProxyUser, profile.ProxyPassword, "missing")
+	if err != nil {
+		t.Fatalf("connect frontend->proxy db=missing: %v", err)
+	}
+	_, err = frontend.Execute("SELECT 1")
+	_ = frontend.Close()
+	var myErr *mysql.MyError
+	if !errors.As(err, &myErr) || myErr.Code != mysql.ER_BAD_DB_ERROR {
+		t.Fatalf("expected unknown database error, got: %v", err)
 	}
 }
 
 func startFakeBackend(t *testing.T, addr, user, pass string) func() {
 	t.Helper()
+	return startNamedFakeBackend(t, addr, user, pass, "")
+}
+
+// startNamedFakeBackend answers `SELECT @@hostname` with name so tests can
+// tell which backend a query reached.
+func startNamedFakeBackend(t *testing.T, addr, user, pass, name string) func() {
+	t.Helper()
 
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -119,7 +1318,7 @@ func startFakeBackend(t *testing.T, addr, user, pass string) func() {
 				}
 				continue
 			}
-			go handleFakeBackendConn(conn, user, pass)
+			go handleFakeBackendConn(conn, user, pass, name)
 		}
 	}()
 
@@ -128,14 +1327,17 @@ func startFakeBackend(t *testing.T, addr, user, pass string) func() {
 	}
 }
 
-func handleFakeBackendConn(conn net.Conn, user, pass string) {
+func handleFakeBackendConn(conn net.Conn, user, pass, name string) {
 	defer conn.Close()
 
-	handler := fakeBackendHandler{}
-	srvConn, err := server.NewConn(conn, user, pass, handler)
+	// Offer compression like a real MySQL server; clients opt in.
+	framed := &fakeCompressedConn{Conn: &compressConn{Conn: conn}}
+	handler := &fakeBackendHandler{name: name}
+	srvConn, err := server.NewConn(framed, user, pass, handler)
 	if err != nil {
 		return
 	}
+	framed.active = clientCompressed(srvConn)
 	for {
 		if err := srvConn.HandleCommand(); err != nil {
 			if err == io.EOF || strings.Contains(err.Error(), "use of closed network connection") {
@@ -146,13 +1348,119 @@ func handleFakeBackendConn(conn net.Conn, user, pass string) {
 	}
 }
 
+// fakeCompressedConn frames traffic like a MySQL server with compression on:
+// a whole response goes out as one uncompressed compressed packet, flushed
+// when the server next reads. go-mysql's own server writes one compressed
+// packet per MySQL packet, which its client cannot read back.
+type fakeCompressedConn struct {
+	net.Conn
+	active bool
+
+	in      []byte
+	out     []byte
+	nextSeq uint8
+}
+
+func (c *fakeCompressedConn) Read(b []byte) (int, error) {
+	if !c.active {
+		return c.Conn.Read(b)
+	}
+	if len(c.out) > 0 {
+		frame := []byte{byte(len(c.out)), byte(len(c.out) >> 8), byte(len(c.out) >> 16), c.nextSeq, 0, 0, 0}
+		if _, err := c.Conn.Write(append(frame, c.out...)); err != nil {
+			return 0, err
+		}
+		c.out = c.out[:0]
+	}
+	for len(c.in) == 0 {
+		var hdr [7]byte
+		if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+			return 0, err
+		}
+		payload := make([]byte, int(hdr[0])|int(hdr[1])<<8|int(hdr[2])<<16)
+		if _, err := io.ReadFull(c.Conn, payload); err != nil {
+			return 0, err
+		}
+		c.nextSeq = hdr[3] + 1
+		if hdr[4] == 0 && hdr[5] == 0 && hdr[6] == 0 {
+			c.in = payload
+			continue
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return 0, err
+		}
+		if c.in, err = io.ReadAll(zr); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.in)
+	c.in = c.in[n:]
+	return n, nil
+}
+
+func (c *fakeCompressedConn) Write(b []byte) (int, error) {
+	if !c.active {
+		return c.Conn.Write(b)
+	}
+	c.out = append(c.out, b...)
+	return len(b), nil
+}
+
 type fakeBackendHandler struct {
 	server.EmptyHandler
+	name      string
+	db        string
+	label     string // value of @proxy_client
+	requestID string // value of @proxy_request_id
+}
+
+// UseDB accepts only the schemas the local e2e tests select.
+func (h *fakeBackendHandler) UseDB(db string) error {
+	switch db {
+	case "analytics", "reporting":
+		h.db = db
+		return nil
+	}
+	return mysql.NewError(mysql.ER_BAD_DB_ERROR, fmt.Sprintf("Unknown database '%s'", db))
 }
 
-func (fakeBackendHandler) HandleQuery(query string) (*mysql.Result, error) {
+func (h *fakeBackendHandler) HandleQuery(query string) (*mysql.Result, error) {
 	q := strings.TrimSpace(strings.ToUpper(query))
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(query), "SET @proxy_client = "); ok {
+		label, requestID, _ := strings.Cut(rest, ", @proxy_request_id = ")
+		h.label, h.requestID = strings.Trim(label, "'"), strings.Trim(requestID, "'")
+		return nil, nil
+	}
+	if strings.HasPrefix(q, "INSERT") {
+		// Answer writes like an Aurora instance that was demoted to a reader.
+		return nil, mysql.NewError(mysql.ER_OPTION_PREVENTS_STATEMENT, "The MySQL server is running with the --read-only option so it cannot execute this statement")
+	}
 	switch q {
+	case "SELECT @PROXY_REQUEST_ID":
+		rs, err := mysql.BuildSimpleTextResultset([]string{"@proxy_request_id"}, [][]interface{}{{h.requestID}})
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewResult(rs), nil
+	case "SELECT @PROXY_CLIENT":
+		rs, err := mysql.BuildSimpleTextResultset([]string{"@proxy_client"}, [][]interface{}{{h.label}})
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewResult(rs), nil
+	case "SELECT DATABASE()":
+		rs, err := mysql.BuildSimpleTextResultset([]string{"DATABASE()"}, [][]interface{}{{h.db}})
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewResult(rs), nil
+	case "SELECT @@HOSTNAME":
+		rs, err := mysql.BuildSimpleTextResultset([]string{"@@hostname"}, [][]interface{}{{h.name}})
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewResult(rs), nil
 	case "SELECT 1", "SELECT 1;":
 		rs, err := mysql.BuildSimpleTextResultset([]string{"1"}, [][]interface{}{{1}})
 		if err != nil {
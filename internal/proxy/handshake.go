@@ -1,14 +1,420 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"slices"
+	"sync"
+	"time"
 
 	"rds-iam-proxy/internal/config"
 
+	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/server"
 )
 
-func authenticateClient(conn net.Conn, p config.Profile) (*server.Conn, error) {
-	// NewConn performs MySQL server greeting + auth validation.
-	return server.NewConn(conn, p.ProxyUser, p.ProxyPassword, server.EmptyHandler{})
+// defaultClientServer mirrors go-mysql's package-level default server
+// (auto-generated TLS cert, mysql_native_password) shared by all listeners.
+var defaultClientServer = sync.OnceValue(server.NewDefaultServer)
+
+// handshakeHandler records the schema requested in the client's handshake
+// response. Command-phase traffic never reaches it: after auth the proxy
+// forwards raw packets.
+type handshakeHandler struct {
+	server.EmptyHandler
+	db string
+}
+
+func (h *handshakeHandler) UseDB(db string) error {
+	h.db = db
+	return nil
+}
+
+// authenticateClient returns the authenticated connection and the database the
+// client asked for, if any. With allowCompression the greeting also offers
+// zlib compression; a client that accepts it gets a server.Conn that writes
+// compressed packets, reported by clientCompressed. A non-empty allowedPlugins
+// refuses clients whose handshake response asks for another auth plugin.
+// Cancelling ctx closes conn to abort a handshake in progress.
+func authenticateClient(ctx context.Context, conn net.Conn, srv *server.Server, creds server.CredentialProvider, allowCompression bool, allowedPlugins []string) (*server.Conn, string, error) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	serverConn, db, err := handshake(conn, srv, creds, allowCompression, allowedPlugins)
+	if !stop() && err == nil {
+		// Cancelled as auth finished; conn is already closed.
+		err = fmt.Errorf("client handshake aborted: %w", context.Cause(ctx))
+	}
+	return serverConn, db, err
+}
+
+func handshake(conn net.Conn, srv *server.Server, creds server.CredentialProvider, allowCompression bool, allowedPlugins []string) (*server.Conn, string, error) {
+	var gate *pluginGateConn
+	if len(allowedPlugins) > 0 {
+		// Innermost, so compressConn below still sits directly under go-mysql.
+		gate = &pluginGateConn{Conn: conn, allowed: allowedPlugins}
+		conn = gate
+	}
+	var cc *compressConn
+	if allowCompression {
+		// go-mysql has no server capability setter, so patch the greeting.
+		cc = &compressConn{Conn: conn}
+		conn = cc
+	}
+	// NewCustomizedConn performs MySQL server greeting + auth validation.
+	h := &handshakeHandler{}
+	serverConn, err := srv.NewCustomizedConn(conn, creds, h)
+	if err != nil && gate != nil && gate.refused != nil {
+		return nil, "", gate.refused
+	}
+	if err == nil && clientCompressed(serverConn) {
+		// Compression starts after the auth OK, which is already sent.
+		serverConn.Conn.Compression = mysql.MYSQL_COMPRESS_ZLIB
+		// Under TLS the wrapper only sees ciphertext and must stay out of the way.
+		cc.resequence = serverConn.Conn.Conn == net.Conn(cc)
+	}
+	return serverConn, h.db, err
+}
+
+// clientCompressed reports whether the client negotiated zlib compression.
+func clientCompressed(c *server.Conn) bool {
+	return c.HasCapability(mysql.CLIENT_COMPRESS)
+}
+
+// stopResequence hands a compressed client connection over to raw forwarding,
+// where the backend numbers the packets.
+func stopResequence(c *server.Conn) {
+	if cc, ok := c.Conn.Conn.(*compressConn); ok {
+		cc.resequence = false
+	}
+}
+
+// compressConn sets CLIENT_COMPRESS in the server greeting, the first packet
+// written on a client connection. go-mysql restarts the compressed sequence
+// at 0 on every write, so while resequence is set each written compressed
+// packet is renumbered to follow the last one read, as clients expect.
+type compressConn struct {
+	net.Conn
+	greeted    bool
+	resequence bool
+
+	hdr       [7]byte
+	hdrLen    int
+	remaining int
+	next      uint8
+}
+
+func (c *compressConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.resequence {
+		c.observe(b[:n])
+	}
+	return n, err
+}
+
+// observe tracks compressed packet headers in the inbound stream.
+func (c *compressConn) observe(p []byte) {
+	for len(p) > 0 {
+		if c.remaining > 0 {
+			k := min(c.remaining, len(p))
+			c.remaining -= k
+			p = p[k:]
+			continue
+		}
+		k := copy(c.hdr[c.hdrLen:], p)
+		c.hdrLen += k
+		p = p[k:]
+		if c.hdrLen == len(c.hdr) {
+			c.remaining = int(c.hdr[0]) | int(c.hdr[1])<<8 | int(c.hdr[2])<<16
+			c.next = c.hdr[3] + 1
+			c.hdrLen = 0
+		}
+	}
+}
+
+func (c *compressConn) Write(b []byte) (int, error) {
+	if !c.greeted {
+		c.greeted = true
+		if i := greetingCapabilityOffset(b); i > 0 {
+			patched := append([]byte(nil), b...)
+			patched[i] |= byte(mysql.CLIENT_COMPRESS)
+			return c.Conn.Write(patched)
+		}
+	}
+	if c.resequence && len(b) >= len(c.hdr) {
+		// go-mysql writes one compressed packet per Write.
+		b[3] = c.next
+		c.next++
+	}
+	return c.Conn.Write(b)
+}
+
+// greetingCapabilityOffset returns the index of the low capability byte in a
+// protocol 10 greeting packet, or -1 if b does not look like one.
+func greetingCapabilityOffset(b []byte) int {
+	const header = 4
+	if len(b) <= header || b[3] != 0 || b[header] != 10 {
+		return -1
+	}
+	end := header + 1
+	for end < len(b) && b[end] != 0 {
+		end++
+	}
+	// server version NUL, connection id (4), auth data part 1 (8), filler (1)
+	i := end + 1 + 4 + 8 + 1
+	if i+1 >= len(b) {
+		return -1
+	}
+	return i
+}
+
+// newClientServer returns the client-facing MySQL server settings for p. With
+// no server_version, allowed_client_auth_plugins or client_tls_autocert it
+// keeps the library defaults; otherwise the handshake advertises p's version
+// (default 8.0.11) and authenticates clients with the first allowed plugin
+// (default mysql_native_password), with client TLS support like the default
+// unless pluginGateConn withdraws it. The returned TLS config is nil for the
+// library defaults.
+func newClientServer(p config.Profile) (*server.Server, *tls.Config, error) {
+	version, authMethod := p.ServerVersion, mysql.AUTH_NATIVE_PASSWORD
+	if len(p.AllowedClientAuthPlugins) > 0 {
+		authMethod = p.AllowedClientAuthPlugins[0]
+	}
+	if version == "" && authMethod == mysql.AUTH_NATIVE_PASSWORD && !p.ClientTLSAutocert {
+		return defaultClientServer(), nil, nil
+	}
+	if version == "" {
+		version = defaultClientServerVersion
+	}
+	var hosts []string
+	if p.ClientTLSAutocert {
+		hosts = autocertHosts
+	}
+	tlsCfg, pubKey, err := newSelfSignedTLS(hosts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return server.NewServer(version, mysql.DEFAULT_COLLATION_ID, authMethod, pubKey, tlsCfg), tlsCfg, nil
+}
+
+// autocertHosts are the names a client_tls_autocert certificate is valid for.
+var autocertHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// defaultClientServerVersion is the version go-mysql's default server
+// advertises.
+const defaultClientServerVersion = "8.0.11"
+
+// maxHandshakeResponse bounds the client handshake response pluginGateConn
+// buffers; MySQL itself caps connection attributes at 64KiB.
+const maxHandshakeResponse = 128 << 10
+
+// pluginGateConn reads the client's handshake response ahead of go-mysql and
+// refuses it with ER_NOT_SUPPORTED_AUTH_MODE when it asks for an auth plugin
+// outside allowed. go-mysql switches every client to the server's own auth
+// method, so this only turns away clients asking for another plugin (e.g. a
+// cleartext fallback) up front. A response sent inside TLS could not be
+// read here, so the gate clears CLIENT_SSL from the greeting and refuses
+// clients that send an SSLRequest anyway.
+type pluginGateConn struct {
+	net.Conn
+	allowed []string
+	greeted bool
+	checked bool
+	pending []byte
+	refused error // why the client was turned away, once it was
+}
+
+func (c *pluginGateConn) Read(b []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		if err := c.check(); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// Write clears CLIENT_SSL in the greeting and drops go-mysql's own error
+// reply after check has answered.
+func (c *pluginGateConn) Write(b []byte) (int, error) {
+	if c.refused != nil {
+		return len(b), nil
+	}
+	if !c.greeted {
+		c.greeted = true
+		if i := greetingCapabilityOffset(b); i > 0 {
+			patched := append([]byte(nil), b...)
+			patched[i+1] &^= byte(mysql.CLIENT_SSL >> 8)
+			if _, err := c.Conn.Write(patched); err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+// check reads the first client packet and keeps it for go-mysql, unless it
+// asks for a plugin outside c.allowed.
+func (c *pluginGateConn) check() error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+		return err
+	}
+	size := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	if size > maxHandshakeResponse {
+		return fmt.Errorf("client handshake response of %d bytes exceeds %d", size, maxHandshakeResponse)
+	}
+	packet := make([]byte, 4+size)
+	copy(packet, hdr[:])
+	if _, err := io.ReadFull(c.Conn, packet[4:]); err != nil {
+		return err
+	}
+	c.pending = packet
+	var msg string
+	if plugin, ok := clientAuthPlugin(packet[4:]); ok {
+		if slices.Contains(c.allowed, plugin) {
+			return nil
+		}
+		msg = fmt.Sprintf("Authentication plugin '%s' is not allowed by this proxy", plugin)
+		c.refused = fmt.Errorf("client auth plugin %q is not in allowed_client_auth_plugins", plugin)
+	} else if isSSLRequest(packet[4:]) {
+		msg = "SSL connection is not supported by this proxy"
+		c.refused = errors.New("client requested TLS, which is not offered while allowed_client_auth_plugins is set")
+	} else {
+		return nil
+	}
+	if err := writeRawErrPacket(c.Conn, hdr[3]+1, mysql.ER_NOT_SUPPORTED_AUTH_MODE, msg); err != nil {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// isSSLRequest reports whether a client's first packet payload is an
+// SSLRequest, after which it expects a TLS handshake.
+func isSSLRequest(payload []byte) bool {
+	return len(payload) == 4+4+1+23 && binary.LittleEndian.Uint32(payload)&mysql.CLIENT_SSL != 0
+}
+
+// clientAuthPlugin returns the auth plugin named in a HandshakeResponse41
+// payload, defaulting to mysql_native_password like go-mysql. It reports
+// false for an SSLRequest, whose real response follows inside TLS, and for
+// payloads too short to parse, which go-mysql rejects itself.
+func clientAuthPlugin(payload []byte) (string, bool) {
+	const fixed = 4 + 4 + 1 + 23 // capabilities, max packet, charset, filler
+	if len(payload) <= fixed {
+		return "", false
+	}
+	capability := binary.LittleEndian.Uint32(payload)
+	if capability&mysql.CLIENT_PLUGIN_AUTH == 0 {
+		return mysql.AUTH_NATIVE_PASSWORD, true
+	}
+	rest := payload[fixed:]
+	skipString := func() bool {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+1:]
+		return true
+	}
+	if !skipString() { // user
+		return "", false
+	}
+	switch {
+	case capability&mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA != 0:
+		// LengthEncodedInt does not bounds-check the integer itself.
+		size := 1
+		if len(rest) > 0 {
+			switch rest[0] {
+			case 0xfc:
+				size = 3
+			case 0xfd:
+				size = 4
+			case 0xfe:
+				size = 9
+			}
+		}
+		if len(rest) < size {
+			return "", false
+		}
+		n, _, _ := mysql.LengthEncodedInt(rest)
+		if uint64(len(rest)-size) < n {
+			return "", false
+		}
+		rest = rest[size+int(n):]
+	case capability&mysql.CLIENT_SECURE_CONNECTION != 0:
+		if len(rest) == 0 || len(rest)-1 < int(rest[0]) {
+			return "", false
+		}
+		rest = rest[1+int(rest[0]):]
+	default:
+		if !skipString() {
+			return "", false
+		}
+	}
+	if capability&mysql.CLIENT_CONNECT_WITH_DB != 0 && !skipString() {
+		return "", false
+	}
+	if i := bytes.IndexByte(rest, 0); i >= 0 {
+		rest = rest[:i]
+	}
+	return string(rest), true
+}
+
+// newSelfSignedTLS generates an in-memory RSA certificate for client-facing
+// TLS, valid for hosts (DNS names or IPs), returning the TLS config and the
+// PEM public key used by sha256 auth.
+func newSelfSignedTLS(hosts ...string) (*tls.Config, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate client tls key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate client tls serial: %w", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "rds-iam-proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create client tls cert: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal client tls public key: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return cfg, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), nil
 }
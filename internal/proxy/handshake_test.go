@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// handshakeResponse builds a HandshakeResponse41 payload naming plugin.
+func handshakeResponse(capability uint32, user, db, plugin string) []byte {
+	payload := binary.LittleEndian.AppendUint32(nil, capability)
+	payload = binary.LittleEndian.AppendUint32(payload, 1<<24)
+	payload = append(payload, mysql.DEFAULT_COLLATION_ID)
+	payload = append(payload, make([]byte, 23)...)
+	payload = append(payload, user...)
+	payload = append(payload, 0, 0) // NUL, then empty auth data
+	if db != "" {
+		payload = append(append(payload, db...), 0)
+	}
+	return append(append(payload, plugin...), 0)
+}
+
+func TestClientAuthPlugin(t *testing.T) {
+	t.Parallel()
+
+	base := uint32(mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION | mysql.CLIENT_PLUGIN_AUTH)
+	cases := []struct {
+		name    string
+		payload []byte
+		want    string
+		ok      bool
+	}{
+		{"plugin", handshakeResponse(base, "u", "", "mysql_clear_password"), "mysql_clear_password", true},
+		{"lenenc auth data", handshakeResponse(base|mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA, "u", "", "caching_sha2_password"), "caching_sha2_password", true},
+		{"with db", handshakeResponse(base|mysql.CLIENT_CONNECT_WITH_DB, "u", "app", "mysql_old_password"), "mysql_old_password", true},
+		{"no plugin auth", handshakeResponse(base&^mysql.CLIENT_PLUGIN_AUTH, "u", "", ""), mysql.AUTH_NATIVE_PASSWORD, true},
+		{"ssl request", handshakeResponse(base, "", "", "")[:32], "", false},
+		{"truncated", handshakeResponse(base, "u", "", "x")[:33], "", false},
+	}
+	for _, tc := range cases {
+		got, ok := clientAuthPlugin(tc.payload)
+		if got != tc.want || ok != tc.ok {
+			t.Fatalf("%s: got (%q, %v), want (%q, %v)", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHandshakeRefusesDisallowedAuthPlugin(t *testing.T) {
+	t.Parallel()
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	_ = peer.SetDeadline(time.Now().Add(5 * time.Second))
+
+	creds := server.NewInMemoryProvider()
+	creds.AddUser("local_proxy", "pw")
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := handshake(conn, defaultClientServer(), creds, false, []string{mysql.AUTH_NATIVE_PASSWORD})
+		errCh <- err
+	}()
+
+	if seq, _ := readPacket(t, peer); seq != 0 {
+		t.Fatalf("expected greeting, got seq %d", seq)
+	}
+	capability := uint32(mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION | mysql.CLIENT_PLUGIN_AUTH)
+	resp := handshakeResponse(capability, "local_proxy", "", "mysql_clear_password")
+	if _, err := peer.Write(append([]byte{byte(len(resp)), byte(len(resp) >> 8), 0, 1}, resp...)); err != nil {
+		t.Fatalf("write handshake response: %v", err)
+	}
+	seq, payload := readPacket(t, peer)
+	if seq != 2 || payload[0] != mysql.ERR_HEADER || binary.LittleEndian.Uint16(payload[1:]) != mysql.ER_NOT_SUPPORTED_AUTH_MODE {
+		t.Fatalf("expected ER_NOT_SUPPORTED_AUTH_MODE at seq 2, got seq %d payload %q", seq, payload)
+	}
+	if !strings.Contains(string(payload), "mysql_clear_password") {
+		t.Fatalf("expected the refused plugin in the error, got %q", payload)
+	}
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), "allowed_client_auth_plugins") {
+		t.Fatalf("expected allowed_client_auth_plugins error, got: %v", err)
+	}
+}
+
+func TestHandshakeGatesTLSClients(t *testing.T) {
+	t.Parallel()
+
+	srv, _, err := newClientServer(config.Profile{AllowedClientAuthPlugins: []string{mysql.AUTH_NATIVE_PASSWORD}})
+	if err != nil {
+		t.Fatalf("newClientServer: %v", err)
+	}
+	creds := server.NewInMemoryProvider()
+	creds.AddUser("local_proxy", "pw")
+	capability := uint32(mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION | mysql.CLIENT_PLUGIN_AUTH | mysql.CLIENT_SSL)
+
+	// A client that wants TLS and mysql_clear_password: either it falls back
+	// to plaintext because TLS is not offered, or it sends an SSLRequest
+	// regardless. Both are refused before go-mysql sees the response.
+	for _, tc := range []struct {
+		name string
+		resp []byte
+		want string
+	}{
+		{"plaintext fallback", handshakeResponse(capability&^mysql.CLIENT_SSL, "local_proxy", "", "mysql_clear_password"), "mysql_clear_password"},
+		{"ssl request", handshakeResponse(capability, "", "", "")[:32], "TLS"},
+	} {
+		conn, peer := net.Pipe()
+		_ = peer.SetDeadline(time.Now().Add(5 * time.Second))
+		errCh := make(chan error, 1)
+		go func() {
+			_, _, err := handshake(conn, srv, creds, false, []string{mysql.AUTH_NATIVE_PASSWORD})
+			errCh <- err
+		}()
+
+		_, payload := readPacket(t, peer)
+		greeting := append([]byte{0, 0, 0, 0}, payload...)
+		if i := greetingCapabilityOffset(greeting); i < 0 || greeting[i+1]&byte(mysql.CLIENT_SSL>>8) != 0 {
+			t.Fatalf("%s: expected the greeting not to offer TLS", tc.name)
+		}
+		if _, err := peer.Write(append([]byte{byte(len(tc.resp)), 0, 0, 1}, tc.resp...)); err != nil {
+			t.Fatalf("%s: write response: %v", tc.name, err)
+		}
+		seq, payload := readPacket(t, peer)
+		if seq != 2 || payload[0] != mysql.ERR_HEADER || binary.LittleEndian.Uint16(payload[1:]) != mysql.ER_NOT_SUPPORTED_AUTH_MODE {
+			t.Fatalf("%s: expected ER_NOT_SUPPORTED_AUTH_MODE at seq 2, got seq %d payload %q", tc.name, seq, payload)
+		}
+		if err := <-errCh; err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), "allowed_client_auth_plugins") {
+			t.Fatalf("%s: expected a refusal naming %s, got: %v", tc.name, tc.want, err)
+		}
+		_ = peer.Close()
+	}
+}
+
+func TestHandshakeAuthenticatesWithFirstAllowedPlugin(t *testing.T) {
+	t.Parallel()
+
+	srv, _, err := newClientServer(config.Profile{AllowedClientAuthPlugins: []string{mysql.AUTH_CACHING_SHA2_PASSWORD}})
+	if err != nil {
+		t.Fatalf("newClientServer: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	creds := server.NewInMemoryProvider()
+	creds.AddUser("local_proxy", "pw")
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		_, _, err = handshake(conn, srv, creds, false, []string{mysql.AUTH_CACHING_SHA2_PASSWORD})
+		errCh <- err
+	}()
+
+	c, err := client.Connect(ln.Addr().String(), "local_proxy", "pw", "")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+	if err := <-errCh; err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+}
+
+func TestNewClientServerAutocertCoversLocalhost(t *testing.T) {
+	t.Parallel()
+
+	if _, tlsCfg, err := newClientServer(config.Profile{}); err != nil || tlsCfg != nil {
+		t.Fatalf("expected the library default server, got tls %v err %v", tlsCfg, err)
+	}
+	_, tlsCfg, err := newClientServer(config.Profile{ClientTLSAutocert: true})
+	if err != nil {
+		t.Fatalf("newClientServer: %v", err)
+	}
+	cert, err := x509.ParseCertificate(tlsCfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("parse cert: %v", err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Fatalf("cert not valid for %s: %v", host, err)
+		}
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pool}); err != nil {
+		t.Fatalf("cert does not verify against itself: %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// maxPayloadLen is the largest payload of a single MySQL packet; commands
+// at least this long continue in follow-up packets.
+const maxPayloadLen = 1<<24 - 1
+
+// commandInspector forwards client->backend traffic packet by packet so single
+// commands can be answered by the proxy or rewritten instead of reaching the
+// backend unchanged. It is
+// only built when a profile enables an inspection feature; otherwise pipe keeps
+// raw io.Copy forwarding.
+type commandInspector struct {
+	deny           []string
+	changeUserMode string
+	proxyUser      string
+	maxPacket      int64  // max_packet_bytes; 0 is unlimited
+	versionReply   []byte // packets answering SELECT @@version_comment; nil forwards it
+	reportMaxConns bool   // report_max_connections
+	maxConnsReply  []byte // packets answering SHOW VARIABLES LIKE 'max_connections'; nil forwards it
+}
+
+// errPacketTooLarge ends a session whose oversized packet had already partly
+// reached the backend, so it cannot be dropped cleanly.
+var errPacketTooLarge = errors.New("client packet exceeds max_packet_bytes")
+
+// verdict is the inspector's decision for one command. The zero value
+// forwards the command unchanged.
+type verdict struct {
+	code    uint16 // non-zero answers the client with this error instead of forwarding
+	reason  string
+	rewrite []byte // non-nil replaces the command payload sent to the backend
+	reply   []byte // non-nil answers the client with these packets instead of forwarding
+}
+
+func newCommandInspector(p config.Profile) *commandInspector {
+	if len(p.DenyStatements) == 0 && p.ChangeUserMode == "" && p.MaxPacketBytes <= 0 && p.VersionComment == "" && !p.ReportMaxConnections {
+		return nil
+	}
+	ci := &commandInspector{changeUserMode: p.ChangeUserMode, proxyUser: p.ProxyUser, maxPacket: int64(p.MaxPacketBytes), reportMaxConns: p.ReportMaxConnections}
+	if p.VersionComment != "" {
+		ci.versionReply = versionCommentReply(p.VersionComment)
+	}
+	for _, stmt := range p.DenyStatements {
+		ci.deny = append(ci.deny, strings.Join(strings.Fields(strings.ToUpper(stmt)), " "))
+	}
+	return ci
+}
+
+// forward copies client packets to backend until the client side fails.
+// Only the first packet of each command (sequence 0) is inspected; continuation
+// packets of large commands and in-command packets (e.g. LOAD DATA payloads)
+// pass through untouched. With max_packet_bytes, a command over the limit is
+// discarded and answered with ER_NET_PACKET_TOO_LARGE; a logical packet that
+// only goes over the limit after part of it was forwarded ends the session
+// with that error, as mysqld does.
+func (ci *commandInspector) forward(backend io.Writer, client io.ReadWriter) (int64, error) {
+	var (
+		total   int64
+		header  [4]byte
+		pending verdict // rejection while discarding a multi-packet command
+		cont    bool    // the next packet continues a max-length packet
+		logical int64   // payload bytes of the current logical packet so far
+	)
+	for {
+		if _, err := io.ReadFull(client, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return total, nil
+			}
+			return total, err
+		}
+		length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		seq := header[3]
+		if !cont {
+			logical = 0
+		}
+		cont = length == maxPayloadLen
+		logical += int64(length)
+
+		if ci.maxPacket > 0 && logical > ci.maxPacket && pending.code == 0 {
+			v := ci.tooLarge()
+			if seq != 0 {
+				_ = writeRawErrPacket(client, seq+1, v.code, v.reason)
+				return total, errPacketTooLarge
+			}
+			if _, err := io.CopyN(io.Discard, client, int64(length)); err != nil {
+				return total, err
+			}
+			if length == maxPayloadLen {
+				pending = v
+				continue
+			}
+			if err := writeRawErrPacket(client, 1, v.code, v.reason); err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		if seq != 0 {
+			if pending.code == 0 {
+				if _, err := backend.Write(header[:]); err != nil {
+					return total, err
+				}
+				n, err := io.CopyN(backend, client, int64(length))
+				total += 4 + n
+				if err != nil {
+					return total, err
+				}
+				continue
+			}
+			if _, err := io.CopyN(io.Discard, client, int64(length)); err != nil {
+				return total, err
+			}
+			if length < maxPayloadLen {
+				if err := writeRawErrPacket(client, seq+1, pending.code, pending.reason); err != nil {
+					return total, err
+				}
+				pending = verdict{}
+			}
+			continue
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(client, payload); err != nil {
+			return total, err
+		}
+		v := ci.inspect(payload)
+		if v.reply != nil {
+			if _, err := client.Write(v.reply); err != nil {
+				return total, err
+			}
+			continue
+		}
+		if v.code != 0 {
+			if length == maxPayloadLen {
+				pending = v
+				continue
+			}
+			if err := writeRawErrPacket(client, 1, v.code, v.reason); err != nil {
+				return total, err
+			}
+			continue
+		}
+		if v.rewrite != nil {
+			payload = v.rewrite
+			header[0], header[1], header[2] = byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16)
+		}
+		if _, err := backend.Write(append(header[:], payload...)); err != nil {
+			return total, err
+		}
+		total += int64(4 + len(payload))
+	}
+}
+
+func (ci *commandInspector) tooLarge() verdict {
+	return verdict{code: mysql.ER_NET_PACKET_TOO_LARGE, reason: fmt.Sprintf("Got a packet bigger than max_packet_bytes (%d) allowed by rds-iam-proxy", ci.maxPacket)}
+}
+
+// inspect decides how the command in payload is handled.
+func (ci *commandInspector) inspect(payload []byte) verdict {
+	if len(payload) == 0 {
+		return verdict{}
+	}
+	switch payload[0] {
+	// Prepared statements carry their SQL text only in COM_STMT_PREPARE; a
+	// denied one never gets a statement id, so binary COM_STMT_EXECUTE
+	// packets can pass through unparsed.
+	case mysql.COM_QUERY, mysql.COM_STMT_PREPARE:
+		if payload[0] == mysql.COM_QUERY && ci.versionReply != nil && isVersionCommentQuery(string(payload[1:])) {
+			return verdict{reply: ci.versionReply}
+		}
+		if payload[0] == mysql.COM_QUERY && ci.maxConnsReply != nil && isMaxConnectionsQuery(string(payload[1:])) {
+			return verdict{reply: ci.maxConnsReply}
+		}
+		if stmt := ci.denied(string(payload[1:])); stmt != "" {
+			return verdict{code: mysql.ER_OPTION_PREVENTS_STATEMENT, reason: fmt.Sprintf("%s statements are denied by rds-iam-proxy", stmt)}
+		}
+	case mysql.COM_SET_OPTION:
+		// denied only sees the first statement of a query, so the backend
+		// session has to stay single-statement while deny_statements is
+		// set; see the capability BackendFactory clears.
+		if len(ci.deny) > 0 && len(payload) >= 3 && binary.LittleEndian.Uint16(payload[1:]) == mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON {
+			return verdict{code: mysql.ER_OPTION_PREVENTS_STATEMENT, reason: "multi-statements are disabled by rds-iam-proxy while deny_statements is set"}
+		}
+	case mysql.COM_CHANGE_USER:
+		return ci.changeUser(payload)
+	case mysql.COM_QUIT:
+		// Backend connections are single-use, so the quit goes through and
+		// the backend closes its end. Returning the backend to the pool
+		// instead would mean swallowing it here and resetting the session.
+		return verdict{}
+	}
+	return verdict{}
+}
+
+// changeUser applies change_user_mode. The backend session is authenticated
+// with an IAM token for rds_db_user, so client credentials cannot be replayed
+// against it. In reset mode a change back to the same proxy_user (what
+// framework pools do to clear session state) becomes COM_RESET_CONNECTION;
+// the backend's OK then answers the client's COM_CHANGE_USER directly.
+func (ci *commandInspector) changeUser(payload []byte) verdict {
+	switch ci.changeUserMode {
+	case config.ChangeUserReject:
+		return verdict{code: mysql.ER_NOT_SUPPORTED_YET, reason: "COM_CHANGE_USER is not supported by rds-iam-proxy; reconnect instead"}
+	case config.ChangeUserReset:
+		user, db, ok := parseChangeUser(payload)
+		if !ok {
+			return verdict{code: mysql.ER_MALFORMED_PACKET, reason: "malformed COM_CHANGE_USER packet"}
+		}
+		if user != ci.proxyUser {
+			return verdict{code: mysql.ER_ACCESS_DENIED_ERROR, reason: fmt.Sprintf("rds-iam-proxy cannot change user to %q on this connection", user)}
+		}
+		if db != "" {
+			return verdict{code: mysql.ER_NOT_SUPPORTED_YET, reason: "COM_CHANGE_USER with a schema is not supported by rds-iam-proxy; issue USE after the reset"}
+		}
+		return verdict{rewrite: []byte{mysql.COM_RESET_CONNECTION}}
+	}
+	return verdict{}
+}
+
+// isVersionCommentQuery matches the banner query clients such as the mysql CLI
+// send right after connecting: SELECT @@version_comment, optionally LIMIT 1.
+func isVersionCommentQuery(query string) bool {
+	q := strings.Join(strings.Fields(strings.ToUpper(strings.TrimRight(query, "; \t\r\n"))), " ")
+	return q == "SELECT @@VERSION_COMMENT" || q == "SELECT @@VERSION_COMMENT LIMIT 1"
+}
+
+// setMaxConns makes the inspector answer SHOW VARIABLES LIKE 'max_connections'
+// with maxConns when report_max_connections is set. An unlimited listener
+// has no cap of its own, so the backend's value is forwarded instead. It
+// must be called before any session uses the inspector.
+func (ci *commandInspector) setMaxConns(maxConns int) {
+	if !ci.reportMaxConns || maxConns < 1 {
+		return
+	}
+	ci.maxConnsReply = textResultReply([]string{"Variable_name", "Value"}, []string{"max_connections", strconv.Itoa(maxConns)})
+}
+
+// isMaxConnectionsQuery matches the query connection pools use to size
+// themselves: SHOW [GLOBAL|SESSION] VARIABLES LIKE 'max_connections'.
+func isMaxConnectionsQuery(query string) bool {
+	q := strings.Join(strings.Fields(strings.ToUpper(strings.TrimRight(query, "; \t\r\n"))), " ")
+	for _, scope := range []string{"", "GLOBAL ", "SESSION "} {
+		for _, quote := range []string{"'", `"`} {
+			if q == "SHOW "+scope+"VARIABLES LIKE "+quote+"MAX_CONNECTIONS"+quote {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// versionCommentReply encodes a one-row text result set for
+// SELECT @@version_comment.
+func versionCommentReply(comment string) []byte {
+	return textResultReply([]string{"@@version_comment"}, []string{comment})
+}
+
+// textResultReply encodes a one-row text result set of string columns,
+// framed with EOF packets since neither side of the proxy negotiates
+// CLIENT_DEPRECATE_EOF.
+func textResultReply(columns, row []string) []byte {
+	eof := []byte{mysql.EOF_HEADER, 0, 0, byte(mysql.SERVER_STATUS_AUTOCOMMIT), 0}
+	payloads := [][]byte{mysql.PutLengthEncodedInt(uint64(len(columns)))}
+	for i, name := range columns {
+		field := &mysql.Field{
+			Name:    []byte(name),
+			Charset: uint16(mysql.DEFAULT_COLLATION_ID),
+			Type:    mysql.MYSQL_TYPE_VAR_STRING,
+			// Column length in bytes; utf8mb4 is up to 4 per character.
+			ColumnLength: uint32(4 * len(row[i])),
+		}
+		payloads = append(payloads, field.Dump())
+	}
+	payloads = append(payloads, eof)
+	var values []byte
+	for _, v := range row {
+		values = append(values, mysql.PutLengthEncodedString([]byte(v))...)
+	}
+	payloads = append(payloads, values, eof)
+	var out []byte
+	for i, payload := range payloads {
+		out = append(out, byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16), byte(i+1))
+		out = append(out, payload...)
+	}
+	return out
+}
+
+// parseChangeUser extracts the user and schema from a COM_CHANGE_USER payload,
+// assuming CLIENT_SECURE_CONNECTION (length-prefixed auth response), which
+// every protocol 4.1 client sends.
+func parseChangeUser(payload []byte) (user, db string, ok bool) {
+	rest := payload[1:]
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return "", "", false
+	}
+	user, rest = string(rest[:end]), rest[end+1:]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return "", "", false
+	}
+	rest = rest[1+int(rest[0]):]
+	if end = bytes.IndexByte(rest, 0); end >= 0 {
+		db = string(rest[:end])
+	}
+	return user, db, true
+}
+
+// denied returns the deny_statements entry matching the leading keywords of
+// query. Only the first statement is checked, which holds because backend
+// sessions never run multi-statements while deny_statements is set.
+func (ci *commandInspector) denied(query string) string {
+	if len(ci.deny) == 0 {
+		return ""
+	}
+	lead := " " + leadingKeywords(query, 3) + " "
+	for _, stmt := range ci.deny {
+		if strings.HasPrefix(lead, " "+stmt+" ") {
+			return stmt
+		}
+	}
+	return ""
+}
+
+// leadingKeywords returns up to n upper-cased leading words of query, skipping
+// whitespace, comments and opening parentheses. The body of MySQL executable
+// comments (/*!40101 ... */) is treated as SQL since the server runs it.
+func leadingKeywords(query string, n int) string {
+	words := make([]string, 0, n)
+	i := 0
+	for i < len(query) && len(words) < n {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(':
+			i++
+		case strings.HasPrefix(query[i:], "/*!"):
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "*/"):
+			i += 2
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return strings.Join(words, " ")
+			}
+			i += end + 4
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return strings.Join(words, " ")
+			}
+			i += end + 1
+		case isKeywordByte(c):
+			start := i
+			for i < len(query) && isKeywordByte(query[i]) {
+				i++
+			}
+			words = append(words, strings.ToUpper(query[start:i]))
+		default:
+			// Anything else (quotes, operators) ends the statement verb.
+			return strings.Join(words, " ")
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func isKeywordByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// writeRawErrPacket writes a complete ERR packet with the given sequence id
+// directly to w, for replies generated outside go-mysql's server.Conn.
+func writeRawErrPacket(w io.Writer, seq uint8, code uint16, msg string) error {
+	payload := make([]byte, 0, 9+len(msg))
+	payload = append(payload, mysql.ERR_HEADER, byte(code), byte(code>>8), '#')
+	payload = append(payload, "HY000"...)
+	payload = append(payload, msg...)
+	packet := make([]byte, 4, 4+len(payload))
+	packet[0] = byte(len(payload))
+	packet[1] = byte(len(payload) >> 8)
+	packet[2] = byte(len(payload) >> 16)
+	packet[3] = seq
+	_, err := w.Write(append(packet, payload...))
+	return err
+}
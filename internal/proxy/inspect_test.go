@@ -0,0 +1,373 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func commandPacket(seq uint8, cmd byte, body string) []byte {
+	payload := append([]byte{cmd}, body...)
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}, payload...)
+}
+
+func readPacket(t *testing.T, r io.Reader) (uint8, []byte) {
+	t.Helper()
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatalf("read packet header: %v", err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("read packet payload: %v", err)
+	}
+	return header[3], payload
+}
+
+func TestLeadingKeywordsSkipsCommentsAndParens(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"select 1":                          "SELECT",
+		"  /* hint */ DELETE FROM t":        "DELETE FROM T",
+		"-- note\nINSERT INTO t VALUES (1)": "INSERT INTO T",
+		"# note\n  update t set a=1":        "UPDATE T SET",
+		"(SELECT 1)":                        "SELECT",
+		"/*!40101 SET NAMES utf8 */":        "SET NAMES UTF",
+		"load data local infile 'x' into t": "LOAD DATA LOCAL",
+		"/* unterminated":                   "",
+		"`weird` identifier":                "",
+	}
+	for query, want := range cases {
+		if got := leadingKeywords(query, 3); got != want {
+			t.Fatalf("leadingKeywords(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestInspectorDeniesConfiguredStatements(t *testing.T) {
+	t.Parallel()
+
+	ci := newCommandInspector(config.Profile{DenyStatements: []string{"insert", "delete", "load  data"}})
+	if ci == nil {
+		t.Fatal("expected inspector when deny_statements is set")
+	}
+	for _, q := range []string{"INSERT INTO t VALUES (1)", "/* x */ delete from t", "LOAD DATA INFILE 'f' INTO TABLE t"} {
+		if ci.denied(q) == "" {
+			t.Fatalf("expected %q to be denied", q)
+		}
+	}
+	for _, q := range []string{"SELECT * FROM inserts", "SHOW TABLES", "LOAD XML INFILE 'f'"} {
+		if stmt := ci.denied(q); stmt != "" {
+			t.Fatalf("expected %q to be allowed, matched %q", q, stmt)
+		}
+	}
+	if newCommandInspector(config.Profile{}) != nil {
+		t.Fatal("expected nil inspector without inspection features")
+	}
+}
+
+func TestInspectorAppliesDenyRulesToPreparedStatements(t *testing.T) {
+	t.Parallel()
+
+	ci := newCommandInspector(config.Profile{DenyStatements: []string{"DELETE"}})
+	v := ci.inspect(append([]byte{mysql.COM_STMT_PREPARE}, "DELETE FROM t WHERE id = ?"...))
+	if v.code != mysql.ER_OPTION_PREVENTS_STATEMENT {
+		t.Fatalf("expected COM_STMT_PREPARE of a denied statement to be rejected, got %+v", v)
+	}
+	if v := ci.inspect(append([]byte{mysql.COM_STMT_PREPARE}, "SELECT * FROM t WHERE id = ?"...)); v.code != 0 || v.rewrite != nil {
+		t.Fatalf("expected allowed prepare to be forwarded, got %+v", v)
+	}
+	// COM_STMT_EXECUTE: statement id, flags, iteration count, then binary params.
+	execute := []byte{mysql.COM_STMT_EXECUTE, 1, 0, 0, 0, 0, 1, 0, 0, 0}
+	execute = append(execute, "DELETE"...)
+	if v := ci.inspect(execute); v.code != 0 || v.rewrite != nil {
+		t.Fatalf("expected COM_STMT_EXECUTE to pass through untouched, got %+v", v)
+	}
+}
+
+func TestInspectorRefusesMultiStatementsUnderDeny(t *testing.T) {
+	t.Parallel()
+
+	setOption := func(option uint16) []byte {
+		return []byte{mysql.COM_SET_OPTION, byte(option), byte(option >> 8)}
+	}
+	ci := newCommandInspector(config.Profile{DenyStatements: []string{"DELETE"}})
+	// Turning multi-statements on would let "SELECT 1; DELETE FROM t" pass
+	// the leading-keyword check.
+	if v := ci.inspect(setOption(mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON)); v.code != mysql.ER_OPTION_PREVENTS_STATEMENT {
+		t.Fatalf("expected MULTI_STATEMENTS_ON to be refused, got %+v", v)
+	}
+	if v := ci.inspect(setOption(mysql.MYSQL_OPTION_MULTI_STATEMENTS_OFF)); v.code != 0 {
+		t.Fatalf("expected MULTI_STATEMENTS_OFF to be forwarded, got %+v", v)
+	}
+	other := newCommandInspector(config.Profile{ChangeUserMode: config.ChangeUserReject})
+	if v := other.inspect(setOption(mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON)); v.code != 0 {
+		t.Fatalf("expected MULTI_STATEMENTS_ON to be forwarded without deny_statements, got %+v", v)
+	}
+}
+
+func TestInspectorForwardRejectsDeniedAndForwardsAllowed(t *testing.T) {
+	t.Parallel()
+
+	ci := newCommandInspector(config.Profile{DenyStatements: []string{"DROP"}})
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ci.forward(backend, client)
+		done <- err
+	}()
+
+	if _, err := clientPeer.Write(commandPacket(0, mysql.COM_QUERY, "DROP TABLE t")); err != nil {
+		t.Fatalf("write denied query: %v", err)
+	}
+	seq, payload := readPacket(t, clientPeer)
+	if seq != 1 || payload[0] != mysql.ERR_HEADER {
+		t.Fatalf("expected ERR packet with seq 1, got seq=%d payload=%q", seq, payload)
+	}
+	if code := uint16(payload[1]) | uint16(payload[2])<<8; code != mysql.ER_OPTION_PREVENTS_STATEMENT {
+		t.Fatalf("unexpected error code %d", code)
+	}
+	if !bytes.Contains(payload, []byte("DROP statements are denied")) {
+		t.Fatalf("unexpected error message: %q", payload)
+	}
+
+	allowed := commandPacket(0, mysql.COM_QUERY, "SELECT 1")
+	go func() {
+		_, _ = clientPeer.Write(allowed)
+	}()
+	got := make([]byte, len(allowed))
+	_ = backendPeer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(backendPeer, got); err != nil {
+		t.Fatalf("read forwarded query: %v", err)
+	}
+	if !bytes.Equal(got, allowed) {
+		t.Fatalf("forwarded packet mismatch: got %q want %q", got, allowed)
+	}
+
+	_ = clientPeer.Close()
+	select {
+	case err := <-done:
+		if err != nil && !isConnCloseErr(err) {
+			t.Fatalf("forward returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("forward did not finish")
+	}
+}
+
+func changeUserPayload(user, db string) string {
+	auth := "0123456789abcdefghij"
+	return user + "\x00" + string(rune(len(auth))) + auth + db + "\x00" + "\x21\x00" + "mysql_native_password\x00"
+}
+
+func TestParseChangeUser(t *testing.T) {
+	t.Parallel()
+
+	payload := append([]byte{mysql.COM_CHANGE_USER}, changeUserPayload("local_proxy", "analytics")...)
+	user, db, ok := parseChangeUser(payload)
+	if !ok || user != "local_proxy" || db != "analytics" {
+		t.Fatalf("parseChangeUser = %q, %q, %v", user, db, ok)
+	}
+	if _, _, ok := parseChangeUser([]byte{mysql.COM_CHANGE_USER, 'u'}); ok {
+		t.Fatal("expected truncated packet to fail")
+	}
+}
+
+func TestInspectorChangeUserModes(t *testing.T) {
+	t.Parallel()
+
+	cmd := func(user, db string) []byte {
+		return append([]byte{mysql.COM_CHANGE_USER}, changeUserPayload(user, db)...)
+	}
+
+	reject := newCommandInspector(config.Profile{ProxyUser: "local_proxy", ChangeUserMode: config.ChangeUserReject})
+	if v := reject.inspect(cmd("local_proxy", "")); v.code != mysql.ER_NOT_SUPPORTED_YET {
+		t.Fatalf("expected reject verdict, got %+v", v)
+	}
+
+	reset := newCommandInspector(config.Profile{ProxyUser: "local_proxy", ChangeUserMode: config.ChangeUserReset})
+	if v := reset.inspect(cmd("local_proxy", "")); v.code != 0 || !bytes.Equal(v.rewrite, []byte{mysql.COM_RESET_CONNECTION}) {
+		t.Fatalf("expected reset rewrite, got %+v", v)
+	}
+	if v := reset.inspect(cmd("someone_else", "")); v.code != mysql.ER_ACCESS_DENIED_ERROR {
+		t.Fatalf("expected access denied for other user, got %+v", v)
+	}
+	if v := reset.inspect(cmd("local_proxy", "analytics")); v.code != mysql.ER_NOT_SUPPORTED_YET {
+		t.Fatalf("expected schema change to be rejected, got %+v", v)
+	}
+
+	passthrough := newCommandInspector(config.Profile{DenyStatements: []string{"DROP"}})
+	if v := passthrough.inspect(cmd("anyone", "")); v.code != 0 || v.rewrite != nil {
+		t.Fatalf("expected passthrough without change_user_mode, got %+v", v)
+	}
+}
+
+func TestInspectorForwardRewritesChangeUserToReset(t *testing.T) {
+	t.Parallel()
+
+	ci := newCommandInspector(config.Profile{ProxyUser: "local_proxy", ChangeUserMode: config.ChangeUserReset})
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+	go func() {
+		_, _ = ci.forward(backend, client)
+	}()
+
+	go func() {
+		_, _ = clientPeer.Write(commandPacket(0, mysql.COM_CHANGE_USER, changeUserPayload("local_proxy", "")))
+	}()
+	_ = backendPeer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	seq, payload := readPacket(t, backendPeer)
+	if seq != 0 || !bytes.Equal(payload, []byte{mysql.COM_RESET_CONNECTION}) {
+		t.Fatalf("expected COM_RESET_CONNECTION seq 0, got seq=%d payload=%q", seq, payload)
+	}
+}
+
+func TestInspectorForwardPassesQuitToBackend(t *testing.T) {
+	t.Parallel()
+
+	ci := newCommandInspector(config.Profile{DenyStatements: []string{"DROP"}, ChangeUserMode: config.ChangeUserReset})
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+	go func() {
+		_, _ = ci.forward(backend, client)
+	}()
+
+	go func() {
+		_, _ = clientPeer.Write(comQuitPacket[:])
+	}()
+	_ = backendPeer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	seq, payload := readPacket(t, backendPeer)
+	if seq != 0 || !bytes.Equal(payload, []byte{mysql.COM_QUIT}) {
+		t.Fatalf("expected COM_QUIT forwarded unchanged, got seq=%d payload=%q", seq, payload)
+	}
+}
+
+func TestInspectorForwardRejectsOversizedPackets(t *testing.T) {
+	t.Parallel()
+
+	ci := newCommandInspector(config.Profile{MaxPacketBytes: 1024})
+	if ci == nil {
+		t.Fatal("expected inspector when max_packet_bytes is set")
+	}
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ci.forward(backend, client)
+		done <- err
+	}()
+
+	// A whole oversized command is dropped and the session goes on.
+	if _, err := clientPeer.Write(commandPacket(0, mysql.COM_QUERY, "SELECT '"+strings.Repeat("x", 2000)+"'")); err != nil {
+		t.Fatalf("write oversized query: %v", err)
+	}
+	seq, payload := readPacket(t, clientPeer)
+	if seq != 1 || payload[0] != mysql.ERR_HEADER {
+		t.Fatalf("expected ERR packet with seq 1, got seq=%d payload=%q", seq, payload)
+	}
+	if code := uint16(payload[1]) | uint16(payload[2])<<8; code != mysql.ER_NET_PACKET_TOO_LARGE {
+		t.Fatalf("unexpected error code %d", code)
+	}
+
+	allowed := commandPacket(0, mysql.COM_QUERY, "SELECT 1")
+	go func() {
+		_, _ = clientPeer.Write(allowed)
+	}()
+	got := make([]byte, len(allowed))
+	_ = backendPeer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(backendPeer, got); err != nil {
+		t.Fatalf("read forwarded query: %v", err)
+	}
+	if !bytes.Equal(got, allowed) {
+		t.Fatalf("forwarded packet mismatch: got %q want %q", got, allowed)
+	}
+
+	// An oversized in-command packet (e.g. LOAD DATA content) cannot be
+	// dropped without desyncing the backend, so it ends the session.
+	go func() {
+		_, _ = clientPeer.Write(commandPacket(2, 'x', strings.Repeat("y", 2000)))
+	}()
+	seq, payload = readPacket(t, clientPeer)
+	if seq != 3 || payload[0] != mysql.ERR_HEADER {
+		t.Fatalf("expected ERR packet with seq 3, got seq=%d payload=%q", seq, payload)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, errPacketTooLarge) {
+			t.Fatalf("expected errPacketTooLarge, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("forward did not finish")
+	}
+}
+
+func TestIsVersionCommentQuery(t *testing.T) {
+	t.Parallel()
+
+	for query, want := range map[string]bool{
+		"select @@version_comment limit 1": true,
+		"SELECT  @@VERSION_COMMENT;":       true,
+		"select @@version_comment limit 2": false,
+		"select @@version_comment, @@port": false,
+		"select @@version":                 false,
+		"/* x */ select @@version_comment": false,
+	} {
+		if got := isVersionCommentQuery(query); got != want {
+			t.Fatalf("isVersionCommentQuery(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestIsMaxConnectionsQuery(t *testing.T) {
+	t.Parallel()
+
+	for query, want := range map[string]bool{
+		"SHOW VARIABLES LIKE 'max_connections'":           true,
+		"show global variables like \"max_connections\";": true,
+		"SHOW  SESSION VARIABLES LIKE 'MAX_CONNECTIONS'":  true,
+		"SHOW VARIABLES LIKE 'max_connect%'":              false,
+		"SHOW VARIABLES LIKE 'max_user_connections'":      false,
+		"SHOW VARIABLES":           false,
+		"SELECT @@max_connections": false,
+	} {
+		if got := isMaxConnectionsQuery(query); got != want {
+			t.Fatalf("isMaxConnectionsQuery(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestInspectorReportsMaxConnsOnlyWhenCapped(t *testing.T) {
+	t.Parallel()
+
+	ci := newCommandInspector(config.Profile{ReportMaxConnections: true})
+	query := append([]byte{mysql.COM_QUERY}, "SHOW VARIABLES LIKE 'max_connections'"...)
+	ci.setMaxConns(config.MaxConnsUnlimited)
+	if v := ci.inspect(query); v.reply != nil {
+		t.Fatal("expected an unlimited listener to forward the query")
+	}
+	ci.setMaxConns(12)
+	if v := ci.inspect(query); v.reply == nil || !strings.Contains(string(v.reply), "max_connections") || !strings.Contains(string(v.reply), "12") {
+		t.Fatalf("expected a max_connections reply of 12, got %q", v.reply)
+	}
+}
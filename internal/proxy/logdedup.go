@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+const (
+	logDedupWindow     = 10 * time.Second
+	logDedupMaxTracked = 256 // bound on remembered reasons
+
+	// logDedupOverflowReason stands in for the reason of any new key seen
+	// while logDedupMaxTracked reasons are still inside their window, so
+	// those are collapsed together instead of growing the map.
+	logDedupOverflowReason = "other"
+)
+
+// logDedup collapses repeats of the same message and reason. The first
+// occurrence in a window is logged as is; later ones are only counted and
+// reported as one "<msg> repeated" line, with the count, when the window ends.
+type logDedup struct {
+	logger *slog.Logger // for the summary lines
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	start      time.Time
+	suppressed int
+	timer      *time.Timer
+}
+
+func newLogDedup(logger *slog.Logger, window time.Duration) *logDedup {
+	return &logDedup{logger: logger, window: window, seen: make(map[string]*dedupEntry)}
+}
+
+// Log writes msg with args to logger unless the same msg and reason were
+// already logged in the current window. Once logDedupMaxTracked reasons are
+// live, new reasons share one logDedupOverflowReason entry per msg.
+func (d *logDedup) Log(logger *slog.Logger, level slog.Level, msg, reason string, args ...any) {
+	key := msg + "\x00" + reason
+	now := time.Now()
+
+	d.mu.Lock()
+	e, ok := d.seen[key]
+	if !ok && len(d.seen) >= logDedupMaxTracked {
+		d.pruneLocked(now)
+		if len(d.seen) >= logDedupMaxTracked {
+			reason = logDedupOverflowReason
+			key = msg + "\x00" + reason
+			e, ok = d.seen[key]
+		}
+	}
+	if ok && now.Sub(e.start) < d.window {
+		e.suppressed++
+		if e.timer == nil {
+			e.timer = time.AfterFunc(e.start.Add(d.window).Sub(now), func() { d.flush(key, e, level, msg, reason) })
+		}
+		d.mu.Unlock()
+		return
+	}
+	d.seen[key] = &dedupEntry{start: now}
+	d.mu.Unlock()
+
+	logger.Log(context.Background(), level, msg, args...)
+}
+
+func (d *logDedup) flush(key string, e *dedupEntry, level slog.Level, msg, reason string) {
+	d.mu.Lock()
+	if d.seen[key] == e {
+		delete(d.seen, key)
+	}
+	n := e.suppressed
+	d.mu.Unlock()
+
+	d.logger.Log(context.Background(), level, msg+" repeated", "reason", reason, "repeats", n, "window_ms", d.window.Milliseconds())
+}
+
+// pruneLocked drops expired entries that have no pending summary.
+func (d *logDedup) pruneLocked(now time.Time) {
+	for key, e := range d.seen {
+		if e.timer == nil && now.Sub(e.start) >= d.window {
+			delete(d.seen, key)
+		}
+	}
+}
+
+// addrPattern matches IPv4 and bracketed IPv6 addresses, with an optional
+// port, as net errors embed them.
+var addrPattern = regexp.MustCompile(`\[[0-9A-Fa-f:.%]+\](:\d+)?|\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`)
+
+// errReason reduces err to a stable class for use as a logDedup reason, so
+// failures that differ only in per-connection detail such as local ports
+// collapse into one entry. Errors it does not recognise fall back to their
+// first line with addresses replaced.
+func errReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	var myErr *mysql.MyError
+	var dnsErr *net.DNSError
+	var unknownCA x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &myErr):
+		return fmt.Sprintf("mysql error %d", myErr.Code)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &dnsErr):
+		return "dns lookup failed"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "host unreachable"
+	case errors.As(err, &unknownCA):
+		return "tls: unknown certificate authority"
+	case errors.As(err, &hostErr):
+		return "tls: certificate hostname mismatch"
+	case errors.As(err, &certErr):
+		return "tls: certificate verification failed"
+	case errors.As(err, &alertErr):
+		return "tls: " + alertErr.Error()
+	case errors.Is(err, mysql.ErrBadConn), isConnCloseErr(err):
+		return "connection closed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return addrPattern.ReplaceAllString(compactErr(err), "<addr>")
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestLogDedupCollapsesRepeatsIntoSummary(t *testing.T) {
+	t.Parallel()
+
+	var out syncBuffer
+	logger := slog.New(slog.NewTextHandler(&out, nil))
+	d := newLogDedup(logger, 50*time.Millisecond)
+
+	for i := 0; i < 48; i++ {
+		d.Log(logger.With("conn_id", i), slog.LevelError, "backend unavailable", "dial backend: connection refused", "error", "x")
+	}
+	d.Log(logger, slog.LevelError, "backend unavailable", "token build failed", "error", "y")
+
+	got := out.String()
+	if n := strings.Count(got, "msg=\"backend unavailable\""); n != 2 {
+		t.Fatalf("expected the first occurrence of each reason logged immediately, got %d lines:\n%s", n, got)
+	}
+	if !strings.Contains(got, "conn_id=0 ") {
+		t.Fatalf("expected the first occurrence with its own attributes, got:\n%s", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(out.String(), "backend unavailable repeated") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a summary after the window, got:\n%s", out.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	got = out.String()
+	if !strings.Contains(got, "repeats=47") || !strings.Contains(got, "window_ms=50") {
+		t.Fatalf("unexpected summary:\n%s", got)
+	}
+	if strings.Count(got, "repeated") != 1 {
+		t.Fatalf("expected no summary for a reason seen once, got:\n%s", got)
+	}
+
+	// A new window logs the next occurrence immediately again.
+	d.Log(logger, slog.LevelError, "backend unavailable", "dial backend: connection refused", "error", "x")
+	if n := strings.Count(out.String(), "msg=\"backend unavailable\""); n != 3 {
+		t.Fatalf("expected a fresh first occurrence after the summary, got:\n%s", out.String())
+	}
+}
+
+func TestLogDedupCapsTrackedReasons(t *testing.T) {
+	t.Parallel()
+
+	var out syncBuffer
+	logger := slog.New(slog.NewTextHandler(&out, nil))
+	d := newLogDedup(logger, time.Hour)
+
+	for i := range logDedupMaxTracked {
+		d.Log(logger, slog.LevelWarn, "pool prewarm failed", fmt.Sprintf("reason %d", i))
+	}
+	for i := range 10 {
+		d.Log(logger, slog.LevelWarn, "pool prewarm failed", fmt.Sprintf("late reason %d", i))
+	}
+
+	if n := strings.Count(out.String(), "msg=\"pool prewarm failed\""); n != logDedupMaxTracked+1 {
+		t.Fatalf("expected reasons past the cap logged once between them, got %d lines", n)
+	}
+	d.mu.Lock()
+	tracked := len(d.seen)
+	_, overflow := d.seen["pool prewarm failed\x00"+logDedupOverflowReason]
+	d.mu.Unlock()
+	if tracked != logDedupMaxTracked+1 || !overflow {
+		t.Fatalf("expected %d tracked reasons plus the overflow entry, got %d (overflow=%v)", logDedupMaxTracked, tracked, overflow)
+	}
+}
+
+func TestErrReasonIgnoresAddresses(t *testing.T) {
+	t.Parallel()
+
+	refused := func(local string) error {
+		return fmt.Errorf("dial backend: %w", &net.OpError{
+			Op:     "dial",
+			Net:    "tcp",
+			Source: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000},
+			Addr:   &net.TCPAddr{IP: net.ParseIP(local), Port: 3306},
+			Err:    os.NewSyscallError("connect", syscall.ECONNREFUSED),
+		})
+	}
+	if a, b := errReason(refused("10.0.0.1")), errReason(refused("10.0.0.2")); a != "connection refused" || a != b {
+		t.Fatalf("expected both refusals as one class, got %q and %q", a, b)
+	}
+	if got := errReason(&mysql.MyError{Code: mysql.ER_ACCESS_DENIED_ERROR, Message: "Access denied for user 'app'@'10.1.2.3'"}); got != "mysql error 1045" {
+		t.Fatalf("unexpected mysql reason %q", got)
+	}
+	if got := errReason(errors.New("backend 10.0.0.7:3306 said no\nmore detail")); got != "backend <addr> said no" {
+		t.Fatalf("unexpected fallback reason %q", got)
+	}
+}
@@ -2,32 +2,79 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-mysql-org/go-mysql/client"
 )
 
+var errPoolClosed = errors.New("backend pool closed")
+
+// invalidateMinInterval bounds how often Invalidate drops the pool, so a burst
+// of read-only errors during one failover rebuilds it once.
+const invalidateMinInterval = 5 * time.Second
+
+const (
+	// prewarmJitter spreads Start's prewarms over this window so a pool does
+	// not open every backend connection in the same instant.
+	prewarmJitter = time.Second
+	// maxLifeJitterDivisor backdates each connection's createdAt by up to
+	// maxLife/maxLifeJitterDivisor, so connections dialed together do not
+	// all expire, and reconnect, together.
+	maxLifeJitterDivisor = 10
+)
+
 type PooledConn struct {
-	conn      *client.Conn
-	createdAt time.Time
+	conn           *client.Conn
+	createdAt      time.Time // backdated by up to maxLife/maxLifeJitterDivisor
+	gen            uint64    // pool generation the connection was dialed in
+	tokenExpiresAt time.Time // expiry of the IAM token it logged in with; zero when the factory did not report it
 }
 
+// PoolDialer opens a backend connection for a BackendPool and returns it with
+// the expiry of the IAM token it logged in with, or a zero time when unknown.
+type PoolDialer func(context.Context) (*client.Conn, time.Time, error)
+
 type BackendPool struct {
 	mu            sync.RWMutex
 	closed        bool
 	conns         chan *PooledConn
 	maxLife       time.Duration
-	factory       func(context.Context) (*client.Conn, error)
+	factory       PoolDialer
 	logger        *slog.Logger
 	refillCtx     context.Context
 	refillCancel  context.CancelFunc
 	refillTimeout time.Duration
+	sweepInterval time.Duration
+	startResults  chan error    // one result per prewarm launched by Start
+	prewarmJitter time.Duration // window Start spreads prewarms over
+	prewarmSem    chan struct{} // bounds concurrent prewarm connects; nil is unbounded
+	gen           atomic.Uint64 // bumped by Invalidate
+	invalidatedAt time.Time     // guarded by mu
+
+	borrowMu     sync.Mutex
+	warmBorrows  BorrowSummary
+	freshBorrows BorrowSummary
+	// Starvation tracking, guarded by borrowMu: emptySince is the first of
+	// a run of fresh borrows with no warm borrow or refill in between, and
+	// starvedWarned whether that run has been reported.
+	starvationWindow time.Duration
+	emptySince       time.Time
+	emptyBorrows     uint64
+	starvedWarned    bool
+	starvations      uint64
+	failLog          *logDedup // prewarm failures
+	invalidations    atomic.Uint64
+	infoLogged       atomic.Bool // backend server info logged after the first prewarm
 }
 
-func NewBackendPool(size int, maxLife, refillTimeout time.Duration, logger *slog.Logger, factory func(context.Context) (*client.Conn, error)) *BackendPool {
+func NewBackendPool(size int, maxLife, refillTimeout time.Duration, logger *slog.Logger, factory PoolDialer) *BackendPool {
 	if size < 1 {
 		size = 1
 	}
@@ -43,17 +90,262 @@ func NewBackendPool(size int, maxLife, refillTimeout time.Duration, logger *slog
 		refillCtx:     refillCtx,
 		refillCancel:  refillCancel,
 		refillTimeout: refillTimeout,
+		startResults:  make(chan error, size),
+		prewarmJitter: prewarmJitter,
+		failLog:       newLogDedup(logger, logDedupWindow),
 	}
 	return p
 }
 
+// SetSweepInterval enables a background health check of idle pooled
+// connections every d. It must be called before Start; 0 disables sweeping.
+func (p *BackendPool) SetSweepInterval(d time.Duration) {
+	p.sweepInterval = d
+}
+
+// SetPrewarmConcurrency limits how many of Start's prewarms connect at once,
+// so a large pool fills in waves instead of bursting connects at the
+// backend. It must be called before Start; 0 leaves prewarms unbounded.
+func (p *BackendPool) SetPrewarmConcurrency(n int) {
+	p.prewarmSem = nil
+	if n > 0 {
+		p.prewarmSem = make(chan struct{}, n)
+	}
+}
+
+// SetStarvationWindow makes the pool warn once every borrow for d has found
+// it empty and had to connect synchronously, which means refills cannot keep
+// up with the borrow rate and pool-size is too small. It must be called
+// before Start; 0 disables the warning.
+func (p *BackendPool) SetStarvationWindow(d time.Duration) {
+	p.starvationWindow = d
+}
+
+// PoolStats is a point-in-time view of a pool's fill state.
+type PoolStats struct {
+	Size  int           // configured number of pre-warmed connections
+	Idle  int           // connections ready to borrow
+	Warm  BorrowSummary // borrows served by an idle pooled connection
+	Fresh BorrowSummary // borrows that had to connect synchronously
+	// Invalidations counts Invalidate calls that dropped the pool.
+	Invalidations uint64
+	// Starvations counts the times the pool stayed empty for the whole
+	// starvation window.
+	Starvations uint64
+}
+
+// BorrowSummary aggregates successful borrow latencies since start.
+type BorrowSummary struct {
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+}
+
+func (s *BorrowSummary) observe(d time.Duration) {
+	s.Count++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+}
+
+// Mean is the average borrow latency, or 0 without borrows.
+func (s BorrowSummary) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+func (p *BackendPool) Stats() PoolStats {
+	p.borrowMu.Lock()
+	defer p.borrowMu.Unlock()
+	return PoolStats{Size: cap(p.conns), Idle: len(p.conns), Warm: p.warmBorrows, Fresh: p.freshBorrows, Invalidations: p.invalidations.Load(), Starvations: p.starvations}
+}
+
+// Start launches one prewarm per pool slot. The first dials at once so
+// WaitReady is not delayed; the others start at random points within
+// prewarmJitter, and then wait their turn under SetPrewarmConcurrency.
 func (p *BackendPool) Start(ctx context.Context) {
 	for i := 0; i < cap(p.conns); i++ {
-		go p.fillOne()
+		var delay time.Duration
+		if i > 0 && p.prewarmJitter > 0 {
+			delay = rand.N(p.prewarmJitter)
+		}
+		go func() { p.startResults <- p.fillOneAfter(delay) }()
+	}
+	if p.sweepInterval > 0 {
+		go p.runSweeper()
 	}
 }
 
+// WaitReady blocks until one of the prewarms launched by Start succeeds. It
+// returns the last prewarm error once all of them have failed, or when ctx
+// ends first. It must be called at most once, after Start.
+func (p *BackendPool) WaitReady(ctx context.Context) error {
+	var lastErr error
+	for i := 0; i < cap(p.conns); i++ {
+		select {
+		case err := <-p.startResults:
+			if err == nil {
+				return nil
+			}
+			lastErr = err
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("%w; last prewarm error: %v", ctx.Err(), lastErr)
+			}
+			return ctx.Err()
+		}
+	}
+	return lastErr
+}
+
+func (p *BackendPool) runSweeper() {
+	ticker := time.NewTicker(p.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.refillCtx.Done():
+			return
+		case <-ticker.C:
+			p.sweep()
+		}
+	}
+}
+
+// sweep pings every idle pooled connection once, discarding and refilling
+// expired or dead ones so Borrow rarely pays the stale-discard cost.
+func (p *BackendPool) sweep() {
+	discarded := 0
+	lastReason := ""
+	for i, n := 0, len(p.conns); i < n; i++ {
+		var pooled *PooledConn
+		select {
+		case pooled = <-p.conns:
+		default:
+		}
+		if pooled == nil {
+			break
+		}
+		if p.stale(pooled) {
+			_ = pooled.conn.Close()
+			go p.fillOne()
+			continue
+		}
+		if err := pooled.conn.Ping(); err != nil {
+			discarded++
+			lastReason = compactErr(err)
+			p.logger.Debug("sweeper discarding stale pooled connection", "reason", lastReason)
+			_ = pooled.conn.Close()
+			go p.fillOne()
+			continue
+		}
+		if !p.put(pooled) {
+			_ = pooled.conn.Close()
+		}
+	}
+	if discarded > 0 {
+		p.logger.Info("pool sweep discarded stale connections", "discarded", discarded, "last_reason", lastReason)
+	}
+}
+
+// put returns an idle connection to the pool unless the pool is closed or
+// full, or the connection predates the last Invalidate.
+func (p *BackendPool) put(item *PooledConn) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed || item.gen != p.gen.Load() {
+		return false
+	}
+	select {
+	case p.conns <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+// stale reports whether pooled outlived maxLife, predates the last
+// Invalidate or logged in with an IAM token that has since expired. The
+// session itself stays authenticated past the token's expiry, but a pooled
+// connection is meant to behave like a fresh login, so it is not handed out.
+func (p *BackendPool) stale(pooled *PooledConn) bool {
+	now := time.Now()
+	if !pooled.tokenExpiresAt.IsZero() && !now.Before(pooled.tokenExpiresAt) {
+		p.logger.Debug("discarding pooled connection with expired IAM token", "token_expired_at", pooled.tokenExpiresAt.UTC().Format(time.RFC3339))
+		return true
+	}
+	return now.Sub(pooled.createdAt) > p.maxLife || pooled.gen != p.gen.Load()
+}
+
+// BorrowInfo describes how a Borrow call was served.
+type BorrowInfo struct {
+	Fresh bool          // no idle connection was usable; the caller waited for a new connect
+	Wait  time.Duration // total time spent in Borrow
+}
+
 func (p *BackendPool) Borrow(ctx context.Context) (*client.Conn, error) {
+	conn, _, err := p.BorrowWithInfo(ctx)
+	return conn, err
+}
+
+// BorrowWithInfo is Borrow plus whether the connection came from the pool and
+// how long that took, for right-sizing pool-size.
+func (p *BackendPool) BorrowWithInfo(ctx context.Context) (*client.Conn, BorrowInfo, error) {
+	start := time.Now()
+	conn, fresh, err := p.borrow(ctx)
+	info := BorrowInfo{Fresh: fresh, Wait: time.Since(start)}
+	if err == nil {
+		p.borrowMu.Lock()
+		if fresh {
+			p.freshBorrows.observe(info.Wait)
+			p.noteEmptyBorrow(start)
+		} else {
+			p.warmBorrows.observe(info.Wait)
+			p.emptySince = time.Time{}
+		}
+		p.borrowMu.Unlock()
+	}
+	return conn, info, err
+}
+
+// noteEmptyBorrow records a borrow that found the pool empty at at, and warns
+// when such borrows have run unbroken for the starvation window. A warm
+// borrow or a refill ends the run, so a quiet pool whose idle connections
+// aged out between two far-apart borrows is not reported. The caller holds
+// borrowMu.
+func (p *BackendPool) noteEmptyBorrow(at time.Time) {
+	if p.starvationWindow <= 0 {
+		return
+	}
+	if p.emptySince.IsZero() {
+		p.emptySince = at
+		p.emptyBorrows = 0
+		p.starvedWarned = false
+	}
+	p.emptyBorrows++
+	emptyFor := time.Since(p.emptySince)
+	if p.starvedWarned || emptyFor < p.starvationWindow {
+		return
+	}
+	p.starvedWarned = true
+	p.starvations++
+	p.logger.Warn("backend pool starved; borrows are connecting synchronously, consider a larger --pool-size",
+		"empty_for_ms", emptyFor.Milliseconds(),
+		"fresh_borrows", p.emptyBorrows,
+		"pool_size", cap(p.conns),
+		"fresh_wait_mean_ms", p.freshBorrows.Mean().Milliseconds(),
+	)
+}
+
+// borrow is Borrow without accounting. It reports whether the connection
+// came from a synchronous connect rather than the pool. After Close it
+// returns errPoolClosed instead of dialing.
+func (p *BackendPool) borrow(ctx context.Context) (*client.Conn, bool, error) {
+	if p.isClosed() {
+		return nil, false, errPoolClosed
+	}
 	staleDiscarded := 0
 	lastStaleReason := ""
 
@@ -63,15 +355,15 @@ func (p *BackendPool) Borrow(ctx context.Context) (*client.Conn, error) {
 			if staleDiscarded > 0 {
 				p.logger.Info("refreshed stale pooled connections", "discarded", staleDiscarded, "last_reason", lastStaleReason)
 			}
-			return nil, ctx.Err()
+			return nil, false, ctx.Err()
 		case pooled := <-p.conns:
 			if pooled == nil {
 				if staleDiscarded > 0 {
 					p.logger.Info("refreshed stale pooled connections", "discarded", staleDiscarded, "last_reason", lastStaleReason)
 				}
-				return p.factory(ctx)
+				return p.dialFresh(ctx)
 			}
-			if time.Since(pooled.createdAt) > p.maxLife {
+			if p.stale(pooled) {
 				_ = pooled.conn.Close()
 				go p.fillOne()
 				continue
@@ -89,45 +381,188 @@ func (p *BackendPool) Borrow(ctx context.Context) (*client.Conn, error) {
 			if staleDiscarded > 0 {
 				p.logger.Info("refreshed stale pooled connections", "discarded", staleDiscarded, "last_reason", lastStaleReason)
 			}
-			return pooled.conn, nil
+			return pooled.conn, false, nil
 		default:
 			if staleDiscarded > 0 {
 				p.logger.Info("refreshed stale pooled connections", "discarded", staleDiscarded, "last_reason", lastStaleReason)
 			}
-			return p.factory(ctx)
+			return p.dialFresh(ctx)
+		}
+	}
+}
+
+// dialFresh connects synchronously for borrow. A connection that finishes
+// after Close is dropped rather than handed to a shutting-down caller.
+func (p *BackendPool) dialFresh(ctx context.Context) (*client.Conn, bool, error) {
+	if p.isClosed() {
+		return nil, false, errPoolClosed
+	}
+	conn, _, err := p.factory(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+	if p.isClosed() {
+		_ = conn.Close()
+		return nil, false, errPoolClosed
+	}
+	return conn, true, nil
+}
+
+// fillOneAfter is fillOne after waiting delay and for a prewarm slot, or
+// errPoolClosed if the pool closes first.
+func (p *BackendPool) fillOneAfter(delay time.Duration) error {
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-p.refillCtx.Done():
+			return errPoolClosed
+		}
+	}
+	if p.prewarmSem != nil {
+		select {
+		case p.prewarmSem <- struct{}{}:
+			defer func() { <-p.prewarmSem }()
+		case <-p.refillCtx.Done():
+			return errPoolClosed
 		}
 	}
+	return p.fillOne()
 }
 
-func (p *BackendPool) fillOne() {
+// fillOne adds one fresh connection to the pool. It reports the factory
+// error, if any, for WaitReady.
+func (p *BackendPool) fillOne() error {
 	p.mu.RLock()
 	if p.closed {
 		p.mu.RUnlock()
-		return
+		return errPoolClosed
 	}
 	p.mu.RUnlock()
+	gen := p.gen.Load()
 
 	ctx, cancel := context.WithTimeout(p.refillCtx, p.refillTimeout)
 	defer cancel()
 
-	conn, err := p.factory(ctx)
+	conn, tokenExpiresAt, err := p.factory(ctx)
 	if err != nil {
-		p.logger.Warn("pool prewarm failed", "reason", compactErr(err))
-		return
+		reason := errReason(err)
+		p.failLog.Log(p.logger, slog.LevelWarn, "pool prewarm failed", reason, "reason", reason, "error", compactErr(err))
+		return err
+	}
+
+	if p.infoLogged.CompareAndSwap(false, true) {
+		p.logger.Info("backend server info", backendServerInfo(conn)...)
 	}
 
 	item := &PooledConn{
-		conn:      conn,
-		createdAt: time.Now(),
+		conn:           conn,
+		createdAt:      time.Now().Add(-p.lifeJitter()),
+		gen:            gen,
+		tokenExpiresAt: tokenExpiresAt,
+	}
+	if !tokenExpiresAt.IsZero() {
+		p.logger.Debug("pooled backend connection ready", "token_expires_in_ms", time.Until(tokenExpiresAt).Milliseconds())
 	}
 
-	select {
-	case p.conns <- item:
-	default:
+	// put holds the read lock while queuing, so a Close that started during
+	// the connect either sees this connection when it drains or refuses it.
+	if !p.put(item) {
 		_ = conn.Close()
+		if p.isClosed() {
+			return errPoolClosed
+		}
+		return nil
+	}
+	p.borrowMu.Lock()
+	p.emptySince = time.Time{}
+	p.borrowMu.Unlock()
+	return nil
+}
+
+// lifeJitter is a random backdate for a new connection's createdAt, below
+// maxLife/maxLifeJitterDivisor, so it only ever shortens the connection's life.
+func (p *BackendPool) lifeJitter() time.Duration {
+	if window := p.maxLife / maxLifeJitterDivisor; window > 0 {
+		return rand.N(window)
+	}
+	return 0
+}
+
+// Invalidate drops every idle connection and refills the pool with new
+// connects, which resolve rds_host again, after the backend moved (e.g. an
+// Aurora failover). Connections being dialed when it is called are discarded
+// once they finish; borrowed ones stay with their callers. Calls within
+// invalidateMinInterval of the last Invalidate or Refresh are ignored. It
+// reports whether the pool was dropped.
+func (p *BackendPool) Invalidate() bool {
+	dropped, ok := p.drop()
+	if !ok {
+		return false
+	}
+	p.invalidations.Add(1)
+	p.logger.Info("backend pool invalidated", "dropped_idle", dropped)
+	return true
+}
+
+// Refresh drops every idle connection and refills the pool, like Invalidate,
+// so an operator can force all pooled sessions to re-authenticate (e.g.
+// after an IAM policy change). It shares Invalidate's rate limit, so
+// repeated triggers coalesce into one rebuild instead of stacking refills.
+// Borrowed connections stay with their callers. It reports whether the pool
+// was dropped.
+func (p *BackendPool) Refresh() bool {
+	dropped, ok := p.drop()
+	if !ok {
+		return false
+	}
+	p.logger.Info("backend pool refreshed", "dropped_idle", dropped)
+	return true
+}
+
+// drop bumps the generation and rebuilds the pool unless it is closed or
+// was dropped within invalidateMinInterval. It returns how many idle
+// connections it closed.
+func (p *BackendPool) drop() (int, bool) {
+	p.mu.Lock()
+	if p.closed || (!p.invalidatedAt.IsZero() && time.Since(p.invalidatedAt) < invalidateMinInterval) {
+		p.mu.Unlock()
+		return 0, false
 	}
+	p.invalidatedAt = time.Now()
+	p.gen.Add(1)
+	p.mu.Unlock()
+	return p.rebuild(), true
+}
+
+// rebuild closes the idle connections after a generation bump and starts a
+// refill for every slot. It returns how many idle connections it closed.
+func (p *BackendPool) rebuild() int {
+	dropped := 0
+	for i, n := 0, len(p.conns); i < n; i++ {
+		select {
+		case c := <-p.conns:
+			_ = c.conn.Close()
+			dropped++
+		default:
+		}
+	}
+	for i := 0; i < cap(p.conns); i++ {
+		go p.fillOne()
+	}
+	return dropped
+}
+
+func (p *BackendPool) isClosed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.closed
 }
 
+// Close stops refills and closes idle connections. Borrowed connections stay
+// with their callers; borrows and refills still in flight close whatever they
+// dial afterwards, so nothing is left open in the pool.
 func (p *BackendPool) Close() {
 	p.mu.Lock()
 	if p.closed {
@@ -3,10 +3,13 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,15 +21,20 @@ func newClientConnFromNetConn(c net.Conn) *client.Conn {
 	return &client.Conn{Conn: packet.NewConn(c)}
 }
 
+// noTokenExpiry adapts a plain connect to a PoolDialer result.
+func noTokenExpiry(conn *client.Conn, err error) (*client.Conn, time.Time, error) {
+	return conn, time.Time{}, err
+}
+
 func TestBorrowReturnsFactoryConnWhenPoolEmpty(t *testing.T) {
 	t.Parallel()
 
 	var called bool
-	factory := func(context.Context) (*client.Conn, error) {
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
 		called = true
 		local, remote := net.Pipe()
 		_ = remote.Close()
-		return newClientConnFromNetConn(local), nil
+		return newClientConnFromNetConn(local), time.Time{}, nil
 	}
 
 	p := NewBackendPool(1, time.Minute, time.Second, slog.Default(), factory)
@@ -49,7 +57,7 @@ func TestBorrowDiscardStaleAndRefill(t *testing.T) {
 	t.Parallel()
 
 	refilled := make(chan struct{}, 1)
-	factory := func(context.Context) (*client.Conn, error) {
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
 		local, remote := net.Pipe()
 		go func() {
 			defer remote.Close()
@@ -59,7 +67,7 @@ func TestBorrowDiscardStaleAndRefill(t *testing.T) {
 		case refilled <- struct{}{}:
 		default:
 		}
-		return newClientConnFromNetConn(local), nil
+		return newClientConnFromNetConn(local), time.Time{}, nil
 	}
 
 	p := NewBackendPool(1, 10*time.Millisecond, time.Second, slog.Default(), factory)
@@ -88,17 +96,49 @@ func TestBorrowDiscardStaleAndRefill(t *testing.T) {
 	}
 }
 
+func TestBorrowSkipsPooledConnWithExpiredToken(t *testing.T) {
+	t.Parallel()
+
+	expiresAt := time.Now().Add(-time.Second)
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		return newClientConnFromNetConn(local), expiresAt, nil
+	}
+	p := NewBackendPool(1, time.Hour, time.Second, slog.Default(), factory)
+	defer p.Close()
+
+	if err := p.fillOne(); err != nil {
+		t.Fatalf("fillOne: %v", err)
+	}
+	pooled := <-p.conns
+	if !pooled.tokenExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected the pooled conn to carry its token expiry %s, got %s", expiresAt, pooled.tokenExpiresAt)
+	}
+	p.conns <- pooled
+
+	// Well inside max_life, but the token it logged in with has expired.
+	conn, info, err := p.BorrowWithInfo(context.Background())
+	if err != nil || !info.Fresh {
+		t.Fatalf("expected a fresh connect instead of the expired-token conn, got fresh=%v err=%v", info.Fresh, err)
+	}
+	_ = conn.Close()
+}
+
 func TestFillOneUsesTimeoutContext(t *testing.T) {
 	t.Parallel()
 
 	factoryCalled := make(chan time.Duration, 1)
-	factory := func(ctx context.Context) (*client.Conn, error) {
+	factory := func(ctx context.Context) (*client.Conn, time.Time, error) {
 		deadline, ok := ctx.Deadline()
 		if !ok {
 			t.Fatal("expected refill context to have deadline")
 		}
 		factoryCalled <- time.Until(deadline)
-		return nil, context.DeadlineExceeded
+		return nil, time.Time{}, context.DeadlineExceeded
 	}
 
 	p := NewBackendPool(1, time.Minute, 500*time.Millisecond, slog.Default(), factory)
@@ -122,13 +162,13 @@ func TestBorrowLogsSingleSummaryForStaleConnections(t *testing.T) {
 	var buf bytes.Buffer
 	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
-	factory := func(context.Context) (*client.Conn, error) {
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
 		local, remote := net.Pipe()
 		go func() {
 			defer remote.Close()
 			_, _ = io.Copy(io.Discard, remote)
 		}()
-		return newClientConnFromNetConn(local), nil
+		return newClientConnFromNetConn(local), time.Time{}, nil
 	}
 
 	p := NewBackendPool(1, time.Minute, time.Second, logger, factory)
@@ -158,3 +198,578 @@ func TestBorrowLogsSingleSummaryForStaleConnections(t *testing.T) {
 		t.Fatalf("did not expect per-connection stale logs at info level, got: %s", out)
 	}
 }
+
+func TestSweepDiscardsDeadConnectionsAndRefills(t *testing.T) {
+	t.Parallel()
+
+	refilled := make(chan struct{}, 1)
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		select {
+		case refilled <- struct{}{}:
+		default:
+		}
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	}
+
+	p := NewBackendPool(2, time.Minute, time.Second, slog.Default(), factory)
+	defer p.Close()
+
+	deadLocal, deadRemote := net.Pipe()
+	_ = deadRemote.Close()
+	p.conns <- &PooledConn{
+		conn:      newClientConnFromNetConn(deadLocal),
+		createdAt: time.Now(),
+	}
+
+	p.sweep()
+
+	select {
+	case <-refilled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected sweep to trigger refill for dead connection")
+	}
+}
+
+func TestSweepDoesNotRequeueAfterClose(t *testing.T) {
+	t.Parallel()
+
+	p := NewBackendPool(1, time.Minute, time.Second, slog.Default(), func(context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, context.Canceled
+	})
+	p.Close()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	if p.put(&PooledConn{conn: newClientConnFromNetConn(local), createdAt: time.Now()}) {
+		t.Fatal("expected put to refuse connections after Close")
+	}
+	_ = local.Close()
+}
+
+func TestWaitReadyReturnsAfterFirstSuccessfulPrewarm(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var calls atomic.Int32
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		if calls.Add(1) == 1 {
+			return nil, time.Time{}, errors.New("dial backend: connection refused")
+		}
+		local, remote := net.Pipe()
+		t.Cleanup(func() { _ = remote.Close() })
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	}
+	p := NewBackendPool(2, time.Minute, time.Second, logger, factory)
+	defer p.Close()
+	p.Start(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := p.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+}
+
+func TestWaitReadyReportsErrorWhenAllPrewarmsFail(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("x509: certificate signed by unknown authority")
+	}
+	p := NewBackendPool(3, time.Minute, time.Second, logger, factory)
+	defer p.Close()
+	p.Start(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := p.WaitReady(ctx)
+	if err == nil || !strings.Contains(err.Error(), "unknown authority") {
+		t.Fatalf("expected last prewarm error, got: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected WaitReady to return before the timeout once every prewarm failed")
+	}
+
+	blocked := NewBackendPool(1, time.Minute, time.Second, logger, func(ctx context.Context) (*client.Conn, time.Time, error) {
+		<-ctx.Done()
+		return nil, time.Time{}, ctx.Err()
+	})
+	blocked.Start(context.Background())
+	defer blocked.Close()
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	if err := blocked.WaitReady(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got: %v", err)
+	}
+}
+
+func TestBorrowWithInfoCountsWarmAndFreshBorrows(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	}
+	p := NewBackendPool(1, time.Minute, time.Second, logger, factory)
+	defer p.Close()
+
+	conn, info, err := p.BorrowWithInfo(context.Background())
+	if err != nil {
+		t.Fatalf("fresh BorrowWithInfo: %v", err)
+	}
+	_ = conn.Close()
+	if !info.Fresh {
+		t.Fatal("expected an empty pool to report a fresh connect")
+	}
+
+	// An idle connection whose backend answers COM_PING with OK.
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		pc := packet.NewConn(remote)
+		if _, err := pc.ReadPacket(); err != nil {
+			return
+		}
+		_ = pc.WritePacket([]byte{0, 0, 0, 0, 0x00, 0x00, 0x00})
+	}()
+	p.conns <- &PooledConn{conn: newClientConnFromNetConn(local), createdAt: time.Now()}
+
+	conn, info, err = p.BorrowWithInfo(context.Background())
+	if err != nil {
+		t.Fatalf("warm BorrowWithInfo: %v", err)
+	}
+	_ = conn.Close()
+	if info.Fresh {
+		t.Fatal("expected the idle connection to be reused")
+	}
+
+	stats := p.Stats()
+	if stats.Warm.Count != 1 || stats.Fresh.Count != 1 {
+		t.Fatalf("unexpected borrow counts: warm=%+v fresh=%+v", stats.Warm, stats.Fresh)
+	}
+	if stats.Fresh.Max < stats.Fresh.Mean() || stats.Fresh.Total != stats.Fresh.Max {
+		t.Fatalf("inconsistent fresh summary: %+v", stats.Fresh)
+	}
+	if (BorrowSummary{}).Mean() != 0 {
+		t.Fatal("expected zero mean without borrows")
+	}
+}
+
+func TestBorrowWarnsWhenPoolStaysEmpty(t *testing.T) {
+	t.Parallel()
+
+	var buf syncBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	}
+	// Without Start nothing refills the pool, so every borrow connects.
+	p := NewBackendPool(2, time.Minute, time.Second, logger, factory)
+	defer p.Close()
+	p.SetStarvationWindow(50 * time.Millisecond)
+
+	borrowFresh := func() {
+		t.Helper()
+		conn, info, err := p.BorrowWithInfo(context.Background())
+		if err != nil {
+			t.Fatalf("BorrowWithInfo: %v", err)
+		}
+		_ = conn.Close()
+		if !info.Fresh {
+			t.Fatal("expected an empty pool to report a fresh connect")
+		}
+	}
+
+	borrowFresh()
+	borrowFresh()
+	if strings.Contains(buf.String(), "backend pool starved") {
+		t.Fatalf("did not expect a warning inside the window, got: %s", buf.String())
+	}
+	time.Sleep(60 * time.Millisecond)
+	borrowFresh()
+	borrowFresh()
+
+	out := buf.String()
+	if strings.Count(out, "backend pool starved") != 1 || !strings.Contains(out, "fresh_borrows=3") || !strings.Contains(out, "pool_size=2") {
+		t.Fatalf("expected one starvation warning, got: %s", out)
+	}
+	if got := p.Stats().Starvations; got != 1 {
+		t.Fatalf("expected 1 starvation, got %d", got)
+	}
+}
+
+func TestBorrowDoesNotWarnWhenPoolRefilledBetweenBorrows(t *testing.T) {
+	t.Parallel()
+
+	var buf syncBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	}
+	p := NewBackendPool(1, time.Minute, time.Second, logger, factory)
+	defer p.Close()
+	p.SetStarvationWindow(50 * time.Millisecond)
+
+	for i := range 2 {
+		if i > 0 {
+			// A quiet profile: the pool refills, then its idle connection
+			// ages out before the next borrow.
+			time.Sleep(60 * time.Millisecond)
+			if err := p.fillOne(); err != nil {
+				t.Fatalf("fillOne: %v", err)
+			}
+			_ = (<-p.conns).conn.Close()
+		}
+		conn, info, err := p.BorrowWithInfo(context.Background())
+		if err != nil || !info.Fresh {
+			t.Fatalf("expected a fresh borrow, got fresh=%v err=%v", info.Fresh, err)
+		}
+		_ = conn.Close()
+	}
+
+	if strings.Contains(buf.String(), "backend pool starved") || p.Stats().Starvations != 0 {
+		t.Fatalf("did not expect a starvation warning after a refill, got: %s", buf.String())
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	open *atomic.Int64
+	once sync.Once
+}
+
+func (c *countingConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+func TestCloseDuringConcurrentBorrowsLeavesNoConnectionsOpen(t *testing.T) {
+	t.Parallel()
+
+	var open atomic.Int64
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		_ = remote.Close()
+		open.Add(1)
+		return newClientConnFromNetConn(&countingConn{Conn: local, open: &open}), time.Time{}, nil
+	}
+	p := NewBackendPool(4, time.Hour, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)), factory)
+	p.Start(context.Background())
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				conn, err := p.Borrow(context.Background())
+				if errors.Is(err, errPoolClosed) {
+					return
+				}
+				if err == nil {
+					_ = conn.Close()
+				}
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	p.Close()
+	close(stop)
+	wg.Wait()
+
+	if _, err := p.Borrow(context.Background()); !errors.Is(err, errPoolClosed) {
+		t.Fatalf("expected errPoolClosed after Close, got %v", err)
+	}
+	// Refills started before Close finish in the background.
+	deadline := time.Now().Add(2 * time.Second)
+	for open.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected every connection closed after Close, %d still open", open.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if idle := p.Stats().Idle; idle != 0 {
+		t.Fatalf("expected empty pool after Close, idle=%d", idle)
+	}
+}
+
+func TestInvalidateDropsIdleAndDiscardsInFlightDials(t *testing.T) {
+	t.Parallel()
+
+	var open atomic.Int64
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		open.Add(1)
+		return newClientConnFromNetConn(&countingConn{Conn: local, open: &open}), time.Time{}, nil
+	}
+	p := NewBackendPool(2, time.Minute, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)), factory)
+	defer p.Close()
+	if err := p.fillOne(); err != nil {
+		t.Fatalf("fillOne: %v", err)
+	}
+
+	// A dial that started before Invalidate must not be pooled afterwards.
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	p.factory = func(ctx context.Context) (*client.Conn, time.Time, error) {
+		started <- struct{}{}
+		<-release
+		return factory(ctx)
+	}
+	inFlight := make(chan error, 1)
+	go func() { inFlight <- p.fillOne() }()
+	<-started
+
+	if !p.Invalidate() {
+		t.Fatal("expected Invalidate to drop the pool")
+	}
+	if p.Invalidate() {
+		t.Fatal("expected a second Invalidate within the minimum interval to be ignored")
+	}
+	if got := open.Load(); got != 0 {
+		t.Fatalf("expected the idle connection to be closed, %d open", got)
+	}
+	close(release)
+	if err := <-inFlight; err != nil {
+		t.Fatalf("in-flight fillOne: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(p.conns) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the pool to refill after Invalidate, idle=%d", len(p.conns))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := open.Load(); got != 2 {
+		t.Fatalf("expected only the two refilled connections open, got %d", got)
+	}
+	if got := p.Stats().Invalidations; got != 1 {
+		t.Fatalf("Invalidations = %d, want 1", got)
+	}
+}
+
+func TestRefreshRebuildsPoolAndCoalescesRepeats(t *testing.T) {
+	t.Parallel()
+
+	var open, dials atomic.Int64
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+		open.Add(1)
+		dials.Add(1)
+		return newClientConnFromNetConn(&countingConn{Conn: local, open: &open}), time.Time{}, nil
+	}
+	p := NewBackendPool(2, time.Minute, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)), factory)
+	for range 2 {
+		if err := p.fillOne(); err != nil {
+			t.Fatalf("fillOne: %v", err)
+		}
+	}
+
+	waitRefilled := func(wantDials int64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for len(p.conns) < 2 || dials.Load() < wantDials {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the pool to refill, idle=%d dials=%d", len(p.conns), dials.Load())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if !p.Refresh() {
+		t.Fatal("expected the pool to be rebuilt")
+	}
+	waitRefilled(4)
+	// A repeat inside the rate limit, from a refresh or an invalidation,
+	// is coalesced into the rebuild already done.
+	if p.Refresh() || p.Invalidate() {
+		t.Fatal("expected repeats within invalidateMinInterval to be ignored")
+	}
+	p.mu.Lock()
+	p.invalidatedAt = time.Now().Add(-invalidateMinInterval)
+	p.mu.Unlock()
+	if !p.Refresh() {
+		t.Fatal("expected a refresh after the rate limit to rebuild the pool")
+	}
+	waitRefilled(6)
+	if got := dials.Load(); got != 6 {
+		t.Fatalf("expected one refill per accepted refresh, got %d dials", got)
+	}
+	if got := open.Load(); got != 2 {
+		t.Fatalf("expected only the two newest connections open, got %d", got)
+	}
+	if got := p.Stats().Invalidations; got != 0 {
+		t.Fatalf("Invalidations = %d, want 0 for operator refreshes", got)
+	}
+
+	p.Close()
+	if p.Refresh() {
+		t.Fatal("expected Refresh on a closed pool to report false")
+	}
+}
+
+func TestPoolLogsBackendServerInfoOnce(t *testing.T) {
+	t.Parallel()
+
+	backendAddr := freeTCPAddr(t)
+	stopBackend := startFakeBackend(t, backendAddr, "backend_user", "backend_pass")
+	defer stopBackend()
+
+	var logs syncBuffer
+	p := NewBackendPool(2, time.Minute, time.Second, slog.New(slog.NewTextHandler(&logs, nil)), func(ctx context.Context) (*client.Conn, time.Time, error) {
+		return noTokenExpiry(client.ConnectWithContext(ctx, backendAddr, "backend_user", "backend_pass", "", 2*time.Second))
+	})
+	defer p.Close()
+	for range 2 {
+		if err := p.fillOne(); err != nil {
+			t.Fatalf("fillOne: %v", err)
+		}
+	}
+
+	got := logs.String()
+	if n := strings.Count(got, `msg="backend server info"`); n != 1 {
+		t.Fatalf("expected one backend server info line, got %d:\n%s", n, got)
+	}
+	if !strings.Contains(got, "server_version=") || strings.Contains(got, `server_version=""`) || !strings.Contains(got, "tls_version=none") || !strings.Contains(got, "remote_addr="+backendAddr) {
+		t.Fatalf("unexpected backend server info: %s", got)
+	}
+}
+
+func TestStartSpreadsPrewarmsAndMaxLives(t *testing.T) {
+	t.Parallel()
+
+	const size = 4
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var (
+		mu     sync.Mutex
+		starts []time.Time
+	)
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		local, remote := net.Pipe()
+		t.Cleanup(func() { _ = remote.Close() })
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	}
+	p := NewBackendPool(size, 10*time.Minute, time.Second, logger, factory)
+	p.prewarmJitter = 200 * time.Millisecond
+	defer p.Close()
+	p.Start(context.Background())
+	for range size {
+		select {
+		case err := <-p.startResults:
+			if err != nil {
+				t.Fatalf("prewarm: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("prewarms did not finish")
+		}
+	}
+
+	mu.Lock()
+	first, last := starts[0], starts[0]
+	for _, s := range starts {
+		if s.Before(first) {
+			first = s
+		}
+		if s.After(last) {
+			last = s
+		}
+	}
+	mu.Unlock()
+	if last.Sub(first) < time.Millisecond {
+		t.Fatalf("expected prewarm starts spread over the jitter window, got %s between first and last", last.Sub(first))
+	}
+
+	created := map[time.Time]bool{}
+	for range size {
+		pooled := <-p.conns
+		if age := time.Since(pooled.createdAt); age > time.Minute+time.Second {
+			t.Fatalf("createdAt backdated by %s, more than maxLife/%d", age, maxLifeJitterDivisor)
+		}
+		created[pooled.createdAt] = true
+		_ = pooled.conn.Close()
+	}
+	if len(created) != size {
+		t.Fatalf("expected %d distinct createdAt values, got %d", size, len(created))
+	}
+}
+
+func TestPoolStartBoundsPrewarmConcurrency(t *testing.T) {
+	t.Parallel()
+
+	const size = 6
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var inFlight, peak atomic.Int32
+	factory := func(context.Context) (*client.Conn, time.Time, error) {
+		n := inFlight.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		inFlight.Add(-1)
+		local, remote := net.Pipe()
+		t.Cleanup(func() { _ = remote.Close() })
+		return newClientConnFromNetConn(local), time.Time{}, nil
+	}
+	p := NewBackendPool(size, 10*time.Minute, time.Second, logger, factory)
+	p.prewarmJitter = 0
+	p.SetPrewarmConcurrency(2)
+	defer p.Close()
+	p.Start(context.Background())
+	for range size {
+		select {
+		case err := <-p.startResults:
+			if err != nil {
+				t.Fatalf("prewarm: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("prewarms did not finish")
+		}
+	}
+	if got := peak.Load(); got != 2 {
+		t.Fatalf("expected at most 2 concurrent prewarm connects, peak was %d", got)
+	}
+	if got := p.Stats().Idle; got != size {
+		t.Fatalf("expected the pool filled to %d, got %d", size, got)
+	}
+}
@@ -2,17 +2,26 @@ package proxy
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/netip"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"rds-iam-proxy/internal/config"
 
+	"github.com/go-mysql-org/go-mysql/client"
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/server"
 )
@@ -20,77 +29,287 @@ import (
 type Proxy struct {
 	profile         config.Profile
 	logger          *slog.Logger
-	pool            *BackendPool
+	routes          map[string]*route
+	creds           *server.InMemoryProvider
+	clientServer    *server.Server
 	shutdownTimeout time.Duration
 	maxConns        int
 	sem             chan struct{}
 	nextConnID      atomic.Uint64
 	activeMu        sync.RWMutex
 	active          map[uint64]*trackedConn
-	ln              net.Listener
 	wg              sync.WaitGroup
+	drainRejects    sync.WaitGroup // rejectDraining calls started by acceptLoop
+	allowedClients  []netip.Prefix // nil allows all; non-nil empty denies all
+	accessLog       *AccessLog
+	requestIDs      string // RequestIDCounter or RequestIDUUID
+	authFails       authFailures
+	acceptErrs      atomic.Uint64                                            // failed Accept calls since start
+	acceptBackoff   time.Duration                                            // longest pause between failing Accept calls
+	lookupHost      func(ctx context.Context, host string) ([]string, error) // aurora_failover_aware endpoint checks
+	bound           chan struct{}                                            // closed once Run has bound every listener
+	boundAddrs      []string                                                 // set before bound is closed
+}
+
+// route binds a proxy_user to the profile and backend pool serving it.
+type route struct {
+	profile        config.Profile
+	logger         *slog.Logger
+	pool           *BackendPool
+	inspector      *commandInspector
+	dialCompressed func(context.Context) (*client.Conn, error) // allow_compression only
+	unavailableLog *logDedup
+}
+
+func newRoute(p config.Profile, logger *slog.Logger, pool *BackendPool) *route {
+	return &route{profile: p, logger: logger, pool: pool, inspector: newCommandInspector(p), unavailableLog: newLogDedup(logger, logDedupWindow)}
+}
+
+// backend returns a backend connection matching the client's compression.
+// Pooled connections are uncompressed, so compressed clients always dial.
+func (rt *route) backend(ctx context.Context, compressed bool) (*client.Conn, BorrowInfo, error) {
+	if !compressed {
+		return rt.pool.BorrowWithInfo(ctx)
+	}
+	if rt.dialCompressed == nil {
+		return nil, BorrowInfo{}, errors.New("client negotiated compression but no compressed backend dialer is set")
+	}
+	start := time.Now()
+	conn, err := rt.dialCompressed(ctx)
+	return conn, BorrowInfo{Fresh: true, Wait: time.Since(start)}, err
 }
 
 type trackedConn struct {
-	client    net.Conn
-	backend   net.Conn
-	startedAt time.Time
+	client     net.Conn
+	backend    net.Conn
+	startedAt  time.Time
+	profile    string       // set once the client is routed
+	longWarned atomic.Bool  // long_session_warn_after already logged
+	serverConn *server.Conn // set after client auth; used for the shutdown ERR
 }
 
+// New builds a proxy for p's listener. maxConns caps concurrent clients; 0
+// uses 200 and config.MaxConnsUnlimited removes the cap.
 func New(p config.Profile, logger *slog.Logger, pool *BackendPool, shutdownTimeout time.Duration, maxConns int) *Proxy {
-	if maxConns <= 0 {
+	if maxConns == 0 {
 		maxConns = 200
 	}
+	var sem chan struct{}
+	if maxConns > 0 {
+		sem = make(chan struct{}, maxConns)
+	}
+	creds := server.NewInMemoryProvider()
+	creds.AddUser(p.ProxyUser, p.ProxyPassword)
+	srv, tlsCfg, err := newClientServer(p)
+	switch {
+	case err != nil:
+		logger.Warn("custom client handshake settings unavailable; using default", "error", err)
+		srv = defaultClientServer()
+	case p.ClientTLSAutocert:
+		fp := sha256.Sum256(tlsCfg.Certificates[0].Certificate[0])
+		logger.Info("generated self-signed client TLS certificate", "hosts", strings.Join(autocertHosts, ","), "sha256_fingerprint", hex.EncodeToString(fp[:]))
+	}
+	var allowed []netip.Prefix
+	if len(p.AllowedClients) > 0 {
+		allowed, err = config.ParseAllowedClients(p.AllowedClients)
+		if err != nil {
+			// Fail closed: a broken allow list must not admit everyone.
+			logger.Error("invalid allowed_clients; rejecting all clients", "error", err)
+			allowed = []netip.Prefix{}
+		}
+	}
 	return &Proxy{
 		profile:         p,
 		logger:          logger,
-		pool:            pool,
+		routes:          map[string]*route{p.ProxyUser: newRoute(p, logger, pool)},
+		creds:           creds,
+		clientServer:    srv,
 		shutdownTimeout: shutdownTimeout,
 		maxConns:        maxConns,
-		sem:             make(chan struct{}, maxConns),
+		sem:             sem,
 		active:          make(map[uint64]*trackedConn),
+		allowedClients:  allowed,
+		acceptBackoff:   maxAcceptBackoff,
+		lookupHost:      net.DefaultResolver.LookupHost,
+		bound:           make(chan struct{}),
 	}
 }
 
-func (p *Proxy) Run(ctx context.Context) error {
-	defer p.pool.Close()
+// AuthFailures returns client auth failure counts by class since start.
+func (p *Proxy) AuthFailures() AuthFailureStats {
+	return p.authFails.stats()
+}
 
-	ln, err := net.Listen("tcp", p.profile.ListenAddr)
-	if err != nil {
-		return err
+// AcceptErrors returns how many times accepting a client connection failed
+// since start.
+func (p *Proxy) AcceptErrors() uint64 {
+	return p.acceptErrs.Load()
+}
+
+// SetCompressedDialer sets how the named profile connects to its backend for
+// clients that negotiated compression under allow_compression. It must be
+// called before Run.
+func (p *Proxy) SetCompressedDialer(profile string, dial func(context.Context) (*client.Conn, error)) error {
+	rt := p.routeForProfile(profile)
+	if rt == nil {
+		return fmt.Errorf("profile %q is not served by listener %q", profile, p.profile.ListenAddr)
 	}
-	p.ln = ln
-	p.logger.Info("proxy listening", "listen_addr", p.profile.ListenAddr, "rds_host", p.profile.RDSHost, "rds_port", p.profile.RDSPort, "max_conns", p.maxConns)
+	rt.dialCompressed = dial
+	return nil
+}
 
-	go func() {
-		<-ctx.Done()
-		_ = p.ln.Close()
-	}()
+// SetAccessLog writes one access log line per completed connection. It must
+// be called before Run; nil disables the access log.
+func (p *Proxy) SetAccessLog(a *AccessLog) {
+	p.accessLog = a
+}
 
-	for {
-		conn, err := p.ln.Accept()
+// SetRequestIDFormat sets how connections are identified in logs. With
+// RequestIDUUID each connection also gets a random request_id, which
+// label_client_session exposes on the backend session. It must be called
+// before Run.
+func (p *Proxy) SetRequestIDFormat(format string) {
+	p.requestIDs = format
+}
+
+// AddRoute serves another profile on this proxy's listener; clients are routed
+// by the proxy_user they authenticate as. The listener's connection cap grows
+// by maxConns, and an unlimited route makes the whole listener unlimited. It
+// must be called before Run.
+func (p *Proxy) AddRoute(prof config.Profile, logger *slog.Logger, pool *BackendPool, maxConns int) error {
+	if prof.ListenAddr != p.profile.ListenAddr {
+		return fmt.Errorf("profile %q listens on %q, not %q", prof.Name, prof.ListenAddr, p.profile.ListenAddr)
+	}
+	if prof.ServerVersion != p.profile.ServerVersion {
+		return fmt.Errorf("profile %q server_version %q differs from %q on shared listener %q", prof.Name, prof.ServerVersion, p.profile.ServerVersion, p.profile.ListenAddr)
+	}
+	if prof.ListenFamily != p.profile.ListenFamily {
+		return fmt.Errorf("profile %q listen_family differs from profile %q on shared listener %q", prof.Name, p.profile.Name, p.profile.ListenAddr)
+	}
+	if prof.AllowCompression != p.profile.AllowCompression {
+		return fmt.Errorf("profile %q allow_compression differs from profile %q on shared listener %q", prof.Name, p.profile.Name, p.profile.ListenAddr)
+	}
+	if prof.AuthTimeout != p.profile.AuthTimeout {
+		return fmt.Errorf("profile %q auth_timeout %s differs from %s on shared listener %q", prof.Name, prof.AuthTimeout, p.profile.AuthTimeout, p.profile.ListenAddr)
+	}
+	if prof.SessionSetupTimeout != p.profile.SessionSetupTimeout {
+		return fmt.Errorf("profile %q session_setup_timeout %s differs from %s on shared listener %q", prof.Name, prof.SessionSetupTimeout, p.profile.SessionSetupTimeout, p.profile.ListenAddr)
+	}
+	if !slices.Equal(prof.AllowedClients, p.profile.AllowedClients) {
+		return fmt.Errorf("profile %q allowed_clients differs from profile %q on shared listener %q", prof.Name, p.profile.Name, p.profile.ListenAddr)
+	}
+	if prof.ProxyProtocol != p.profile.ProxyProtocol {
+		return fmt.Errorf("profile %q proxy_protocol differs from profile %q on shared listener %q", prof.Name, p.profile.Name, p.profile.ListenAddr)
+	}
+	if prof.ClientTLSAutocert != p.profile.ClientTLSAutocert {
+		return fmt.Errorf("profile %q client_tls_autocert differs from profile %q on shared listener %q", prof.Name, p.profile.Name, p.profile.ListenAddr)
+	}
+	if prof.DrainMode != p.profile.DrainMode {
+		return fmt.Errorf("profile %q drain_mode differs from profile %q on shared listener %q", prof.Name, p.profile.Name, p.profile.ListenAddr)
+	}
+	if !slices.Equal(prof.AllowedClientAuthPlugins, p.profile.AllowedClientAuthPlugins) {
+		return fmt.Errorf("profile %q allowed_client_auth_plugins differs from profile %q on shared listener %q", prof.Name, p.profile.Name, p.profile.ListenAddr)
+	}
+	if prev, ok := p.routes[prof.ProxyUser]; ok {
+		return fmt.Errorf("proxy_user %q is reused by profiles %q and %q", prof.ProxyUser, prev.profile.Name, prof.Name)
+	}
+	p.routes[prof.ProxyUser] = newRoute(prof, logger, pool)
+	p.creds.AddUser(prof.ProxyUser, prof.ProxyPassword)
+	if maxConns == 0 {
+		maxConns = 200
+	}
+	if maxConns < 0 || p.maxConns < 0 {
+		p.maxConns, p.sem = config.MaxConnsUnlimited, nil
+		return nil
+	}
+	p.maxConns += maxConns
+	p.sem = make(chan struct{}, p.maxConns)
+	return nil
+}
+
+func (p *Proxy) Run(ctx context.Context) error {
+	defer p.closePools()
+
+	// A localhost listen_addr binds both loopback families; each listener
+	// feeds the same accept loop, sem and connection IDs.
+	addrs := p.profile.ListenAddrs()
+	lns := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
 		if err != nil {
-			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
-				break
+			for _, l := range lns {
+				_ = l.Close()
 			}
-			p.logger.Warn("accept failed", "error", err)
-			continue
+			return p.bindError(addr, err)
 		}
+		lns = append(lns, ln)
+	}
+	for _, ln := range lns {
+		p.boundAddrs = append(p.boundAddrs, ln.Addr().String())
+	}
+	// Routes are fixed once Run starts, so the listener's cap is final.
+	for _, rt := range p.routes {
+		if rt.inspector != nil {
+			rt.inspector.setMaxConns(p.maxConns)
+		}
+	}
+	close(p.bound)
+	listenAddr := strings.Join(addrs, ",")
+	if len(p.routes) == 1 {
+		p.logger.Info("proxy listening", "listen_addr", listenAddr, "rds_host", strings.Join(p.profile.BackendHosts(), ","), "rds_port", p.profile.RDSPort, "max_conns", p.maxConns)
+	} else {
+		for _, rt := range p.routes {
+			rt.logger.Info("proxy listening", "listen_addr", listenAddr, "rds_host", strings.Join(rt.profile.BackendHosts(), ","), "rds_port", rt.profile.RDSPort, "proxy_user", rt.profile.ProxyUser, "shared_max_conns", p.maxConns)
+		}
+	}
 
-		select {
-		case p.sem <- struct{}{}:
-		case <-ctx.Done():
-			_ = conn.Close()
-			return nil
+	rejectOnDrain := p.profile.DrainMode == config.DrainRejectWithError
+	go func() {
+		<-ctx.Done()
+		for _, ln := range lns {
+			// Expiring the deadline stops the accept loops but keeps the
+			// socket open for rejectWhileDraining.
+			if d, ok := ln.(interface{ SetDeadline(time.Time) error }); ok && rejectOnDrain {
+				_ = d.SetDeadline(time.Now())
+				continue
+			}
+			_ = ln.Close()
 		}
+	}()
+	if interval := p.longSessionCheckInterval(); interval > 0 {
+		go p.watchLongSessions(ctx, interval)
+	}
+	for _, rt := range p.routes {
+		if rt.profile.AuroraFailoverAware {
+			go p.watchAuroraEndpoint(ctx, rt, auroraResolveInterval)
+		}
+	}
 
-		connID := p.nextConnID.Add(1)
-		p.wg.Add(1)
-		go func(c net.Conn, id uint64) {
-			defer p.wg.Done()
-			defer func() { <-p.sem }()
-			p.handleConn(ctx, c, id)
-		}(conn, connID)
+	var (
+		accepting sync.WaitGroup
+		failOnce  sync.Once
+		acceptErr error
+	)
+	for _, ln := range lns {
+		accepting.Add(1)
+		go func(ln net.Listener) {
+			defer accepting.Done()
+			if err := p.acceptLoop(ctx, ln); err != nil {
+				// One broken listener stops the profile; clients that got
+				// through drain as on shutdown.
+				failOnce.Do(func() {
+					acceptErr = err
+					for _, l := range lns {
+						_ = l.Close()
+					}
+				})
+			}
+		}(ln)
+	}
+	accepting.Wait()
+	if rejectOnDrain && acceptErr == nil {
+		stopRejecting := p.rejectWhileDraining(lns)
+		defer stopRejecting()
 	}
 
 	done := make(chan struct{})
@@ -101,119 +320,773 @@ func (p *Proxy) Run(ctx context.Context) error {
 
 	select {
 	case <-done:
-		return nil
+		return acceptErr
 	case <-time.After(p.shutdownTimeout):
 		activeCount, oldestAge := p.activeSummary()
+		notified := p.notifyShutdown(500 * time.Millisecond)
 		forced := p.forceCloseActive()
 		p.logger.Warn(
 			"shutdown timeout hit; forcing active connection close",
 			"active_count", activeCount,
 			"oldest_age_ms", oldestAge.Milliseconds(),
+			"shutdown_errors_sent", notified,
 			"forced_closes", forced,
 		)
 		select {
 		case <-done:
-			return nil
 		case <-time.After(2 * time.Second):
-			return nil
 		}
+		return acceptErr
 	}
 }
 
+// Accept error handling: consecutive failures back off from minAcceptBackoff,
+// doubling up to maxAcceptBackoff, and after maxAcceptFailures in a row the
+// listener is given up on.
+const (
+	minAcceptBackoff  = 5 * time.Millisecond
+	maxAcceptBackoff  = time.Second
+	maxAcceptFailures = 50
+)
+
+// acceptLoop hands connections from ln to handleConn until ln is closed. It
+// returns an error only when Accept keeps failing, after maxAcceptFailures
+// consecutive failures; warnings are logged for the first failure and every
+// tenth after it.
+func (p *Proxy) acceptLoop(ctx context.Context, ln net.Listener) error {
+	var failures int
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				return nil
+			}
+			p.acceptErrs.Add(1)
+			failures++
+			if failures >= maxAcceptFailures {
+				p.logger.Error("listener unhealthy; giving up after repeated accept failures", "listen_addr", ln.Addr().String(), "consecutive_failures", failures, "error", err)
+				return fmt.Errorf("accept on %s failed %d times in a row: %w", ln.Addr(), failures, err)
+			}
+			backoff = min(max(2*backoff, minAcceptBackoff), p.acceptBackoff)
+			if failures == 1 || failures%10 == 0 {
+				p.logger.Warn("accept failed", "error", err, "consecutive_failures", failures, "backoff_ms", backoff.Milliseconds())
+			}
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		failures, backoff = 0, 0
+		if !p.clientAllowed(conn.RemoteAddr()) {
+			p.logger.Warn("client rejected by allowed_clients", "remote_addr", conn.RemoteAddr().String())
+			_ = conn.Close()
+			continue
+		}
+		// Shutdown began after this client connected but before the
+		// listener stopped; it must not get a session on a dead ctx.
+		if ctx.Err() != nil {
+			if p.profile.DrainMode == config.DrainRejectWithError {
+				p.drainRejects.Add(1)
+				go func(c net.Conn) {
+					defer p.drainRejects.Done()
+					p.rejectDraining(c, p.nextConnID.Add(1))
+				}(conn)
+			} else {
+				_ = conn.Close()
+			}
+			continue
+		}
+
+		// A nil sem means max_conns is unlimited.
+		if p.sem != nil {
+			select {
+			case p.sem <- struct{}{}:
+			case <-ctx.Done():
+				_ = conn.Close()
+				return nil
+			}
+		}
+
+		connID := p.nextConnID.Add(1)
+		p.wg.Add(1)
+		go func(c net.Conn, id uint64) {
+			defer p.wg.Done()
+			if p.sem != nil {
+				defer func() { <-p.sem }()
+			}
+			p.handleConn(ctx, c, id)
+		}(conn, connID)
+	}
+}
+
+// drainRejectTimeout bounds the handshake of a client rejected during drain
+// when auth_timeout is unset.
+const drainRejectTimeout = 5 * time.Second
+
+// rejectWhileDraining keeps accepting on lns under drain_mode
+// reject-with-error: each client is authenticated and then sent
+// ER_SERVER_SHUTDOWN, so it gets a clear reason instead of connection
+// refused. Rejected clients take no max_conns slot and do not hold the drain
+// open. The returned stop closes lns and waits for rejections in progress.
+func (p *Proxy) rejectWhileDraining(lns []net.Listener) (stop func()) {
+	p.logger.Info("draining; rejecting new connections with server shutdown error")
+	var wg sync.WaitGroup
+	for _, ln := range lns {
+		if d, ok := ln.(interface{ SetDeadline(time.Time) error }); ok {
+			_ = d.SetDeadline(time.Time{})
+		}
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			for {
+				conn, err := ln.Accept()
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				if err != nil {
+					time.Sleep(minAcceptBackoff)
+					continue
+				}
+				if !p.clientAllowed(conn.RemoteAddr()) {
+					_ = conn.Close()
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.rejectDraining(conn, p.nextConnID.Add(1))
+				}()
+			}
+		}(ln)
+	}
+	return func() {
+		for _, ln := range lns {
+			_ = ln.Close()
+		}
+		wg.Wait()
+		p.drainRejects.Wait()
+	}
+}
+
+// rejectDraining authenticates clientConn and answers it with
+// ER_SERVER_SHUTDOWN. Compression is not offered, so the ERR needs no
+// framing beyond the plain protocol.
+func (p *Proxy) rejectDraining(clientConn net.Conn, connID uint64) {
+	startedAt := time.Now()
+	defer clientConn.Close()
+	timeout := p.profile.AuthTimeout
+	if timeout <= 0 {
+		timeout = drainRejectTimeout
+	}
+	_ = clientConn.SetDeadline(startedAt.Add(timeout))
+	if p.profile.ProxyProtocol {
+		conn, err := readProxyHeader(clientConn)
+		if err != nil {
+			return
+		}
+		clientConn = conn
+	}
+	log := p.logger.With("conn_id", connID, "remote_addr", clientConn.RemoteAddr().String())
+	access := AccessLogEntry{Time: startedAt, RemoteAddr: clientConn.RemoteAddr().String(), CloseReason: closeDrainRejected}
+	serverConn, _, err := handshake(clientConn, p.clientServer, p.creds, false, p.profile.AllowedClientAuthPlugins)
+	if err != nil {
+		log.Debug("client handshake during drain failed", "error", err)
+		access.CloseReason = closeAuthFailed
+	} else {
+		if rt, ok := p.routes[serverConn.GetUser()]; ok {
+			access.Profile, access.ProxyUser = rt.profile.Name, rt.profile.ProxyUser
+		}
+		serverConn.ResetSequence()
+		if err := writeErrPacket(serverConn, mysql.ER_SERVER_SHUTDOWN, "server shutting down"); err != nil {
+			logUndeliveredErr(log, mysql.ER_SERVER_SHUTDOWN, err)
+		}
+		log.Info("connection rejected: draining")
+	}
+	if p.accessLog != nil {
+		access.Duration = time.Since(startedAt)
+		p.accessLog.Log(access)
+	}
+}
+
+// bindError names the profiles served by this listener and, for a port
+// already in use, hints at the usual cause.
+func (p *Proxy) bindError(addr string, err error) error {
+	names := make([]string, 0, len(p.routes))
+	for _, rt := range p.routes {
+		names = append(names, rt.profile.Name)
+	}
+	slices.Sort(names)
+	err = fmt.Errorf("profile %s: listen on %s: %w", strings.Join(names, ","), addr, err)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		err = fmt.Errorf("%w (is another rds-iam-proxy already running on this port?)", err)
+	}
+	return err
+}
+
+// clientAllowed reports whether remote matches allowed_clients. Without an
+// allow list every accepted connection proceeds.
+func (p *Proxy) clientAllowed(remote net.Addr) bool {
+	if p.allowedClients == nil {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(remote.String())
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range p.allowedClients {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Proxy) handleConn(ctx context.Context, clientConn net.Conn, connID uint64) {
 	startedAt := time.Now()
+	// Bound the handshake so a silent client cannot hold a goroutine and a
+	// max_conns slot; the deadline is cleared once auth succeeds.
+	var authDeadline time.Time
+	if p.profile.AuthTimeout > 0 {
+		authDeadline = startedAt.Add(p.profile.AuthTimeout)
+		_ = clientConn.SetDeadline(authDeadline)
+	}
+	// session_setup_timeout bounds everything up to the pipe: auth, borrow,
+	// schema switch and session label.
+	var setupDeadline time.Time
+	if p.profile.SessionSetupTimeout > 0 {
+		setupDeadline = startedAt.Add(p.profile.SessionSetupTimeout)
+		if authDeadline.IsZero() || setupDeadline.Before(authDeadline) {
+			_ = clientConn.SetDeadline(setupDeadline)
+		}
+	}
+	setupExpired := func() bool {
+		return !setupDeadline.IsZero() && !time.Now().Before(setupDeadline)
+	}
 	p.trackClient(connID, clientConn, startedAt)
 	defer p.untrack(connID)
+	peerAddr := clientConn.RemoteAddr()
+	var headerErr error
+	if p.profile.ProxyProtocol {
+		// Like the handshake, a header still in flight is dropped on shutdown.
+		peer := clientConn
+		stop := context.AfterFunc(ctx, func() { _ = peer.Close() })
+		clientConn, headerErr = readProxyHeader(peer)
+		stop()
+		p.trackClient(connID, clientConn, startedAt)
+	}
 
-	log := p.logger.With("conn_id", connID, "remote_addr", clientConn.RemoteAddr().String())
+	logAttrs := []any{"conn_id", connID, "remote_addr", clientConn.RemoteAddr().String()}
+	if p.profile.ProxyProtocol {
+		logAttrs = append(logAttrs, "peer_addr", peerAddr.String())
+	}
+	var requestID string
+	if p.requestIDs == RequestIDUUID {
+		requestID = newRequestUUID()
+		logAttrs = append(logAttrs, "request_id", requestID)
+	}
+	log := p.logger.With(logAttrs...)
 	log.Info("connection accepted")
+	access := AccessLogEntry{Time: startedAt, RemoteAddr: clientConn.RemoteAddr().String(), CloseReason: closeNormal}
+	if len(p.routes) == 1 {
+		access.Profile = p.profile.Name
+	}
 	defer clientConn.Close()
 	defer func() {
 		log.Info("connection closed", "duration_ms", time.Since(startedAt).Milliseconds())
+		if p.accessLog != nil {
+			access.Duration = time.Since(startedAt)
+			p.accessLog.Log(access)
+		}
 	}()
 
-	serverConn, err := authenticateClient(clientConn, p.profile)
+	if headerErr != nil && ctx.Err() != nil {
+		log.Info("PROXY protocol header aborted by shutdown")
+		access.CloseReason = closeProxyProtocolError
+		return
+	}
+	if headerErr != nil {
+		log.Warn("invalid PROXY protocol header", "error", headerErr)
+		access.CloseReason = closeProxyProtocolError
+		return
+	}
+	serverConn, clientDB, err := authenticateClient(ctx, clientConn, p.clientServer, p.creds, p.profile.AllowCompression, p.profile.AllowedClientAuthPlugins)
+	if err != nil && ctx.Err() != nil {
+		log.Info("client handshake aborted by shutdown")
+		access.CloseReason = closeAuthFailed
+		return
+	}
+	if err != nil && setupExpired() && (authDeadline.IsZero() || setupDeadline.Before(authDeadline)) {
+		log.Warn("session setup timed out", "stage", "auth", "session_setup_timeout_ms", p.profile.SessionSetupTimeout.Milliseconds())
+		access.CloseReason = closeSetupTimeout
+		return
+	}
 	if err != nil {
-		log.Warn("client auth failed", "error", err)
+		if !authDeadline.IsZero() && !time.Now().Before(authDeadline) {
+			log.Warn("client auth timed out", "auth_timeout_ms", p.profile.AuthTimeout.Milliseconds())
+		}
+		class := classifyAuthError(err)
+		log.Warn("client auth failed", "reason", class, "error", err)
+		ip := remoteIP(clientConn.RemoteAddr())
+		if n := p.authFails.record(class, ip, time.Now()); n > 0 {
+			log.Warn("repeated client auth failures", "remote_ip", ip, "failures", n, "window_ms", authFailWindow.Milliseconds())
+		}
+		access.CloseReason = closeAuthFailed
 		return
 	}
+	_ = clientConn.SetDeadline(time.Time{})
+	p.trackServerConn(connID, serverConn)
+	rt, ok := p.routes[serverConn.GetUser()]
+	if !ok {
+		// Unreachable with InMemoryProvider: unknown users fail authentication.
+		log.Error("no route for authenticated user", "proxy_user", serverConn.GetUser())
+		access.CloseReason = closeNoRoute
+		return
+	}
+	access.Profile, access.ProxyUser = rt.profile.Name, rt.profile.ProxyUser
+	if len(p.routes) > 1 {
+		log = rt.logger.With(logAttrs...)
+		log.Debug("connection routed", "proxy_user", rt.profile.ProxyUser)
+	}
 
-	backendConn, err := p.pool.Borrow(ctx)
+	// The client has its auth OK and is waiting on its first command, so a
+	// setup timeout from here on answers that command with an error.
+	failSetup := func(stage string) {
+		log.Warn("session setup timed out", "stage", stage, "session_setup_timeout_ms", p.profile.SessionSetupTimeout.Milliseconds())
+		msg := fmt.Sprintf("rds-iam-proxy could not set up the session within session_setup_timeout (%s)", p.profile.SessionSetupTimeout)
+		if _, err := respondDeferredErr(serverConn, mysql.ER_CON_COUNT_ERROR, msg); err != nil {
+			logUndeliveredErr(log, mysql.ER_CON_COUNT_ERROR, err)
+		}
+		access.CloseReason = closeSetupTimeout
+	}
+	setupCtx := ctx
+	if !setupDeadline.IsZero() {
+		var cancel context.CancelFunc
+		setupCtx, cancel = context.WithDeadline(ctx, setupDeadline)
+		defer cancel()
+	}
+
+	compressed := clientCompressed(serverConn)
+	backendConn, borrow, err := rt.backend(setupCtx, compressed)
+	if err != nil && ctx.Err() == nil && setupExpired() {
+		failSetup("backend")
+		return
+	}
 	if err != nil {
-		log.Error("backend unavailable", "error", err)
-		respondBackendUnavailable(serverConn)
+		rt.unavailableLog.Log(log, slog.LevelError, "backend unavailable", errReason(err), "error", err)
+		cmd, err := respondBackendUnavailable(serverConn, rt.profile.BackendUnavailableMessage)
+		if err != nil {
+			logUndeliveredErr(log, mysql.ER_CON_COUNT_ERROR, err)
+		}
+		if rt.profile.LogUnavailableQuery && len(cmd) > 0 && cmd[0] == mysql.COM_QUERY {
+			log.Warn("query refused: backend unavailable", "query", truncateQuery(cmd[1:]))
+		}
+		access.CloseReason = closeBackendUnavailable
 		return
 	}
 	defer backendConn.Close() // single-use by design
-	p.trackBackend(connID, backendConn.Conn)
+	source := "pool"
+	switch {
+	case compressed:
+		source = "compressed"
+	case borrow.Fresh:
+		source = "fresh"
+	}
+	log.Debug("backend borrowed", "source", source, "wait_ms", borrow.Wait.Milliseconds())
+	// Track the raw socket: packet.Conn.Close is not safe to call concurrently.
+	p.trackBackend(connID, backendConn.Conn.Conn, rt.profile.Name)
+	if !setupDeadline.IsZero() {
+		_ = backendConn.Conn.Conn.SetDeadline(setupDeadline)
+	}
+
+	// Pooled and fresh backends both start in default_db and are single-use,
+	// so only a different client-requested schema needs switching.
+	if clientDB != "" && clientDB != rt.profile.DefaultDB {
+		if err := backendConn.UseDB(clientDB); err != nil {
+			if setupExpired() {
+				failSetup("select_db")
+				return
+			}
+			log.Warn("client database unavailable", "db", clientDB, "error", err)
+			code, msg := uint16(mysql.ER_BAD_DB_ERROR), fmt.Sprintf("Unknown database '%s'", clientDB)
+			var myErr *mysql.MyError
+			if errors.As(err, &myErr) {
+				code, msg = myErr.Code, myErr.Message
+			}
+			if _, err := respondDeferredErr(serverConn, code, msg); err != nil {
+				logUndeliveredErr(log, code, err)
+			}
+			access.CloseReason = closeDatabaseUnavailable
+			return
+		}
+		log.Debug("client database selected", "db", clientDB)
+	}
+
+	if rt.profile.LabelClientSession {
+		// Best effort: a session without the label still works.
+		if err := labelClientSession(backendConn, clientConn.RemoteAddr(), requestID); err != nil {
+			// A timed-out label leaves its reply unread on the backend.
+			if setupExpired() {
+				failSetup("label_session")
+				return
+			}
+			log.Warn("client session label failed", "error", err)
+		}
+	}
+	if !setupDeadline.IsZero() {
+		_ = backendConn.Conn.Conn.SetDeadline(time.Time{})
+	}
 
 	log.Debug("backend connection acquired")
 
-	up, down, pipeErr := p.pipe(serverConn.Conn, backendConn.Conn)
+	if hasSocketOptions(rt.profile) {
+		for side, conn := range map[string]net.Conn{"client": clientConn, "backend": backendConn.Conn.Conn} {
+			if _, err := applySocketOptions(conn, rt.profile); err != nil {
+				log.Warn("socket tuning failed", "side", side, "error", err)
+			}
+		}
+	}
+
+	if compressed {
+		stopResequence(serverConn)
+	}
+	// Compressed sessions are not parsed, so they cannot trigger a failover.
+	var downstream io.Writer
+	if rt.profile.AuroraFailoverAware && !compressed {
+		downstream = newReadOnlyDetector(func(code uint16, msg string) {
+			log.Warn("backend is read-only; invalidating backend pool for failover", "code", code, "error", msg)
+			rt.pool.Invalidate()
+		})
+	}
+	// Compressed sessions get no notice: a plain ERR would break their framing.
+	// Their packets cannot be followed either, so shutdown leaves them to the
+	// shutdown timeout.
+	var notices pipeNotices
+	if !compressed {
+		notices.shutdown = func() { notifyServerShutdown(serverConn, time.Now().Add(500*time.Millisecond)) }
+		notices.backendLost = func() { notifyBackendLost(serverConn, time.Now().Add(500*time.Millisecond)) }
+	}
+	counters := &transferCounters{}
+	pipeStart := time.Now()
+	if interval := rt.profile.TransferProgressInterval; interval > 0 {
+		stopProgress := logTransferProgress(log, counters, interval)
+		defer stopProgress()
+	}
+	up, down, backendClosed, pipeErr := p.pipe(ctx, serverConn.Conn, backendConn.Conn, rt.inspector, downstream, !compressed, notices, counters)
+	access.BytesUp, access.BytesDown = up, down
+	elapsed := time.Since(pipeStart)
+	transfer := []any{"bytes_up", up, "bytes_down", down, "bytes_up_per_sec", bytesPerSec(up, elapsed), "bytes_down_per_sec", bytesPerSec(down, elapsed)}
+	if pipeErr == nil && ctx.Err() != nil {
+		log.Info("pipe interrupted by shutdown", transfer...)
+		access.CloseReason = closeShutdown
+		return
+	}
 	if pipeErr != nil {
-		log.Warn("pipe ended with error", "error", pipeErr, "bytes_up", up, "bytes_down", down)
+		log.Warn("pipe ended with error", append([]any{"error", pipeErr}, transfer...)...)
+		access.CloseReason = closePipeError
 		return
 	}
-	log.Info("pipe finished", "bytes_up", up, "bytes_down", down)
+	if backendClosed {
+		log.Info("backend closed the connection", transfer...)
+		access.CloseReason = closeBackendClosed
+		return
+	}
+	log.Info("pipe finished", transfer...)
+}
+
+// transferCounters are a session's bytes forwarded so far, updated by pipe
+// as data moves.
+type transferCounters struct {
+	up, down atomic.Int64
+}
+
+// countingReader adds every byte read to n.
+type countingReader struct {
+	io.Reader
+	n *atomic.Int64
+}
+
+func (r countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// bytesPerSec is n over d, or 0 for an empty duration.
+func bytesPerSec(n int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(n) / d.Seconds())
 }
 
-func (p *Proxy) pipe(client net.Conn, backend net.Conn) (int64, int64, error) {
+// logTransferProgress logs c's totals and the rates since the previous line
+// every interval, skipping intervals in which nothing moved so idle sessions
+// stay quiet. The returned stop ends it.
+func logTransferProgress(log *slog.Logger, c *transferCounters, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var lastUp, lastDown int64
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				up, down := c.up.Load(), c.down.Load()
+				if up == lastUp && down == lastDown {
+					last = now
+					continue
+				}
+				elapsed := now.Sub(last)
+				log.Info("pipe progress", "bytes_up", up, "bytes_down", down,
+					"bytes_up_per_sec", bytesPerSec(up-lastUp, elapsed), "bytes_down_per_sec", bytesPerSec(down-lastDown, elapsed))
+				lastUp, lastDown, last = up, down, now
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// pipe forwards traffic both ways until either side closes or ctx ends. A
+// non-nil inspector handles the client->backend direction packet by packet; a
+// non-nil downstream gets a copy of the backend->client direction. When ctx
+// ends, a tracked session is ended as soon as it is idle between commands:
+// pipe runs notices.shutdown once the last reply has been delivered and then
+// expires both connections' deadlines, so queries and result sets in flight
+// finish and idle sessions do not hold the drain open until the shutdown
+// timeout; the resulting timeouts are not reported as errors. An untracked
+// session, whose packets cannot be framed, is left to the shutdown timeout.
+// When the backend closes first, as on wait_timeout or a server restart,
+// pipe runs notices.backendLost while the client is still connected and
+// reports backendClosed. A non-nil counters is updated as bytes move.
+func (p *Proxy) pipe(ctx context.Context, client net.Conn, backend net.Conn, inspector *commandInspector, downstream io.Writer, tracked bool, notices pipeNotices, counters *transferCounters) (up, down int64, backendClosed bool, err error) {
 	type copyResult struct {
-		n   int64
-		err error
+		n           int64
+		err         error
+		fromBackend bool
 	}
 	resCh := make(chan copyResult, 2)
+	quit := &quitTracker{Conn: client}
+	if counters != nil {
+		quit.read = &counters.up
+	}
+
+	var (
+		toBackend io.Writer = backend
+		toClient  io.Writer = client
+		replies   io.Writer = client
+	)
+	interrupted := make(chan struct{})
+	stopInterrupt := func() bool { return true }
+	if tracked {
+		tracker := newCommandTracker(client, backend, notices.shutdown)
+		toBackend, toClient, replies = tracker.toBackend(), tracker.toClient(), tracker.replies()
+		stopInterrupt = context.AfterFunc(ctx, func() {
+			defer close(interrupted)
+			tracker.shutdown()
+		})
+	}
 
 	go func() {
-		n, err := io.Copy(backend, client)
+		var (
+			n   int64
+			err error
+		)
+		if inspector != nil {
+			n, err = inspector.forward(toBackend, struct {
+				io.Reader
+				io.Writer
+			}{quit, replies})
+		} else {
+			n, err = io.Copy(toBackend, quit)
+		}
 		resCh <- copyResult{n: n, err: err}
 	}()
 
 	go func() {
-		n, err := io.Copy(client, backend)
-		resCh <- copyResult{n: n, err: err}
+		var src io.Reader = backend
+		if downstream != nil {
+			src = io.TeeReader(backend, downstream)
+		}
+		if counters != nil {
+			src = countingReader{Reader: src, n: &counters.down}
+		}
+		n, err := io.Copy(toClient, src)
+		resCh <- copyResult{n: n, err: err, fromBackend: true}
 	}()
 
 	first := <-resCh
+	// io.Copy returns nil at EOF; a reset is the backend going away too. The
+	// backend also hangs up after COM_QUIT, often before the client does.
+	backendClosed = first.fromBackend && (first.err == nil || isConnCloseErr(first.err)) && ctx.Err() == nil && !quit.sent.Load()
+	if backendClosed && notices.backendLost != nil {
+		notices.backendLost()
+	}
 	_ = client.Close()
 	_ = backend.Close()
 	second := <-resCh
+	if !stopInterrupt() {
+		<-interrupted
+	}
 
-	up := first.n
-	down := second.n
+	for _, res := range []copyResult{first, second} {
+		if res.fromBackend {
+			down = res.n
+		} else {
+			up = res.n
+		}
+	}
 
-	if first.err != nil && !isConnCloseErr(first.err) {
-		return up, down, first.err
+	// Deadlines expired by the interrupt, commands refused after it, and the
+	// closes that follow are the shutdown itself rather than a pipe failure.
+	wasInterrupted := ctx.Err() != nil
+	for _, res := range []copyResult{first, second} {
+		if res.err == nil || isConnCloseErr(res.err) || (wasInterrupted && (errors.Is(res.err, os.ErrDeadlineExceeded) || errors.Is(res.err, errSessionDrained))) {
+			continue
+		}
+		return up, down, backendClosed, res.err
 	}
-	if second.err != nil && !isConnCloseErr(second.err) {
-		return up, down, second.err
+
+	return up, down, backendClosed, nil
+}
+
+// comQuitPacket is a complete COM_QUIT packet as clients send it.
+var comQuitPacket = [5]byte{1, 0, 0, 0, mysql.COM_QUIT}
+
+// quitTracker notes whether the last bytes read from a client were a
+// COM_QUIT, so pipe can tell a requested close from the backend going away.
+type quitTracker struct {
+	net.Conn
+	tail [len(comQuitPacket)]byte
+	sent atomic.Bool
+	read *atomic.Int64 // optional running total of bytes read
+}
+
+func (c *quitTracker) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if c.read != nil {
+			c.read.Add(int64(n))
+		}
+		if n >= len(c.tail) {
+			copy(c.tail[:], b[n-len(c.tail):n])
+		} else {
+			copy(c.tail[:], c.tail[n:])
+			copy(c.tail[len(c.tail)-n:], b[:n])
+		}
+		c.sent.Store(c.tail == comQuitPacket)
 	}
+	return n, err
+}
 
-	return up, down, nil
+// pipeNotices are the best-effort errors pipe sends the client when the
+// session ends for a reason it cannot see. Nil fields send nothing.
+type pipeNotices struct {
+	shutdown    func() // ctx ended and the session is idle
+	backendLost func() // the backend closed first
 }
 
+func (p *Proxy) closePools() {
+	for _, rt := range p.routes {
+		rt.pool.Close()
+	}
+}
+
+// defaultBackendUnavailableMessage is sent when a profile does not set
+// backend_unavailable_message.
+const defaultBackendUnavailableMessage = "backend unavailable"
+
+// writeErrPacket answers on conn with an ERR packet through go-mysql, which
+// continues conn's sequence and fills in the SQL state matching code.
 func writeErrPacket(conn *server.Conn, code uint16, msg string) error {
+	return conn.WriteValue(mysql.NewError(code, msg))
+}
+
+// respondBackendUnavailable answers the client's first command with msg and
+// returns that command packet, or nil if none arrived.
+func respondBackendUnavailable(conn *server.Conn, msg string) ([]byte, error) {
 	if msg == "" {
-		msg = "backend unavailable"
+		msg = defaultBackendUnavailableMessage
+	}
+	return respondDeferredErr(conn, mysql.ER_CON_COUNT_ERROR, msg)
+}
+
+// logUndeliveredErr records that a client never got the error meant for it:
+// at debug level when it had simply gone away, at warn level otherwise.
+func logUndeliveredErr(log *slog.Logger, code uint16, err error) {
+	level := slog.LevelWarn
+	if isConnCloseErr(err) {
+		level = slog.LevelDebug
+	}
+	log.Log(context.Background(), level, "client did not receive error", "code", code, "error", err)
+}
+
+// maxLoggedQueryLen bounds the SQL text log_unavailable_query writes.
+const maxLoggedQueryLen = 256
+
+// truncateQuery returns up to maxLoggedQueryLen bytes of q without splitting
+// a UTF-8 sequence, marking anything cut off.
+func truncateQuery(q []byte) string {
+	if len(q) <= maxLoggedQueryLen {
+		return string(q)
 	}
-	data := make([]byte, 4, 16+len(msg))
-	data = append(data, mysql.ERR_HEADER)
-	data = append(data, byte(code), byte(code>>8))
-	data = append(data, '#')
-	data = append(data, []byte("HY000")...)
-	data = append(data, msg...)
-	return conn.WritePacket(data)
+	cut := maxLoggedQueryLen
+	for cut > 0 && !utf8.RuneStart(q[cut]) {
+		cut--
+	}
+	return string(q[:cut]) + "...(truncated)"
+}
+
+const (
+	// clientLabelVariable is the backend user variable label_client_session
+	// sets to the client's remote address.
+	clientLabelVariable = "@proxy_client"
+	// requestIDVariable holds the connection's request_id alongside it.
+	requestIDVariable = "@proxy_request_id"
+)
+
+// labelClientSession records the originating client, and requestID when set,
+// on the backend session, where every connection otherwise looks the same as
+// rds_db_user.
+func labelClientSession(conn *client.Conn, remote net.Addr, requestID string) error {
+	stmt := "SET " + clientLabelVariable + " = " + quoteSQLString(remote.String())
+	if requestID != "" {
+		stmt += ", " + requestIDVariable + " = " + quoteSQLString(requestID)
+	}
+	r, err := conn.Execute(stmt)
+	if err != nil {
+		return err
+	}
+	r.Close()
+	return nil
+}
+
+// quoteSQLString returns s as a single-quoted MySQL string literal.
+func quoteSQLString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
 }
 
-func respondBackendUnavailable(conn *server.Conn) {
-	// Best-effort protocol-correct error response:
-	// wait for one client command packet, then reply with ERR.
+// respondDeferredErr reports a failure found after the client already got its
+// auth OK. Best-effort protocol-correct error response: wait for one client
+// command packet, then reply with ERR. It returns the packet it answered, and
+// an error when the client never received the ERR.
+func respondDeferredErr(conn *server.Conn, code uint16, msg string) ([]byte, error) {
 	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
 	defer conn.SetReadDeadline(time.Time{})
-	if _, err := conn.ReadPacket(); err != nil {
-		return
+	data, err := conn.ReadPacket()
+	if err != nil {
+		return nil, fmt.Errorf("wait for client command: %w", err)
 	}
-	_ = writeErrPacket(conn, mysql.ER_CON_COUNT_ERROR, "backend unavailable")
+	if err := writeErrPacket(conn, code, msg); err != nil {
+		return data, fmt.Errorf("write error packet: %w", err)
+	}
+	return data, nil
 }
 
 func isConnCloseErr(err error) bool {
@@ -243,10 +1116,19 @@ func (p *Proxy) trackClient(connID uint64, client net.Conn, startedAt time.Time)
 	p.activeMu.Unlock()
 }
 
-func (p *Proxy) trackBackend(connID uint64, backend net.Conn) {
+func (p *Proxy) trackServerConn(connID uint64, serverConn *server.Conn) {
+	p.activeMu.Lock()
+	if tc, ok := p.active[connID]; ok {
+		tc.serverConn = serverConn
+	}
+	p.activeMu.Unlock()
+}
+
+func (p *Proxy) trackBackend(connID uint64, backend net.Conn, profile string) {
 	p.activeMu.Lock()
 	if tc, ok := p.active[connID]; ok {
 		tc.backend = backend
+		tc.profile = profile
 	}
 	p.activeMu.Unlock()
 }
@@ -271,6 +1153,206 @@ func (p *Proxy) activeSummary() (count int, oldestAge time.Duration) {
 	return count, oldestAge
 }
 
+// longSessionCheckInterval returns how often to scan for long sessions: a
+// quarter of the smallest long_session_warn_after, between 1s and 30s, or 0
+// when no route sets a threshold.
+func (p *Proxy) longSessionCheckInterval() time.Duration {
+	var smallest time.Duration
+	for _, rt := range p.routes {
+		if d := rt.profile.LongSessionWarnAfter; d > 0 && (smallest == 0 || d < smallest) {
+			smallest = d
+		}
+	}
+	if smallest == 0 {
+		return 0
+	}
+	return min(max(smallest/4, time.Second), 30*time.Second)
+}
+
+func (p *Proxy) watchLongSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.warnLongSessions(time.Now())
+		}
+	}
+}
+
+// warnLongSessions logs once per session that outlived its profile's
+// long_session_warn_after. Sessions still authenticating are skipped.
+func (p *Proxy) warnLongSessions(now time.Time) {
+	type longSession struct {
+		id        uint64
+		rt        *route
+		remote    string
+		age       time.Duration
+		threshold time.Duration
+	}
+	var found []longSession
+
+	p.activeMu.RLock()
+	for id, tc := range p.active {
+		if tc.profile == "" || tc.longWarned.Load() {
+			continue
+		}
+		rt := p.routeForProfile(tc.profile)
+		if rt == nil || rt.profile.LongSessionWarnAfter <= 0 {
+			continue
+		}
+		if age := now.Sub(tc.startedAt); age >= rt.profile.LongSessionWarnAfter && tc.longWarned.CompareAndSwap(false, true) {
+			found = append(found, longSession{id: id, rt: rt, remote: tc.client.RemoteAddr().String(), age: age, threshold: rt.profile.LongSessionWarnAfter})
+		}
+	}
+	p.activeMu.RUnlock()
+
+	for _, s := range found {
+		s.rt.logger.Warn("long-lived session", "conn_id", s.id, "remote_addr", s.remote, "age_ms", s.age.Milliseconds(), "threshold_ms", s.threshold.Milliseconds())
+	}
+}
+
+func (p *Proxy) routeForProfile(name string) *route {
+	for _, rt := range p.routes {
+		if rt.profile.Name == name {
+			return rt
+		}
+	}
+	return nil
+}
+
+// ProfileSnapshot is a point-in-time view of one profile served by a Proxy.
+type ProfileSnapshot struct {
+	Profile     string
+	ListenAddr  string
+	ActiveConns int
+	OldestAge   time.Duration
+	Pool        PoolStats
+}
+
+// Snapshot reports active connections and pool state per routed profile.
+// Clients still in the handshake on a shared listener are not attributed to
+// any profile yet.
+func (p *Proxy) Snapshot() []ProfileSnapshot {
+	byProfile := make(map[string]*ProfileSnapshot, len(p.routes))
+	for _, rt := range p.routes {
+		byProfile[rt.profile.Name] = &ProfileSnapshot{
+			Profile:    rt.profile.Name,
+			ListenAddr: p.profile.ListenAddr,
+			Pool:       rt.pool.Stats(),
+		}
+	}
+
+	now := time.Now()
+	p.activeMu.RLock()
+	for _, tc := range p.active {
+		name := tc.profile
+		if name == "" && len(p.routes) == 1 {
+			name = p.profile.Name
+		}
+		snap, ok := byProfile[name]
+		if !ok {
+			continue
+		}
+		snap.ActiveConns++
+		if age := now.Sub(tc.startedAt); age > snap.OldestAge {
+			snap.OldestAge = age
+		}
+	}
+	p.activeMu.RUnlock()
+
+	out := make([]ProfileSnapshot, 0, len(byProfile))
+	for _, snap := range byProfile {
+		out = append(out, *snap)
+	}
+	slices.SortFunc(out, func(a, b ProfileSnapshot) int { return strings.Compare(a.Profile, b.Profile) })
+	return out
+}
+
+// ListenAddr is the address this proxy listens on.
+func (p *Proxy) ListenAddr() string {
+	return p.profile.ListenAddr
+}
+
+// Bound returns a channel that is closed once Run has bound every listener.
+// It is never closed if binding fails.
+func (p *Proxy) Bound() <-chan struct{} {
+	return p.bound
+}
+
+// BoundAddrs returns the addresses Run bound, with any port 0 resolved, or
+// nil before Bound is closed.
+func (p *Proxy) BoundAddrs() []string {
+	select {
+	case <-p.bound:
+		return p.boundAddrs
+	default:
+		return nil
+	}
+}
+
+// ActiveConns is the number of client connections currently tracked.
+func (p *Proxy) ActiveConns() int {
+	p.activeMu.RLock()
+	defer p.activeMu.RUnlock()
+	return len(p.active)
+}
+
+// notifyServerShutdown sends one best-effort ER_SERVER_SHUTDOWN to sc, as an
+// unsolicited ERR starting a new sequence the way MySQL does on shutdown.
+func notifyServerShutdown(sc *server.Conn, deadline time.Time) bool {
+	_ = sc.SetWriteDeadline(deadline)
+	sc.ResetSequence()
+	return writeErrPacket(sc, mysql.ER_SERVER_SHUTDOWN, "server shutting down") == nil
+}
+
+// crServerLost is the client library's "Lost connection to MySQL server"
+// error, which go-mysql does not define.
+const crServerLost = 2013
+
+// notifyBackendLost sends one best-effort CR_SERVER_LOST to sc after the
+// backend closed the session, as an unsolicited ERR like MySQL's own
+// wait_timeout notice, so the client reports why instead of a bare close.
+func notifyBackendLost(sc *server.Conn, deadline time.Time) bool {
+	_ = sc.SetWriteDeadline(deadline)
+	sc.ResetSequence()
+	return writeErrPacket(sc, crServerLost, "Lost connection to backend") == nil
+}
+
+// notifyShutdown sends a best-effort ER_SERVER_SHUTDOWN to every authenticated
+// client so applications log a reason instead of a bare connection reset. The
+// packet goes through the client's server.Conn so TLS sessions stay intact;
+// clients that do not accept it within timeout are left to forceCloseActive.
+func (p *Proxy) notifyShutdown(timeout time.Duration) int {
+	p.activeMu.RLock()
+	conns := make([]*server.Conn, 0, len(p.active))
+	for _, tc := range p.active {
+		if tc.serverConn != nil {
+			conns = append(conns, tc.serverConn)
+		}
+	}
+	p.activeMu.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		sent atomic.Int64
+	)
+	deadline := time.Now().Add(timeout)
+	for _, sc := range conns {
+		wg.Add(1)
+		go func(sc *server.Conn) {
+			defer wg.Done()
+			if notifyServerShutdown(sc, deadline) {
+				sent.Add(1)
+			}
+		}(sc)
+	}
+	wg.Wait()
+	return int(sent.Load())
+}
+
 func (p *Proxy) forceCloseActive() int {
 	type pair struct {
 		client  net.Conn
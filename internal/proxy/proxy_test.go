@@ -1,11 +1,24 @@
 package proxy
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"rds-iam-proxy/internal/config"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
 )
 
 func TestPipeTransfersDataBothDirections(t *testing.T) {
@@ -23,7 +36,7 @@ func TestPipeTransfersDataBothDirections(t *testing.T) {
 		err      error
 	}, 1)
 	go func() {
-		up, down, err := p.pipe(client, backend)
+		up, down, _, err := p.pipe(context.Background(), client, backend, nil, nil, false, pipeNotices{}, nil)
 		done <- struct {
 			up, down int64
 			err      error
@@ -65,6 +78,170 @@ func TestPipeTransfersDataBothDirections(t *testing.T) {
 	}
 }
 
+func TestPipeNotifiesClientWhenBackendClosesFirst(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		clientSent []byte
+		want       bool
+	}{
+		{"idle session", []byte{0x0c, 0, 0, 0, mysql.COM_QUERY, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1'}, true},
+		{"after COM_QUIT", comQuitPacket[:], false},
+	} {
+		p := &Proxy{}
+		client, clientPeer := net.Pipe()
+		backend, backendPeer := net.Pipe()
+
+		var lost atomic.Bool
+		type result struct {
+			up, down      int64
+			backendClosed bool
+			err           error
+		}
+		done := make(chan result, 1)
+		go func() {
+			up, down, closed, err := p.pipe(context.Background(), client, backend, nil, nil, false, pipeNotices{backendLost: func() { lost.Store(true) }}, nil)
+			done <- result{up, down, closed, err}
+		}()
+
+		go func() { _, _ = clientPeer.Write(tc.clientSent) }()
+		if _, err := io.ReadFull(backendPeer, make([]byte, len(tc.clientSent))); err != nil {
+			t.Fatalf("%s: read backend peer: %v", tc.name, err)
+		}
+		go func() { _, _ = io.Copy(io.Discard, clientPeer) }()
+		if _, err := backendPeer.Write([]byte("reply")); err != nil {
+			t.Fatalf("%s: write backend peer: %v", tc.name, err)
+		}
+		_ = backendPeer.Close()
+
+		select {
+		case res := <-done:
+			if res.err != nil {
+				t.Fatalf("%s: pipe returned error: %v", tc.name, res.err)
+			}
+			if res.backendClosed != tc.want || lost.Load() != tc.want {
+				t.Fatalf("%s: backendClosed=%v notified=%v, want %v", tc.name, res.backendClosed, lost.Load(), tc.want)
+			}
+			if res.up != int64(len(tc.clientSent)) || res.down != int64(len("reply")) {
+				t.Fatalf("%s: got up=%d down=%d", tc.name, res.up, res.down)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: pipe did not finish", tc.name)
+		}
+		_ = clientPeer.Close()
+	}
+}
+
+func TestPipeEndsIdleSessionPromptlyWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	p := &Proxy{}
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	noticed := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := p.pipe(ctx, client, backend, nil, nil, true, pipeNotices{shutdown: func() { close(noticed) }}, nil)
+		done <- err
+	}()
+
+	// A query is answered, then both sides stay open and silent: only the
+	// cancel can end the pipe.
+	go func() { _, _ = clientPeer.Write(commandPacket(0, mysql.COM_QUERY, "SELECT 1")) }()
+	readPacket(t, backendPeer)
+	go func() { _, _ = backendPeer.Write(commandPacket(1, mysql.OK_HEADER, "\x00\x00\x02\x00\x00\x00")) }()
+	readPacket(t, clientPeer)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected an interrupted pipe to end without error, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pipe did not end after cancel")
+	}
+	select {
+	case <-noticed:
+	default:
+		t.Fatal("expected the shutdown notice to run before the pipe ended")
+	}
+}
+
+func TestPipeLetsResultSetFinishWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	p := &Proxy{}
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notice := func() { _ = writeRawErrPacket(client, 0, mysql.ER_SERVER_SHUTDOWN, "server shutting down") }
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := p.pipe(ctx, client, backend, nil, nil, true, pipeNotices{shutdown: notice}, nil)
+		done <- err
+	}()
+
+	go func() { _, _ = clientPeer.Write(commandPacket(0, mysql.COM_QUERY, "SELECT 1")) }()
+	readPacket(t, backendPeer)
+
+	eof := "\x00\x00\x02\x00"
+	first := append(commandPacket(1, 0x01, ""), commandPacket(2, 0x03, "def")...)
+	rest := append(commandPacket(3, mysql.EOF_HEADER, eof), commandPacket(4, 0x01, "1")...)
+	rest = append(rest, commandPacket(5, mysql.EOF_HEADER, eof)...)
+	go func() { _, _ = backendPeer.Write(first) }()
+	readPacket(t, clientPeer)
+	readPacket(t, clientPeer)
+
+	// Shutdown starts mid-result-set: the session must stay up until the
+	// rest of it has been delivered.
+	cancel()
+	select {
+	case err := <-done:
+		t.Fatalf("pipe ended mid-result-set: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The backend's remaining packets go out in pieces, as they would
+	// from a socket.
+	go func() {
+		for _, chunk := range [][]byte{rest[:3], rest[3:10], rest[10:]} {
+			if _, err := backendPeer.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	for i, want := range []byte{mysql.EOF_HEADER, 0x01, mysql.EOF_HEADER} {
+		seq, payload := readPacket(t, clientPeer)
+		if int(seq) != i+3 || payload[0] != want {
+			t.Fatalf("packet %d: got seq=%d payload=%q", i, seq, payload)
+		}
+	}
+	seq, payload := readPacket(t, clientPeer)
+	if seq != 0 || payload[0] != mysql.ERR_HEADER || binary.LittleEndian.Uint16(payload[1:]) != mysql.ER_SERVER_SHUTDOWN {
+		t.Fatalf("expected ER_SERVER_SHUTDOWN after the result set, got seq=%d payload=%q", seq, payload)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected an interrupted pipe to end without error, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pipe did not end once the result set was delivered")
+	}
+}
+
 func TestIsConnCloseErr(t *testing.T) {
 	t.Parallel()
 
@@ -102,7 +279,7 @@ func TestActiveConnectionTrackingAndForceClose(t *testing.T) {
 	defer backendPeer.Close()
 
 	p.trackClient(42, client, time.Now().Add(-2*time.Second))
-	p.trackBackend(42, backend)
+	p.trackBackend(42, backend, "p1")
 
 	count, oldest := p.activeSummary()
 	if count != 1 {
@@ -147,3 +324,443 @@ func TestUntrackRemovesActiveConnection(t *testing.T) {
 		t.Fatalf("expected oldest age = 0, got %v", oldest)
 	}
 }
+
+func TestClientAllowedMatchesAllowList(t *testing.T) {
+	t.Parallel()
+
+	open := &Proxy{}
+	if !open.clientAllowed(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 5000}) {
+		t.Fatal("expected all clients allowed without allowed_clients")
+	}
+
+	p := New(config.Profile{
+		ProxyUser:      "local_proxy",
+		AllowedClients: []string{"127.0.0.0/8", "::1", "172.17.0.0/16"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, time.Second, 0)
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "127.0.0.1", want: true},
+		{ip: "::1", want: true},
+		{ip: "::ffff:127.0.0.1", want: true},
+		{ip: "172.17.4.2", want: true},
+		{ip: "172.18.0.1", want: false},
+		{ip: "10.0.0.1", want: false},
+	}
+	for _, tc := range cases {
+		if got := p.clientAllowed(&net.TCPAddr{IP: net.ParseIP(tc.ip), Port: 5000}); got != tc.want {
+			t.Fatalf("clientAllowed(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+
+	closed := New(config.Profile{ProxyUser: "local_proxy", AllowedClients: []string{"not-an-ip"}},
+		slog.New(slog.NewTextHandler(io.Discard, nil)), nil, time.Second, 0)
+	if closed.clientAllowed(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}) {
+		t.Fatal("expected invalid allowed_clients to reject all clients")
+	}
+}
+
+func TestSnapshotGroupsActiveConnectionsByProfile(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	poolA := NewBackendPool(2, time.Minute, time.Second, logger, nil)
+	poolB := NewBackendPool(3, time.Minute, time.Second, logger, nil)
+	p := New(config.Profile{Name: "a", ListenAddr: "127.0.0.1:3307", ProxyUser: "user_a"}, logger, poolA, time.Second, 0)
+	if err := p.AddRoute(config.Profile{Name: "b", ListenAddr: "127.0.0.1:3307", ProxyUser: "user_b"}, logger, poolB, 0); err != nil {
+		t.Fatalf("AddRoute: %v", err)
+	}
+
+	client, clientPeer := net.Pipe()
+	defer client.Close()
+	defer clientPeer.Close()
+	p.trackClient(1, client, time.Now().Add(-time.Second))
+	p.trackBackend(1, client, "b")
+	p.trackClient(2, client, time.Now()) // still in handshake
+
+	snaps := p.Snapshot()
+	if len(snaps) != 2 || snaps[0].Profile != "a" || snaps[1].Profile != "b" {
+		t.Fatalf("unexpected snapshots: %+v", snaps)
+	}
+	if snaps[0].ActiveConns != 0 || snaps[0].Pool.Size != 2 {
+		t.Fatalf("unexpected snapshot for a: %+v", snaps[0])
+	}
+	if snaps[1].ActiveConns != 1 || snaps[1].OldestAge <= 0 || snaps[1].Pool.Size != 3 || snaps[1].Pool.Idle != 0 {
+		t.Fatalf("unexpected snapshot for b: %+v", snaps[1])
+	}
+}
+
+func TestWarnLongSessionsLogsOncePerSession(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+	logger := slog.New(slog.NewTextHandler(&out, nil))
+	p := New(config.Profile{Name: "p1", ProxyUser: "u1", LongSessionWarnAfter: time.Minute}, logger, nil, time.Second, 0)
+	if got := p.longSessionCheckInterval(); got != 15*time.Second {
+		t.Fatalf("expected 15s check interval, got %s", got)
+	}
+
+	client, clientPeer := net.Pipe()
+	defer client.Close()
+	defer clientPeer.Close()
+	now := time.Now()
+	p.trackClient(1, client, now.Add(-2*time.Minute))
+	p.trackBackend(1, client, "p1")
+	p.trackClient(2, client, now.Add(-10*time.Second))
+	p.trackBackend(2, client, "p1")
+	p.trackClient(3, client, now.Add(-time.Hour)) // still authenticating
+
+	p.warnLongSessions(now)
+	p.warnLongSessions(now.Add(time.Second))
+
+	if got := strings.Count(out.String(), "long-lived session"); got != 1 {
+		t.Fatalf("expected exactly one warning, got %d: %s", got, out.String())
+	}
+	if !strings.Contains(out.String(), "conn_id=1") || !strings.Contains(out.String(), "threshold_ms=60000") {
+		t.Fatalf("unexpected warning: %s", out.String())
+	}
+
+	if got := (&Proxy{}).longSessionCheckInterval(); got != 0 {
+		t.Fatalf("expected no check without threshold, got %s", got)
+	}
+}
+
+func TestRunReportsPortInUseWithProfileName(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pool := NewBackendPool(1, time.Minute, time.Second, logger, nil)
+	p := New(config.Profile{Name: "busy", ListenAddr: ln.Addr().String(), ProxyUser: "u1"}, logger, pool, time.Second, 0)
+
+	err = p.Run(context.Background())
+	if err == nil {
+		t.Fatal("expected bind error")
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("expected wrapped EADDRINUSE, got: %v", err)
+	}
+	for _, want := range []string{"profile busy", ln.Addr().String(), "another rds-iam-proxy"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in error, got: %v", want, err)
+		}
+	}
+}
+
+func TestQuoteSQLStringEscapesQuotesAndBackslashes(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"127.0.0.1:50000":  `'127.0.0.1:50000'`,
+		"[fe80::1%eth0]:1": `'[fe80::1%eth0]:1'`,
+		`it's \ odd`:       `'it\'s \\ odd'`,
+	}
+	for in, want := range cases {
+		if got := quoteSQLString(in); got != want {
+			t.Fatalf("quoteSQLString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestTruncateQueryKeepsUTF8Intact(t *testing.T) {
+	t.Parallel()
+
+	if got := truncateQuery([]byte("SELECT 1")); got != "SELECT 1" {
+		t.Fatalf("short query changed: %q", got)
+	}
+	long := "SELECT '" + strings.Repeat("é", maxLoggedQueryLen) + "'"
+	got := truncateQuery([]byte(long))
+	if !strings.HasSuffix(got, "...(truncated)") {
+		t.Fatalf("expected truncation marker, got: %q", got)
+	}
+	body := strings.TrimSuffix(got, "...(truncated)")
+	if len(body) > maxLoggedQueryLen || !utf8.ValidString(body) {
+		t.Fatalf("truncated body is %d bytes, valid UTF-8 %v", len(body), utf8.ValidString(body))
+	}
+}
+
+func TestUnlimitedMaxConnsRemovesListenerCap(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	base := config.Profile{Name: "a", ListenAddr: "127.0.0.1:3307", ProxyUser: "local_a"}
+
+	px := New(base, logger, nil, time.Second, config.MaxConnsUnlimited)
+	if px.sem != nil || px.maxConns != config.MaxConnsUnlimited {
+		t.Fatalf("unlimited New: sem=%v maxConns=%d", px.sem, px.maxConns)
+	}
+
+	px = New(base, logger, nil, time.Second, 10)
+	if cap(px.sem) != 10 {
+		t.Fatalf("capped New: cap(sem)=%d", cap(px.sem))
+	}
+	if err := px.AddRoute(config.Profile{Name: "b", ListenAddr: base.ListenAddr, ProxyUser: "local_b"}, logger, nil, config.MaxConnsUnlimited); err != nil {
+		t.Fatalf("AddRoute: %v", err)
+	}
+	if err := px.AddRoute(config.Profile{Name: "c", ListenAddr: base.ListenAddr, ProxyUser: "local_c"}, logger, nil, 5); err != nil {
+		t.Fatalf("AddRoute: %v", err)
+	}
+	if px.sem != nil || px.maxConns != config.MaxConnsUnlimited {
+		t.Fatalf("shared listener with an unlimited route: sem=%v maxConns=%d", px.sem, px.maxConns)
+	}
+}
+
+func TestRespondDeferredErrIsDecodedByClient(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		cmd []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		sc, err := server.NewConn(conn, "u", "pw", server.EmptyHandler{})
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		cmd, err := respondDeferredErr(sc, mysql.ER_BAD_DB_ERROR, "Unknown database 'nope'")
+		done <- result{cmd: cmd, err: err}
+	}()
+
+	c, err := client.Connect(ln.Addr().String(), "u", "pw", "")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+	_, err = c.Execute("SELECT 1")
+	var myErr *mysql.MyError
+	if !errors.As(err, &myErr) {
+		t.Fatalf("expected a MySQL error, got: %v", err)
+	}
+	if myErr.Code != mysql.ER_BAD_DB_ERROR || myErr.State != "42000" || myErr.Message != "Unknown database 'nope'" {
+		t.Fatalf("unexpected error decoded: code=%d state=%s msg=%q", myErr.Code, myErr.State, myErr.Message)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("respondDeferredErr: %v", res.err)
+	}
+	if len(res.cmd) == 0 || res.cmd[0] != mysql.COM_QUERY {
+		t.Fatalf("expected the answered COM_QUERY, got %v", res.cmd)
+	}
+}
+
+func TestRespondDeferredErrReportsClientGone(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		sc, err := server.NewConn(conn, "u", "pw", server.EmptyHandler{})
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = respondDeferredErr(sc, mysql.ER_CON_COUNT_ERROR, "backend unavailable")
+		done <- err
+	}()
+
+	c, err := client.Connect(ln.Addr().String(), "u", "pw", "")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	_ = c.Conn.Close()
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "wait for client command") {
+		t.Fatalf("expected an undelivered error, got: %v", err)
+	}
+}
+
+// faultyListener fails every Accept with err until failures run out, then
+// reports itself closed.
+type faultyListener struct {
+	net.Listener
+	err      error
+	failures int
+	calls    []time.Time
+}
+
+func (l *faultyListener) Accept() (net.Conn, error) {
+	l.calls = append(l.calls, time.Now())
+	if l.failures == 0 {
+		return nil, net.ErrClosed
+	}
+	if l.failures > 0 {
+		l.failures--
+	}
+	return nil, l.err
+}
+
+func (l *faultyListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3307}
+}
+
+func TestAcceptLoopBacksOffOnRepeatedErrors(t *testing.T) {
+	t.Parallel()
+
+	p := New(config.Profile{Name: "p1", ProxyUser: "u1"}, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, time.Second, 0)
+	p.acceptBackoff = 40 * time.Millisecond
+	ln := &faultyListener{err: syscall.EMFILE, failures: 5}
+	if err := p.acceptLoop(context.Background(), ln); err != nil {
+		t.Fatalf("expected a clean stop once the listener closes, got: %v", err)
+	}
+	if got := p.AcceptErrors(); got != 5 {
+		t.Fatalf("expected 5 accept errors, got %d", got)
+	}
+	// Pauses double from 5ms and are capped at 40ms.
+	want := []time.Duration{5, 10, 20, 40, 40}
+	for i, w := range want {
+		if gap := ln.calls[i+1].Sub(ln.calls[i]); gap < w*time.Millisecond {
+			t.Fatalf("pause %d was %s, want at least %dms", i, gap, w)
+		}
+	}
+}
+
+func TestAcceptLoopGivesUpOnPersistentErrors(t *testing.T) {
+	t.Parallel()
+
+	var out syncBuffer
+	p := New(config.Profile{Name: "p1", ProxyUser: "u1"}, slog.New(slog.NewTextHandler(&out, nil)), nil, time.Second, 0)
+	p.acceptBackoff = time.Millisecond
+	err := p.acceptLoop(context.Background(), &faultyListener{err: syscall.EMFILE, failures: -1})
+	if err == nil || !errors.Is(err, syscall.EMFILE) || !strings.Contains(err.Error(), "50 times in a row") {
+		t.Fatalf("expected give-up error wrapping EMFILE, got: %v", err)
+	}
+	if got := p.AcceptErrors(); got != maxAcceptFailures {
+		t.Fatalf("expected %d accept errors, got %d", maxAcceptFailures, got)
+	}
+	logs := out.String()
+	// The first failure and every tenth after it: 1, 10, 20, 30, 40.
+	if n := strings.Count(logs, `msg="accept failed"`); n != 5 {
+		t.Fatalf("expected 5 accept warnings, got %d:\n%s", n, logs)
+	}
+	if !strings.Contains(logs, "level=ERROR") || !strings.Contains(logs, "listener unhealthy") {
+		t.Fatalf("expected an error-level give-up log, got:\n%s", logs)
+	}
+}
+
+func TestRunSignalsBoundWithResolvedAddrs(t *testing.T) {
+	t.Parallel()
+
+	pool := NewBackendPool(0, time.Minute, time.Second, slog.Default(), func(context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("no backend")
+	})
+	px := New(config.Profile{Name: "p", ListenAddr: "127.0.0.1:0", ProxyUser: "u", ProxyPassword: "pw"}, slog.Default(), pool, time.Second, 1)
+	if px.BoundAddrs() != nil {
+		t.Fatal("expected no bound addrs before Run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- px.Run(ctx)
+	}()
+	select {
+	case <-px.Bound():
+	case <-time.After(3 * time.Second):
+		t.Fatal("Bound was not closed after Run started")
+	}
+	addrs := px.BoundAddrs()
+	if len(addrs) != 1 || strings.HasSuffix(addrs[0], ":0") {
+		t.Fatalf("expected one resolved address, got %v", addrs)
+	}
+	conn, err := net.Dial("tcp", addrs[0])
+	if err != nil {
+		t.Fatalf("dial bound addr: %v", err)
+	}
+	_ = conn.Close()
+	cancel()
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func TestLogTransferProgressReportsRatesWhileDataMoves(t *testing.T) {
+	t.Parallel()
+
+	if got := bytesPerSec(3000, 2*time.Second); got != 1500 {
+		t.Fatalf("bytesPerSec = %d, want 1500", got)
+	}
+	if got := bytesPerSec(3000, 0); got != 0 {
+		t.Fatalf("bytesPerSec over no time = %d, want 0", got)
+	}
+
+	var buf syncBuffer
+	counters := &transferCounters{}
+	counters.up.Store(10)
+	counters.down.Store(4096)
+	stop := logTransferProgress(slog.New(slog.NewTextHandler(&buf, nil)), counters, 20*time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "pipe progress") {
+		if time.Now().After(deadline) {
+			t.Fatal("no progress line logged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Nothing moves from here on, so no further lines are logged.
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	out := buf.String()
+	if strings.Count(out, "pipe progress") != 1 || !strings.Contains(out, "bytes_up=10") || !strings.Contains(out, "bytes_down=4096") || !strings.Contains(out, "bytes_down_per_sec=") {
+		t.Fatalf("expected one progress line with totals and rates, got:\n%s", out)
+	}
+}
+
+func TestPipeUpdatesTransferCounters(t *testing.T) {
+	t.Parallel()
+
+	p := &Proxy{}
+	client, clientPeer := net.Pipe()
+	backend, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+
+	counters := &transferCounters{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _, _ = p.pipe(context.Background(), client, backend, nil, nil, false, pipeNotices{}, counters)
+	}()
+
+	go func() { _, _ = clientPeer.Write([]byte("query")) }()
+	if _, err := io.ReadFull(backendPeer, make([]byte, 5)); err != nil {
+		t.Fatalf("read backend peer: %v", err)
+	}
+	go func() { _, _ = backendPeer.Write([]byte("result-set")) }()
+	if _, err := io.ReadFull(clientPeer, make([]byte, 10)); err != nil {
+		t.Fatalf("read client peer: %v", err)
+	}
+	if up, down := counters.up.Load(), counters.down.Load(); up != 5 || down != 10 {
+		t.Fatalf("counters while open: up=%d down=%d, want 5 and 10", up, down)
+	}
+	_ = clientPeer.Close()
+	<-done
+}
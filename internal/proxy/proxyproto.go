@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature opens every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Header is the longest v1 header the spec allows, CRLF included.
+const maxProxyV1Header = 107
+
+// proxiedConn is a client connection that arrived through a load balancer
+// speaking the PROXY protocol; RemoteAddr reports the original client.
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr { return c.remote }
+
+// NetConn returns the connection to the load balancer.
+func (c *proxiedConn) NetConn() net.Conn { return c.Conn }
+
+// readProxyHeader consumes a PROXY protocol v1 or v2 header from conn and
+// returns conn reporting the client address it names. A LOCAL (v2) or
+// UNKNOWN (v1) header, as sent by health checks, keeps conn's own address.
+// It reads no further than the header, so the MySQL handshake that follows is
+// untouched. On error conn is returned unchanged.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	// "PROXY " and the first six bytes of the v2 signature differ, and both
+	// headers are longer than that.
+	head := make([]byte, 6)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return conn, fmt.Errorf("read PROXY header: %w", err)
+	}
+	var remote net.Addr
+	var err error
+	switch {
+	case string(head) == "PROXY ":
+		remote, err = readProxyV1(conn)
+	case bytes.Equal(head, proxyV2Signature[:6]):
+		remote, err = readProxyV2(conn)
+	default:
+		return conn, errors.New("connection did not start with a PROXY protocol header")
+	}
+	if err != nil {
+		return conn, err
+	}
+	if remote == nil {
+		return conn, nil
+	}
+	return &proxiedConn{Conn: conn, remote: remote}, nil
+}
+
+// readProxyV1 parses the rest of a text header after "PROXY ", e.g.
+// "TCP4 192.0.2.1 198.51.100.1 56324 3307\r\n". It reads byte by byte so
+// nothing past the CRLF is consumed.
+func readProxyV1(conn net.Conn) (net.Addr, error) {
+	line := make([]byte, 0, maxProxyV1Header)
+	var b [1]byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxProxyV1Header-len("PROXY ") {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			return nil, fmt.Errorf("read PROXY v1 header: %w", err)
+		}
+		line = append(line, b[0])
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) > 0 && fields[0] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip, err := netip.ParseAddr(fields[1])
+	if err != nil || ip.Is4() != (fields[0] == "TCP4") {
+		return nil, fmt.Errorf("PROXY v1 header has invalid source address %q", fields[1])
+	}
+	port, err := strconv.ParseUint(fields[3], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 header has invalid source port %q", fields[3])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 parses the rest of a binary header after the first six
+// signature bytes. TLVs after the addresses are skipped.
+func readProxyV2(conn net.Conn) (net.Addr, error) {
+	// Rest of the signature, version/command, family, length.
+	var fixed [10]byte
+	if _, err := io.ReadFull(conn, fixed[:]); err != nil {
+		return nil, fmt.Errorf("read PROXY v2 header: %w", err)
+	}
+	if !bytes.Equal(fixed[:6], proxyV2Signature[6:]) {
+		return nil, errors.New("malformed PROXY v2 signature")
+	}
+	verCmd, family := fixed[6], fixed[7]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[8:]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, fmt.Errorf("read PROXY v2 addresses: %w", err)
+	}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", verCmd&0x0f)
+	}
+	var ipLen int
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		ipLen = 4
+	case 0x2: // AF_INET6
+		ipLen = 16
+	default:
+		// AF_UNSPEC or AF_UNIX carry no client IP.
+		return nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("PROXY v2 address block of %d bytes is too short", len(body))
+	}
+	ip, _ := netip.AddrFromSlice(body[:ipLen])
+	port := binary.BigEndian.Uint16(body[2*ipLen:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
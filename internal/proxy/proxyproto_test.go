@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header builds a v2 PROXY header for a TCP source; a nil src makes it
+// a LOCAL header.
+func proxyV2Header(src *net.TCPAddr, tlv []byte) []byte {
+	h := append([]byte(nil), proxyV2Signature...)
+	if src == nil {
+		return append(h, 0x20, 0x00, 0, 0)
+	}
+	ip, family := src.IP.To4(), byte(0x11)
+	if ip == nil {
+		ip, family = src.IP.To16(), 0x21
+	}
+	body := append(append([]byte(nil), ip...), make([]byte, len(ip))...)
+	body = binary.BigEndian.AppendUint16(body, uint16(src.Port))
+	body = binary.BigEndian.AppendUint16(body, 3307)
+	body = append(body, tlv...)
+	h = append(h, 0x21, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(body)))
+	return append(h, body...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		header string
+		want   string // "" keeps the peer address
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.7 127.0.0.1 40001 3307\r\n", "203.0.113.7:40001"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::7 ::1 40001 3307\r\n", "[2001:db8::7]:40001"},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", ""},
+		{"v2 tcp4", string(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("198.51.100.9"), Port: 5000}, nil)), "198.51.100.9:5000"},
+		{"v2 tcp6 with tlv", string(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::9"), Port: 5000}, []byte{0x04, 0, 1, 'x'})), "[2001:db8::9]:5000"},
+		{"v2 local", string(proxyV2Header(nil, nil)), ""},
+	}
+	for _, tc := range cases {
+		conn, peer := net.Pipe()
+		go func() {
+			_, _ = io.WriteString(peer, tc.header+"after")
+		}()
+		got, err := readProxyHeader(conn)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		want := tc.want
+		if want == "" {
+			want = conn.RemoteAddr().String()
+		}
+		if got.RemoteAddr().String() != want {
+			t.Fatalf("%s: remote addr %s, want %s", tc.name, got.RemoteAddr(), want)
+		}
+		// Bytes after the header are left for the MySQL handshake.
+		rest := make([]byte, len("after"))
+		if _, err := io.ReadFull(got, rest); err != nil || string(rest) != "after" {
+			t.Fatalf("%s: read after header = %q, %v", tc.name, rest, err)
+		}
+		_ = conn.Close()
+		_ = peer.Close()
+	}
+}
+
+func TestReadProxyHeaderRejectsMalformedHeaders(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name, header, wantErr string
+	}{
+		{"no header", "\x10\x00\x00\x01garbage", "did not start with a PROXY protocol header"},
+		{"v1 bad family", "PROXY UDP4 1.2.3.4 5.6.7.8 1 2\r\n", "malformed PROXY v1 header"},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 ::1 1 2\r\n", "invalid source address"},
+		{"v1 bad port", "PROXY TCP4 1.2.3.4 5.6.7.8 70000 2\r\n", "invalid source port"},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120), "too long"},
+		{"v2 short addresses", string(proxyV2Signature) + "\x21\x11\x00\x04abcd", "too short"},
+	}
+	for _, tc := range cases {
+		conn, peer := net.Pipe()
+		go func() {
+			_, _ = io.WriteString(peer, tc.header)
+			_ = peer.Close()
+		}()
+		got, err := readProxyHeader(conn)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%s: expected error containing %q, got: %v", tc.name, tc.wantErr, err)
+		}
+		if got != conn {
+			t.Fatalf("%s: expected the original conn back on error", tc.name)
+		}
+		_ = conn.Close()
+	}
+}
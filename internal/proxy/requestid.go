@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Request ID formats for SetRequestIDFormat.
+const (
+	RequestIDCounter = "counter" // only the process-local conn_id
+	RequestIDUUID    = "uuid"    // a random UUID per connection, logged as request_id
+)
+
+// ValidRequestIDFormat reports whether format is empty or a known format.
+func ValidRequestIDFormat(format string) bool {
+	return format == "" || format == RequestIDCounter || format == RequestIDUUID
+}
+
+// newRequestUUID returns a random RFC 9562 version 4 UUID. conn_id restarts
+// at 1 with the process; this stays unique across restarts and instances.
+func newRequestUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:]) // never fails on supported platforms
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,20 @@
+// Package serverinternal gives the rds-iam-proxy CLI the parts of an
+// rdsiamproxy.Server that are built from internal packages and so are not
+// part of the public embedding API.
+package serverinternal
+
+import (
+	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/token"
+)
+
+// Internals are a Server's listeners and its shared IAM token cache.
+type Internals struct {
+	Proxies    []*proxy.Proxy // one per listener
+	TokenCache *token.Cache
+}
+
+// Of returns the Internals of an *rdsiamproxy.Server. Package rdsiamproxy
+// sets it at init; server is typed any because this package cannot import
+// rdsiamproxy without a cycle.
+var Of func(server any) Internals
@@ -2,24 +2,37 @@ package token
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"rds-iam-proxy/internal/config"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 var (
-	loadDefaultAWSConfig = awsconfig.LoadDefaultConfig
-	buildRDSAuthToken    = auth.BuildAuthToken
+	loadDefaultAWSConfig  = awsconfig.LoadDefaultConfig
+	buildRDSAuthToken     = auth.BuildAuthToken
+	newAssumeRoleProvider = func(cfg aws.Config, roleARN string, optFns ...func(*stscreds.AssumeRoleOptions)) aws.CredentialsProvider {
+		return stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN, optFns...)
+	}
+	callerIdentityTimes = stsCallerIdentityTimes
 )
 
+// MaxTokenTTL is how long RDS accepts an IAM auth token after it is signed.
+const MaxTokenTTL = 15 * time.Minute
+
 type CachedToken struct {
 	Value     string
 	ExpiresAt time.Time
@@ -29,45 +42,204 @@ type Cache struct {
 	mu            sync.Mutex
 	entries       map[string]CachedToken
 	awsProviders  map[string]aws.CredentialsProvider
+	awsConfigs    map[string]aws.Config
+	failures      map[string]buildFailure
 	refreshBefore time.Duration
 	tokenTTL      time.Duration
+	cooldown      time.Duration
+
+	hits        atomic.Uint64
+	refreshes   atomic.Uint64
+	buildErrors atomic.Uint64
+	cooledDown  atomic.Uint64
+}
+
+// Stats is a point-in-time snapshot of cache effectiveness counters.
+type Stats struct {
+	Hits        uint64 `json:"hits"`         // served from cache before the refresh window
+	Refreshes   uint64 `json:"refreshes"`    // fresh tokens built (first use or refresh window reached)
+	BuildErrors uint64 `json:"build_errors"` // failed attempts to build a fresh token
+	CooledDown  uint64 `json:"cooled_down"`  // calls answered with a recent build error without calling AWS
 }
 
+// buildFailure is the last failed build for a cache key.
+type buildFailure struct {
+	err error
+	at  time.Time // when the failure was recorded or its retry claimed
+}
+
+// ClampTTL limits ttl to MaxTokenTTL, reporting whether it was lowered. A
+// longer TTL would have the cache serve tokens RDS already rejects; callers
+// taking a TTL from configuration use it to warn before calling New.
+func ClampTTL(ttl time.Duration) (time.Duration, bool) {
+	if ttl > MaxTokenTTL {
+		return MaxTokenTTL, true
+	}
+	return ttl, false
+}
+
+// New returns an empty cache. tokenTTL above MaxTokenTTL is clamped.
 func New(refreshBefore, tokenTTL time.Duration) *Cache {
+	tokenTTL, _ = ClampTTL(tokenTTL)
 	return &Cache{
 		entries:       map[string]CachedToken{},
 		awsProviders:  map[string]aws.CredentialsProvider{},
+		awsConfigs:    map[string]aws.Config{},
+		failures:      map[string]buildFailure{},
 		refreshBefore: refreshBefore,
 		tokenTTL:      tokenTTL,
 	}
 }
 
+// SetFailureCooldown makes Get return a failed build's error for d before
+// asking AWS again, so a throttled or broken profile is retried once per
+// cooldown instead of once per connection. It must be called before the cache
+// is shared; 0 disables the cooldown.
+func (c *Cache) SetFailureCooldown(d time.Duration) {
+	c.cooldown = d
+}
+
+// Purge drops every cached token and recorded build failure, so the next Get
+// for each profile signs a fresh token. Credentials providers are kept.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	clear(c.failures)
+}
+
+// ExpiresAt reports when the token cached for p expires; ok is false when
+// none is cached.
+func (c *Cache) ExpiresAt(p config.Profile) (expiresAt time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(p)]
+	return entry.ExpiresAt, ok
+}
+
+// NextExpiry reports the soonest expiry among the tokens cached for p, one
+// per rds_hosts entry; ok is false when none is cached. It goes past once
+// refreshes keep failing, which is what expiry alerts watch for.
+func (c *Cache) NextExpiry(p config.Profile) (next time.Time, ok bool) {
+	for _, host := range p.BackendHosts() {
+		exp, cached := c.ExpiresAt(p.ForHost(host))
+		if cached && (!ok || exp.Before(next)) {
+			next, ok = exp, true
+		}
+	}
+	return next, ok
+}
+
+// Timing breaks down how long a Get call spent in each phase. Both phases are
+// zero when the token was served from cache; ConfigLoad is zero when the AWS
+// credentials provider was already initialized for the profile.
+type Timing struct {
+	Cached     bool
+	ConfigLoad time.Duration // loading shared AWS config and credentials provider
+	Build      time.Duration // signing the RDS auth token
+}
+
+// Total is the combined time spent building a fresh token.
+func (t Timing) Total() time.Duration {
+	return t.ConfigLoad + t.Build
+}
+
 func (c *Cache) Get(ctx context.Context, p config.Profile) (CachedToken, error) {
+	tok, _, err := c.GetWithTiming(ctx, p)
+	return tok, err
+}
+
+// GetWithTiming is Get plus per-phase latency, for diagnosing slow IAM setups.
+func (c *Cache) GetWithTiming(ctx context.Context, p config.Profile) (CachedToken, Timing, error) {
 	key := cacheKey(p)
+	var timing Timing
 
 	c.mu.Lock()
 	entry, ok := c.entries[key]
 	if ok && time.Until(entry.ExpiresAt) > c.refreshBefore {
 		c.mu.Unlock()
-		return entry, nil
+		c.hits.Add(1)
+		timing.Cached = true
+		return entry, timing, nil
+	}
+	if f, ok := c.failures[key]; ok && c.cooldown > 0 {
+		if since := time.Since(f.at); since < c.cooldown {
+			c.mu.Unlock()
+			c.cooledDown.Add(1)
+			return CachedToken{}, timing, fmt.Errorf("token build failed %s ago, retrying after %s: %w",
+				since.Round(time.Millisecond), (c.cooldown - since).Round(time.Millisecond), f.err)
+		}
+		// This caller makes the one retry; others keep the cached error meanwhile.
+		f.at = time.Now()
+		c.failures[key] = f
 	}
 	c.mu.Unlock()
 
-	provider, err := c.getOrInitProvider(ctx, p)
+	// aws_credential_timeout bounds both phases, so a credential chain stuck
+	// on an unreachable IMDS fails fast instead of waiting out SDK retries.
+	awsCtx := ctx
+	if p.AWSCredentialTimeout > 0 {
+		var cancel context.CancelFunc
+		awsCtx, cancel = context.WithTimeout(ctx, p.AWSCredentialTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	provider, err := c.getOrInitProvider(awsCtx, p)
+	timing.ConfigLoad = time.Since(start)
 	if err != nil {
-		return CachedToken{}, err
+		err = credentialTimeoutErr(ctx, awsCtx, p, err)
+		c.buildErrors.Add(1)
+		c.recordFailure(ctx, key, err)
+		return CachedToken{}, timing, err
 	}
 
-	fresh, err := build(ctx, p, c.tokenTTL, provider)
+	start = time.Now()
+	fresh, err := build(awsCtx, p, c.tokenTTL, provider)
+	timing.Build = time.Since(start)
 	if err != nil {
-		return CachedToken{}, err
+		err = credentialTimeoutErr(ctx, awsCtx, p, err)
+		c.buildErrors.Add(1)
+		c.recordFailure(ctx, key, err)
+		return CachedToken{}, timing, err
 	}
+	c.refreshes.Add(1)
 
 	c.mu.Lock()
 	c.entries[key] = fresh
+	delete(c.failures, key)
 	c.mu.Unlock()
 
-	return fresh, nil
+	return fresh, timing, nil
+}
+
+// credentialTimeoutErr names aws_credential_timeout when it, rather than the
+// caller's ctx, cut a token build short.
+func credentialTimeoutErr(ctx, awsCtx context.Context, p config.Profile, err error) error {
+	if ctx.Err() == nil && awsCtx.Err() != nil {
+		return fmt.Errorf("aws credentials not available within aws_credential_timeout %s (is the credential chain waiting on EC2 instance metadata?): %w", p.AWSCredentialTimeout, err)
+	}
+	return err
+}
+
+// recordFailure remembers err for the cooldown. Failures caused by the
+// caller's own cancelled context say nothing about AWS and are not kept.
+func (c *Cache) recordFailure(ctx context.Context, key string, err error) {
+	if c.cooldown <= 0 || ctx.Err() != nil {
+		return
+	}
+	c.mu.Lock()
+	c.failures[key] = buildFailure{err: err, at: time.Now()}
+	c.mu.Unlock()
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:        c.hits.Load(),
+		Refreshes:   c.refreshes.Load(),
+		BuildErrors: c.buildErrors.Load(),
+		CooledDown:  c.cooledDown.Load(),
+	}
 }
 
 func (c *Cache) getOrInitProvider(ctx context.Context, p config.Profile) (aws.CredentialsProvider, error) {
@@ -86,22 +258,105 @@ func (c *Cache) getOrInitProvider(ctx context.Context, p config.Profile) (aws.Cr
 	if p.AWSProfile != "" {
 		opts = append(opts, awsconfig.WithSharedConfigProfile(p.AWSProfile))
 	}
+	// The SDK silently skips missing shared files, so check explicit ones here.
+	if p.AWSConfigFile != "" {
+		if _, err := os.Stat(p.AWSConfigFile); err != nil {
+			return nil, fmt.Errorf("aws_config_file: %w", err)
+		}
+		opts = append(opts, awsconfig.WithSharedConfigFiles([]string{p.AWSConfigFile}))
+	}
+	if p.AWSCredentialsFile != "" {
+		if _, err := os.Stat(p.AWSCredentialsFile); err != nil {
+			return nil, fmt.Errorf("aws_credentials_file: %w", err)
+		}
+		opts = append(opts, awsconfig.WithSharedCredentialsFiles([]string{p.AWSCredentialsFile}))
+	}
+	if p.AWSEndpointURL != "" {
+		opts = append(opts, awsconfig.WithBaseEndpoint(p.AWSEndpointURL))
+	}
+	if p.STSEndpointURL != "" {
+		opts = append(opts, awsconfig.WithServiceOptions(stsEndpointOverride(p.STSEndpointURL)))
+	}
 
 	awsCfg, err := loadDefaultAWSConfig(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("load aws config: %w", err)
 	}
 
+	provider := awsCfg.Credentials
+	if p.AssumeRoleARN != "" {
+		// The base credentials only sign the AssumeRole call; tokens are built with the role.
+		provider = aws.NewCredentialsCache(newAssumeRoleProvider(awsCfg, p.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if p.ExternalID != "" {
+				o.ExternalID = aws.String(p.ExternalID)
+			}
+			if p.RoleSessionName != "" {
+				o.RoleSessionName = p.RoleSessionName
+			}
+		}))
+	}
+
+	awsCfg.Credentials = provider
+
+	c.mu.Lock()
+	c.awsProviders[key] = provider
+	c.awsConfigs[key] = awsCfg
+	c.mu.Unlock()
+
+	return provider, nil
+}
+
+// ClockSkew compares the local clock with the Date header of an STS
+// GetCallerIdentity response for the profile's credentials. A positive result
+// means the local clock is ahead. RDS validates the token's signing time, so a
+// skewed host produces tokens that are rejected even though they look fresh.
+func (c *Cache) ClockSkew(ctx context.Context, p config.Profile) (time.Duration, error) {
+	if _, err := c.getOrInitProvider(ctx, p); err != nil {
+		return 0, err
+	}
 	c.mu.Lock()
-	c.awsProviders[key] = awsCfg.Credentials
+	awsCfg := c.awsConfigs[providerKey(p)]
 	c.mu.Unlock()
 
-	return awsCfg.Credentials, nil
+	local, serverTime, err := callerIdentityTimes(ctx, awsCfg)
+	if err != nil {
+		return 0, fmt.Errorf("check clock skew: %w", err)
+	}
+	return local.Sub(serverTime), nil
+}
+
+func stsCallerIdentityTimes(ctx context.Context, cfg aws.Config) (time.Time, time.Time, error) {
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	serverTime, ok := awsmiddleware.GetServerTime(out.ResultMetadata)
+	if !ok {
+		return time.Time{}, time.Time{}, errors.New("sts response has no Date header")
+	}
+	local, ok := awsmiddleware.GetResponseAt(out.ResultMetadata)
+	if !ok {
+		local = time.Now()
+	}
+	return local, serverTime, nil
+}
+
+// stsEndpointOverride points STS clients created during credential resolution
+// (e.g. shared-config role chaining) at a custom endpoint.
+func stsEndpointOverride(endpoint string) func(string, any) {
+	return func(serviceID string, opts any) {
+		if serviceID != sts.ServiceID {
+			return
+		}
+		if o, ok := opts.(*sts.Options); ok {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}
 }
 
 func build(ctx context.Context, p config.Profile, ttl time.Duration, provider aws.CredentialsProvider) (CachedToken, error) {
 	endpoint := net.JoinHostPort(p.RDSHost, strconv.Itoa(p.RDSPort))
-	token, err := buildRDSAuthToken(ctx, endpoint, p.RDSRegion, p.RDSDBUser, provider)
+	token, err := buildRDSAuthToken(ctx, endpoint, p.RDSRegion, p.TokenDBUser(), provider)
 	if err != nil {
 		return CachedToken{}, fmt.Errorf("build auth token: %w", err)
 	}
@@ -113,9 +368,11 @@ func build(ctx context.Context, p config.Profile, ttl time.Duration, provider aw
 }
 
 func cacheKey(p config.Profile) string {
-	return p.Name + "|" + p.RDSHost + "|" + strconv.Itoa(p.RDSPort) + "|" + p.RDSRegion + "|" + p.RDSDBUser + "|" + p.AWSProfile
+	return p.Name + "|" + p.RDSHost + "|" + strconv.Itoa(p.RDSPort) + "|" + p.RDSRegion + "|" + p.TokenDBUser() + "|" + p.AWSProfile
 }
 
 func providerKey(p config.Profile) string {
-	return p.RDSRegion + "|" + p.AWSProfile
+	return p.RDSRegion + "|" + p.AWSProfile + "|" + p.AWSEndpointURL + "|" + p.STSEndpointURL +
+		"|" + p.AssumeRoleARN + "|" + p.ExternalID + "|" + p.RoleSessionName +
+		"|" + p.AWSConfigFile + "|" + p.AWSCredentialsFile
 }
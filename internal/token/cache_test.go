@@ -2,6 +2,11 @@ package token
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -10,7 +15,9 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 type staticProvider struct{}
@@ -68,6 +75,20 @@ func TestCacheGetReturnsCachedTokenBeforeRefreshWindow(t *testing.T) {
 	}
 }
 
+func TestNewClampsTTLToRDSMaximum(t *testing.T) {
+	t.Parallel()
+
+	if got, clamped := ClampTTL(20 * time.Minute); got != MaxTokenTTL || !clamped {
+		t.Fatalf("ClampTTL(20m) = %s, %v; want %s, true", got, clamped, MaxTokenTTL)
+	}
+	if got, clamped := ClampTTL(10 * time.Minute); got != 10*time.Minute || clamped {
+		t.Fatalf("ClampTTL(10m) = %s, %v; want 10m, false", got, clamped)
+	}
+	if c := New(5*time.Minute, time.Hour); c.tokenTTL != MaxTokenTTL {
+		t.Fatalf("expected New to clamp the TTL to %s, got %s", MaxTokenTTL, c.tokenTTL)
+	}
+}
+
 func TestCacheRefreshesWithinRefreshWindow(t *testing.T) {
 	origLoad := loadDefaultAWSConfig
 	origBuild := buildRDSAuthToken
@@ -149,3 +170,565 @@ func TestProviderCacheIsReusedForSameRegionAndAWSProfile(t *testing.T) {
 		t.Fatalf("expected single aws config load due to provider cache, got %d", loadCalls)
 	}
 }
+
+func TestProviderCacheSeparatesEndpointOverrides(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	var loadCalls int32
+	var lastOpts awsconfig.LoadOptions
+	loadDefaultAWSConfig = func(_ context.Context, optFns ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		atomic.AddInt32(&loadCalls, 1)
+		lastOpts = awsconfig.LoadOptions{}
+		for _, fn := range optFns {
+			if err := fn(&lastOpts); err != nil {
+				return aws.Config{}, err
+			}
+		}
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	buildRDSAuthToken = func(_ context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		return "token", nil
+	}
+
+	c := New(20*time.Minute, 15*time.Minute)
+	p := config.Profile{
+		Name:      "p1",
+		RDSHost:   "db.example",
+		RDSPort:   3306,
+		RDSRegion: "us-gov-west-1",
+		RDSDBUser: "db_user_1",
+	}
+	if _, err := c.Get(context.Background(), p); err != nil {
+		t.Fatalf("Get without endpoints: %v", err)
+	}
+
+	p.AWSEndpointURL = "https://vpce.example.amazonaws.com"
+	p.STSEndpointURL = "https://sts-fips.us-gov-west-1.amazonaws.com"
+	if _, err := c.Get(context.Background(), p); err != nil {
+		t.Fatalf("Get with endpoints: %v", err)
+	}
+
+	if atomic.LoadInt32(&loadCalls) != 2 {
+		t.Fatalf("expected separate provider per endpoint set, got %d loads", loadCalls)
+	}
+	if lastOpts.BaseEndpoint != p.AWSEndpointURL {
+		t.Fatalf("expected base endpoint %q, got %q", p.AWSEndpointURL, lastOpts.BaseEndpoint)
+	}
+	if len(lastOpts.ServiceOptions) != 1 {
+		t.Fatalf("expected sts service option, got %d", len(lastOpts.ServiceOptions))
+	}
+	stsOpts := &sts.Options{}
+	lastOpts.ServiceOptions[0](sts.ServiceID, stsOpts)
+	if stsOpts.BaseEndpoint == nil || *stsOpts.BaseEndpoint != p.STSEndpointURL {
+		t.Fatalf("expected sts endpoint override %q, got %v", p.STSEndpointURL, stsOpts.BaseEndpoint)
+	}
+}
+
+func TestProviderCacheSeparatesSharedFiles(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	var loadCalls int32
+	var lastOpts awsconfig.LoadOptions
+	loadDefaultAWSConfig = func(_ context.Context, optFns ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		atomic.AddInt32(&loadCalls, 1)
+		lastOpts = awsconfig.LoadOptions{}
+		for _, fn := range optFns {
+			if err := fn(&lastOpts); err != nil {
+				return aws.Config{}, err
+			}
+		}
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	buildRDSAuthToken = func(_ context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		return "token", nil
+	}
+
+	tmp := t.TempDir()
+	writeFile := func(name string) string {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte("[default]\n"), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+	c := New(20*time.Minute, 15*time.Minute)
+	p := config.Profile{
+		Name:               "p1",
+		RDSHost:            "db.example",
+		RDSPort:            3306,
+		RDSRegion:          "eu-west-1",
+		RDSDBUser:          "db_user_1",
+		AWSConfigFile:      writeFile("config-a"),
+		AWSCredentialsFile: writeFile("credentials-a"),
+	}
+	if _, err := c.Get(context.Background(), p); err != nil {
+		t.Fatalf("Get with files a: %v", err)
+	}
+	if len(lastOpts.SharedConfigFiles) != 1 || lastOpts.SharedConfigFiles[0] != p.AWSConfigFile {
+		t.Fatalf("expected shared config file %q, got %v", p.AWSConfigFile, lastOpts.SharedConfigFiles)
+	}
+	if len(lastOpts.SharedCredentialsFiles) != 1 || lastOpts.SharedCredentialsFiles[0] != p.AWSCredentialsFile {
+		t.Fatalf("expected shared credentials file %q, got %v", p.AWSCredentialsFile, lastOpts.SharedCredentialsFiles)
+	}
+
+	p.Name = "p2"
+	p.AWSCredentialsFile = writeFile("credentials-b")
+	if _, err := c.Get(context.Background(), p); err != nil {
+		t.Fatalf("Get with files b: %v", err)
+	}
+	if atomic.LoadInt32(&loadCalls) != 2 {
+		t.Fatalf("expected separate provider per credentials file, got %d loads", loadCalls)
+	}
+
+	p.Name = "p3"
+	p.AWSCredentialsFile = filepath.Join(tmp, "missing")
+	if _, err := c.Get(context.Background(), p); err == nil || !strings.Contains(err.Error(), "aws_credentials_file") {
+		t.Fatalf("expected missing credentials file error, got: %v", err)
+	}
+}
+
+func TestAssumeRoleProviderIsCachedPerRole(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	origAssume := newAssumeRoleProvider
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+		newAssumeRoleProvider = origAssume
+	})
+
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	buildRDSAuthToken = func(_ context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		return "token", nil
+	}
+
+	var assumed []stscreds.AssumeRoleOptions
+	newAssumeRoleProvider = func(_ aws.Config, roleARN string, optFns ...func(*stscreds.AssumeRoleOptions)) aws.CredentialsProvider {
+		o := stscreds.AssumeRoleOptions{RoleARN: roleARN}
+		for _, fn := range optFns {
+			fn(&o)
+		}
+		assumed = append(assumed, o)
+		return staticProvider{}
+	}
+
+	c := New(20*time.Minute, 15*time.Minute)
+	base := config.Profile{
+		RDSHost:   "db.example",
+		RDSPort:   3306,
+		RDSRegion: "eu-west-1",
+	}
+	p1 := base
+	p1.Name, p1.RDSDBUser = "p1", "db_user_1"
+	p1.AssumeRoleARN = "arn:aws:iam::111111111111:role/reporting"
+	p1.ExternalID = "ext-1"
+	p1.RoleSessionName = "proxy-p1"
+	p2 := base
+	p2.Name, p2.RDSDBUser = "p2", "db_user_2"
+	p2.AssumeRoleARN = "arn:aws:iam::222222222222:role/app"
+
+	for _, p := range []config.Profile{p1, p1, p2} {
+		if _, err := c.Get(context.Background(), p); err != nil {
+			t.Fatalf("Get %s: %v", p.Name, err)
+		}
+	}
+
+	if len(assumed) != 2 {
+		t.Fatalf("expected one assume-role provider per role, got %d", len(assumed))
+	}
+	if assumed[0].RoleARN != p1.AssumeRoleARN || assumed[0].RoleSessionName != "proxy-p1" {
+		t.Fatalf("unexpected first assume-role options: %+v", assumed[0])
+	}
+	if assumed[0].ExternalID == nil || *assumed[0].ExternalID != "ext-1" {
+		t.Fatalf("expected external id to be forwarded, got %v", assumed[0].ExternalID)
+	}
+	if assumed[1].RoleARN != p2.AssumeRoleARN || assumed[1].ExternalID != nil {
+		t.Fatalf("unexpected second assume-role options: %+v", assumed[1])
+	}
+}
+
+func TestCacheStatsCountHitsRefreshesAndErrors(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	var fail atomic.Bool
+	buildRDSAuthToken = func(_ context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		if fail.Load() {
+			return "", errors.New("throttled")
+		}
+		return "token", nil
+	}
+
+	c := New(5*time.Minute, 15*time.Minute)
+	p := config.Profile{
+		Name:      "p1",
+		RDSHost:   "db.example",
+		RDSPort:   3306,
+		RDSRegion: "eu-west-1",
+		RDSDBUser: "db_user_1",
+	}
+	if _, err := c.Get(context.Background(), p); err != nil {
+		t.Fatalf("first Get: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = c.Get(context.Background(), p)
+		}()
+	}
+	wg.Wait()
+
+	fail.Store(true)
+	other := p
+	other.Name = "p2"
+	if _, err := c.Get(context.Background(), other); err == nil {
+		t.Fatal("expected build error")
+	}
+
+	got := c.Stats()
+	want := Stats{Hits: 20, Refreshes: 1, BuildErrors: 1}
+	if got != want {
+		t.Fatalf("unexpected stats: got %+v, want %+v", got, want)
+	}
+}
+
+func TestGetWithTimingReportsPhases(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		time.Sleep(5 * time.Millisecond)
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	buildRDSAuthToken = func(_ context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		time.Sleep(5 * time.Millisecond)
+		return "token", nil
+	}
+
+	c := New(5*time.Minute, 15*time.Minute)
+	p := config.Profile{
+		Name:      "p1",
+		RDSHost:   "db.example",
+		RDSPort:   3306,
+		RDSRegion: "eu-west-1",
+		RDSDBUser: "db_user_1",
+	}
+	_, timing, err := c.GetWithTiming(context.Background(), p)
+	if err != nil {
+		t.Fatalf("GetWithTiming: %v", err)
+	}
+	if timing.Cached || timing.ConfigLoad < 5*time.Millisecond || timing.Build < 5*time.Millisecond {
+		t.Fatalf("unexpected fresh timing: %+v", timing)
+	}
+	if timing.Total() != timing.ConfigLoad+timing.Build {
+		t.Fatalf("total mismatch: %+v", timing)
+	}
+
+	_, timing, err = c.GetWithTiming(context.Background(), p)
+	if err != nil {
+		t.Fatalf("cached GetWithTiming: %v", err)
+	}
+	if !timing.Cached || timing.Total() != 0 {
+		t.Fatalf("expected cached zero timing, got %+v", timing)
+	}
+}
+
+func TestClockSkewUsesCachedConfigAndServerTime(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origTimes := callerIdentityTimes
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		callerIdentityTimes = origTimes
+	})
+
+	var loads atomic.Int32
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		loads.Add(1)
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	local := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	callerIdentityTimes = func(_ context.Context, cfg aws.Config) (time.Time, time.Time, error) {
+		if cfg.Credentials == nil {
+			t.Fatal("expected credentials on cached config")
+		}
+		return local, local.Add(-90 * time.Second), nil
+	}
+
+	c := New(5*time.Minute, 15*time.Minute)
+	p := config.Profile{Name: "p1", RDSRegion: "eu-west-1"}
+	skew, err := c.ClockSkew(context.Background(), p)
+	if err != nil {
+		t.Fatalf("ClockSkew: %v", err)
+	}
+	if skew != 90*time.Second {
+		t.Fatalf("expected 90s skew, got %s", skew)
+	}
+	if _, err := c.ClockSkew(context.Background(), p); err != nil {
+		t.Fatalf("second ClockSkew: %v", err)
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("expected aws config to load once, got %d", loads.Load())
+	}
+
+	callerIdentityTimes = func(context.Context, aws.Config) (time.Time, time.Time, error) {
+		return time.Time{}, time.Time{}, errors.New("no network")
+	}
+	if _, err := c.ClockSkew(context.Background(), p); err == nil || !strings.Contains(err.Error(), "clock skew") {
+		t.Fatalf("expected wrapped clock skew error, got: %v", err)
+	}
+}
+
+func TestBuildUsesIAMTokenDBUser(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	var users []string
+	buildRDSAuthToken = func(_ context.Context, _, _, dbUser string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		users = append(users, dbUser)
+		return "token-" + dbUser, nil
+	}
+
+	c := New(5*time.Minute, 15*time.Minute)
+	p := config.Profile{Name: "p1", RDSHost: "db.example", RDSPort: 3306, RDSRegion: "eu-west-1", RDSDBUser: "app_login"}
+	if _, err := c.Get(context.Background(), p); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.IAMTokenDBUser = "iam_auth"
+	if _, err := c.Get(context.Background(), p); err != nil {
+		t.Fatalf("Get with token user: %v", err)
+	}
+	if len(users) != 2 || users[0] != "app_login" || users[1] != "iam_auth" {
+		t.Fatalf("expected tokens for app_login then iam_auth (separate cache entries), got %v", users)
+	}
+}
+
+func TestFailureCooldownSuppressesRetriesUntilExpiry(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	var buildCalls atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+	buildRDSAuthToken = func(_ context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		buildCalls.Add(1)
+		if fail.Load() {
+			return "", errors.New("throttled")
+		}
+		return "token", nil
+	}
+
+	const cooldown = 50 * time.Millisecond
+	c := New(5*time.Minute, 15*time.Minute)
+	c.SetFailureCooldown(cooldown)
+	p := config.Profile{
+		Name:      "p1",
+		RDSHost:   "db.example",
+		RDSPort:   3306,
+		RDSRegion: "eu-west-1",
+		RDSDBUser: "db_user_1",
+	}
+
+	if _, err := c.Get(context.Background(), p); err == nil {
+		t.Fatal("expected build error")
+	}
+	for i := 0; i < 5; i++ {
+		_, err := c.Get(context.Background(), p)
+		if err == nil || !strings.Contains(err.Error(), "throttled") || !strings.Contains(err.Error(), "retrying after") {
+			t.Fatalf("expected cached build error, got: %v", err)
+		}
+	}
+	if got := buildCalls.Load(); got != 1 {
+		t.Fatalf("expected one build during cooldown, got %d", got)
+	}
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if _, err := c.Get(context.Background(), p); err == nil {
+		t.Fatal("expected retry to fail again")
+	}
+	if got := buildCalls.Load(); got != 2 {
+		t.Fatalf("expected one retry after cooldown, got %d builds", got)
+	}
+
+	fail.Store(false)
+	time.Sleep(cooldown + 10*time.Millisecond)
+	tok, err := c.Get(context.Background(), p)
+	if err != nil || tok.Value != "token" {
+		t.Fatalf("expected recovery after cooldown, got %q, %v", tok.Value, err)
+	}
+
+	want := Stats{Refreshes: 1, BuildErrors: 2, CooledDown: 5}
+	if got := c.Stats(); got != want {
+		t.Fatalf("unexpected stats: got %+v, want %+v", got, want)
+	}
+
+	// A caller giving up must not put the profile into cooldown.
+	fail.Store(true)
+	other := p
+	other.Name = "p2"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _ = c.Get(ctx, other)
+	_, _ = c.Get(context.Background(), other)
+	if got := buildCalls.Load(); got != 5 {
+		t.Fatalf("expected cancelled failure not to be cached, got %d builds", got)
+	}
+}
+
+func TestAWSCredentialTimeoutBoundsTokenBuild(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	// Credential retrieval that never answers, like IMDS off EC2.
+	buildRDSAuthToken = func(ctx context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	c := New(5*time.Minute, 15*time.Minute)
+	p := config.Profile{
+		Name:                 "p1",
+		RDSHost:              "db.example",
+		RDSPort:              3306,
+		RDSRegion:            "eu-west-1",
+		RDSDBUser:            "db_user_1",
+		AWSCredentialTimeout: 30 * time.Millisecond,
+	}
+
+	start := time.Now()
+	_, err := c.Get(context.Background(), p)
+	if err == nil || !strings.Contains(err.Error(), "aws_credential_timeout 30ms") || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected aws_credential_timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the build to fail fast, took %s", elapsed)
+	}
+
+	// The caller's own deadline is reported as is.
+	p.AWSCredentialTimeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, p); err == nil || strings.Contains(err.Error(), "aws_credential_timeout") {
+		t.Fatalf("expected a plain context error without aws_credential_timeout, got: %v", err)
+	}
+}
+
+func TestPurgeForcesFreshTokenAndKeepsProvider(t *testing.T) {
+	origLoad := loadDefaultAWSConfig
+	origBuild := buildRDSAuthToken
+	t.Cleanup(func() {
+		loadDefaultAWSConfig = origLoad
+		buildRDSAuthToken = origBuild
+	})
+
+	var loadCalls, buildCalls int32
+	loadDefaultAWSConfig = func(_ context.Context, _ ...func(*awsconfig.LoadOptions) error) (aws.Config, error) {
+		atomic.AddInt32(&loadCalls, 1)
+		return aws.Config{Credentials: staticProvider{}}, nil
+	}
+	buildRDSAuthToken = func(_ context.Context, _, _, _ string, _ aws.CredentialsProvider, _ ...func(options *auth.BuildAuthTokenOptions)) (string, error) {
+		return "token-" + string(rune('0'+atomic.AddInt32(&buildCalls, 1))), nil
+	}
+
+	c := New(5*time.Minute, 15*time.Minute)
+	p := config.Profile{
+		Name:      "p1",
+		RDSHost:   "db.example",
+		RDSPort:   3306,
+		RDSRegion: "eu-west-1",
+		RDSDBUser: "db_user_1",
+	}
+
+	first, err := c.Get(context.Background(), p)
+	if err != nil {
+		t.Fatalf("first Get: %v", err)
+	}
+	if exp, ok := c.ExpiresAt(p); !ok || !exp.Equal(first.ExpiresAt) {
+		t.Fatalf("ExpiresAt = %v, %v; want %v", exp, ok, first.ExpiresAt)
+	}
+	c.Purge()
+	if _, ok := c.ExpiresAt(p); ok {
+		t.Fatal("expected no cached expiry after Purge")
+	}
+	second, err := c.Get(context.Background(), p)
+	if err != nil {
+		t.Fatalf("Get after Purge: %v", err)
+	}
+	if first.Value == second.Value || atomic.LoadInt32(&buildCalls) != 2 {
+		t.Fatalf("expected a fresh token after Purge, got %q then %q (%d builds)", first.Value, second.Value, buildCalls)
+	}
+	if got := atomic.LoadInt32(&loadCalls); got != 1 {
+		t.Fatalf("expected the credentials provider to be reused, loaded config %d times", got)
+	}
+}
+
+func TestNextExpiryReturnsSoonestHostToken(t *testing.T) {
+	t.Parallel()
+
+	c := New(5*time.Minute, 15*time.Minute)
+	p := config.Profile{
+		Name:      "p1",
+		RDSHosts:  []string{"a.example", "b.example"},
+		RDSPort:   3306,
+		RDSRegion: "eu-west-1",
+		RDSDBUser: "db_user_1",
+	}
+	if _, ok := c.NextExpiry(p); ok {
+		t.Fatal("expected no expiry before any token is cached")
+	}
+
+	// An expiry in the past stays visible, so a failing refresh shows up as
+	// a negative countdown.
+	past := time.Now().Add(-time.Minute)
+	c.entries[cacheKey(p.ForHost("a.example"))] = CachedToken{Value: "a", ExpiresAt: time.Now().Add(10 * time.Minute)}
+	c.entries[cacheKey(p.ForHost("b.example"))] = CachedToken{Value: "b", ExpiresAt: past}
+	if next, ok := c.NextExpiry(p); !ok || !next.Equal(past) {
+		t.Fatalf("NextExpiry = %v, %v; want %v", next, ok, past)
+	}
+}
@@ -0,0 +1,85 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistedToken is one cache entry in a token cache file.
+type persistedToken struct {
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SaveFile writes every cached token still outside the refresh window to
+// path, keyed like the in-memory cache, so a restart can reuse them. The
+// file holds live database credentials and is written with mode 0600.
+func (c *Cache) SaveFile(path string) (int, error) {
+	entries := map[string]persistedToken{}
+	c.mu.Lock()
+	for key, tok := range c.entries {
+		if time.Until(tok.ExpiresAt) > c.refreshBefore {
+			entries[key] = persistedToken{Value: tok.Value, ExpiresAt: tok.ExpiresAt.UTC()}
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return 0, fmt.Errorf("encode token cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("write token cache: %w", err)
+	}
+	// CreateTemp opens the file with mode 0600.
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return 0, fmt.Errorf("write token cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("write token cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("write token cache: %w", err)
+	}
+	return len(entries), nil
+}
+
+// LoadFile adds the tokens saved by SaveFile at path to the cache and returns
+// how many it kept. Tokens already inside the refresh window are skipped, and
+// a missing file loads nothing without error.
+func (c *Cache) LoadFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read token cache: %w", err)
+	}
+	var entries map[string]persistedToken
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, fmt.Errorf("parse token cache %s: %w", path, err)
+	}
+
+	loaded := 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, tok := range entries {
+		if tok.Value == "" || time.Until(tok.ExpiresAt) <= c.refreshBefore {
+			continue
+		}
+		if cur, ok := c.entries[key]; ok && !tok.ExpiresAt.After(cur.ExpiresAt) {
+			continue
+		}
+		c.entries[key] = CachedToken{Value: tok.Value, ExpiresAt: tok.ExpiresAt}
+		loaded++
+	}
+	return loaded, nil
+}
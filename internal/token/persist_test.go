@@ -0,0 +1,66 @@
+package token
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadFileKeepsOnlyTokensOutsideRefreshWindow(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	src := New(5*time.Minute, 15*time.Minute)
+	src.entries["fresh"] = CachedToken{Value: "tok-fresh", ExpiresAt: time.Now().Add(12 * time.Minute)}
+	src.entries["refreshing"] = CachedToken{Value: "tok-old", ExpiresAt: time.Now().Add(2 * time.Minute)}
+
+	n, err := src.SaveFile(path)
+	if err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 saved token, got %d", n)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Fatalf("expected mode 0600, got %o", perm)
+		}
+	}
+
+	dst := New(5*time.Minute, 15*time.Minute)
+	if n, err := dst.LoadFile(path); err != nil || n != 1 {
+		t.Fatalf("LoadFile = %d, %v; want 1, nil", n, err)
+	}
+	if tok, ok := dst.entries["fresh"]; !ok || tok.Value != "tok-fresh" {
+		t.Fatalf("expected fresh token loaded, got %+v", dst.entries)
+	}
+
+	// A longer refresh window on load drops what is now too close to expiry.
+	strict := New(13*time.Minute, 15*time.Minute)
+	if n, err := strict.LoadFile(path); err != nil || n != 0 {
+		t.Fatalf("LoadFile with a wider refresh window = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestLoadFileMissingAndCorrupt(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	c := New(5*time.Minute, 15*time.Minute)
+	if n, err := c.LoadFile(filepath.Join(dir, "missing.json")); err != nil || n != 0 {
+		t.Fatalf("LoadFile(missing) = %d, %v; want 0, nil", n, err)
+	}
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := c.LoadFile(bad); err == nil {
+		t.Fatal("expected a parse error for a corrupt file")
+	}
+}
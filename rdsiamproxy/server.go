@@ -0,0 +1,405 @@
+// Package rdsiamproxy embeds the proxy in another program. It wires the same
+// token cache, backend pools and listeners the rds-iam-proxy CLI runs, from
+// already-loaded profiles:
+//
+//	cfg, err := rdsiamproxy.LoadConfig("config.yaml")
+//	...
+//	err = rdsiamproxy.Run(ctx, cfg.Profiles, rdsiamproxy.Options{Logger: logger})
+package rdsiamproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
+	"rds-iam-proxy/internal/serverinternal"
+	"rds-iam-proxy/internal/token"
+)
+
+func init() {
+	serverinternal.Of = func(server any) serverinternal.Internals {
+		s := server.(*Server)
+		return serverinternal.Internals{Proxies: s.instances, TokenCache: s.cache}
+	}
+}
+
+const (
+	// TokenTTL is the lifetime of an RDS IAM auth token.
+	TokenTTL = token.MaxTokenTTL
+	// TokenRefreshBefore is how long before expiry a cached token is rebuilt.
+	TokenRefreshBefore = 5 * time.Minute
+)
+
+// Request ID formats for Options.RequestIDFormat.
+const (
+	RequestIDCounter = proxy.RequestIDCounter // only the process-local conn_id
+	RequestIDUUID    = proxy.RequestIDUUID    // a random UUID per connection, logged as request_id
+)
+
+// MaxConnsUnlimited as Options.MaxConns or a profile's max_conns removes the
+// frontend connection cap.
+const MaxConnsUnlimited = config.MaxConnsUnlimited
+
+// Config and Profile are the parsed config.yaml types.
+type (
+	Config  = config.Config
+	Profile = config.Profile
+)
+
+// LoadConfig reads, defaults and validates a config file.
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// LoadConfigDir merges every *.yaml file in dir into one config, as
+// --config-dir does.
+func LoadConfigDir(dir string) (*Config, error) {
+	return config.LoadDir(dir)
+}
+
+// Options are the process-wide settings the CLI takes as flags. Zero values
+// use the CLI defaults except where noted.
+type Options struct {
+	Logger                *slog.Logger  // nil uses slog.Default()
+	PoolSize              int           // pre-warmed backend connections per profile; default 5
+	PoolMaxLife           time.Duration // default 14m; must be below TokenTTL
+	PoolSweepInterval     time.Duration // 0 disables idle health checks
+	PoolStarvationWindow  time.Duration // warn when every borrow for this long found the pool empty; 0 disables
+	ConnectTimeout        time.Duration // backend connect timeout; default 8s
+	ShutdownTimeout       time.Duration // graceful drain on ctx cancel; default 30s
+	MaxConns              int           // overrides every profile's max_conns when != 0; MaxConnsUnlimited removes the cap
+	RouteByUser           bool          // let profiles share a listen_addr, routed by proxy_user
+	AccessLogPath         string        // one line per connection; "-" for stdout, empty disables
+	TokenFailureCooldown  time.Duration // 0 disables; the CLI default is 5s
+	AllowDevEmptyPassword bool          // accept an empty proxy_password
+	AllowInsecure         bool          // accept insecure_skip_tls_verify
+	// IsolateProfiles keeps the other listeners serving when one fails;
+	// Run then returns once every listener has stopped.
+	IsolateProfiles bool
+	// TokenCacheFile, when set, seeds the token cache from this file in New
+	// and saves still-valid tokens to it when Run returns.
+	TokenCacheFile string
+	// RequestIDFormat is RequestIDCounter (default) or RequestIDUUID,
+	// which adds a random request_id to each connection's logs.
+	RequestIDFormat string
+	// ProfileLogger returns the logger for one profile's pool and listener,
+	// before the profile attribute is added; nil uses Logger for every
+	// profile. The CLI uses it to apply log_level.
+	ProfileLogger func(Profile) *slog.Logger
+}
+
+func (o *Options) applyDefaults() {
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = 5
+	}
+	if o.PoolMaxLife == 0 {
+		o.PoolMaxLife = 14 * time.Minute
+	}
+	if o.ConnectTimeout <= 0 {
+		o.ConnectTimeout = 8 * time.Second
+	}
+	if o.ShutdownTimeout <= 0 {
+		o.ShutdownTimeout = 30 * time.Second
+	}
+}
+
+// Server runs one listener per distinct listen_addr of its profiles.
+type Server struct {
+	logger    *slog.Logger
+	cache     *token.Cache
+	accessLog *proxy.AccessLog
+	pools     map[string]*proxy.BackendPool
+	instances []*proxy.Proxy
+	owners    []Profile // profile that opened each instance's listener
+	isolate   bool
+	tokenFile string
+	startOnce sync.Once
+}
+
+// New validates profiles and builds their pools and listeners without
+// connecting or binding anything; Start and Run do that.
+func New(profiles []Profile, opts Options) (*Server, error) {
+	opts.applyDefaults()
+	if len(profiles) == 0 {
+		return nil, errors.New("no profiles to run")
+	}
+	if opts.PoolMaxLife < 0 || opts.PoolMaxLife >= TokenTTL {
+		return nil, fmt.Errorf("pool max life %s must be positive and less than token ttl %s", opts.PoolMaxLife, TokenTTL)
+	}
+	if opts.MaxConns > config.MaxConnsHardLimit() {
+		return nil, fmt.Errorf("max conns %d exceeds hard limit %d", opts.MaxConns, config.MaxConnsHardLimit())
+	}
+	if opts.MaxConns < MaxConnsUnlimited {
+		return nil, fmt.Errorf("max conns %d is invalid; use %d for unlimited", opts.MaxConns, MaxConnsUnlimited)
+	}
+	if !proxy.ValidRequestIDFormat(opts.RequestIDFormat) {
+		return nil, fmt.Errorf("request id format %q must be %q or %q", opts.RequestIDFormat, RequestIDCounter, RequestIDUUID)
+	}
+	if !opts.RouteByUser {
+		if err := ValidateUniqueListenAddrs(profiles); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range profiles {
+		if err := p.ValidateRuntime(opts.AllowDevEmptyPassword, opts.AllowInsecure); err != nil {
+			return nil, &config.ProfileError{Profile: p.Name, Err: err}
+		}
+	}
+
+	logger := opts.Logger
+	s := &Server{
+		logger:    logger,
+		cache:     token.New(TokenRefreshBefore, TokenTTL),
+		pools:     make(map[string]*proxy.BackendPool, len(profiles)),
+		isolate:   opts.IsolateProfiles,
+		tokenFile: opts.TokenCacheFile,
+	}
+	s.cache.SetFailureCooldown(opts.TokenFailureCooldown)
+	if s.tokenFile != "" {
+		// A bad file costs one fresh token per profile, not startup.
+		if n, err := s.cache.LoadFile(s.tokenFile); err != nil {
+			logger.Warn("token cache file not loaded", "path", s.tokenFile, "error", err)
+		} else {
+			logger.Info("token cache file loaded", "path", s.tokenFile, "tokens", n)
+		}
+	}
+	if opts.AccessLogPath != "" {
+		al, err := proxy.OpenAccessLog(opts.AccessLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("access log: %w", err)
+		}
+		s.accessLog = al
+	}
+
+	listeners := map[string]*proxy.Proxy{}
+	for _, p := range profiles {
+		plog := logger
+		if opts.ProfileLogger != nil {
+			plog = opts.ProfileLogger(p)
+		}
+		plog = plog.With("profile", p.Name)
+		factory, err := proxy.NewBackendFactory(p, s.cache, opts.ConnectTimeout)
+		if err != nil {
+			s.closeAccessLog()
+			return nil, &config.ProfileError{Profile: p.Name, Err: fmt.Errorf("backend factory: %w", err)}
+		}
+		pool := proxy.NewBackendPool(opts.PoolSize, opts.PoolMaxLife, opts.ConnectTimeout, plog, factory.NewPoolConn)
+		pool.SetSweepInterval(opts.PoolSweepInterval)
+		pool.SetStarvationWindow(opts.PoolStarvationWindow)
+		pool.SetPrewarmConcurrency(p.PrewarmConcurrency)
+		s.pools[p.Name] = pool
+
+		maxConns := p.MaxConns
+		if opts.MaxConns != 0 {
+			maxConns = opts.MaxConns
+		}
+		px, shared := listeners[p.ListenAddr]
+		if shared {
+			if err := px.AddRoute(p, plog, pool, maxConns); err != nil {
+				s.closeAccessLog()
+				return nil, &config.ProfileError{Profile: p.Name, Err: err}
+			}
+		} else {
+			px = proxy.New(p, plog, pool, opts.ShutdownTimeout, maxConns)
+			px.SetAccessLog(s.accessLog)
+			px.SetRequestIDFormat(opts.RequestIDFormat)
+			listeners[p.ListenAddr] = px
+			s.instances = append(s.instances, px)
+			s.owners = append(s.owners, p)
+		}
+		if p.AllowCompression {
+			_ = px.SetCompressedDialer(p.Name, factory.NewCompressedConn)
+		}
+	}
+	return s, nil
+}
+
+// Run is New followed by Server.Run.
+func Run(ctx context.Context, profiles []Profile, opts Options) error {
+	s, err := New(profiles, opts)
+	if err != nil {
+		return err
+	}
+	return s.Run(ctx)
+}
+
+// Start begins pre-warming backend connections. Run calls it; call it
+// earlier to overlap warm-up with other startup work or to use WaitReady.
+func (s *Server) Start(ctx context.Context) {
+	s.startOnce.Do(func() {
+		for _, pool := range s.pools {
+			pool.Start(ctx)
+		}
+	})
+}
+
+// WaitReady waits until every profile has opened one backend connection, so
+// a broken CA bundle or security group fails startup instead of the first
+// client. The error names each profile that did not become ready. It must be
+// called at most once, after Start.
+func (s *Server) WaitReady(ctx context.Context, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		failed []string
+		wg     sync.WaitGroup
+	)
+	for name, pool := range s.pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pool.WaitReady(waitCtx); err != nil {
+				mu.Lock()
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+				mu.Unlock()
+				return
+			}
+			s.logger.Info("backend ready", "profile", name)
+		}()
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		return fmt.Errorf("%d of %d profiles not ready within %s: %s", len(failed), len(s.pools), timeout, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Run serves every listener until ctx is cancelled, then drains connections
+// for up to ShutdownTimeout. If any listener fails, the others are stopped
+// and its error is returned; with IsolateProfiles they keep serving and the
+// failures of every listener are returned once all have stopped. Run closes
+// the access log on return.
+func (s *Server) Run(ctx context.Context) error {
+	defer s.closeAccessLog()
+	defer s.saveTokenCache()
+	s.Start(ctx)
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		first    error
+		isolated []error
+	)
+	for i, px := range s.instances {
+		wg.Add(1)
+		go func(pf Profile, px *proxy.Proxy) {
+			defer wg.Done()
+			if err := px.Run(ctx); err != nil {
+				if s.isolate {
+					s.logger.Error("proxy failed; other profiles keep serving", "profile", pf.Name, "error", err)
+					errMu.Lock()
+					isolated = append(isolated, fmt.Errorf("profile %s: %w", pf.Name, err))
+					errMu.Unlock()
+					return
+				}
+				// Name the failing profile before the others are torn down.
+				s.logger.Error("proxy failed; stopping remaining profiles", "profile", pf.Name, "error", err)
+				errMu.Lock()
+				if first == nil {
+					first = err
+				}
+				errMu.Unlock()
+				stop()
+			}
+		}(s.owners[i], px)
+	}
+	wg.Wait()
+	if s.isolate {
+		return errors.Join(isolated...)
+	}
+	return first
+}
+
+// WaitListening blocks until every profile's listener is bound by Run and
+// returns the bound addresses, or returns ctx's error first. A listener that
+// fails to bind keeps it waiting, so callers should pass a context that ends
+// with Run.
+func (s *Server) WaitListening(ctx context.Context) ([]string, error) {
+	var addrs []string
+	for _, px := range s.instances {
+		select {
+		case <-px.Bound():
+			addrs = append(addrs, px.BoundAddrs()...)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return addrs, nil
+}
+
+// ReopenAccessLog reopens the access log file after rotation. It is a no-op
+// without AccessLogPath.
+func (s *Server) ReopenAccessLog() error {
+	if s.accessLog == nil {
+		return nil
+	}
+	return s.accessLog.Reopen()
+}
+
+// RefreshBackends discards cached IAM tokens and rebuilds every profile's
+// warm pool, so new backend sessions re-authenticate without a restart.
+// Sessions already forwarding are left alone. A pool rebuilt in the last few
+// seconds, by a refresh or a failover, is skipped so repeated calls coalesce.
+// It returns how many pools were refreshed.
+func (s *Server) RefreshBackends() int {
+	s.cache.Purge()
+	n := 0
+	for _, pool := range s.pools {
+		if pool.Refresh() {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) saveTokenCache() {
+	if s.tokenFile == "" {
+		return
+	}
+	n, err := s.cache.SaveFile(s.tokenFile)
+	if err != nil {
+		s.logger.Warn("token cache file not saved", "path", s.tokenFile, "error", err)
+		return
+	}
+	s.logger.Info("token cache file saved", "path", s.tokenFile, "tokens", n)
+}
+
+func (s *Server) closeAccessLog() {
+	if s.accessLog != nil {
+		_ = s.accessLog.Close()
+	}
+}
+
+// ValidateUniqueListenAddrs rejects profiles that would bind the same
+// listen_addr; use Options.RouteByUser to share one deliberately.
+func ValidateUniqueListenAddrs(profiles []Profile) error {
+	seen := map[string]Profile{}
+	for _, p := range profiles {
+		if prev, ok := seen[p.ListenAddr]; ok {
+			err := fmt.Errorf("listen_addr %q is reused by profile %s", p.ListenAddr, prev.Describe())
+			if p.SourceFile != "" {
+				err = fmt.Errorf("%s: %w", filepath.Base(p.SourceFile), err)
+			}
+			return &config.ProfileError{Profile: p.Name, Err: err}
+		}
+		seen[p.ListenAddr] = p
+	}
+	return nil
+}
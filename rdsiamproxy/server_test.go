@@ -0,0 +1,339 @@
+package rdsiamproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rds-iam-proxy/internal/config"
+	"rds-iam-proxy/internal/proxy"
+
+	"github.com/go-mysql-org/go-mysql/client"
+)
+
+func writeTestCABundle(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+	return path
+}
+
+func testProfile(name, addr, ca string) Profile {
+	return Profile{
+		Name:          name,
+		ListenAddr:    addr,
+		MaxConns:      5,
+		ProxyUser:     "local_" + name,
+		ProxyPassword: "pw-" + name,
+		RDSHost:       "127.0.0.1",
+		RDSPort:       1,
+		RDSRegion:     "eu-west-1",
+		RDSDBUser:     "db_" + name,
+		CABundle:      ca,
+		AuthTimeout:   time.Second,
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestValidateUniqueListenAddrs(t *testing.T) {
+	t.Parallel()
+
+	err := ValidateUniqueListenAddrs([]Profile{
+		{Name: "p1", ListenAddr: "127.0.0.1:3307"},
+		{Name: "p2", ListenAddr: "127.0.0.1:3307"},
+	})
+	var pe *config.ProfileError
+	if !errors.As(err, &pe) || pe.Profile != "p2" || !strings.Contains(err.Error(), "reused") {
+		t.Fatalf("expected duplicate listen address error for p2, got: %v", err)
+	}
+}
+
+func TestNewValidatesProfilesAndOptions(t *testing.T) {
+	t.Parallel()
+
+	ca := writeTestCABundle(t)
+	addr := freeAddr(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := New(nil, Options{Logger: logger}); err == nil {
+		t.Fatal("expected an error without profiles")
+	}
+	shared := []Profile{testProfile("a", addr, ca), testProfile("b", addr, ca)}
+	if _, err := New(shared, Options{Logger: logger}); err == nil || !strings.Contains(err.Error(), "reused") {
+		t.Fatalf("expected shared listen_addr to need RouteByUser, got: %v", err)
+	}
+	srv, err := New(shared, Options{Logger: logger, RouteByUser: true})
+	if err != nil {
+		t.Fatalf("New with RouteByUser: %v", err)
+	}
+	if n := len(srv.instances); n != 1 {
+		t.Fatalf("expected one shared listener, got %d", n)
+	}
+
+	empty := testProfile("c", freeAddr(t), ca)
+	empty.ProxyPassword = ""
+	if _, err := New([]Profile{empty}, Options{Logger: logger}); err == nil || !strings.Contains(err.Error(), "proxy_password") {
+		t.Fatalf("expected runtime validation error, got: %v", err)
+	}
+	if _, err := New([]Profile{empty}, Options{Logger: logger, AllowDevEmptyPassword: true}); err != nil {
+		t.Fatalf("expected AllowDevEmptyPassword to accept an empty password, got: %v", err)
+	}
+	if _, err := New([]Profile{testProfile("d", freeAddr(t), ca)}, Options{Logger: logger, PoolMaxLife: TokenTTL}); err == nil {
+		t.Fatal("expected PoolMaxLife at the token TTL to be rejected")
+	}
+}
+
+func TestRunServesUntilCancelled(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	addr := freeAddr(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, []Profile{testProfile("lib", addr, writeTestCABundle(t))}, Options{Logger: logger, ShutdownTimeout: time.Second})
+	}()
+
+	// The listener answers with a MySQL greeting even while the backend is down.
+	deadline := time.Now().Add(3 * time.Second)
+	var conn net.Conn
+	for {
+		c, err := net.DialTimeout("tcp", addr, 200*time.Millisecond)
+		if err == nil {
+			conn = c
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("proxy did not listen on %s: %v", addr, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("expected a greeting, got: %v", err)
+	}
+	_ = conn.Close()
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestRunIsolateProfilesKeepsOthersServing(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer busy.Close()
+	ca := writeTestCABundle(t)
+	okAddr := freeAddr(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx, []Profile{testProfile("ok", okAddr, ca), testProfile("busy", busy.Addr().String(), ca)},
+			Options{Logger: logger, ShutdownTimeout: time.Second, IsolateProfiles: true})
+	}()
+
+	// The healthy listener keeps answering after the other failed to bind.
+	time.Sleep(200 * time.Millisecond)
+	conn, err := net.DialTimeout("tcp", okAddr, time.Second)
+	if err != nil {
+		t.Fatalf("healthy profile stopped serving: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("expected a greeting, got: %v", err)
+	}
+	_ = conn.Close()
+	select {
+	case err := <-runErr:
+		t.Fatalf("Run returned before cancel: %v", err)
+	default:
+	}
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err == nil || !strings.Contains(err.Error(), "profile busy") {
+			t.Fatalf("expected the failed profile's error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestWaitReadyNamesUnreadyProfiles(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	failing := proxy.NewBackendPool(1, time.Minute, time.Second, logger, func(context.Context) (*client.Conn, time.Time, error) {
+		return nil, time.Time{}, errors.New("dial backend: i/o timeout")
+	})
+	failing.Start(context.Background())
+	defer failing.Close()
+
+	s := &Server{logger: logger, pools: map[string]*proxy.BackendPool{"broken": failing}}
+	err := s.WaitReady(context.Background(), time.Second)
+	if err == nil || !strings.Contains(err.Error(), "1 of 1 profiles") || !strings.Contains(err.Error(), "broken: dial backend: i/o timeout") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (&Server{logger: logger}).WaitReady(context.Background(), time.Second); err != nil {
+		t.Fatalf("expected no error without pools, got: %v", err)
+	}
+}
+
+func TestWaitListeningReturnsEveryBoundAddr(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	ca := writeTestCABundle(t)
+	first, second := freeAddr(t), freeAddr(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv, err := New([]Profile{testProfile("a", first, ca), testProfile("b", second, ca)}, Options{Logger: logger, ShutdownTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelWait()
+	if _, err := srv.WaitListening(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected WaitListening to block before Run, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- srv.Run(ctx)
+	}()
+	waitCtx, cancelWait = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelWait()
+	addrs, err := srv.WaitListening(waitCtx)
+	if err != nil {
+		t.Fatalf("WaitListening: %v", err)
+	}
+	if strings.Join(addrs, ",") != first+","+second {
+		t.Fatalf("expected %s,%s, got %v", first, second, addrs)
+	}
+	cancel()
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+}
+
+func TestProfileLoggerIsUsedPerProfile(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	ca := writeTestCABundle(t)
+	quiet, noisy := testProfile("quiet", freeAddr(t), ca), testProfile("noisy", freeAddr(t), ca)
+	noisy.LogLevel = "debug"
+	var quietOut, noisyOut syncBuffer
+	opts := Options{
+		Logger:               slog.New(slog.NewTextHandler(&quietOut, nil)),
+		TokenFailureCooldown: time.Minute,
+		ProfileLogger: func(p Profile) *slog.Logger {
+			if p.LogLevel == "debug" {
+				return slog.New(slog.NewTextHandler(&noisyOut, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			}
+			return slog.New(slog.NewTextHandler(&quietOut, nil))
+		},
+	}
+	s, err := New([]Profile{quiet, noisy}, opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	// Prewarms fail without AWS credentials and log through each profile's logger.
+	_ = s.WaitReady(ctx, 5*time.Second)
+	cancel()
+	for _, pool := range s.pools {
+		pool.Close()
+	}
+
+	if got := quietOut.String(); !strings.Contains(got, "profile=quiet") || strings.Contains(got, "profile=noisy") {
+		t.Fatalf("quiet logger output should only name profile quiet:\n%s", got)
+	}
+	if got := noisyOut.String(); !strings.Contains(got, "profile=noisy") || strings.Contains(got, "profile=quiet") {
+		t.Fatalf("noisy logger output should only name profile noisy:\n%s", got)
+	}
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}